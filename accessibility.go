@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// AccessibilityModes are what --accessibility takes: simulations of the
+// three kinds of color blindness, to check how a picture reads to those who
+// have them, and a high contrast mode for bad projectors and poor eyesight.
+var AccessibilityModes = []string{"deuteranopia", "protanopia", "tritanopia", "high-contrast"}
+
+// colorBlindness are the matrices of Machado, Oliveira and Fernandes (2009)
+// at full severity, on linear RGB.
+var colorBlindness = map[string][3][3]float64{
+	"protanopia": {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	"deuteranopia": {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	"tritanopia": {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+func ParseAccessibility(mode string) (FrameFilter, error) {
+	if mode == "high-contrast" {
+		return PixelFilter(highContrast), nil
+	}
+
+	matrix, ok := colorBlindness[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown accessibility mode %s, expected one of %s", mode, strings.Join(AccessibilityModes, ", "))
+	}
+
+	return simulate(matrix), nil
+}
+
+// toLinear and fromLinear convert between sRGB and linear light, the
+// latter from 12 bits.
+var toLinear, fromLinear = func() ([256]float64, [4096]uint8) {
+	var to [256]float64
+	for v := range to {
+		c := float64(v) / 255
+		if c <= 0.04045 {
+			to[v] = c / 12.92
+		} else {
+			to[v] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+
+	var from [4096]uint8
+	for v := range from {
+		c := float64(v) / 4095
+		if c <= 0.0031308 {
+			c *= 12.92
+		} else {
+			c = 1.055*math.Pow(c, 1/2.4) - 0.055
+		}
+		from[v] = uint8(math.Round(c * 255))
+	}
+
+	return to, from
+}()
+
+func linearToByte(c float64) uint8 {
+	return fromLinear[int(min(max(c, 0), 1)*4095+0.5)]
+}
+
+func simulate(matrix [3][3]float64) PixelFilter {
+	return func(picture *image.NRGBA) {
+		for i := 0; i+3 < len(picture.Pix); i += 4 {
+			r, g, b := toLinear[picture.Pix[i]], toLinear[picture.Pix[i+1]], toLinear[picture.Pix[i+2]]
+			for c, row := range matrix {
+				picture.Pix[i+c] = linearToByte(row[0]*r + row[1]*g + row[2]*b)
+			}
+		}
+	}
+}
+
+// highContrast stretches the brightness of each frame to the full range,
+// leaving out the darkest and brightest 2%, then steepens the middle tones
+// and strengthens colors so neighbors stand apart.
+func highContrast(picture *image.NRGBA) {
+	var histogram [256]int
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		histogram[luma(picture.Pix[i:i+3])]++
+	}
+
+	pixels := len(picture.Pix) / 4
+	low, high := 0, 255
+	for seen := 0; low < 255 && seen+histogram[low] <= pixels/50; low++ {
+		seen += histogram[low]
+	}
+	for seen := 0; high > low && seen+histogram[high] <= pixels/50; high-- {
+		seen += histogram[high]
+	}
+
+	var curve [256]uint8
+	for v := range curve {
+		x := float64(v-low) / float64(max(high-low, 1))
+		x = min(max(x, 0), 1)
+		// smoothstep, steepest at mid gray
+		curve[v] = uint8(math.Round(x * x * (3 - 2*x) * 255))
+	}
+
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		l := luma(picture.Pix[i : i+3])
+		for c := 0; c < 3; c++ {
+			// saturation up by half around the brightness
+			v := l + (int(picture.Pix[i+c])-l)*3/2
+			picture.Pix[i+c] = curve[clampByte(v)]
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ANSI_MARKER starts the timing markers of an .ansi file. They're APC
+// strings, which terminals ignore, so the file also shows with cat.
+const ANSI_MARKER = "\u001b_termtv;"
+
+// AnsiWriter writes the escape stream to a file as it was written to the
+// terminal, each write after a marker with its time since the start and its
+// length, like "t=1.250000;n=4096", and the size of the terminal at the start
+// and on each resize, like "size=80x24". termtv replay plays it back.
+type AnsiWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	out   *bufio.Writer
+	start time.Time
+}
+
+func NewAnsiWriter(path string) (*AnsiWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &AnsiWriter{
+		file:  file,
+		out:   bufio.NewWriter(file),
+		start: time.Now(),
+	}
+	w.Resize(TerminalSize())
+
+	return w, nil
+}
+
+func (w *AnsiWriter) marker(format string, args ...any) {
+	w.out.WriteString(ANSI_MARKER)
+	fmt.Fprintf(w.out, format, args...)
+	w.out.WriteString("\u001b\\")
+}
+
+func (w *AnsiWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.marker("t=%.6f;n=%d", time.Since(w.start).Seconds(), len(p))
+	return w.out.Write(p)
+}
+
+func (w *AnsiWriter) Resize(cols, rows int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.marker("size=%dx%d", cols, rows)
+	return nil
+}
+
+func (w *AnsiWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.out.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// ansiChunk is a write read back from an .ansi file, or, with data nil,
+// another marker.
+type ansiChunk struct {
+	at   time.Duration
+	data []byte
+}
+
+// AnsiReader reads back the writes of an AnsiWriter.
+type AnsiReader struct {
+	in *bufio.Reader
+}
+
+func NewAnsiReader(r io.Reader) *AnsiReader {
+	return &AnsiReader{in: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// next reads the next write or other marker, io.EOF at the end.
+func (r *AnsiReader) next() (ansiChunk, error) {
+	var chunk ansiChunk
+
+	prefix := make([]byte, len(ANSI_MARKER))
+	if _, err := io.ReadFull(r.in, prefix); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("cut off marker")
+		}
+		return chunk, err
+	}
+	if string(prefix) != ANSI_MARKER {
+		return chunk, fmt.Errorf("not an .ansi file of termtv")
+	}
+
+	marker, err := r.in.ReadString('\u001b')
+	if err != nil {
+		return chunk, fmt.Errorf("cut off marker")
+	}
+	if end, err := r.in.ReadByte(); err != nil || end != '\\' {
+		return chunk, fmt.Errorf("invalid marker %q", marker)
+	}
+
+	n := -1
+	for _, field := range strings.Split(strings.TrimSuffix(marker, "\u001b"), ";") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "t":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return chunk, fmt.Errorf("invalid time %q", value)
+			}
+			chunk.at = time.Duration(seconds * float64(time.Second))
+		case "n":
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				return chunk, fmt.Errorf("invalid length %q", value)
+			}
+		}
+	}
+
+	if n < 0 {
+		return chunk, nil
+	}
+
+	chunk.data = make([]byte, n)
+	if _, err := io.ReadFull(r.in, chunk.data); err != nil {
+		return chunk, fmt.Errorf("cut off write")
+	}
+
+	return chunk, nil
+}
+
+// ReplayAnsi writes the escape stream of an .ansi file to the terminal as it
+// was timed, at speed, until it ends or the user quits. Pausing stops the
+// clock.
+func ReplayAnsi(r io.Reader, speed float64, bindings KeyBindings) error {
+	reader := NewAnsiReader(r)
+
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	// start is when the recording started, as far as the clock goes
+	start := time.Now()
+	var pausedAt time.Time
+
+	for {
+		chunk, err := reader.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if chunk.data == nil {
+			continue
+		}
+
+		for {
+			var due <-chan time.Time
+			if pausedAt.IsZero() {
+				wait := time.Until(start.Add(time.Duration(float64(chunk.at) / speed)))
+				if wait <= 0 {
+					break
+				}
+				due = time.After(wait)
+			}
+
+			select {
+			case <-due:
+			case <-interrupt:
+				return nil
+			case key, ok := <-keys:
+				if !ok {
+					keys = nil
+					continue
+				}
+
+				c, err := ParseCommand(bindings[key])
+				if err != nil {
+					continue
+				}
+
+				switch c.Name {
+				case "quit":
+					return nil
+				case "pause":
+					if pausedAt.IsZero() {
+						pausedAt = time.Now()
+					} else {
+						start = start.Add(time.Since(pausedAt))
+						pausedAt = time.Time{}
+					}
+				}
+			}
+		}
+
+		os.Stdout.Write(chunk.data)
+	}
+}
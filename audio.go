@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// AudioPlayer plays the sound of a source with ffplay, without a window. The
+// player starts it again at the new position on seeks and stops it while
+// paused, ffplay takes no commands without its window.
+type AudioPlayer struct {
+	Input string
+	// Delay plays the sound later than the picture, or earlier when it's
+	// negative, to make up for terminals that are slow to draw.
+	Delay time.Duration
+	// Volume is from 0 to 100, ffplay's own scale.
+	Volume int
+
+	cmd *exec.Cmd
+}
+
+// Start plays from offset, stopping what was playing before.
+func (a *AudioPlayer) Start(offset time.Duration) {
+	a.Stop()
+
+	offset -= a.Delay
+
+	args := append(SeekArgs(offset), HttpArgs(a.Input, nil)...)
+	args = append(args, LogArgs()...)
+	args = append(args, "-nodisp", "-autoexit", "-volume", strconv.Itoa(a.Volume), "-i", a.Input)
+	// before the start the sound is held back with silence
+	if offset < 0 {
+		args = append(args, "-af", fmt.Sprintf("adelay=%d:all=1", (-offset).Milliseconds()))
+	}
+
+	cmd := ChildCommand(context.Background(), ToolPath("ffplay"), args...)
+	cmd.Stderr = NewChildLog("ffplay")
+	if err := StartChild(cmd); err != nil {
+		return
+	}
+
+	a.cmd = cmd
+}
+
+func (a *AudioPlayer) Stop() {
+	if a.cmd == nil {
+		return
+	}
+
+	killGroup(a.cmd.Process.Pid)
+	WaitChild(a.cmd)
+	a.cmd = nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TOKEN_ENV is where --token is read from by default, to keep it out of the
+// process list.
+const TOKEN_ENV = "TERMTV_TOKEN"
+
+// IsLoopback tells whether addr, as net.Listen takes it, is only reachable
+// from this machine. ":7070" isn't, listening on every interface.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServerTLS loads the certificate and key of --tls-cert and --tls-key, or
+// returns nil without them.
+func ServerTLS(certPath, keyPath string) (*tls.Config, error) {
+	if certPath == "" && keyPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("--tls-cert and --tls-key go together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// ClientTLS is the config to connect over TLS with, trusting the
+// certificates in caPath as well as the system's, like a self signed one.
+func ClientTLS(caPath string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caPath == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caPath)
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
+
+// TokenMatches is whether given is the token, taking as long either way.
+// Without a token anything matches.
+func TokenMatches(token, given string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(given)) == 1
+}
+
+// RequireToken lets requests to handler through only with the token, as a
+// bearer token or the password of basic auth, which is what Prometheus and
+// browsers send.
+func RequireToken(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			given = password
+		}
+
+		if !TokenMatches(token, given) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="termtv"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
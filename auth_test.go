@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"localhost:7070", true},
+		{"127.0.0.1:7070", true},
+		{"127.1.2.3:7070", true},
+		{"[::1]:7070", true},
+		{":7070", false},
+		{"0.0.0.0:7070", false},
+		{"[::]:7070", false},
+		{"192.168.1.2:7070", false},
+		{"example.com:7070", false},
+		{"localhost", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := IsLoopback(test.addr); got != test.want {
+			t.Errorf("IsLoopback(%q) is %t, want %t", test.addr, got, test.want)
+		}
+	}
+}
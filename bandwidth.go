@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bandwidth keeps output under a budget of bytes per second. Frames are
+// dropped while the budget is spent, and colors are quantized more coarsely
+// while frames take more than their share of it, which shortens escape
+// sequences and leaves more cells unchanged for diff updates.
+type Bandwidth struct {
+	Rate float64
+
+	tokens  float64
+	updated time.Time
+
+	// Level is the number of low bits dropped from each color channel
+	Level     int
+	frameSize float64
+	adjusted  time.Time
+
+	quantized *image.NRGBA
+}
+
+const MAX_QUANTIZE_LEVEL = 5
+
+func NewBandwidth(rate float64) *Bandwidth {
+	return &Bandwidth{Rate: rate, tokens: rate, updated: time.Now()}
+}
+
+// ParseBandwidth parses rates like 200KB/s, 1.5MB or 64KiB/s into bytes per
+// second.
+func ParseBandwidth(value string) (float64, error) {
+	rate, ok := parseBytes(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if !ok {
+		return 0, fmt.Errorf("invalid bandwidth %s", value)
+	}
+
+	return rate, nil
+}
+
+// ParseByteSize parses sizes like 500MB, 1.5GB or 64MiB into bytes. Unlike
+// ParseBandwidth it refuses rates, which a size like 64MB/s isn't.
+func ParseByteSize(value string) (int64, error) {
+	size, ok := parseBytes(strings.TrimSpace(value))
+	if !ok || size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %s, expected one like 500MB", value)
+	}
+
+	return int64(size), nil
+}
+
+// parseBytes parses a number of bytes above 0, in the units of
+// ParseBandwidth and ParseByteSize.
+func parseBytes(value string) (float64, bool) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"B", 1},
+	}
+
+	scale := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, scale = strings.TrimSuffix(value, unit.suffix), unit.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n * scale, true
+}
+
+func (b *Bandwidth) refill() {
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.updated).Seconds()*b.Rate, b.Rate)
+	b.updated = now
+}
+
+// Allow reports whether there is budget left for a frame.
+func (b *Bandwidth) Allow() bool {
+	b.refill()
+	return b.tokens > 0
+}
+
+// Spend takes n written bytes from the budget, and adapts the quantization
+// to how the frames fit the share of the budget interval gives them.
+func (b *Bandwidth) Spend(n int, interval time.Duration) {
+	b.refill()
+	b.tokens -= float64(n)
+
+	b.frameSize = (b.frameSize*7 + float64(n)) / 8
+
+	if interval <= 0 || time.Since(b.adjusted) < time.Second {
+		return
+	}
+	b.adjusted = time.Now()
+
+	share := b.Rate * interval.Seconds()
+	switch {
+	case b.frameSize > share && b.Level < MAX_QUANTIZE_LEVEL:
+		b.Level++
+	case b.frameSize < share/2 && b.Level > 0:
+		b.Level--
+	}
+}
+
+// Quantize returns picture with the low Level bits of every channel cleared.
+func (b *Bandwidth) Quantize(picture *image.NRGBA) *image.NRGBA {
+	if b.Level == 0 {
+		return picture
+	}
+
+	if b.quantized == nil || b.quantized.Rect != picture.Rect {
+		b.quantized = image.NewNRGBA(picture.Rect)
+	}
+
+	mask := byte(0xff << b.Level)
+	for i, v := range picture.Pix {
+		b.quantized.Pix[i] = v & mask
+	}
+
+	return b.quantized
+}
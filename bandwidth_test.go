@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{"500MB", 500e6, false},
+		{"1.5GB", 1.5e9, false},
+		{"64MiB", 64 << 20, false},
+		{" 100 KB ", 100e3, false},
+		{"4096", 4096, false},
+		{"64MB/s", 0, true},
+		{"0MB", 0, true},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"lots", 0, true},
+		{"1e30GB", 0, true},
+		{"", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseByteSize(test.value)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ParseByteSize(%q) is %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+}
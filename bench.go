@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// BenchResult is how long each stage of the pipeline took over a run of
+// `termtv bench`. Decode is the time spent waiting for ffmpeg, which decodes
+// in parallel with the rest.
+type BenchResult struct {
+	Renderer string
+	Frames   int
+	Bytes    int64
+
+	Decode time.Duration
+	Scale  time.Duration
+	Encode time.Duration
+	Write  time.Duration
+	Total  time.Duration
+}
+
+// Bench plays source as fast as it decodes through renderer, for a terminal
+// of cols x rows, discarding the output. frames limits the run, 0 for the
+// whole source.
+func Bench(source *Source, renderer Renderer, cols, rows, frames int) (BenchResult, error) {
+	result := BenchResult{Renderer: renderer.Name()}
+
+	grid := renderer.Grid(cols, rows)
+	resized := image.NewNRGBA(image.Rectangle{Max: grid})
+
+	var buffer bytes.Buffer
+	var drawn *image.NRGBA
+
+	playback := Playback{Source: source}
+	playback.Start(grid, 0)
+
+	start := time.Now()
+	mark := start
+
+	// each stage's time runs up to the next mark
+	lap := func(stage *time.Duration) {
+		now := time.Now()
+		*stage += now.Sub(mark)
+		mark = now
+	}
+
+	for frame := range playback.Frames() {
+		lap(&result.Decode)
+
+		picture := Fit(frame.Picture, resized)
+		lap(&result.Scale)
+
+		if diff, ok := renderer.(DiffRenderer); ok && drawn != nil {
+			diff.RenderDiff(&buffer, drawn, picture)
+		} else {
+			renderer.Render(&buffer, picture)
+			drawn = image.NewNRGBA(picture.Rect)
+		}
+		copy(drawn.Pix, picture.Pix)
+		lap(&result.Encode)
+
+		result.Bytes += int64(buffer.Len())
+		io.Copy(io.Discard, &buffer)
+		lap(&result.Write)
+
+		if result.Frames++; frames > 0 && result.Frames >= frames {
+			playback.Stop()
+			break
+		}
+	}
+
+	result.Total = time.Since(start)
+
+	if frames <= 0 || result.Frames < frames {
+		if err := playback.Wait(); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+const BENCH_HEADER = "renderer    frames  decode fps   scale fps  encode fps   write fps   total fps    KB/frame"
+
+func (r BenchResult) String() string {
+	fps := func(d time.Duration) float64 {
+		if d <= 0 {
+			return 0
+		}
+		return float64(r.Frames) / d.Seconds()
+	}
+
+	kb := 0.0
+	if r.Frames > 0 {
+		kb = float64(r.Bytes) / float64(r.Frames) / 1000
+	}
+
+	return fmt.Sprintf(
+		"%-10s %7d %11.1f %11.1f %11.1f %11.1f %11.1f %11.1f",
+		r.Renderer, r.Frames, fps(r.Decode), fps(r.Scale), fps(r.Encode), fps(r.Write), fps(r.Total), kb,
+	)
+}
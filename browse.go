@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// BROWSE_PREVIEW_AT is how far into a video its preview is taken, as a
+	// part of its duration, past any black at its start.
+	BROWSE_PREVIEW_AT = 0.1
+	// BROWSE_PREVIEW_DELAY is how long the highlight rests on a file before
+	// its preview is decoded, so scrolling past files doesn't start ffmpeg
+	// for each.
+	BROWSE_PREVIEW_DELAY = 150 * time.Millisecond
+	// BROWSE_PREVIEW_TIMEOUT is how long a preview may take.
+	BROWSE_PREVIEW_TIMEOUT = 5 * time.Second
+
+	BROWSE_DIR_STYLE      = "0;1;94;40"
+	BROWSE_SELECTED_STYLE = "0;30;47"
+)
+
+// BrowseEntry is a directory or file listed by the Browser.
+type BrowseEntry struct {
+	Name string
+	Dir  bool
+}
+
+// ListDir lists the directories in dir, then its media files, or all its
+// files with all, leaving out hidden ones. ".." comes first but at the root.
+func ListDir(dir string, all bool) ([]BrowseEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs, files []BrowseEntry
+	if filepath.Dir(dir) != dir {
+		dirs = append(dirs, BrowseEntry{Name: "..", Dir: true})
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		// links are followed to tell directories
+		stat, err := os.Stat(filepath.Join(dir, name))
+		switch {
+		case err == nil && stat.IsDir():
+			dirs = append(dirs, BrowseEntry{Name: name, Dir: true})
+		case all || MediaExtensions[strings.ToLower(filepath.Ext(name))]:
+			files = append(files, BrowseEntry{Name: name})
+		}
+	}
+
+	return append(dirs, files...), nil
+}
+
+// browsePreview is the preview of path, with a line about it. picture is nil
+// when it couldn't be decoded.
+type browsePreview struct {
+	path    string
+	picture *image.NRGBA
+	info    string
+}
+
+// Browser is the file picker termtv starts into without arguments: a list of
+// the current directory on the left and a preview of the highlighted file on
+// the right.
+type Browser struct {
+	Dir      string
+	Renderer Renderer
+	// All lists every file rather than only media files.
+	All bool
+
+	entries  []BrowseEntry
+	selected int
+	top      int
+	err      error
+
+	// previews are kept by path, loading is the path being decoded
+	previews map[string]browsePreview
+	loaded   chan browsePreview
+	loading  string
+	settled  <-chan time.Time
+
+	grid    image.Point
+	canvas  *image.NRGBA
+	drawn   *image.NRGBA
+	buffer  bytes.Buffer
+	preview image.Rectangle
+}
+
+// Browse lets the user pick a file to play, returning its path, or false when
+// they quit instead.
+func Browse(dir string, renderer Renderer) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		dir = "."
+	}
+
+	b := &Browser{
+		Dir:      dir,
+		Renderer: renderer,
+		previews: map[string]browsePreview{},
+		loaded:   make(chan browsePreview, 1),
+	}
+
+	return b.Run()
+}
+
+func (b *Browser) Run() (string, bool) {
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	b.open(b.Dir, "")
+	b.layout()
+
+	for {
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			b.layout()
+
+		case <-interrupt:
+			return "", false
+
+		case key, ok := <-keys:
+			if !ok {
+				return "", false
+			}
+
+			if path, picked, quit := b.key(key); picked || quit {
+				ClearScreen()
+				return path, picked
+			}
+			b.draw()
+
+		case <-b.settled:
+			b.settled = nil
+			b.load()
+
+		case preview := <-b.loaded:
+			// one decoded before a resize is decoded again
+			if preview.picture == nil || preview.picture.Rect.Size() == b.preview.Size() {
+				b.previews[preview.path] = preview
+			}
+			b.loading = ""
+			b.load()
+			b.draw()
+		}
+	}
+}
+
+// key handles a key, returning the path of the file picked, or quit when
+// the user is done.
+func (b *Browser) key(key string) (path string, picked, quit bool) {
+	_, rows := TerminalSize()
+	page := max(rows-2, 1)
+
+	switch key {
+	case "q", "esc", "ctrl-c":
+		return "", false, true
+	case "up", "k":
+		b.move(-1)
+	case "down", "j":
+		b.move(1)
+	case "pgup":
+		b.move(-page)
+	case "pgdown":
+		b.move(page)
+	case "home":
+		b.move(-len(b.entries))
+	case "end":
+		b.move(len(b.entries))
+	case "a":
+		b.All = !b.All
+		name := ""
+		if entry, ok := b.current(); ok {
+			name = entry.Name
+		}
+		b.open(b.Dir, name)
+	case "backspace", "left", "h":
+		b.open(filepath.Dir(b.Dir), filepath.Base(b.Dir))
+	case "enter", "right", "l":
+		entry, ok := b.current()
+		switch {
+		case !ok:
+		case entry.Name == "..":
+			b.open(filepath.Dir(b.Dir), filepath.Base(b.Dir))
+		case entry.Dir:
+			b.open(filepath.Join(b.Dir, entry.Name), "")
+		default:
+			return filepath.Join(b.Dir, entry.Name), true, false
+		}
+	}
+
+	return "", false, false
+}
+
+func (b *Browser) current() (BrowseEntry, bool) {
+	if b.selected < len(b.entries) {
+		return b.entries[b.selected], true
+	}
+	return BrowseEntry{}, false
+}
+
+// open lists dir, highlighting the entry called name when it has one.
+func (b *Browser) open(dir, name string) {
+	entries, err := ListDir(dir, b.All)
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	b.Dir = dir
+	b.entries = entries
+	b.err = nil
+	b.selected = 0
+	b.top = 0
+
+	for i, entry := range entries {
+		if entry.Name == name {
+			b.selected = i
+		}
+	}
+	b.move(0)
+}
+
+// move moves the highlight by by entries, scrolling to keep it in view, and
+// has the preview of the file it lands on decoded once it rests there.
+func (b *Browser) move(by int) {
+	b.selected = min(max(b.selected+by, 0), max(len(b.entries)-1, 0))
+
+	_, rows := TerminalSize()
+	height := max(rows-2, 1)
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if b.selected >= b.top+height {
+		b.top = b.selected - height + 1
+	}
+
+	b.settled = time.After(BROWSE_PREVIEW_DELAY)
+}
+
+// highlighted is the path of the highlighted file, "" on a directory.
+func (b *Browser) highlighted() string {
+	entry, ok := b.current()
+	if !ok || entry.Dir {
+		return ""
+	}
+	return filepath.Join(b.Dir, entry.Name)
+}
+
+// load decodes the preview of the highlighted file unless it has one or
+// another is being decoded, which loads the next one once it's done.
+func (b *Browser) load() {
+	path := b.highlighted()
+	if path == "" || b.loading != "" || b.preview.Empty() {
+		return
+	}
+	if _, ok := b.previews[path]; ok {
+		return
+	}
+
+	b.loading = path
+	size := b.preview.Size()
+
+	go func() {
+		b.loaded <- decodePreview(path, size)
+	}()
+}
+
+// decodePreview decodes a frame a little way into path, fitted to size.
+func decodePreview(path string, size image.Point) browsePreview {
+	preview := browsePreview{path: path}
+
+	source, err := Sniff(path, true)
+	if err != nil {
+		preview.info = err.Error()
+		return preview
+	}
+	if source.Close != nil {
+		defer source.Close()
+	}
+
+	info := source.Info
+	var details []string
+	if info.Size.X > 0 {
+		details = append(details, fmt.Sprintf("%dx%d", info.Size.X, info.Size.Y))
+	}
+	if info.Duration > 0 {
+		details = append(details, FormatDuration(info.Duration))
+	}
+	preview.info = strings.Join(details, "  ")
+
+	playback := Playback{Source: source, Buffer: 1}
+	playback.Start(size, time.Duration(float64(info.Duration)*BROWSE_PREVIEW_AT))
+	defer playback.Stop()
+
+	select {
+	case frame, ok := <-playback.Frames():
+		if !ok {
+			return preview
+		}
+		preview.picture = image.NewNRGBA(image.Rectangle{Max: size})
+		if Fit(frame.Picture, preview.picture) == frame.Picture {
+			copy(preview.picture.Pix, frame.Picture.Pix)
+		}
+	case <-time.After(BROWSE_PREVIEW_TIMEOUT):
+	}
+
+	return preview
+}
+
+// layout sizes the list and the preview to the terminal and draws them
+// afresh.
+func (b *Browser) layout() {
+	cols, _ := TerminalSize()
+	b.grid = TerminalGrid(b.Renderer)
+	b.canvas = image.NewNRGBA(image.Rectangle{Max: b.grid})
+	b.drawn = nil
+
+	// the list takes the left half, the preview what's right of it
+	list := b.listWidth(cols)
+	b.preview = image.Rect(b.grid.X*(list+1)/max(cols, 1), 0, b.grid.X, b.grid.Y)
+
+	// previews at the old size no longer fit
+	clear(b.previews)
+	b.move(0)
+
+	ClearScreen()
+	b.draw()
+}
+
+func (b *Browser) listWidth(cols int) int {
+	return min(max(cols/2, 20), cols)
+}
+
+func (b *Browser) draw() {
+	cols, rows := TerminalSize()
+	list := b.listWidth(cols)
+
+	clear(b.canvas.Pix)
+	info := ""
+	if preview, ok := b.previews[b.highlighted()]; ok {
+		info = preview.info
+		if preview.picture != nil && preview.picture.Rect.Size() == b.preview.Size() {
+			copyInto(b.canvas, preview.picture, b.preview.Min)
+		}
+	}
+
+	if diff, ok := b.Renderer.(DiffRenderer); ok && b.drawn != nil {
+		diff.RenderDiff(&b.buffer, b.drawn, b.canvas)
+	} else {
+		b.Renderer.Render(&b.buffer, b.canvas)
+		b.drawn = image.NewNRGBA(b.canvas.Rect)
+	}
+	copy(b.drawn.Pix, b.canvas.Pix)
+
+	writeRow := func(row int, style, text string) {
+		fmt.Fprintf(&b.buffer, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", row, style, fitText(text, list))
+	}
+
+	writeRow(1, CAPTION_STYLE, " "+b.Dir)
+
+	height := max(rows-2, 1)
+	for i := 0; i < height; i++ {
+		index := b.top + i
+		if index >= len(b.entries) {
+			writeRow(i+2, CAPTION_STYLE, "")
+			continue
+		}
+
+		entry := b.entries[index]
+		style, name := CAPTION_STYLE, entry.Name
+		if entry.Dir {
+			style, name = BROWSE_DIR_STYLE, name+"/"
+		}
+		if index == b.selected {
+			style = BROWSE_SELECTED_STYLE
+		}
+		writeRow(i+2, style, " "+name)
+	}
+
+	switch {
+	case b.err != nil:
+		info = b.err.Error()
+	case len(b.entries) == 0 || len(b.entries) == 1 && b.entries[0].Name == "..":
+		info = "no media files here, a lists all files"
+	}
+
+	help := " enter play  backspace up  a all files  q quit"
+	if info != "" {
+		help += "  ·  " + info
+	}
+	fmt.Fprintf(&b.buffer, "\u001b[%d;1H\u001b[2K\u001b[%sm%s\u001b[0m", rows, CAPTION_STYLE, fitText(help, cols))
+
+	os.Stdout.Write(b.buffer.Bytes())
+	b.buffer.Reset()
+}
+
+// fitText cuts text to width cells, or pads it to them.
+func fitText(text string, width int) string {
+	if n := utf8.RuneCountInString(text); n < width {
+		return text + strings.Repeat(" ", width-n)
+	}
+	return string([]rune(text)[:max(width, 0)])
+}
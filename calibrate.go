@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+const (
+	// CALIBRATE_FPS is the frame rate a renderer has to keep up at the full
+	// size of the terminal for calibrate to recommend it without a cap.
+	CALIBRATE_FPS = 24
+	// CALIBRATE_HEADROOM is the part of what the terminal took in that
+	// the recommended --max-bandwidth leaves to playback.
+	CALIBRATE_HEADROOM = 0.8
+
+	CALIBRATE_HEADER = "renderer        size     fps  throughput"
+)
+
+// cursorPosition is the answer to DSR 6, which a terminal only gives once it
+// got through everything written before it.
+var cursorPosition = regexp.MustCompile(`\x1b\[[0-9]+;[0-9]+R`)
+
+// CalibrationResult is how fast the terminal took in the frames of one
+// renderer at one size.
+type CalibrationResult struct {
+	Renderer   string
+	Cols, Rows int
+	Frames     int
+	Bytes      int64
+	Elapsed    time.Duration
+}
+
+func (r CalibrationResult) Fps() float64 {
+	return float64(r.Frames) / r.Elapsed.Seconds()
+}
+
+// Rate is in bytes per second.
+func (r CalibrationResult) Rate() float64 {
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+func (r CalibrationResult) String() string {
+	return fmt.Sprintf("%-10s %9s %7.1f %9.1fMB/s", r.Renderer, fmt.Sprintf("%dx%d", r.Cols, r.Rows), r.Fps(), r.Rate()/1e6)
+}
+
+// drawCalibrationFrame draws frame n of what calibrate writes, hues drifting
+// across shades so that every cell has a color of its own and changes every
+// frame, like busy video.
+func drawCalibrationFrame(frame *image.NRGBA, n int) {
+	size := frame.Rect.Size()
+
+	for y := 0; y < size.Y; y++ {
+		shade := 0.3 + 0.7*float64(y)/float64(max(size.Y-1, 1))
+
+		for x := 0; x < size.X; x++ {
+			c := Hue(float64(x)/float64(size.X) + float64(n)/50)
+			c.R, c.G, c.B = uint8(float64(c.R)*shade), uint8(float64(c.G)*shade), uint8(float64(c.B)*shade)
+			frame.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+// syncTerminal waits for the terminal to get through what was written.
+func syncTerminal(timeout time.Duration) error {
+	_, err := QueryTerminalTimeout("\u001b[6n", cursorPosition, timeout)
+	return err
+}
+
+// MeasureLatency is the median time the terminal takes to answer a query
+// with nothing else to do.
+func MeasureLatency() (time.Duration, error) {
+	var times []time.Duration
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		if err := syncTerminal(500 * time.Millisecond); err != nil {
+			return 0, err
+		}
+		times = append(times, time.Since(start))
+	}
+
+	slices.Sort(times)
+	return times[len(times)/2], nil
+}
+
+// MeasureThroughput draws full frames with renderer on cols by rows of the
+// terminal for duration, as fast as it takes them, and measures how many it
+// got through including the time to draw the last.
+func MeasureThroughput(renderer Renderer, cols, rows int, duration time.Duration) (CalibrationResult, error) {
+	result := CalibrationResult{Renderer: renderer.Name(), Cols: cols, Rows: rows}
+
+	grid := renderer.Grid(cols, rows)
+	if grid.X <= 0 || grid.Y <= 0 {
+		return result, fmt.Errorf("%dx%d is too small", cols, rows)
+	}
+
+	picture := image.NewNRGBA(image.Rectangle{Max: grid})
+	var buffer bytes.Buffer
+
+	ClearScreen()
+	start := time.Now()
+
+	for n := 0; time.Since(start) < duration; n++ {
+		drawCalibrationFrame(picture, n)
+		renderer.Render(&buffer, picture)
+
+		if _, err := os.Stdout.Write(buffer.Bytes()); err != nil {
+			return result, err
+		}
+
+		result.Frames++
+		result.Bytes += int64(buffer.Len())
+		buffer.Reset()
+	}
+
+	// writes only wait for the terminal once its input buffer is full
+	if err := syncTerminal(10 * time.Second); err != nil {
+		return result, fmt.Errorf("the terminal didn't catch up: %w", err)
+	}
+	result.Elapsed = time.Since(start)
+
+	return result, nil
+}
+
+// supports is whether a terminal with caps can show the renderer of name.
+func (c Capabilities) supports(name string) bool {
+	switch name {
+	case "kitty":
+		return c.Kitty
+	case "sixel":
+		return c.Sixel
+	case "truecolor":
+		return c.Colors > 256
+	case "256":
+		return c.Colors >= 256
+	case "16":
+		return c.Colors >= 16 || c.Colors == 8
+	case "ascii":
+		return true
+	}
+
+	// registered renderers may not draw on the terminal at all
+	return false
+}
+
+// Recommend picks the best renderer that keeps up with CALIBRATE_FPS at the
+// full size in results, which come in the order of Renderers, or the fastest
+// with a cap on the bandwidth it may use, 0 for none.
+func Recommend(results []CalibrationResult, cols, rows int) (string, float64) {
+	var fastest *CalibrationResult
+
+	for i, result := range results {
+		if result.Cols != cols || result.Rows != rows {
+			continue
+		}
+		if result.Fps() >= CALIBRATE_FPS {
+			return result.Renderer, 0
+		}
+		if fastest == nil || result.Fps() > fastest.Fps() {
+			fastest = &results[i]
+		}
+	}
+
+	if fastest == nil {
+		return "", 0
+	}
+
+	return fastest.Renderer, fastest.Rate() * CALIBRATE_HEADROOM
+}
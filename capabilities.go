@@ -0,0 +1,231 @@
+package main
+
+import (
+	"image"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Capabilities are what the terminal is known to support, from the
+// environment, terminfo and, outside of multiplexers, its answers to queries.
+type Capabilities struct {
+	Kitty bool
+	Sixel bool
+	// Colors is 1<<24 for truecolor, otherwise 256, 16 or 0.
+	Colors int
+	// Multiplexer is "tmux" or "screen" when running inside one, and
+	// Passthrough set when it lets graphics through to the terminal it runs
+	// in, see WritePassthrough.
+	Multiplexer string
+	Passthrough bool
+	// Plain is set when output must not contain escape sequences at all.
+	Plain bool
+	// Synchronized is set when the terminal holds off drawing between the
+	// begin and end synchronized update sequences, so frames never tear.
+	Synchronized bool
+	// CellPixels is the size of a cell in pixels, when the terminal doesn't
+	// report it, see OverrideCapabilities.
+	CellPixels image.Point
+	// Renderer is the renderer auto picks and MaxBandwidth the default of
+	// --max-bandwidth, for terminals slower than they let on, as set by
+	// termtv calibrate --apply.
+	Renderer     string
+	MaxBandwidth float64
+}
+
+var deviceAttributes = regexp.MustCompile(`\x1b\[\?([0-9;]*)c`)
+
+func DetectCapabilities() Capabilities {
+	termName := os.Getenv("TERM")
+	colorterm := os.Getenv("COLORTERM")
+
+	// see https://no-color.org, a dumb terminal can't do more either
+	if os.Getenv("NO_COLOR") != "" || termName == "dumb" {
+		return Capabilities{Plain: true}
+	}
+
+	caps := Capabilities{Colors: TerminfoColors()}
+
+	switch {
+	case caps.Colors == 0 && termName != "":
+		caps.Colors = 16
+	}
+
+	if strings.Contains(termName, "256color") {
+		caps.Colors = max(caps.Colors, 256)
+	}
+
+	if colorterm == "truecolor" || colorterm == "24bit" {
+		caps.Colors = 1 << 24
+	}
+
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "ghostty":
+		caps.Colors = 1 << 24
+		caps.Synchronized = true
+	case "vscode":
+		caps.Colors = 1 << 24
+	}
+
+	if os.Getenv("KITTY_WINDOW_ID") != "" || termName == "xterm-kitty" {
+		caps.Kitty = true
+		caps.Colors = 1 << 24
+		caps.Synchronized = true
+	}
+
+	if os.Getenv("TMUX") != "" {
+		caps.Multiplexer = "tmux"
+	} else if os.Getenv("STY") != "" || strings.HasPrefix(termName, "screen") {
+		caps.Multiplexer = "screen"
+	}
+
+	// ask for kitty graphics support followed by the primary device
+	// attributes, which every terminal answers, so there is no need to wait
+	// out the timeout on terminals that ignore the first query
+	query := "\u001b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\u001b\\\u001b[c"
+
+	if caps.Multiplexer != "" {
+		// a multiplexer answers for itself, which tells whether it does
+		// sixel, but kitty graphics only get through to the terminal it
+		// runs in and only the environment says if that is kitty
+		caps.Passthrough = MultiplexerPassthrough(caps.Multiplexer)
+		caps.Kitty = caps.Kitty && caps.Passthrough
+		query = "\u001b[c"
+	}
+
+	answer, err := QueryTerminal(query, deviceAttributes)
+	if err != nil {
+		return caps
+	}
+
+	if strings.Contains(answer, "_Gi=31;OK") {
+		caps.Kitty = true
+	}
+
+	if match := deviceAttributes.FindStringSubmatch(answer); match != nil {
+		for _, attribute := range strings.Split(match[1], ";") {
+			if attribute == "4" {
+				caps.Sixel = true
+			}
+		}
+	}
+
+	return caps
+}
+
+// Best returns the best renderer the terminal supports.
+func (c Capabilities) Best() string {
+	switch {
+	case c.Renderer != "":
+		return c.Renderer
+	case c.Kitty:
+		return "kitty"
+	case c.Sixel:
+		return "sixel"
+	case c.Colors > 256:
+		return "truecolor"
+	case c.Colors >= 256:
+		return "256"
+	case c.Colors >= 16 || c.Colors == 8:
+		return "16"
+	}
+
+	return "ascii"
+}
+
+// MultiplexerPassthrough reports whether the multiplexer passes escape
+// sequences wrapped by WritePassthrough on. tmux only does with the
+// allow-passthrough option on.
+func MultiplexerPassthrough(multiplexer string) bool {
+	if multiplexer == "screen" {
+		return true
+	}
+
+	out, err := exec.Command("tmux", "show-options", "-Apv", "allow-passthrough").Output()
+	if err != nil {
+		return false
+	}
+
+	value := strings.TrimSpace(string(out))
+	return value == "on" || value == "all"
+}
+
+// TerminfoColors returns the number of colors terminfo has for the terminal,
+// or 0 when it is unknown.
+func TerminfoColors() int {
+	out, err := exec.Command("tput", "colors").Output()
+	if err != nil {
+		return 0
+	}
+
+	colors, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || colors < 0 {
+		return 0
+	}
+
+	return colors
+}
+
+// QueryTerminal writes query to the terminal and returns what it answers, up
+// to the first match of end. Fails when there is no terminal or it doesn't
+// answer in time.
+func QueryTerminal(query string, end *regexp.Regexp) (string, error) {
+	return QueryTerminalTimeout(query, end, 500*time.Millisecond)
+}
+
+// QueryTerminalTimeout is QueryTerminal waiting up to timeout, for a terminal
+// that has a lot of output to get through before it answers.
+func QueryTerminalTimeout(query string, end *regexp.Regexp, timeout time.Duration) (string, error) {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return "", os.ErrNotExist
+	}
+
+	tty, err := OpenTty()
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	// without deadlines a terminal that never answers would hang termtv
+	if err := tty.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	// Fd would put the tty back into blocking mode, ignoring the deadline
+	conn, err := tty.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var fd int
+	conn.Control(func(f uintptr) { fd = int(f) })
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+
+	if _, err := os.Stdout.WriteString(query); err != nil {
+		return "", err
+	}
+
+	var answer []byte
+	buf := make([]byte, 256)
+
+	for !end.Match(answer) {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return string(answer), err
+		}
+		answer = append(answer, buf[:n]...)
+	}
+
+	return string(answer), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// graphEscaper escapes what a filter graph takes as its own syntax.
+var graphEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+
+// filterValue quotes value as the option of a filter in a filter graph, so
+// paths with colons, commas or brackets in them come through whole.
+func filterValue(value string) string {
+	return graphEscaper.Replace("'" + strings.ReplaceAll(value, "'", `'\''`) + "'")
+}
+
+// ExtractCaptions decodes the CEA-608/708 closed captions carried in the
+// video of input, as broadcast captures have them, with ffmpeg's subcc
+// output of the movie source. The cues are added to the subtitles returned
+// as ffmpeg gets to them, which for files is well ahead of playback, until
+// ctx is done.
+func ExtractCaptions(ctx context.Context, input string) (*Subtitles, error) {
+	args := []string{"-f", "lavfi", "-i", "movie=" + filterValue(input) + "[out0+subcc]"}
+	args = append(args, LogArgs()...)
+	args = append(args, "-map", "0:s", "-c:s", "srt", "-flush_packets", "1", "-f", "srt", "pipe:1")
+
+	cmd := ChildCommand(ctx, ToolPath("ffmpeg"), args...)
+	cmd.Stderr = NewChildLog("captions: ffmpeg")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect stdout pipe for ffmpeg: %w", err)
+	}
+	if err := StartChild(cmd); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg command: %w", err)
+	}
+
+	subtitles := &Subtitles{}
+	go func() {
+		scanCues(out, subtitles.Add)
+		WaitChild(cmd)
+	}()
+
+	return subtitles, nil
+}
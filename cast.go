@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type CastHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+
+	// Termtv ties the cast back to what was played, players ignore it
+	Termtv struct {
+		Source string `json:"source"`
+	} `json:"termtv"`
+}
+
+// CastRecorder writes an asciicast v2 recording of everything written to it.
+// Markers carry the media time so a cast can be seeked by position in the
+// source, see MediaMarker and ChapterMarker.
+type CastRecorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	out    *bufio.Writer
+	start  time.Time
+	header CastHeader
+	// size is the bytes written so far
+	size int64
+}
+
+func NewCastRecorder(path string, source string, title string) (*CastRecorder, error) {
+	cols, rows := TerminalSize()
+	return NewCastRecorderSized(path, source, title, cols, rows)
+}
+
+// NewCastRecorderSized records a terminal of cols by rows other than this
+// one, like a client's.
+func NewCastRecorderSized(path string, source string, title string, cols, rows int) (*CastRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CastRecorder{
+		file:  file,
+		out:   bufio.NewWriter(file),
+		start: time.Now(),
+	}
+
+	r.header.Version = 2
+	r.header.Width, r.header.Height = cols, rows
+	r.header.Timestamp = r.start.Unix()
+	r.header.Title = title
+	r.header.Termtv.Source = source
+
+	line, err := json.Marshal(r.header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r.out.Write(line)
+	r.out.WriteByte('\n')
+	r.size = int64(len(line)) + 1
+
+	return r, nil
+}
+
+func (r *CastRecorder) event(code string, data string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	line, err := json.Marshal([]any{time.Since(r.start).Seconds(), code, data})
+	if err != nil {
+		return err
+	}
+
+	r.size += int64(len(line)) + 1
+	r.out.Write(line)
+	return r.out.WriteByte('\n')
+}
+
+func (r *CastRecorder) Write(p []byte) (int, error) {
+	if err := r.event("o", string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *CastRecorder) Resize(cols int, rows int) error {
+	return r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Size is how many bytes the cast has so far.
+func (r *CastRecorder) Size() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.size
+}
+
+func (r *CastRecorder) Marker(label string) error {
+	return r.event("m", label)
+}
+
+func MediaMarker(position time.Duration) string {
+	return fmt.Sprintf("media=%.3f", position.Seconds())
+}
+
+func ChapterMarker(chapter Chapter, position time.Duration) string {
+	return fmt.Sprintf("chapter=%s %s", chapter.Title, MediaMarker(position))
+}
+
+func (r *CastRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.out.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+
+	return r.file.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CHILD_LOG_ERRORS is how many of a child's last error lines are kept, to
+// say why it failed.
+const CHILD_LOG_ERRORS = 5
+
+// verboseLog, set by --verbose, gets every line the children log.
+var verboseLog struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// verboseFlag is --verbose, which streams the children's logs to stderr, or
+// with --verbose=path to a file.
+type verboseFlag struct{}
+
+func (verboseFlag) String() string   { return "" }
+func (verboseFlag) IsBoolFlag() bool { return true }
+
+func (verboseFlag) Set(value string) error {
+	switch value {
+	case "false":
+		verboseLog.w = nil
+	case "true":
+		verboseLog.w = os.Stderr
+	default:
+		file, err := os.OpenFile(value, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		verboseLog.w = file
+	}
+	return nil
+}
+
+func RegisterVerboseFlag(flags *flag.FlagSet) {
+	flags.Var(verboseFlag{}, "verbose", "stream the logs of ffmpeg and the other tools to stderr, or with --verbose=path to a file")
+}
+
+// LogArgs set the log level of ffmpeg, ffprobe and ffplay: errors only,
+// unless --verbose wants more. Lines are tagged with their level for
+// ChildLog to tell errors by.
+func LogArgs() []string {
+	level := "error"
+	if verboseLog.w != nil {
+		level = "info"
+	}
+	return []string{"-loglevel", "level+" + level, "-hide_banner"}
+}
+
+// ChildLog takes the log of a child, line by line, keeping its last errors
+// and copying it all to the --verbose log. It's an io.Writer for
+// exec.Cmd.Stderr.
+type ChildLog struct {
+	Name string
+
+	mu      sync.Mutex
+	partial []byte
+	errors  []string
+}
+
+func NewChildLog(name string) *ChildLog {
+	return &ChildLog{Name: name}
+}
+
+func (l *ChildLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.partial = append(l.partial, p...)
+	var lines []string
+	for {
+		end := bytes.IndexAny(l.partial, "\r\n")
+		if end < 0 {
+			break
+		}
+		lines = append(lines, string(l.partial[:end]))
+		l.partial = l.partial[end+1:]
+	}
+	l.mu.Unlock()
+
+	for _, line := range lines {
+		l.Add(line)
+	}
+	return len(p), nil
+}
+
+// Add takes a line of the log.
+func (l *ChildLog) Add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	verboseLog.Lock()
+	if verboseLog.w != nil {
+		fmt.Fprintf(verboseLog.w, "%s: %s\n", l.Name, line)
+	}
+	verboseLog.Unlock()
+
+	if !isErrorLine(line) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, line)
+	if len(l.errors) > CHILD_LOG_ERRORS {
+		l.errors = l.errors[1:]
+	}
+}
+
+// isErrorLine reports whether a line tagged by "-loglevel level+..." is an
+// error. Tools that don't tag their lines, like yt-dlp, say ERROR.
+func isErrorLine(line string) bool {
+	for _, tag := range []string{"[error]", "[fatal]", "[panic]", "ERROR:"} {
+		if strings.Contains(line, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the last error lines, "" if there were none.
+func (l *ChildLog) Errors() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return strings.Join(l.errors, "\n")
+}
+
+// Wrap adds the last errors to err, the exit of the child, so it says why.
+func (l *ChildLog) Wrap(err error) error {
+	if errors := l.Errors(); errors != "" {
+		return fmt.Errorf("%s: %w\n%s", l.Name, err, errors)
+	}
+	return fmt.Errorf("%s: %w", l.Name, err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import "strings"
+
+// HD_HEIGHT is the height from which video that doesn't say what YUV matrix
+// it uses is taken to be BT.709, as players take it, rather than BT.601.
+const HD_HEIGHT = 720
+
+// ColorInfo is how the pixels of a video map to colors, as ffprobe names it.
+// Fields the source doesn't say are empty.
+type ColorInfo struct {
+	// Space is the YUV matrix, like bt709 or smpte170m
+	Space string
+	// Range is tv for limited YUV, 16 to 235, or pc for full
+	Range string
+	// Transfer and Primaries tell HDR, see HDR
+	Transfer  string
+	Primaries string
+}
+
+// scaleMatrices are the in_color_matrix of ffmpeg's scale filter for
+// ffprobe's color spaces. RGB sources need none.
+var scaleMatrices = map[string]string{
+	"bt709":     "bt709",
+	"smpte170m": "smpte170m",
+	"bt470bg":   "bt470",
+	"smpte240m": "smpte240m",
+	"fcc":       "fcc",
+	"bt2020nc":  "bt2020",
+	"bt2020c":   "bt2020",
+}
+
+// ProbeColor reads the ColorInfo of a video stream from ffprobe's fields.
+// HD video that doesn't say its matrix gets BT.709.
+func ProbeColor(fields map[string]string, height int) ColorInfo {
+	color := ColorInfo{
+		Space:     fields["color_space"],
+		Range:     fields["color_range"],
+		Transfer:  fields["color_transfer"],
+		Primaries: fields["color_primaries"],
+	}
+
+	if color.Space == "unknown" || color.Space == "" {
+		color.Space = ""
+		if height >= HD_HEIGHT {
+			color.Space = "bt709"
+		}
+	}
+	if color.Range != "tv" && color.Range != "pc" {
+		color.Range = ""
+	}
+	if color.Transfer == "unknown" {
+		color.Transfer = ""
+	}
+	if color.Primaries == "unknown" {
+		color.Primaries = ""
+	}
+
+	return color
+}
+
+// ScaleOptions are the options of ffmpeg's scale filter converting from c
+// to full range RGB, "" when there is nothing to go by and ffmpeg's own
+// guess has to do.
+func (c ColorInfo) ScaleOptions() string {
+	var options []string
+
+	if matrix, ok := scaleMatrices[c.Space]; ok {
+		options = append(options, "in_color_matrix="+matrix)
+	}
+	if c.Range != "" {
+		options = append(options, "in_range="+c.Range)
+	}
+
+	if len(options) == 0 {
+		return ""
+	}
+
+	options = append(options, "out_range=pc")
+	return strings.Join(options, ":")
+}
+
+// YuvMatrix holds the factors of a YUV to RGB matrix in 16.16 fixed point:
+// red from V, green from U and V, blue from U.
+type YuvMatrix struct {
+	rv, gu, gv, bu int32
+}
+
+var (
+	BT601 = YuvMatrix{91881, 22554, 46802, 116130}
+	BT709 = YuvMatrix{103206, 12276, 30679, 121609}
+)
+
+// MatrixFor is the matrix for video of height that doesn't say which it
+// uses.
+func MatrixFor(height int) YuvMatrix {
+	if height >= HD_HEIGHT {
+		return BT709
+	}
+	return BT601
+}
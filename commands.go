@@ -0,0 +1,1761 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+type Subcommand struct {
+	Usage       string
+	Description string
+	Run         func(args []string)
+}
+
+var commands map[string]*Subcommand
+
+func init() {
+	commands = map[string]*Subcommand{
+		"play": {
+			"play [flags] <path|url|dir|->...",
+			"play files, urls, directories or stdin (the default command)",
+			playCommand,
+		},
+		"browse": {
+			"browse [flags] [dir]",
+			"pick a file to play from a directory (the default without arguments)",
+			browseCommand,
+		},
+		"search": {
+			"search [flags] <query>",
+			"search YouTube and pick a video to play",
+			searchCommand,
+		},
+		"add": {
+			"add [flags] <path|url|dir>...",
+			"add to the playlist of the termtv that's playing, or play",
+			addCommand,
+		},
+		"watch": {
+			"watch [flags] <dir>",
+			"play media files as they appear in a directory, idle in between",
+			watchCommand,
+		},
+		"replay": {
+			"replay [flags] <file.ansi>",
+			"play back what --output wrote, as it was timed",
+			replayCommand,
+		},
+		"record": {
+			"record [flags] -o out.cast <path|url|dir|->...",
+			"play while recording an asciinema cast",
+			recordCommand,
+		},
+		"info": {
+			"info [flags] <path|url|dir>...",
+			"print what termtv knows about a source",
+			infoCommand,
+		},
+		"mosaic": {
+			"mosaic [flags] <path|url|dir>...",
+			"play several sources at once in a grid of tiles",
+			mosaicCommand,
+		},
+		"compare": {
+			"compare [flags] <a> <b>",
+			"play two sources in lockstep side by side, to compare encodes",
+			compareCommand,
+		},
+		"export-gif": {
+			"export-gif [flags] -o out.gif <path|url>",
+			"render a source as termtv draws it into an animated gif",
+			exportGifCommand,
+		},
+		"export-frame": {
+			"export-frame [flags] -o out.html|out.svg <path|url>",
+			"render a frame as termtv draws it into html or svg",
+			exportFrameCommand,
+		},
+		"headless-encode": {
+			"headless-encode [flags] --listen addr <path|url|dir>...",
+			"decode and scale sources for termtv connect clients",
+			headlessEncodeCommand,
+		},
+		"connect": {
+			"connect [flags] host:port",
+			"watch what a headless-encode server sends",
+			connectCommand,
+		},
+		"frames": {
+			"frames [flags] <path|url|->",
+			"print the metadata of every frame as json lines",
+			framesCommand,
+		},
+		"bench": {
+			"bench [flags] <path|url>",
+			"measure how fast each stage of the pipeline runs",
+			benchCommand,
+		},
+		"calibrate": {
+			"calibrate [flags]",
+			"measure how fast the terminal takes output and recommend settings",
+			calibrateCommand,
+		},
+		"keys": {
+			"keys [flags]",
+			"list the current key bindings",
+			keysCommand,
+		},
+		"help": {
+			"help",
+			"show this help",
+			func([]string) { Usage() },
+		},
+	}
+}
+
+func Usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: termtv [command] [flags] <path|url|dir|->...")
+	fmt.Fprintln(os.Stderr)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].Description)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run termtv <command> -h for the flags of a command.")
+}
+
+func NewFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: termtv %s\n\n", commands[name].Usage)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// ParseArgs parses flags mixed in with positional arguments, which the flag
+// package would otherwise stop at, and returns the positional ones.
+func ParseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+type PlayOptions struct {
+	Path string
+	Url  string
+	// Source names a built-in source, only testpattern for now.
+	Source      string
+	Args        []string
+	ConfigPath  string
+	FfmpegScale bool
+	Hooks       Hooks
+	MinSize     image.Point
+	Buffer      int
+	Renderer    string
+	SkipIntro   bool
+	// ReplayLength is how much of what was shown the replay key shows
+	// again, 0 to keep nothing.
+	ReplayLength time.Duration
+	// MaxRetries is how many times a dropped network source is
+	// reconnected in a row.
+	MaxRetries int
+	// LrcPath are the lyrics to show, otherwise those next to a file are.
+	LrcPath string
+	// Smooth and SmoothThreshold set up a Smoother, when either is set.
+	Smooth          float64
+	SmoothThreshold int
+	// FalseColor maps brightness to a palette, after smoothing.
+	FalseColor FrameFilter
+	// Presets are the looks of --preset.
+	Presets []FrameFilter
+	// Pip is the source of --pip, played in an inset.
+	Pip string
+	// Watermark is the image of --watermark, blended in after the presets.
+	WatermarkPath string
+	Watermark     Watermark
+	// Accessibility is the filter of --accessibility, applied last.
+	Accessibility FrameFilter
+	// MaxBandwidth is in bytes per second, 0 for no limit.
+	MaxBandwidth float64
+	// PprofAddr is where to serve profiles of playback, if anywhere.
+	PprofAddr string
+	// MetricsAddr is where headless-encode serves its ServerMetrics, if
+	// anywhere.
+	MetricsAddr string
+	// Overlay is the text and clock of --overlay-text and --overlay-clock.
+	Overlay TextOverlay
+	// MaxMemory bounds the frames kept in memory, see Player.MaxMemory.
+	MaxMemory int64
+	// Center draws the picture in the middle of the terminal, see
+	// Player.Center.
+	Center bool
+	// Background is the color around the picture, nil for the terminal's
+	// own background, see Backdrop.
+	Background *color.NRGBA
+	// AudioDelay is the AudioPlayer.Delay to start with.
+	AudioDelay time.Duration
+	// Volume is the AudioPlayer.Volume to start with.
+	Volume int
+	// NoMouse leaves the mouse to the terminal, see Player.Mouse.
+	NoMouse bool
+	// SubPath are the subtitles to show, otherwise those next to a file
+	// are, SubDelay after their times and styled by SubStyle.
+	SubPath  string
+	SubDelay time.Duration
+	SubStyle SubtitleStyle
+	// Interpolate doubles the frame rate with blends, see
+	// Player.Interpolate.
+	Interpolate bool
+	// Captions shows the closed captions in the video in place of
+	// subtitles, see ExtractCaptions.
+	Captions bool
+	// NoTitle leaves out the title line, see Player.TitleLine.
+	NoTitle bool
+	// TUI lays the playlist, a volume slider and key help out around the
+	// picture, see Shell.
+	TUI bool
+	// ClipCenter shows the middle of the picture rather than its top left
+	// while the terminal is smaller than it, see Viewport.
+	ClipCenter bool
+	// NoAdaptive keeps the picture at the size of the terminal even when
+	// the terminal can't keep up, see Player.Adaptive.
+	NoAdaptive bool
+	// Screensaver plays the items shuffled over and over with nothing over
+	// the picture, until any key is pressed.
+	Screensaver bool
+	// Watch is a directory to play new media files from as they appear,
+	// showing Idle in between, until the user quits.
+	Watch string
+	Idle  string
+	// WaitQueue waits for items from termtv add once the playlist is done,
+	// showing Idle, rather than quitting.
+	WaitQueue bool
+	// KeepOpen holds the last frame once the playlist is done, until a key
+	// is pressed.
+	KeepOpen bool
+	// OutputPath is where --output writes the escape stream, with its
+	// timing, see AnsiWriter.
+	OutputPath string
+	// StatsJsonPath is where to write the Stats, as well as printing them.
+	StatsJsonPath string
+
+	RecordPath     string
+	MarkerInterval time.Duration
+
+	// Compressions of the remote stream, most preferred first.
+	Compressions []string
+	ResumeWindow time.Duration
+	// MaxClients and MaxClientsPerIP cap the clients of headless-encode, 0
+	// for no limit.
+	MaxClients      int
+	MaxClientsPerIP int
+	// RecordDir is where headless-encode records each client's session,
+	// see SessionRecorder.
+	RecordDir     string
+	RecordMaxSize int64
+	RecordKeep    int
+	// TLS is what headless-encode serves over, nil for plain TCP, and Token
+	// what clients and metrics scrapes need to send, if anything.
+	TLS   *tls.Config
+	Token string
+}
+
+func (o *PlayOptions) Register(flags *flag.FlagSet) {
+	flags.StringVar(&o.Path, "path", "", "path to video file")
+	flags.StringVar(&o.Url, "url", "", "url of a video source")
+	flags.StringVar(&o.Source, "source", "", "built-in source to play: testpattern")
+	flags.StringVar(&o.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	RegisterInputFlags(flags)
+	flags.BoolVar(&NoVideo, "no-video", false, "show a visualization of the audio instead of the video")
+	flags.DurationVar(&o.ReplayLength, "replay", 10*time.Second, "how much of what was shown to keep in memory for instant replay, 0 to keep nothing")
+	flags.StringVar(&o.LrcPath, "lrc", "", "path of an LRC file of lyrics to show under the picture, by default the .lrc next to a file")
+	flags.Func("smooth", "blend each cell with its last color by a strength from 0 to 1, against flicker", func(value string) error {
+		strength, err := strconv.ParseFloat(value, 64)
+		if err != nil || strength < 0 || strength >= 1 {
+			return fmt.Errorf("invalid strength %s, expected at least 0 and less than 1", value)
+		}
+		o.Smooth = strength
+		return nil
+	})
+	flags.IntVar(&o.SmoothThreshold, "smooth-threshold", 0, "only change a cell once a channel of its color changes by more than this, from 0 to 255")
+	flags.Func("false-color", "show brightness in a palette, for IR cameras and depth maps: "+strings.Join(FalseColors, ", "), func(value string) (err error) {
+		o.FalseColor, err = ParseFalseColor(value)
+		return err
+	})
+	flags.Func("preset", "looks to give the picture, comma separated: "+strings.Join(Presets, ", "), func(value string) (err error) {
+		o.Presets, err = ParsePresets(value)
+		return err
+	})
+	flags.StringVar(&o.WatermarkPath, "watermark", "", "path of an image, like a png logo, to blend into a corner of the picture")
+	o.Watermark.Size = WATERMARK_SIZE
+	flags.Float64Var(&o.Watermark.Size, "watermark-size", o.Watermark.Size, "width of the watermark as a part of the picture's")
+	flags.Float64Var(&o.Watermark.Opacity, "watermark-opacity", 0.8, "opacity of the watermark, from 0 to 1")
+	flags.Func("watermark-corner", "corner of the watermark: "+strings.Join(Corners, ", ")+" (default bottom-right)", func(value string) (err error) {
+		o.Watermark.Corner, err = ParseCorner(value)
+		return err
+	})
+	flags.Func("accessibility", "simulate color blindness or raise contrast: "+strings.Join(AccessibilityModes, ", "), func(value string) (err error) {
+		o.Accessibility, err = ParseAccessibility(value)
+		return err
+	})
+	flags.StringVar(&o.Overlay.Text, "overlay-text", "", "text to keep in a corner over the picture, like the name of a display")
+	flags.BoolVar(&o.Overlay.Clock, "overlay-clock", false, "keep a clock in a corner over the picture")
+	o.Overlay.Corner = 3
+	flags.Func("overlay-corner", "corner of --overlay-text and --overlay-clock: "+strings.Join(Corners, ", ")+" (default top-right)", func(value string) (err error) {
+		o.Overlay.Corner, err = ParseCorner(value)
+		return err
+	})
+	flags.BoolVar(&o.KeepOpen, "keep-open", false, "hold the last frame once everything played, until a key is pressed, rather than quitting")
+	flags.BoolVar(&o.Screensaver, "screensaver", false, "play shuffled and on loop with nothing over the picture, until any key clears the screen and quits")
+	flags.StringVar(&o.Pip, "pip", "", "path or url of a source to play in an inset in a corner of the picture, like a webcam")
+	flags.BoolVar(&o.FfmpegScale, "ffmpeg-scale", false, "scale video files in ffmpeg instead of in Go (url sources always are)")
+	RegisterHwaccelFlag(flags)
+	RegisterTonemapFlag(flags)
+	flags.StringVar(&o.Hooks.OnStart, "on-start", "", "shell command to run when playback starts")
+	flags.StringVar(&o.Hooks.OnEnd, "on-end", "", "shell command to run when playback ends")
+	flags.StringVar(&o.Hooks.OnError, "on-error", "", "shell command to run when playback fails")
+
+	flags.BoolVar(&o.SkipIntro, "skip-intro", false, "skip intros that the episodes played share, found by their audio")
+	flags.Func("max-bandwidth", "cap output to a rate like 200KB/s by dropping frames, quantizing colors and only redrawing changes", func(value string) (err error) {
+		o.MaxBandwidth, err = ParseBandwidth(value)
+		return err
+	})
+	flags.BoolVar(&o.Center, "center", true, "draw the picture in the middle of the terminal rather than in its top left corner, --center=false to not")
+	flags.Func("background", "color like #1e1e2e to fill the bars and the rest of the terminal around the picture with (default the terminal's background)", func(value string) error {
+		c, err := ParseColor(value)
+		o.Background = &c
+		return err
+	})
+	flags.DurationVar(&o.AudioDelay, "audio-delay", 0, "play the sound later than the picture by this much, like 150ms, or earlier when negative; + and - change it while playing")
+	flags.StringVar(&o.SubPath, "sub", "", "path of an SRT or WebVTT file of subtitles to show, by default the .srt or .vtt next to a file")
+	flags.DurationVar(&o.SubDelay, "sub-delay", 0, "show subtitles later than their times by this much, like 500ms, or earlier when negative; x and z change it while playing")
+	flags.BoolVar(&o.Captions, "cc", false, "show the CEA-608/708 closed captions carried in the video, as broadcast captures have them, in place of subtitles")
+	o.Volume = 100
+	o.SubStyle = SubtitleStyle{Scale: 1, Color: SUBTITLE_COLOR}
+	flags.Func("sub-scale", "height of a line of subtitles in rows; above 1 they're drawn into the picture in a built-in font (default 1)", func(value string) error {
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil || scale <= 0 {
+			return fmt.Errorf("invalid scale %s, expected more than 0", value)
+		}
+		o.SubStyle.Scale = scale
+		return nil
+	})
+	flags.Func("sub-color", "color like #ffff00 of the subtitles (default #ffffff)", func(value string) error {
+		c, err := ParseColor(value)
+		o.SubStyle.Color = c
+		return err
+	})
+	flags.Func("sub-position", "where to show subtitles: bottom or top (default bottom)", func(value string) error {
+		switch value {
+		case "bottom", "top":
+			o.SubStyle.Top = value == "top"
+			return nil
+		}
+		return fmt.Errorf("invalid position %s, expected bottom or top", value)
+	})
+	flags.BoolVar(&o.Interpolate, "interpolate", false, "show a blend of each frame and the next between them, for smoother low-fps GIFs and stop motion at the cost of CPU")
+	flags.Func("volume", "volume of the sound from 0 to 100, 9 and 0 or the mouse wheel change it while playing (default 100)", func(value string) error {
+		volume, err := strconv.Atoi(value)
+		if err != nil || volume < 0 || volume > 100 {
+			return fmt.Errorf("invalid volume %s, expected 0 to 100", value)
+		}
+		o.Volume = volume
+		return nil
+	})
+	flags.BoolVar(&o.NoMouse, "no-mouse", false, "leave the mouse to the terminal for selecting text, rather than clicking the progress bar and pause button")
+	flags.BoolVar(&o.TUI, "tui", false, "show the playlist, a volume slider and the keys around the picture, with the title row over it")
+	flags.BoolVar(&o.NoTitle, "no-title", false, "use the top row for the picture too, rather than for the title, size and codec of what's playing")
+	flags.BoolVar(&o.ClipCenter, "clip-center", false, "show the middle of the picture rather than its top left while the terminal is smaller than it")
+	flags.BoolVar(&o.NoAdaptive, "no-adaptive", false, "keep the picture at the size of the terminal when the terminal can't keep up, rather than shrinking it")
+	flags.IntVar(&o.MaxRetries, "max-retries", 5, "times in a row to reconnect a network source that dropped or stalled before giving up")
+	flags.Func("timeshift", "spool live streams to disk, up to a size like 500MB, to pause them and seek back", func(value string) error {
+		size, err := ParseByteSize(value)
+		TimeshiftSize = size
+		return err
+	})
+	flags.StringVar(&o.PprofAddr, "pprof", "", "loopback address like localhost:6060 to serve net/http/pprof profiles on while playing")
+	flags.StringVar(&o.OutputPath, "output", "", "path of an .ansi file to write what's drawn to, with its timing, for termtv replay")
+	flags.StringVar(&o.StatsJsonPath, "stats-json", "", "path to write playback statistics to as json when playback ends")
+	flags.IntVar(&o.Buffer, "buffer", 8, "frames to let ffmpeg decode ahead, smoothing over slow frames")
+	flags.Func("max-memory", "bound the frames decoded ahead and kept for replay to a size like 64MB, and have Go keep to it", func(value string) (err error) {
+		o.MaxMemory, err = ParseByteSize(value)
+		return err
+	})
+	flags.StringVar(&o.Renderer, "renderer", "auto", "renderer to draw with: auto, "+strings.Join(Renderers, ", "))
+	flags.BoolVar(&AdaptivePalette, "adaptive-palette", false, "have the 256 color renderer redefine the terminal's palette to fit each frame, for terminals that allow it")
+
+	o.MinSize = image.Pt(20, 6)
+	flags.Func("min-size", "smallest terminal to play in as COLSxROWS (default 20x6)", func(value string) (err error) {
+		o.MinSize, err = ParseSize(value)
+		return err
+	})
+}
+
+// Filters are the FrameFilters the options ask for.
+func (o *PlayOptions) Filters() []FrameFilter {
+	var filters []FrameFilter
+
+	if o.Smooth > 0 || o.SmoothThreshold > 0 {
+		filters = append(filters, &Smoother{Strength: o.Smooth, Threshold: o.SmoothThreshold})
+	}
+
+	if o.FalseColor != nil {
+		filters = append(filters, o.FalseColor)
+	}
+
+	filters = append(filters, o.Presets...)
+
+	if o.WatermarkPath != "" {
+		if !o.Watermark.Loaded() {
+			if err := o.Watermark.Load(o.WatermarkPath); err != nil {
+				Fatalf("Failed to load watermark: %v", err)
+			}
+		}
+		filters = append(filters, &o.Watermark)
+	}
+
+	if o.Accessibility != nil {
+		filters = append(filters, o.Accessibility)
+	}
+
+	return filters
+}
+
+// ParseColor parses colors written as #RRGGBB or #RGB, the # optional.
+func ParseColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %s, expected #RRGGBB", value)
+	}
+
+	return color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}, nil
+}
+
+// ParseSize parses sizes written as WIDTHxHEIGHT.
+func ParseSize(value string) (image.Point, error) {
+	width, height, found := strings.Cut(value, "x")
+	if !found {
+		return image.Point{}, fmt.Errorf("expected WIDTHxHEIGHT")
+	}
+
+	x, errX := strconv.Atoi(width)
+	y, errY := strconv.Atoi(height)
+	if errX != nil || errY != nil || x < 0 || y < 0 {
+		return image.Point{}, fmt.Errorf("invalid size %s", value)
+	}
+
+	return image.Pt(x, y), nil
+}
+
+// Parse parses args into the list of things to play: --path, --url and any
+// positional arguments, or stdin when there are none and it is piped.
+func (o *PlayOptions) Parse(flags *flag.FlagSet, args []string) {
+	positional := ParseArgs(flags, args)
+
+	if o.Path != "" {
+		o.Args = append(o.Args, o.Path)
+	}
+	if o.Url != "" {
+		o.Args = append(o.Args, o.Url)
+	}
+	if o.Source != "" {
+		if o.Source != "testpattern" {
+			Fatalf("Unknown source %s, expected testpattern", o.Source)
+		}
+		o.Args = append(o.Args, TESTPATTERN)
+	}
+	o.Args = append(o.Args, positional...)
+
+	if len(o.Args) == 0 && !term.IsTerminal(int(os.Stdin.Fd())) {
+		o.Args = []string{"-"}
+	}
+
+	if len(o.Args) == 0 {
+		log.Println("Incorrect usage")
+		flags.Usage()
+		os.Exit(1)
+	}
+}
+
+func (o *PlayOptions) LoadConfig() Config {
+	config, err := LoadConfig(o.ConfigPath)
+	if err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := RegisterConfigExtractors(config); err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := ApplyToolConfig(config); err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	return config
+}
+
+// Items expands directories among the arguments into the files to play.
+func (o *PlayOptions) Items() []string {
+	var items []string
+
+	for _, arg := range o.Args {
+		expanded, err := Expand(arg)
+		if err != nil {
+			Fatalf("Failed to read %s: %v", arg, err)
+		}
+		items = append(items, expanded...)
+	}
+
+	return items
+}
+
+func LoadKeyBindings(config Config) KeyBindings {
+	bindings := DefaultKeyBindings()
+
+	if err := bindings.Apply(config["keys"]); err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	return bindings
+}
+
+// SelectRenderer returns the named renderer, or the best one the terminal
+// supports for auto.
+func SelectRenderer(name string) Renderer {
+	caps, err := OverrideCapabilities(DetectCapabilities(), DefaultTerminalsPath())
+	if err != nil {
+		Fatalf("Failed to load terminal overrides: %v", err)
+	}
+
+	if name == "auto" {
+		name = caps.Best()
+	}
+
+	renderer, err := NewRenderer(name, caps)
+	if err != nil {
+		Fatalf("Failed to select renderer: %v", err)
+	}
+
+	plainOutput = caps.Plain && name == "ascii"
+	terminalMaxBandwidth = caps.MaxBandwidth
+
+	return renderer
+}
+
+func playCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("play")
+	options.Register(flags)
+	// watch takes --idle as the source to loop in between
+	flags.BoolVar(&options.WaitQueue, "idle", false, "clear the screen and wait for items from termtv add once everything played, rather than quitting")
+	options.Parse(flags, args)
+
+	Play(options)
+}
+
+// browseCommand lets the user pick files to play in a Browser, coming back
+// to it after each.
+func browseCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("browse")
+	options.Register(flags)
+	positional := ParseArgs(flags, args)
+
+	dir := "."
+	if len(positional) > 0 {
+		dir = positional[0]
+	}
+
+	renderer := SelectRenderer(options.Renderer)
+
+	for {
+		path, picked := Browse(dir, renderer)
+		if !picked {
+			break
+		}
+
+		dir = filepath.Dir(path)
+		options.Args = []string{path}
+		Play(options)
+	}
+
+	KillChildren()
+}
+
+// searchCommand lists the videos a search finds to pick from, coming back to
+// the list after each one played.
+func searchCommand(args []string) {
+	var options PlayOptions
+	var n int
+
+	flags := NewFlagSet("search")
+	options.Register(flags)
+	flags.IntVar(&n, "results", 20, "how many results to list")
+	query := strings.Join(ParseArgs(flags, args), " ")
+
+	if query == "" {
+		log.Println("Missing query")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	options.LoadConfig()
+
+	results, err := Search(query, n)
+	if err != nil {
+		Fatalf("Failed to search: %v", err)
+	}
+	if len(results) == 0 {
+		Fatalf("Nothing found for %q", query)
+	}
+
+	selected := 0
+	for {
+		var picked bool
+		selected, picked = PickResult(query, results, selected)
+		if !picked {
+			break
+		}
+
+		options.Args = []string{results[selected].Url}
+		Play(options)
+	}
+
+	KillChildren()
+}
+
+// addCommand queues items on the termtv that's playing, or plays them when
+// none is.
+func addCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("add")
+	options.Register(flags)
+	options.Parse(flags, args)
+
+	items := options.Items()
+
+	err := Enqueue(items)
+	if errors.Is(err, ErrNoQueue) {
+		Play(options)
+		return
+	}
+	if err != nil {
+		Fatalf("Failed to queue: %v", err)
+	}
+
+	for _, item := range items {
+		fmt.Printf("queued %s\n", item)
+	}
+}
+
+// waitForKey returns once a key is pressed or termtv is interrupted.
+func waitForKey(keys <-chan string) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	select {
+	case <-keys:
+	case <-interrupt:
+	}
+}
+
+// watchCommand plays what appears in a directory, for terminals that show
+// whatever other processes drop into it.
+func watchCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("watch")
+	options.Register(flags)
+	flags.StringVar(&options.Idle, "idle", "", "path or url of a source to loop while there's nothing new to play, instead of a black screen")
+	positional := ParseArgs(flags, args)
+
+	if len(positional) != 1 {
+		log.Println("Expected one directory to watch")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	options.Watch = positional[0]
+	if stat, err := os.Stat(options.Watch); err != nil || !stat.IsDir() {
+		Fatalf("Failed to watch %s: not a directory", options.Watch)
+	}
+
+	Play(options)
+}
+
+// replayCommand plays back an .ansi file of --output, which needs no ffmpeg
+// as it's what was drawn.
+func replayCommand(args []string) {
+	var configPath string
+	var speed float64
+
+	flags := NewFlagSet("replay")
+	flags.StringVar(&configPath, "config", DefaultConfigPath(), "path to config file")
+	flags.Float64Var(&speed, "speed", 1, "how many times as fast to play back")
+	positional := ParseArgs(flags, args)
+
+	if len(positional) != 1 || speed <= 0 {
+		log.Println("Expected one .ansi file and a speed above 0")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	file, err := os.Open(positional[0])
+	if err != nil {
+		Fatalf("Failed to open %s: %v", positional[0], err)
+	}
+	defer file.Close()
+
+	ClearScreen()
+	err = ReplayAnsi(file, speed, LoadKeyBindings(config))
+	RestoreTerminal()
+
+	if err != nil {
+		Fatalf("Failed to replay %s: %v", positional[0], err)
+	}
+}
+
+func recordCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("record")
+	options.Register(flags)
+	flags.StringVar(&options.RecordPath, "o", "", "path of the asciinema cast to write")
+	flags.DurationVar(&options.MarkerInterval, "markers", 10*time.Second, "interval of media time markers, 0 to only mark seeks and chapters")
+	options.Parse(flags, args)
+
+	if options.RecordPath == "" {
+		log.Println("Missing -o")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	Play(options)
+}
+
+func Play(options PlayOptions) {
+	KillChildrenOnHangup()
+
+	if options.PprofAddr != "" {
+		if err := StartPprof(options.PprofAddr); err != nil {
+			Fatalf("Failed to serve pprof: %v", err)
+		}
+	}
+
+	if options.MaxMemory > 0 {
+		// the garbage collector works harder near it rather than let the
+		// heap grow past it
+		debug.SetMemoryLimit(options.MaxMemory)
+	}
+
+	config := options.LoadConfig()
+	bindings := LoadKeyBindings(config)
+	renderer := SelectRenderer(options.Renderer)
+	if options.MaxBandwidth == 0 {
+		options.MaxBandwidth = terminalMaxBandwidth
+	}
+
+	var recorder *CastRecorder
+	if options.RecordPath != "" {
+		var err error
+
+		recorder, err = NewCastRecorder(options.RecordPath, strings.Join(options.Args, " "), "")
+		if err != nil {
+			Fatalf("Failed to start recording: %v", err)
+		}
+	}
+
+	var tee []io.Writer
+	var output *AnsiWriter
+	if options.OutputPath != "" {
+		var err error
+
+		output, err = NewAnsiWriter(options.OutputPath)
+		if err != nil {
+			Fatalf("Failed to create output: %v", err)
+		}
+		tee = append(tee, output)
+	}
+
+	var intro *IntroDetector
+	if options.SkipIntro {
+		intro = &IntroDetector{}
+	}
+
+	var overlay *TextOverlay
+	if options.Overlay.Text != "" || options.Overlay.Clock {
+		overlay = &options.Overlay
+	}
+
+	var pip *PictureInPicture
+	if options.Pip != "" {
+		pip = NewPictureInPicture(options.Pip, options.Buffer, options.MaxRetries)
+	}
+
+	keys := MakeInputRaw()
+
+	var stats Stats
+
+	// items termtv add queues are played after the rest
+	queue, err := ListenQueue()
+	if err != nil {
+		log.Printf("Failed to listen for queued items: %v", err)
+	}
+	if queue != nil {
+		defer queue.Close()
+	} else if options.WaitQueue {
+		Fatalf("Failed to idle: another termtv takes the items of termtv add")
+	}
+
+	var watcher *FolderWatcher
+	if options.Watch != "" {
+		watcher, err = WatchFolder(options.Watch)
+		if err != nil {
+			Fatalf("Failed to watch %s: %v", options.Watch, err)
+		}
+		defer watcher.Close()
+	}
+
+	take := func() []string {
+		var items []string
+		if queue != nil {
+			items = append(items, queue.Take()...)
+		}
+		if watcher != nil {
+			items = append(items, watcher.Take()...)
+		}
+		return items
+	}
+
+	caption := "waiting for new files in " + options.Watch
+	if watcher == nil {
+		caption = "waiting for termtv add"
+	}
+
+	idle := IdleOptions{
+		Item:       options.Idle,
+		Caption:    caption,
+		Renderer:   renderer,
+		Bindings:   bindings,
+		Buffer:     options.Buffer,
+		MaxRetries: options.MaxRetries,
+	}
+
+	items := options.Items()
+	if options.Screensaver {
+		rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	}
+
+	// rendered is what the screensaver showed since it last started over
+	rendered := 0
+	quit, failed := false, false
+	// the volume changed while playing carries on to the next item
+	volume := options.Volume
+
+	var shell *Shell
+	if options.TUI && !options.Screensaver && !plainOutput {
+		shell = NewShell(items, bindings)
+	}
+
+	for i := 0; ; i++ {
+		items = append(items, take()...)
+
+		// the screensaver starts over, unless nothing played at all
+		if i >= len(items) && options.Screensaver && rendered > 0 {
+			rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+			i, rendered = 0, 0
+		}
+
+		// a watched directory or the queue is waited on once the rest were
+		// played
+		if i >= len(items) && (watcher != nil || options.WaitQueue) {
+			more, ok := Idle(take, idle, keys)
+			if !ok {
+				quit = true
+				break
+			}
+			items = append(items, more...)
+		}
+		if i >= len(items) {
+			break
+		}
+		item := items[i]
+		if shell != nil {
+			shell.Items, shell.Current = items, i
+		}
+
+		var bandwidth *Bandwidth
+		if options.MaxBandwidth > 0 {
+			bandwidth = NewBandwidth(options.MaxBandwidth)
+		}
+
+		var replay *ReplayCache
+		if options.ReplayLength > 0 {
+			replay = &ReplayCache{Length: options.ReplayLength}
+		}
+
+		var backdrop *Backdrop
+		if options.Background != nil {
+			backdrop = &Backdrop{Color: *options.Background}
+		}
+
+		player := &Player{
+			Intro:          intro,
+			Replay:         replay,
+			MaxRetries:     options.MaxRetries,
+			Bandwidth:      bandwidth,
+			Bindings:       bindings,
+			Renderer:       renderer,
+			MinSize:        options.MinSize,
+			Buffer:         options.Buffer,
+			MaxMemory:      options.MaxMemory,
+			Recorder:       recorder,
+			MarkerInterval: options.MarkerInterval,
+			Filters:        options.Filters(),
+			Pip:            pip,
+			Screensaver:    options.Screensaver,
+			Overlay:        overlay,
+			Tee:            tee,
+			Adaptive:       !options.NoAdaptive,
+			Viewport:       Viewport{Center: options.ClipCenter},
+			Center:         options.Center,
+			TitleLine:      (!options.NoTitle || shell != nil) && !options.Screensaver && !plainOutput,
+			Backdrop:       backdrop,
+			SubtitleDelay:  options.SubDelay,
+			SubtitleStyle:  options.SubStyle,
+			Interpolate:    options.Interpolate,
+			Mouse:          !options.NoMouse && !options.Screensaver,
+			Volume:         volume,
+			Shell:          shell,
+		}
+
+		// an item that fails is skipped, for the next one or the idle
+		// screen, and termtv exits with an error in the end
+		if err := PlayItem(player, item, options, keys); err != nil {
+			RestoreTerminal()
+			log.Printf("Skipping %s: %v", item, err)
+			keys = MakeInputRaw()
+			failed = true
+		}
+		stats.Add(player.Stats)
+		volume = player.Volume
+		rendered += player.Stats.Rendered
+
+		if player.Quit {
+			quit = true
+			break
+		}
+
+		// an item clicked in the playlist plays next
+		if shell != nil && shell.Jump >= 0 {
+			i, shell.Jump = shell.Jump-1, -1
+		}
+	}
+
+	if options.KeepOpen && !quit && !plainOutput {
+		waitForKey(keys)
+	}
+
+	if pip != nil {
+		pip.Close()
+	}
+
+	KillChildren()
+	RestoreTerminal()
+
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			log.Printf("Failed to save recording: %v", err)
+		}
+	}
+
+	if output != nil {
+		if err := output.Close(); err != nil {
+			log.Printf("Failed to save output: %v", err)
+		}
+	}
+
+	// the screensaver leaves the screen blank
+	if options.Screensaver {
+		ClearScreen()
+	} else {
+		// the cursor is left at the end of the last row of the picture
+		if !plainOutput {
+			fmt.Fprintln(os.Stderr)
+		}
+		fmt.Fprintln(os.Stderr, stats)
+	}
+
+	if options.StatsJsonPath != "" {
+		if err := WriteStatsJson(options.StatsJsonPath, stats); err != nil {
+			log.Printf("Failed to write stats: %v", err)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// PlayItem plays item with player, running the hooks around it. It fails,
+// after the error hook ran, when item can't be played, so that the playlist
+// can carry on without it.
+func PlayItem(player *Player, item string, options PlayOptions, keys <-chan string) error {
+	hooks := options.Hooks
+	event := HookEvent{Source: item}
+
+	var source *Source
+
+	fail := func(format string, err error) error {
+		if source != nil && source.Close != nil {
+			source.Close()
+		}
+
+		event.Err = err
+		hooks.Run(HOOK_ERROR, event)
+		return fmt.Errorf(format, err)
+	}
+
+	source, err := Sniff(item, options.FfmpegScale)
+	if err != nil {
+		return fail("failed to open source: %w", err)
+	}
+
+	event.Title = source.Title
+	player.Source = source
+
+	// without ffplay sound is left out rather than failing
+	player.Audio = nil
+	if source.Audio != "" && RequireTools(FFPLAY) == nil {
+		player.Audio = &AudioPlayer{Input: source.Audio, Delay: options.AudioDelay, Volume: player.Volume}
+	}
+
+	player.Lyrics = nil
+	if lrc := cmp.Or(options.LrcPath, LyricsFor(item)); lrc != "" {
+		lyrics, err := ReadLyrics(lrc)
+		if err != nil {
+			return fail("failed to read lyrics: %w", err)
+		}
+		player.Lyrics = lyrics
+	}
+
+	player.Subtitles = nil
+	if sub := cmp.Or(options.SubPath, SubtitlesFor(item)); sub != "" {
+		subtitles, err := ReadSubtitles(sub)
+		if err != nil {
+			return fail("failed to read subtitles: %w", err)
+		}
+		player.Subtitles = subtitles
+	}
+
+	// stdin can't be read a second time for them
+	if options.Captions && source.Input != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		captions, err := ExtractCaptions(ctx, source.Input)
+		if err != nil {
+			return fail("failed to extract captions: %w", err)
+		}
+		player.Subtitles = captions
+	}
+
+	if player.Intro != nil {
+		if intro, found := player.Intro.Detect(source); found {
+			player.Skip = append(player.Skip, intro)
+		}
+	}
+
+	hooks.Run(HOOK_START, event)
+
+	err = player.Run(keys)
+	if source.Close != nil {
+		source.Close()
+	}
+
+	event.Frame = player.Stats.Rendered
+	event.Position = player.Position()
+
+	if err != nil {
+		return fail("playback failed: %w", err)
+	}
+
+	hooks.Run(HOOK_END, event)
+	return nil
+}
+
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+func infoCommand(args []string) {
+	var options PlayOptions
+
+	flags := NewFlagSet("info")
+	flags.StringVar(&options.Path, "path", "", "path to video file")
+	flags.StringVar(&options.Url, "url", "", "url of a video source")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	RegisterInputFlags(flags)
+	options.Parse(flags, args)
+	options.LoadConfig()
+
+	for i, item := range options.Items() {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		source, err := Sniff(item, false)
+		if err != nil {
+			Fatalf("Failed to open source: %v", err)
+		}
+
+		PrintInfo(source)
+	}
+}
+
+func PrintInfo(source *Source) {
+	info := source.Info
+
+	fmt.Printf("source    %s\n", source.Name)
+	fmt.Printf("title     %s\n", source.Title)
+
+	if info.Size.X > 0 {
+		fmt.Printf("size      %dx%d\n", info.Size.X, info.Size.Y)
+	}
+
+	if info.FrameRate > 0 {
+		fmt.Printf("fps       %.3f\n", info.FrameRate)
+	}
+
+	if info.Color != (ColorInfo{}) {
+		fmt.Printf("color     %s\n", strings.Join(strings.Fields(info.Color.Space+" "+info.Color.Range+" "+info.Color.Transfer), " "))
+	}
+
+	if info.Color.HDR() {
+		fmt.Printf("hdr       tonemapped %t\n", info.Color.Tonemapped())
+	}
+
+	if info.Duration > 0 {
+		fmt.Printf("duration  %s\n", FormatDuration(info.Duration))
+	} else {
+		fmt.Printf("duration  unknown\n")
+	}
+
+	fmt.Printf("seekable  %t\n", source.Seekable)
+
+	if len(info.Chapters) > 0 {
+		fmt.Printf("chapters\n")
+		for _, chapter := range info.Chapters {
+			fmt.Printf("  %s  %s\n", FormatDuration(chapter.Start), chapter.Title)
+		}
+	}
+}
+
+func mosaicCommand(args []string) {
+	var options PlayOptions
+	var layout image.Point
+
+	flags := NewFlagSet("mosaic")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	RegisterInputFlags(flags)
+	flags.Func("grid", "tiles across and down as COLSxROWS (default as square as fits the sources)", func(value string) (err error) {
+		layout, err = ParseSize(value)
+		return err
+	})
+	flags.IntVar(&options.MaxRetries, "max-retries", 5, "times in a row to reconnect a source that dropped or stalled before giving up on its tile")
+	flags.IntVar(&options.Buffer, "buffer", 8, "frames to let ffmpeg decode ahead for each tile")
+	flags.StringVar(&options.Renderer, "renderer", "auto", "renderer to draw with: auto, "+strings.Join(Renderers, ", "))
+	options.Parse(flags, args)
+
+	KillChildrenOnHangup()
+
+	config := options.LoadConfig()
+	Mosaic(options.Items(), MosaicOptions{
+		Layout:     layout,
+		Renderer:   SelectRenderer(options.Renderer),
+		Bindings:   LoadKeyBindings(config),
+		Buffer:     options.Buffer,
+		MaxRetries: options.MaxRetries,
+	})
+}
+
+func compareCommand(args []string) {
+	var options PlayOptions
+	var wipe bool
+
+	flags := NewFlagSet("compare")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	flags.BoolVar(&wipe, "wipe", false, "show the sources over each other split by a divider instead of side by side")
+	flags.IntVar(&options.Buffer, "buffer", 8, "frames to let ffmpeg decode ahead")
+	flags.StringVar(&options.Renderer, "renderer", "auto", "renderer to draw with: auto, "+strings.Join(Renderers, ", "))
+	options.Parse(flags, args)
+
+	if len(options.Args) != 2 {
+		log.Println("Expected two sources to compare")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	KillChildrenOnHangup()
+
+	config := options.LoadConfig()
+	bindings := LoadKeyBindings(config)
+	renderer := SelectRenderer(options.Renderer)
+
+	var sources []*Source
+	for _, item := range options.Args {
+		source, err := Sniff(item, true)
+		if err != nil {
+			Fatalf("Failed to open source: %v", err)
+		}
+		sources = append(sources, source)
+	}
+
+	err := Compare(sources[0], sources[1], CompareOptions{
+		Renderer: renderer,
+		Bindings: bindings,
+		Buffer:   options.Buffer,
+		Wipe:     wipe,
+	})
+
+	for _, source := range sources {
+		if source.Close != nil {
+			source.Close()
+		}
+	}
+
+	KillChildren()
+	RestoreTerminal()
+
+	if err != nil {
+		Fatalf("Playback failed: %v", err)
+	}
+}
+
+func framesCommand(args []string) {
+	var options PlayOptions
+	size := image.Pt(160, 90)
+
+	flags := NewFlagSet("frames")
+	flags.StringVar(&options.Path, "path", "", "path to video file")
+	flags.StringVar(&options.Url, "url", "", "url of a video source")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	flags.Func("size", "size to measure frames at as WIDTHxHEIGHT (default 160x90)", func(value string) (err error) {
+		size, err = ParseSize(value)
+		return err
+	})
+	options.Parse(flags, args)
+	options.LoadConfig()
+
+	items := options.Items()
+	if len(items) != 1 {
+		log.Println("Incorrect usage")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	source, err := Sniff(items[0], true)
+	if err != nil {
+		Fatalf("Failed to open source: %v", err)
+	}
+
+	stream := StartStream(source.Runner, size, 0, 0)
+	out := bufio.NewWriter(os.Stdout)
+
+	for frame := range stream.Frames {
+		line, _ := json.Marshal(frame.Meta)
+		out.Write(append(line, '\n'))
+	}
+
+	out.Flush()
+
+	if err := stream.Wait(); err != nil {
+		Fatalf("Decoding failed: %v", err)
+	}
+}
+
+// parseExportFlags registers the flags the export commands share and
+// parses args, returning the source to export.
+func parseExportFlags(flags *flag.FlagSet, args []string, export *ExportOptions, renderer *string, output *string) *Source {
+	var options PlayOptions
+	size := image.Pt(80, 24)
+
+	flags.StringVar(&options.Path, "path", "", "path to video file")
+	flags.StringVar(&options.Url, "url", "", "url of a video source")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	flags.StringVar(output, "o", "", "path to write to")
+	flags.StringVar(renderer, "renderer", "truecolor", "renderer to draw with: "+strings.Join(Renderers, ", ")+", except the graphics ones")
+	flags.Func("size", "terminal size to draw for as COLSxROWS (default 80x24)", func(value string) (err error) {
+		size, err = ParseSize(value)
+		return err
+	})
+	options.Parse(flags, args)
+	options.LoadConfig()
+
+	items := options.Items()
+	if len(items) != 1 || *output == "" {
+		log.Println("Expected one source and -o")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *renderer == "kitty" || *renderer == "sixel" {
+		Fatalf("The %s renderer draws graphics, which can't be exported", *renderer)
+	}
+
+	var err error
+	export.Renderer, err = NewRenderer(*renderer, Capabilities{})
+	if err != nil {
+		Fatalf("Failed to select renderer: %v", err)
+	}
+	export.Cols, export.Rows = size.X, size.Y
+
+	source, err := Sniff(items[0], true)
+	if err != nil {
+		Fatalf("Failed to open source: %v", err)
+	}
+
+	return source
+}
+
+func exportGifCommand(args []string) {
+	var export ExportOptions
+	var renderer, output string
+
+	flags := NewFlagSet("export-gif")
+	flags.DurationVar(&export.From, "from", 0, "position in the source to start at")
+	flags.DurationVar(&export.Length, "length", 5*time.Second, "how much of the source to export")
+	flags.Float64Var(&export.Fps, "fps", 10, "frames per second of the gif")
+	source := parseExportFlags(flags, args, &export, &renderer, &output)
+
+	if export.Fps <= 0 || export.Fps > 50 {
+		Fatalf("Invalid --fps %g, expected above 0 and up to 50", export.Fps)
+	}
+
+	err := ExportGif(source, export, output)
+
+	if source.Close != nil {
+		source.Close()
+	}
+	KillChildren()
+
+	if err != nil {
+		Fatalf("Failed to export: %v", err)
+	}
+}
+
+func exportFrameCommand(args []string) {
+	var export ExportOptions
+	var renderer, output string
+
+	flags := NewFlagSet("export-frame")
+	flags.DurationVar(&export.From, "at", 0, "position in the source of the frame")
+	source := parseExportFlags(flags, args, &export, &renderer, &output)
+
+	write := WriteHTML
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".html", ".htm":
+	case ".svg":
+		write = WriteSVG
+	default:
+		Fatalf("Expected -o to end in .html or .svg, got %s", output)
+	}
+
+	// with no Length only the frame at --at is rendered
+	export.Fps = 1
+	err := RenderScreens(source, export, func(screen *Screen, position time.Duration) error {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+
+		// the last row is kept free for the cursor
+		if err := write(file, screen, export.Rows-1); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	})
+
+	if source.Close != nil {
+		source.Close()
+	}
+	KillChildren()
+
+	if err != nil {
+		Fatalf("Failed to export: %v", err)
+	}
+}
+
+func benchCommand(args []string) {
+	var options PlayOptions
+	var renderer string
+	var frames int
+	size := image.Pt(160, 45)
+
+	flags := NewFlagSet("bench")
+	flags.StringVar(&options.Path, "path", "", "path to video file")
+	flags.StringVar(&options.Url, "url", "", "url of a video source")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	flags.BoolVar(&options.FfmpegScale, "ffmpeg-scale", false, "scale video files in ffmpeg instead of in Go (url sources always are)")
+	flags.StringVar(&options.Source, "source", "", "built-in source to measure: testpattern")
+	flags.StringVar(&renderer, "renderer", "all", "renderer to measure, or all of them: "+strings.Join(Renderers, ", "))
+	flags.IntVar(&frames, "frames", 0, "frames to measure, 0 for the whole source")
+	flags.Func("size", "terminal size to render for as COLSxROWS (default 160x45)", func(value string) (err error) {
+		size, err = ParseSize(value)
+		return err
+	})
+	options.Parse(flags, args)
+	options.LoadConfig()
+
+	items := options.Items()
+	if len(items) != 1 {
+		log.Println("Incorrect usage")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	names := Renderers
+	if renderer != "all" {
+		names = []string{renderer}
+	}
+
+	fmt.Println(BENCH_HEADER)
+
+	for _, name := range names {
+		renderer, err := NewRenderer(name, Capabilities{})
+		if err != nil {
+			Fatalf("Failed to select renderer: %v", err)
+		}
+
+		source, err := Sniff(items[0], options.FfmpegScale)
+		if err != nil {
+			Fatalf("Failed to open source: %v", err)
+		}
+
+		result, err := Bench(source, renderer, size.X, size.Y, frames)
+		if err != nil {
+			Fatalf("Decoding failed: %v", err)
+		}
+
+		fmt.Println(result)
+	}
+}
+
+func calibrateCommand(args []string) {
+	var renderer string
+	var duration time.Duration
+	var apply bool
+
+	flags := NewFlagSet("calibrate")
+	flags.StringVar(&renderer, "renderer", "all", "renderer to measure, or all the terminal supports: "+strings.Join(Renderers, ", "))
+	flags.DurationVar(&duration, "duration", time.Second, "how long to measure each renderer at each size")
+	flags.BoolVar(&apply, "apply", false, "save the recommended settings for this terminal to terminals.toml")
+	flags.Parse(args)
+
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		Fatalf("calibrate measures the terminal it runs in, and there is none")
+	}
+
+	caps, err := OverrideCapabilities(DetectCapabilities(), DefaultTerminalsPath())
+	if err != nil {
+		Fatalf("Failed to load terminal overrides: %v", err)
+	}
+
+	var names []string
+	for _, name := range Renderers {
+		if renderer == name || renderer == "all" && caps.supports(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		Fatalf("Unknown renderer %s", renderer)
+	}
+
+	latency, err := MeasureLatency()
+	if err != nil {
+		Fatalf("The terminal doesn't answer queries: %v", err)
+	}
+
+	cols, rows := TerminalSize()
+	var results []CalibrationResult
+
+	for _, name := range names {
+		renderer, err := NewRenderer(name, caps)
+		if err != nil {
+			Fatalf("Failed to select renderer: %v", err)
+		}
+
+		for _, size := range []image.Point{{cols, rows}, {cols / 2, rows / 2}} {
+			result, err := MeasureThroughput(renderer, size.X, size.Y, duration)
+			if err != nil {
+				ClearScreen()
+				Fatalf("Failed to measure %s: %v", name, err)
+			}
+			results = append(results, result)
+		}
+	}
+
+	ClearScreen()
+
+	fmt.Printf("round trip %.1fms\n\n", float64(latency.Microseconds())/1000)
+	fmt.Println(CALIBRATE_HEADER)
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	fmt.Println()
+
+	name, bandwidth := Recommend(results, cols, rows)
+	values := map[string]string{"renderer": name, "max_bandwidth": ""}
+
+	if bandwidth > 0 {
+		values["max_bandwidth"] = fmt.Sprintf("%.0fKB/s", bandwidth/1e3)
+		fmt.Printf("Recommended: --renderer %s --max-bandwidth %s, none keeps up with %d fps at %dx%d\n", name, values["max_bandwidth"], CALIBRATE_FPS, cols, rows)
+	} else {
+		fmt.Printf("Recommended: --renderer %s, which keeps up with %d fps at %dx%d\n", name, CALIBRATE_FPS, cols, rows)
+	}
+
+	if !apply {
+		return
+	}
+
+	section, err := TerminalSection()
+	if err != nil {
+		Fatalf("Failed to save: %v", err)
+	}
+
+	path := DefaultTerminalsPath()
+	if err := SaveTerminalSettings(path, section, values); err != nil {
+		Fatalf("Failed to save: %v", err)
+	}
+
+	fmt.Printf("Saved to [%s] in %s\n", section, path)
+}
+
+func keysCommand(args []string) {
+	var configPath string
+
+	flags := NewFlagSet("keys")
+	flags.StringVar(&configPath, "config", DefaultConfigPath(), "path to config file")
+	flags.Parse(args)
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	LoadKeyBindings(config).Print(os.Stdout)
+}
+
+func headlessEncodeCommand(args []string) {
+	var options PlayOptions
+	var listen, tlsCert, tlsKey string
+
+	flags := NewFlagSet("headless-encode")
+	flags.StringVar(&options.Path, "path", "", "path to video file")
+	flags.StringVar(&options.Url, "url", "", "url of a video source")
+	flags.StringVar(&options.ConfigPath, "config", DefaultConfigPath(), "path to config file")
+	RegisterToolFlags(flags)
+	RegisterNetworkFlags(flags)
+	RegisterVerboseFlag(flags)
+	RegisterInputFlags(flags)
+	flags.BoolVar(&options.FfmpegScale, "ffmpeg-scale", false, "scale video files in ffmpeg instead of in Go (url sources always are)")
+	flags.StringVar(&listen, "listen", "localhost:7070", "address to listen on, like :7070 for every interface, which takes a --token")
+	flags.IntVar(&options.Buffer, "buffer", 8, "frames to let ffmpeg decode ahead, smoothing over slow frames")
+	flags.StringVar(&options.PprofAddr, "pprof", "", "loopback address like localhost:6060 to serve net/http/pprof profiles on")
+	flags.DurationVar(&options.ResumeWindow, "resume-window", time.Minute, "how long clients that drop can resume their session, 0 to disable")
+	flags.StringVar(&options.MetricsAddr, "metrics", "", "address like localhost:9100 to serve Prometheus metrics on at /metrics, which takes a --token unless it's a loopback one")
+	flags.IntVar(&options.MaxClients, "max-clients", 0, "clients to serve at once at most, 0 for no limit")
+	flags.IntVar(&options.MaxClientsPerIP, "max-clients-per-ip", 0, "clients to serve at once from one address at most, 0 for no limit")
+	flags.Func("max-bandwidth", "cap what each client is sent to a rate like 200KB/s by dropping frames and quantizing colors", func(value string) (err error) {
+		options.MaxBandwidth, err = ParseBandwidth(value)
+		return err
+	})
+	flags.StringVar(&options.RecordDir, "record-dir", "", "directory to record each client's session to as asciinema casts")
+	flags.Func("record-max-size", "start a new cast once one grows past a size like 100MB", func(value string) (err error) {
+		options.RecordMaxSize, err = ParseByteSize(value)
+		return err
+	})
+	flags.IntVar(&options.RecordKeep, "record-keep", 0, "casts to keep in --record-dir, removing the oldest, 0 to keep all")
+	flags.StringVar(&tlsCert, "tls-cert", "", "path of a certificate to serve over TLS with, with --tls-key")
+	flags.StringVar(&tlsKey, "tls-key", "", "path of the key of --tls-cert")
+	flags.StringVar(&options.Token, "token", "", "token clients and metrics scrapes have to send (default $"+TOKEN_ENV+")")
+	options.Compressions = Compressions
+	flags.Func("compression", "compressions to accept in order of preference, or none (default zstd,deflate)", func(value string) (err error) {
+		options.Compressions, err = ParseCompressions(value)
+		return err
+	})
+	options.Parse(flags, args)
+	options.LoadConfig()
+
+	// read after parsing, so that -h doesn't show it
+	options.Token = cmp.Or(options.Token, os.Getenv(TOKEN_ENV))
+
+	// anyone who can reach the server could watch and control it
+	if options.Token == "" {
+		for _, addr := range []string{listen, options.MetricsAddr} {
+			if addr != "" && !IsLoopback(addr) {
+				Fatalf("Refusing to serve on %s without a --token, or $%s, as anyone could connect; listen on a loopback address like localhost:7070 otherwise", addr, TOKEN_ENV)
+			}
+		}
+	}
+
+	var err error
+	options.TLS, err = ServerTLS(tlsCert, tlsKey)
+	if err != nil {
+		Fatalf("Failed to load TLS certificate: %v", err)
+	}
+
+	if options.RecordDir != "" {
+		if err := os.MkdirAll(options.RecordDir, 0o755); err != nil {
+			Fatalf("Failed to create --record-dir: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		Fatalf("Failed to listen: %v", err)
+	}
+
+	if options.TLS != nil {
+		listener = tls.NewListener(listener, options.TLS)
+		log.Printf("Listening on %s over TLS", listener.Addr())
+	} else {
+		log.Printf("Listening on %s", listener.Addr())
+	}
+
+	if options.PprofAddr != "" {
+		if err := StartPprof(options.PprofAddr); err != nil {
+			Fatalf("Failed to serve pprof: %v", err)
+		}
+	}
+
+	if err := Serve(listener, options.Items(), options); err != nil {
+		Fatalf("Server failed: %v", err)
+	}
+}
+
+func connectCommand(args []string) {
+	var configPath, renderer, tlsCA string
+	var useTLS bool
+	options := ConnectOptions{Compressions: Compressions}
+
+	flags := NewFlagSet("connect")
+	flags.StringVar(&configPath, "config", DefaultConfigPath(), "path to config file")
+	flags.StringVar(&renderer, "renderer", "auto", "renderer to draw with: auto, "+strings.Join(Renderers, ", "))
+	flags.DurationVar(&options.Reconnect, "reconnect", 30*time.Second, "how long to try resuming the session after the connection drops, 0 to disable")
+	flags.Func("compression", "compressions to offer the server, or none (default zstd,deflate)", func(value string) (err error) {
+		options.Compressions, err = ParseCompressions(value)
+		return err
+	})
+	flags.BoolVar(&useTLS, "tls", false, "connect over TLS")
+	flags.StringVar(&tlsCA, "tls-ca", "", "path of a certificate to trust as well as the system's, like the server's self signed one, implies --tls")
+	flags.StringVar(&options.Token, "token", "", "token of the server's --token (default $"+TOKEN_ENV+")")
+	flags.BoolVar(&options.ClipCenter, "clip-center", false, "show the middle of frames rather than their top left while the terminal is smaller than them")
+	positional := ParseArgs(flags, args)
+
+	if len(positional) != 1 {
+		log.Println("Incorrect usage")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	options.Token = cmp.Or(options.Token, os.Getenv(TOKEN_ENV))
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		Fatalf("Failed to load config: %v", err)
+	}
+
+	options.Bindings = LoadKeyBindings(config)
+	options.Renderer = SelectRenderer(renderer)
+
+	if useTLS || tlsCA != "" {
+		if options.TLS, err = ClientTLS(tlsCA); err != nil {
+			Fatalf("Failed to load TLS certificate: %v", err)
+		}
+	}
+
+	if err := Connect(positional[0], options); err != nil {
+		RestoreTerminal()
+		Fatalf("Connection failed: %v", err)
+	}
+}
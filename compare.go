@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var wipeDivider = color.NRGBA{255, 255, 255, 255}
+
+type CompareOptions struct {
+	Renderer Renderer
+	Bindings KeyBindings
+	Buffer   int
+	// Wipe starts with the sources over each other, split by a divider,
+	// rather than side by side.
+	Wipe bool
+}
+
+// compareSide is one of the two sources of Compare.
+type compareSide struct {
+	source   *Source
+	playback Playback
+	// picture is its last frame fitted to its side
+	picture *image.NRGBA
+	ended   bool
+	started bool
+}
+
+// take fits frame into the side's picture.
+func (s *compareSide) take(frame Frame) {
+	s.playback.Advance(frame.Meta)
+
+	if Fit(frame.Picture, s.picture) == frame.Picture {
+		copy(s.picture.Pix, frame.Picture.Pix)
+	}
+}
+
+// catchUp takes frames until the side is at position, the last one staying
+// up once it ends.
+func (s *compareSide) catchUp(position time.Duration) error {
+	for !s.ended && s.playback.Position() < position-s.playback.FrameInterval()/2 {
+		frame, ok := <-s.playback.Frames()
+		if !ok {
+			s.ended = true
+			return s.playback.Wait()
+		}
+		s.take(frame)
+	}
+
+	return nil
+}
+
+// start starts the side at offset with frames of size, in place of the
+// stream it played.
+func (s *compareSide) start(size image.Point, offset time.Duration) {
+	s.stop()
+
+	s.picture = image.NewNRGBA(image.Rectangle{Max: size})
+	s.ended = false
+	s.started = true
+	s.playback.Start(size, offset)
+}
+
+func (s *compareSide) stop() {
+	if s.started {
+		s.playback.Stop()
+		s.started = false
+	}
+}
+
+// Compare plays a and b in lockstep, side by side or over each other split
+// by a divider that can be moved, to compare encodes or filters. a sets the
+// pace and b follows its position. It returns when a ends or the user quits.
+func Compare(a, b *Source, options CompareOptions) error {
+	sides := []*compareSide{{source: a}, {source: b}}
+	for _, side := range sides {
+		side.playback = Playback{Source: side.source, Buffer: options.Buffer}
+		defer side.stop()
+	}
+
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	wipe := options.Wipe
+	divider := 0.5
+
+	var grid image.Point
+	var canvas, drawn *image.NRGBA
+	var pacer Pacer
+	var due <-chan time.Time
+	paused := false
+
+	// start starts both sides at offset, sized for the layout
+	start := func(offset time.Duration) {
+		grid = TerminalGrid(options.Renderer)
+		canvas = image.NewNRGBA(image.Rectangle{Max: grid})
+		drawn = nil
+
+		sizes := []image.Point{grid, grid}
+		if !wipe {
+			sizes = []image.Point{image.Pt(grid.X/2, grid.Y), image.Pt(grid.X-grid.X/2, grid.Y)}
+		}
+
+		for i, side := range sides {
+			side.start(sizes[i], offset)
+		}
+
+		pacer.Reset(sides[0].playback.Position())
+		due = nil
+
+		ClearScreen()
+	}
+
+	buffer := &bytes.Buffer{}
+	draw := func() {
+		if wipe {
+			split := int(float64(grid.X) * divider)
+			for y := 0; y < grid.Y; y++ {
+				row := canvas.Pix[y*canvas.Stride:][:grid.X*4]
+				copy(row[:split*4], sides[0].picture.Pix[y*sides[0].picture.Stride:])
+				copy(row[split*4:], sides[1].picture.Pix[y*sides[1].picture.Stride+split*4:][:(grid.X-split)*4])
+				if split < grid.X {
+					canvas.SetNRGBA(split, y, wipeDivider)
+				}
+			}
+		} else {
+			copyInto(canvas, sides[0].picture, image.Point{})
+			copyInto(canvas, sides[1].picture, image.Pt(grid.X/2, 0))
+		}
+
+		if diff, ok := options.Renderer.(DiffRenderer); ok && drawn != nil {
+			diff.RenderDiff(buffer, drawn, canvas)
+		} else {
+			options.Renderer.Render(buffer, canvas)
+			drawn = image.NewNRGBA(canvas.Rect)
+		}
+		copy(drawn.Pix, canvas.Pix)
+
+		writeCompareLabels(buffer, a.Title, b.Title, wipe)
+
+		os.Stdout.Write(buffer.Bytes())
+		buffer.Reset()
+	}
+
+	start(0)
+
+	for {
+		frames := sides[0].playback.Frames()
+		if paused || due != nil {
+			frames = nil
+		}
+
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			start(sides[0].playback.Position())
+
+		case <-interrupt:
+			return nil
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			c, err := ParseCommand(options.Bindings[key])
+			if err != nil {
+				continue
+			}
+
+			switch c.Name {
+			case "quit":
+				return nil
+			case "pause":
+				paused = !paused
+				pacer.Reset(sides[0].playback.Position())
+			case "seek":
+				if sides[0].source.Seekable && sides[1].source.Seekable {
+					position := sides[0].playback.Position() + time.Duration(c.Arg*float64(time.Second))
+					if duration := a.Info.Duration; duration > 0 {
+						position = min(position, duration)
+					}
+					start(max(position, 0))
+				}
+			case "split":
+				wipe = !wipe
+				start(sides[0].playback.Position())
+			case "wipe":
+				if wipe {
+					divider = min(max(divider+c.Arg, 0), 1)
+					draw()
+				}
+			}
+
+		case frame, ok := <-frames:
+			if !ok {
+				return sides[0].playback.Wait()
+			}
+
+			sides[0].take(frame)
+			if err := sides[1].catchUp(sides[0].playback.Position()); err != nil {
+				return err
+			}
+
+			wait, drop := pacer.Schedule(sides[0].playback.Position(), sides[0].playback.FrameInterval())
+			switch {
+			case drop:
+			case wait > 0:
+				due = time.After(wait)
+			default:
+				draw()
+			}
+
+		case <-due:
+			due = nil
+			draw()
+		}
+	}
+}
+
+// writeCompareLabels names the sources over the top corners of their sides.
+func writeCompareLabels(buffer *bytes.Buffer, a, b string, wipe bool) {
+	if plainOutput {
+		return
+	}
+
+	cols, _ := TerminalSize()
+	half := cols / 2
+
+	a, b = " "+a+" ", " "+b+" "
+	a = string([]rune(a)[:min(len([]rune(a)), half)])
+	b = string([]rune(b)[:min(len([]rune(b)), cols-half)])
+
+	column := half + 1
+	if wipe {
+		column = cols - len([]rune(b)) + 1
+	}
+
+	fmt.Fprintf(buffer, "\u001b[1;1H\u001b[%sm%s\u001b[0m", CAPTION_STYLE, a)
+	fmt.Fprintf(buffer, "\u001b[1;%dH\u001b[%sm%s\u001b[0m", column, CAPTION_STYLE, b)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	COMPRESSION_NONE    = "none"
+	COMPRESSION_DEFLATE = "deflate"
+	COMPRESSION_ZSTD    = "zstd"
+)
+
+// Compressions lists the supported compressions of the remote stream, most
+// preferred first.
+var Compressions = []string{COMPRESSION_ZSTD, COMPRESSION_DEFLATE}
+
+// ParseCompressions parses a comma separated list of compressions, "none"
+// meaning an empty one.
+func ParseCompressions(value string) ([]string, error) {
+	var list []string
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+
+		switch name {
+		case COMPRESSION_NONE, "":
+		case COMPRESSION_ZSTD, COMPRESSION_DEFLATE:
+			list = append(list, name)
+		default:
+			return nil, fmt.Errorf("unknown compression %s", name)
+		}
+	}
+
+	return list, nil
+}
+
+// ChooseCompression picks the first of supported that the peer offered.
+func ChooseCompression(supported, offered []string) string {
+	for _, name := range supported {
+		for _, offer := range offered {
+			if name == offer {
+				return name
+			}
+		}
+	}
+
+	return COMPRESSION_NONE
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// StreamWriter buffers messages and compresses them as one stream, so every
+// frame benefits from the ones before it. Flush ends a frame, making all of
+// it readable by the other side.
+type StreamWriter struct {
+	*bufio.Writer
+	compressor flushWriteCloser
+}
+
+func NewStreamWriter(w io.Writer, compression string) (*StreamWriter, error) {
+	var compressor flushWriteCloser
+	var err error
+
+	switch compression {
+	case COMPRESSION_NONE:
+	case COMPRESSION_DEFLATE:
+		compressor, err = flate.NewWriter(w, flate.BestSpeed)
+	case COMPRESSION_ZSTD:
+		compressor, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	default:
+		err = fmt.Errorf("unknown compression %s", compression)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compressor != nil {
+		w = compressor
+	}
+
+	return &StreamWriter{bufio.NewWriter(w), compressor}, nil
+}
+
+func (w *StreamWriter) Flush() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+
+	if w.compressor == nil {
+		return nil
+	}
+
+	return w.compressor.Flush()
+}
+
+func (w *StreamWriter) Close() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+
+	if w.compressor == nil {
+		return nil
+	}
+
+	return w.compressor.Close()
+}
+
+// NewStreamReader undoes the compression of a StreamWriter.
+func NewStreamReader(r io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case COMPRESSION_NONE:
+		return r, nil
+	case COMPRESSION_DEFLATE:
+		return flate.NewReader(r), nil
+	case COMPRESSION_ZSTD:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the parsed config file as section -> key -> value. Only the
+// subset of TOML termtv needs is understood: [section] headers, comments and
+// key = value pairs with string, number or boolean values.
+type Config map[string]map[string]string
+
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "termtv", "config.toml")
+}
+
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func ParseConfig(r io.Reader) (Config, error) {
+	config := Config{}
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated section header", n)
+			}
+
+			section = strings.TrimSpace(line[1:end])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if config[section] == nil {
+			config[section] = map[string]string{}
+		}
+		config[section][strings.Trim(strings.TrimSpace(key), `"`)] = value
+	}
+
+	return config, scanner.Err()
+}
+
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		quoted, err := strconv.QuotedPrefix(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return strconv.Unquote(quoted)
+
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : end+1], nil
+	}
+
+	if comment := strings.Index(raw, "#"); comment >= 0 {
+		raw = raw[:comment]
+	}
+
+	return strings.TrimSpace(raw), nil
+}
+
+// Sections returns every section whose name starts with prefix followed by
+// a dot, keyed by the remainder of the name, e.g. "extractor.vimeo" -> "vimeo".
+func (c Config) Sections(prefix string) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+
+	for name, values := range c {
+		if rest, ok := strings.CutPrefix(name, prefix+"."); ok {
+			sections[rest] = values
+		}
+	}
+
+	return sections
+}
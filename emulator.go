@@ -0,0 +1,333 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Screen is a headless terminal that keeps the cells output written to it
+// would leave on a real one, for checking what termtv draws without a tty.
+// It understands what termtv writes: text, cursor movement, erasing and SGR
+// colors. Graphics and other strings are skipped.
+type Screen struct {
+	Cols, Rows int
+	Cells      [][]Cell
+	// X and Y are the cursor position, from 0
+	X, Y int
+
+	pen Cell
+	// wrap is set once a character is written to the last column, the
+	// next one goes to the start of the next line
+	wrap bool
+
+	state    int
+	sequence []byte
+	pending  []byte
+}
+
+// Cell is a character and its colors, which are zero for the defaults.
+type Cell struct {
+	Rune rune
+	Fg   color.NRGBA
+	Bg   color.NRGBA
+}
+
+const (
+	screenGround = iota
+	screenEscape
+	screenCsi
+	// a string like an OSC, APC or DCS, ended by ST or BEL
+	screenString
+	screenStringEscape
+)
+
+func NewScreen(cols, rows int) *Screen {
+	s := &Screen{Cols: cols, Rows: rows, Cells: make([][]Cell, rows)}
+	for y := range s.Cells {
+		s.Cells[y] = make([]Cell, cols)
+		s.clear(y, 0, cols)
+	}
+
+	return s
+}
+
+// Write consumes terminal output, it never fails.
+func (s *Screen) Write(p []byte) (int, error) {
+	data := append(s.pending, p...)
+	s.pending = nil
+
+	for len(data) > 0 {
+		if s.state == screenGround && data[0] >= 0x80 {
+			if !utf8.FullRune(data) {
+				s.pending = append([]byte(nil), data...)
+				break
+			}
+
+			r, size := utf8.DecodeRune(data)
+			s.print(r)
+			data = data[size:]
+			continue
+		}
+
+		s.consume(data[0])
+		data = data[1:]
+	}
+
+	return len(p), nil
+}
+
+func (s *Screen) consume(b byte) {
+	switch s.state {
+	case screenEscape:
+		switch b {
+		case '[':
+			s.state = screenCsi
+			s.sequence = s.sequence[:0]
+		case ']', '_', 'P', '^', 'X':
+			s.state = screenString
+		default:
+			s.state = screenGround
+		}
+
+	case screenCsi:
+		if b >= 0x40 && b <= 0x7e {
+			s.csi(string(s.sequence), b)
+			s.state = screenGround
+			return
+		}
+		s.sequence = append(s.sequence, b)
+
+	case screenString:
+		switch b {
+		case 0x1b:
+			s.state = screenStringEscape
+		case 0x07:
+			s.state = screenGround
+		}
+
+	case screenStringEscape:
+		// ESC \ ends the string, tmux passthrough doubles the ESCs in it
+		if b == '\\' {
+			s.state = screenGround
+		} else {
+			s.state = screenString
+		}
+
+	default:
+		switch b {
+		case 0x1b:
+			s.state = screenEscape
+		case '\r':
+			s.X, s.wrap = 0, false
+		case '\n':
+			s.lineFeed()
+		case '\b':
+			s.X, s.wrap = max(s.X-1, 0), false
+		default:
+			if b >= 0x20 && b < 0x7f {
+				s.print(rune(b))
+			}
+		}
+	}
+}
+
+func (s *Screen) print(r rune) {
+	if s.wrap {
+		s.X, s.wrap = 0, false
+		s.lineFeed()
+	}
+
+	cell := s.pen
+	cell.Rune = r
+	s.Cells[s.Y][s.X] = cell
+
+	if s.X == s.Cols-1 {
+		s.wrap = true
+	} else {
+		s.X++
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrap = false
+
+	if s.Y < s.Rows-1 {
+		s.Y++
+		return
+	}
+
+	copy(s.Cells, s.Cells[1:])
+	s.Cells[s.Rows-1] = make([]Cell, s.Cols)
+	s.clear(s.Rows-1, 0, s.Cols)
+}
+
+func (s *Screen) clear(y, from, to int) {
+	for x := max(from, 0); x < min(to, s.Cols); x++ {
+		s.Cells[y][x] = Cell{Rune: ' '}
+	}
+}
+
+func (s *Screen) csi(parameters string, final byte) {
+	// private modes like synchronized output change nothing on screen
+	if strings.HasPrefix(parameters, "?") {
+		return
+	}
+
+	var args []int
+	for _, field := range strings.Split(parameters, ";") {
+		n, _ := strconv.Atoi(field)
+		args = append(args, n)
+	}
+
+	arg := func(i, fallback int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return fallback
+	}
+
+	s.wrap = false
+
+	switch final {
+	case 'H', 'f':
+		s.Y = min(arg(0, 1), s.Rows) - 1
+		s.X = min(arg(1, 1), s.Cols) - 1
+	case 'A':
+		s.Y = max(s.Y-arg(0, 1), 0)
+	case 'B':
+		s.Y = min(s.Y+arg(0, 1), s.Rows-1)
+	case 'C':
+		s.X = min(s.X+arg(0, 1), s.Cols-1)
+	case 'D':
+		s.X = max(s.X-arg(0, 1), 0)
+	case 'G':
+		s.X = min(arg(0, 1), s.Cols) - 1
+	case 'd':
+		s.Y = min(arg(0, 1), s.Rows) - 1
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.Y, s.X, s.Cols)
+			for y := s.Y + 1; y < s.Rows; y++ {
+				s.clear(y, 0, s.Cols)
+			}
+		case 1:
+			s.clear(s.Y, 0, s.X+1)
+			for y := 0; y < s.Y; y++ {
+				s.clear(y, 0, s.Cols)
+			}
+		default:
+			for y := 0; y < s.Rows; y++ {
+				s.clear(y, 0, s.Cols)
+			}
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.Y, s.X, s.Cols)
+		case 1:
+			s.clear(s.Y, 0, s.X+1)
+		default:
+			s.clear(s.Y, 0, s.Cols)
+		}
+	case 'm':
+		s.sgr(args)
+	}
+}
+
+func (s *Screen) sgr(args []int) {
+	for i := 0; i < len(args); i++ {
+		switch n := args[i]; {
+		case n == 0:
+			s.pen = Cell{}
+		case n >= 30 && n <= 37:
+			s.pen.Fg = palette16[n-30]
+		case n >= 90 && n <= 97:
+			s.pen.Fg = palette16[n-90+8]
+		case n == 39:
+			s.pen.Fg = color.NRGBA{}
+		case n >= 40 && n <= 47:
+			s.pen.Bg = palette16[n-40]
+		case n >= 100 && n <= 107:
+			s.pen.Bg = palette16[n-100+8]
+		case n == 49:
+			s.pen.Bg = color.NRGBA{}
+		case n == 38 || n == 48:
+			c, used := sgrColor(args[i+1:])
+			i += used
+
+			if n == 38 {
+				s.pen.Fg = c
+			} else {
+				s.pen.Bg = c
+			}
+		}
+	}
+}
+
+// sgrColor parses the rest of an extended color, 5;n or 2;r;g;b, returning
+// the color and how many arguments it took.
+func sgrColor(args []int) (color.NRGBA, int) {
+	switch {
+	case len(args) >= 2 && args[0] == 5:
+		return Palette256(args[1]), 2
+	case len(args) >= 4 && args[0] == 2:
+		return color.NRGBA{uint8(args[1]), uint8(args[2]), uint8(args[3]), 255}, 4
+	}
+
+	return color.NRGBA{}, len(args)
+}
+
+// Palette256 returns the color xterm shows for index n of its 256 colors.
+func Palette256(n int) color.NRGBA {
+	switch {
+	case n < 16:
+		return palette16[max(n, 0)]
+	case n < 232:
+		n -= 16
+		return color.NRGBA{cubeLevel(n / 36), cubeLevel(n / 6 % 6), cubeLevel(n % 6), 255}
+	}
+
+	gray := uint8(8 + (min(n, 255)-232)*10)
+	return color.NRGBA{gray, gray, gray, 255}
+}
+
+// Text returns the characters on screen, a line per row with trailing spaces
+// trimmed.
+func (s *Screen) Text() string {
+	lines := make([]string, s.Rows)
+	for y, row := range s.Cells {
+		var line strings.Builder
+		for _, cell := range row {
+			line.WriteRune(cell.Rune)
+		}
+		lines[y] = strings.TrimRight(line.String(), " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// HalfBlocks reads back the picture drawn with upper half blocks from the top
+// left corner, two pixels per cell, taking other cells for the background.
+func (s *Screen) HalfBlocks(size image.Point) *image.NRGBA {
+	picture := image.NewNRGBA(image.Rectangle{Max: size})
+
+	for y := 0; y < size.Y; y += 2 {
+		for x := 0; x < size.X; x++ {
+			cell := s.Cells[y/2][x]
+
+			top := cell.Bg
+			if cell.Rune == '▀' {
+				top = cell.Fg
+			}
+
+			picture.SetNRGBA(x, y, top)
+			picture.SetNRGBA(x, y+1, cell.Bg)
+		}
+	}
+
+	return picture
+}
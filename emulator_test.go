@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func comparePictures(got, want *image.NRGBA) error {
+	for y := want.Rect.Min.Y; y < want.Rect.Max.Y; y++ {
+		for x := want.Rect.Min.X; x < want.Rect.Max.X; x++ {
+			if got.NRGBAAt(x, y) != want.NRGBAAt(x, y) {
+				return fmt.Errorf("pixel %d,%d is %v, want %v", x, y, got.NRGBAAt(x, y), want.NRGBAAt(x, y))
+			}
+		}
+	}
+
+	return nil
+}
+
+func compareScreens(got, want *Screen) error {
+	for y := range want.Cells {
+		for x := range want.Cells[y] {
+			if got.Cells[y][x] != want.Cells[y][x] {
+				return fmt.Errorf("cell %d,%d is %v, want %v", x, y, got.Cells[y][x], want.Cells[y][x])
+			}
+		}
+	}
+
+	return nil
+}
+
+// ColorName names the color c is closest to, black, gray, white, red, yellow,
+// green, cyan, blue or magenta, for checks like "the cell is red-ish" that
+// hold however the color was encoded.
+func ColorName(c color.NRGBA) string {
+	r, g, b := int(c.R), int(c.G), int(c.B)
+	high, low := max(r, g, b), min(r, g, b)
+
+	if high-low < 64 {
+		switch {
+		case high < 64:
+			return "black"
+		case low > 160:
+			return "white"
+		}
+		return "gray"
+	}
+
+	// channels close to the highest one make up the hue
+	near := func(v int) bool { return high-v < (high-low)/2 }
+
+	switch {
+	case near(r) && near(g) && !near(b):
+		return "yellow"
+	case near(g) && near(b) && !near(r):
+		return "cyan"
+	case near(r) && near(b) && !near(g):
+		return "magenta"
+	case near(r):
+		return "red"
+	case near(g):
+		return "green"
+	}
+	return "blue"
+}
+
+// TestDiff draws frames as diffs, which has to leave the screen as drawing
+// the last one in full does.
+func TestDiff(t *testing.T) {
+	for _, name := range []string{"truecolor", "256", "16"} {
+		t.Run(name, func(t *testing.T) {
+			renderer, _ := NewRenderer(name, Capabilities{})
+			size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+			frames := []*image.NRGBA{TestPattern(size, 0), TestPattern(size, 1), TestPattern(size, 1), TestPattern(size, 5)}
+
+			diffed := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+			renderTo(diffed, renderer, true, frames...)
+
+			full := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+			renderTo(full, renderer, false, frames[len(frames)-1])
+
+			if err := compareScreens(diffed, full); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestResize draws a frame, then a smaller one after clearing as a resize
+// does, which must leave nothing of the first one.
+func TestResize(t *testing.T) {
+	renderer := HalfBlockRenderer{Colors: 1 << 24}
+
+	screen := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+	renderTo(screen, renderer, false, TestPattern(renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS), 0))
+
+	screen.Write([]byte(CLEAR_SCREEN))
+	small := TestPattern(renderer.Grid(SELFTEST_COLS/2, SELFTEST_ROWS/2), 1)
+	renderTo(screen, renderer, false, small)
+
+	want := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+	renderTo(want, renderer, false, small)
+
+	if err := compareScreens(screen, want); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSynchronized makes sure synchronized updates change nothing on screen.
+func TestSynchronized(t *testing.T) {
+	renderer := HalfBlockRenderer{Colors: 256}
+	size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+	frames := []*image.NRGBA{TestPattern(size, 0), TestPattern(size, 1)}
+
+	synchronized := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+	renderTo(synchronized, SynchronizedRenderer{renderer}, true, frames...)
+
+	plain := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+	renderTo(plain, renderer, true, frames...)
+
+	if err := compareScreens(synchronized, plain); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGraphics makes sure images, passed through a multiplexer or not, are
+// sent whole as strings the terminal takes no text from.
+func TestGraphics(t *testing.T) {
+	for _, multiplexer := range []string{"", "tmux", "screen"} {
+		for _, renderer := range []Renderer{KittyRenderer{multiplexer}, SixelRenderer{Passthrough: multiplexer}} {
+			name := renderer.Name()
+			if multiplexer != "" {
+				name += "/" + multiplexer
+			}
+
+			t.Run(name, func(t *testing.T) {
+				size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+
+				screen := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+				renderTo(screen, renderer, true, TestPattern(size, 0), TestPattern(size, 1))
+
+				if text := strings.TrimSpace(screen.Text()); text != "" {
+					t.Errorf("text leaked onto the screen: %q", text)
+				}
+			})
+		}
+	}
+}
+
+// TestBars looks at the colors the SMPTE bars of the test pattern end up in
+// on screen, which have to keep their names in any number of colors.
+func TestBars(t *testing.T) {
+	for _, name := range []string{"truecolor", "256", "16"} {
+		t.Run(name, func(t *testing.T) {
+			renderer, _ := NewRenderer(name, Capabilities{})
+			size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+
+			screen := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+			renderTo(screen, renderer, false, TestPattern(size, 0))
+
+			names := []string{"white", "yellow", "cyan", "green", "magenta", "red", "blue"}
+
+			for i, want := range names {
+				// the middle of the bar, on the top row which the box stays below
+				x := (i*2 + 1) * SELFTEST_COLS / (len(names) * 2)
+
+				cell := screen.Cells[0][x]
+				for _, c := range []color.NRGBA{cell.Fg, cell.Bg} {
+					if got := ColorName(c); got != want {
+						t.Errorf("cell %d,0 is %s (%v), want %s", x, got, c, want)
+					}
+				}
+			}
+		})
+	}
+}
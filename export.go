@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"time"
+)
+
+// ExportOptions are how the export commands render a source.
+type ExportOptions struct {
+	Renderer   Renderer
+	Cols, Rows int
+	// From is where in the source to start, Length how much of it to
+	// render, 0 for only the frame at From.
+	From   time.Duration
+	Length time.Duration
+	// Fps is how many frames a second of the source are rendered.
+	Fps float64
+}
+
+// RenderScreens renders frames of source as the renderer draws them on a
+// terminal of Cols by Rows, onto a headless Screen, calling take with the
+// screen and the position of each. Graphics the Screen can't keep, like
+// kitty's, leave it blank.
+func RenderScreens(source *Source, options ExportOptions, take func(screen *Screen, position time.Duration) error) error {
+	grid := options.Renderer.Grid(options.Cols, options.Rows)
+	if grid.X <= 0 || grid.Y <= 0 {
+		return fmt.Errorf("%dx%d is too small", options.Cols, options.Rows)
+	}
+
+	playback := Playback{Source: source, Buffer: 1}
+	playback.Start(grid, options.From)
+	defer playback.Stop()
+
+	screen := NewScreen(options.Cols, options.Rows)
+	picture := image.NewNRGBA(image.Rectangle{Max: grid})
+	var buffer bytes.Buffer
+
+	interval := time.Duration(float64(time.Second) / options.Fps)
+	next := options.From
+	taken := false
+
+	for frame := range playback.Frames() {
+		playback.Advance(frame.Meta)
+
+		position := playback.Position()
+		if position >= options.From+options.Length && taken {
+			return nil
+		}
+		if position < next-interval/2 {
+			continue
+		}
+
+		if Fit(frame.Picture, picture) == frame.Picture {
+			copy(picture.Pix, frame.Picture.Pix)
+		}
+		options.Renderer.Render(&buffer, picture)
+		screen.Write(buffer.Bytes())
+		buffer.Reset()
+
+		if err := take(screen, position); err != nil {
+			return err
+		}
+		taken = true
+
+		// a frame taken a little early still stands for the one due at next
+		next += interval
+		for next <= position {
+			next += interval
+		}
+	}
+
+	if err := playback.Wait(); err != nil {
+		return err
+	}
+	if !taken {
+		return fmt.Errorf("no frames from %s", source.Name)
+	}
+
+	return nil
+}
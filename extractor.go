@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os/exec"
+	urlpath "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media is a source resolved to something ffmpeg can open directly.
+type Media struct {
+	URL     string
+	Title   string
+	Headers map[string]string
+}
+
+type Extractor interface {
+	Name() string
+	Match(url string) bool
+	Resolve(url string) (*Media, error)
+}
+
+var extractors []Extractor
+
+func RegisterExtractor(extractor Extractor) {
+	extractors = append(extractors, extractor)
+}
+
+// RegisterConfigExtractors adds the [extractor.<name>] sections of the config
+// file. They are checked before the built-in extractors, in name order.
+func RegisterConfigExtractors(config Config) error {
+	sections := config.Sections("extractor")
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	custom := make([]Extractor, 0, len(names))
+	for _, name := range names {
+		values := sections[name]
+
+		pattern, err := regexp.Compile(values["match"])
+		if err != nil {
+			return fmt.Errorf("extractor %s: %w", name, err)
+		}
+
+		command := strings.Fields(values["command"])
+		if len(command) == 0 {
+			return fmt.Errorf("extractor %s: missing command", name)
+		}
+
+		custom = append(custom, &CommandExtractor{name, pattern, command})
+	}
+
+	extractors = append(custom, extractors...)
+	return nil
+}
+
+func ResolveMedia(url string) (*Media, error) {
+	for _, extractor := range extractors {
+		if !extractor.Match(url) {
+			continue
+		}
+
+		media, err := extractor.Resolve(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", extractor.Name(), err)
+		}
+
+		return media, nil
+	}
+
+	return nil, fmt.Errorf("no extractor for %s", url)
+}
+
+func init() {
+	RegisterExtractor(&HlsExtractor{})
+	RegisterExtractor(&DirectExtractor{})
+	RegisterExtractor(&YtdlExtractor{})
+}
+
+// CommandExtractor runs a user supplied command, replacing {url} in its
+// arguments. The first line of output is the media url, the optional second
+// line is the title.
+type CommandExtractor struct {
+	name    string
+	pattern *regexp.Regexp
+	command []string
+}
+
+func (e *CommandExtractor) Name() string {
+	return e.name
+}
+
+func (e *CommandExtractor) Match(url string) bool {
+	return e.pattern.MatchString(url)
+}
+
+func (e *CommandExtractor) Resolve(url string) (*Media, error) {
+	args := make([]string, len(e.command))
+	for i, arg := range e.command {
+		args[i] = strings.ReplaceAll(arg, "{url}", url)
+	}
+
+	out, err := ChildOutput(ChildCommand(context.Background(), args[0], args[1:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if lines[0] == "" {
+		return nil, errors.New("command printed no url")
+	}
+
+	media := &Media{URL: strings.TrimSpace(lines[0])}
+	if len(lines) > 1 {
+		media.Title = strings.TrimSpace(lines[1])
+	}
+
+	return media, nil
+}
+
+// MediaExtensions are the file extensions taken to be video files.
+var MediaExtensions = map[string]bool{
+	".avi": true, ".flv": true, ".m4v": true, ".mkv": true, ".mov": true,
+	".mp4": true, ".mpg": true, ".mpeg": true, ".ogv": true, ".ts": true,
+	".webm": true, ".gif": true, ".mjpeg": true, ".mjpg": true,
+}
+
+// DirectExtractor passes through urls that already point at a media file.
+type DirectExtractor struct{}
+
+func (e *DirectExtractor) Name() string {
+	return "direct"
+}
+
+func (e *DirectExtractor) Match(rawUrl string) bool {
+	u, err := neturl.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+
+	return MediaExtensions[strings.ToLower(urlpath.Ext(u.Path))]
+}
+
+func (e *DirectExtractor) Resolve(rawUrl string) (*Media, error) {
+	u, err := neturl.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Media{URL: rawUrl, Title: urlpath.Base(u.Path)}, nil
+}
+
+// HlsExtractor picks the lowest bandwidth variant of a master playlist, which
+// is plenty for a terminal sized picture.
+type HlsExtractor struct{}
+
+func (e *HlsExtractor) Name() string {
+	return "hls"
+}
+
+func (e *HlsExtractor) Match(rawUrl string) bool {
+	u, err := neturl.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+
+	return strings.ToLower(urlpath.Ext(u.Path)) == ".m3u8"
+}
+
+var bandwidthPattern = regexp.MustCompile(`[:,]BANDWIDTH=(\d+)`)
+
+// HLS_PLAYLIST_TIMEOUT bounds fetching the master playlist, so a server that
+// stalls doesn't hang startup.
+const HLS_PLAYLIST_TIMEOUT = 10 * time.Second
+
+func (e *HlsExtractor) Resolve(rawUrl string) (*Media, error) {
+	base, err := neturl.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HLS_PLAYLIST_TIMEOUT)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "GET", rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := Network.Client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("playlist request failed: %s", res.Status)
+	}
+
+	best := ""
+	bestBandwidth := -1
+	bandwidth := -1
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			bandwidth = 0
+			if matches := bandwidthPattern.FindStringSubmatch(line); matches != nil {
+				bandwidth, _ = strconv.Atoi(matches[1])
+			}
+
+		case line != "" && !strings.HasPrefix(line, "#") && bandwidth >= 0:
+			if bestBandwidth < 0 || bandwidth < bestBandwidth {
+				best = line
+				bestBandwidth = bandwidth
+			}
+			bandwidth = -1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	media := &Media{URL: rawUrl, Title: urlpath.Base(base.Path)}
+
+	// a media playlist rather than a master one, ffmpeg can take it as is
+	if best == "" {
+		return media, nil
+	}
+
+	variant, err := base.Parse(best)
+	if err != nil {
+		return nil, err
+	}
+
+	media.URL = variant.String()
+	return media, nil
+}
+
+// YtdlBinary finds yt-dlp, or youtube-dl when yt-dlp isn't installed and
+// wasn't pointed at.
+func YtdlBinary() (string, error) {
+	bin, err := exec.LookPath(ToolPath("yt-dlp"))
+	if err != nil && ToolPaths["yt-dlp"] == "" {
+		bin, err = exec.LookPath("youtube-dl")
+	}
+	if err != nil {
+		return "", YTDL
+	}
+
+	return bin, nil
+}
+
+// YtdlExtractor asks yt-dlp (or youtube-dl) for the worst format of a page.
+// It matches everything and so is registered last.
+type YtdlExtractor struct{}
+
+func (e *YtdlExtractor) Name() string {
+	return "ytdl"
+}
+
+func (e *YtdlExtractor) Match(url string) bool {
+	return true
+}
+
+func (e *YtdlExtractor) Resolve(url string) (*Media, error) {
+	bin, err := YtdlBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(YtdlArgs(), "-j", "--no-playlist", "-f", "worst", url)
+	out, err := ChildOutput(ChildCommand(context.Background(), bin, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Url         string            `json:"url"`
+		Title       string            `json:"title"`
+		HttpHeaders map[string]string `json:"http_headers"`
+	}
+
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, err
+	}
+
+	if info.Url == "" {
+		return nil, errors.New("no direct url in metadata")
+	}
+
+	return &Media{URL: info.Url, Title: info.Title, Headers: info.HttpHeaders}, nil
+}
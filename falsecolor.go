@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+)
+
+// FalseColors are the palettes --false-color takes. They show brightness as
+// color, which brings out more of IR cameras, depth maps and scientific
+// images than gray does, on a terminal all the more.
+var FalseColors = []string{"inferno", "viridis", "jet"}
+
+// colorStop is a color of a palette, at a position from 0 to 1.
+type colorStop struct {
+	at    float64
+	color color.NRGBA
+}
+
+// falseColorStops are matplotlib's palettes, sampled.
+var falseColorStops = map[string][]colorStop{
+	"inferno": evenStops(0x000004, 0x1f0c48, 0x550f6d, 0x88226a, 0xba3655, 0xe35933, 0xf98c0a, 0xf9c932, 0xfcffa4),
+	"viridis": evenStops(0x440154, 0x472c7a, 0x3b518b, 0x2c718e, 0x21908d, 0x27ad81, 0x5cc863, 0xaadc32, 0xfde725),
+	"jet": {
+		{0, rgb(0x00007f)},
+		{0.125, rgb(0x0000ff)},
+		{0.375, rgb(0x00ffff)},
+		{0.625, rgb(0xffff00)},
+		{0.875, rgb(0xff0000)},
+		{1, rgb(0x7f0000)},
+	},
+}
+
+func rgb(hex uint32) color.NRGBA {
+	return color.NRGBA{uint8(hex >> 16), uint8(hex >> 8), uint8(hex), 255}
+}
+
+func evenStops(colors ...uint32) []colorStop {
+	stops := make([]colorStop, len(colors))
+	for i, hex := range colors {
+		stops[i] = colorStop{float64(i) / float64(len(colors)-1), rgb(hex)}
+	}
+	return stops
+}
+
+// ParseFalseColor returns the filter mapping brightness to the named
+// palette.
+func ParseFalseColor(name string) (FrameFilter, error) {
+	stops, ok := falseColorStops[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette %s, expected one of %s", name, strings.Join(FalseColors, ", "))
+	}
+
+	var table [256]color.NRGBA
+	for v := range table {
+		at := float64(v) / 255
+		i := slices.IndexFunc(stops[1:], func(stop colorStop) bool { return stop.at >= at })
+		from, to := stops[i], stops[i+1]
+
+		t := (at - from.at) / (to.at - from.at)
+		mix := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5) }
+		table[v] = color.NRGBA{mix(from.color.R, to.color.R), mix(from.color.G, to.color.G), mix(from.color.B, to.color.B), 255}
+	}
+
+	return PixelFilter(func(picture *image.NRGBA) {
+		for i := 0; i+3 < len(picture.Pix); i += 4 {
+			c := table[luma(picture.Pix[i:i+3])]
+			picture.Pix[i], picture.Pix[i+1], picture.Pix[i+2] = c.R, c.G, c.B
+		}
+	}), nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FIFO_STALL is how long a writer can go without writing before the FIFO is
+// reported as stalled.
+const FIFO_STALL = 2 * time.Second
+
+func IsFifo(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.Mode()&os.ModeNamedPipe != 0
+}
+
+// Fifo is a named pipe played as a long lived display: writers may come and
+// go, each one's stream is played as it arrives, and in between the FIFO is
+// reopened to wait for the next. Streams are read as stdin is, see OpenPipe.
+type Fifo struct {
+	Path string
+
+	mu sync.Mutex
+	// open is set while a writer is connected
+	open bool
+	// read is when data last came
+	read time.Time
+}
+
+func OpenFifo(path string) *Source {
+	fifo := &Fifo{Path: path}
+
+	return &Source{
+		Name:  path,
+		Title: path,
+		// streams are scaled by ffmpeg, y4m and raw frames come at their
+		// own size
+		Scale:  true,
+		Runner: fifo.Run,
+		Status: fifo.Status,
+	}
+}
+
+func (f *Fifo) Status() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.open {
+		return fmt.Sprintf("waiting for a writer on %s", f.Path)
+	}
+
+	if quiet := time.Since(f.read); quiet >= FIFO_STALL {
+		return fmt.Sprintf("no data from %s for %s", f.Path, quiet.Truncate(time.Second))
+	}
+
+	return ""
+}
+
+// Run plays the stream of one writer after another, until ctx is done.
+func (f *Fifo) Run(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	for ctx.Err() == nil {
+		file, err := f.wait(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		f.open, f.read = true, time.Now()
+		f.mu.Unlock()
+
+		err = f.play(ctx, file, size, framesChannel)
+		file.Close()
+
+		f.mu.Lock()
+		f.open = false
+		f.mu.Unlock()
+
+		var missing *MissingToolError
+		if errors.As(err, &missing) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wait opens the FIFO, which blocks until a writer opens it too, or until
+// ctx is done.
+func (f *Fifo) wait(ctx context.Context) (*os.File, error) {
+	// a blocked open can't be cancelled, opening the other end lets it
+	// return instead
+	stop := context.AfterFunc(ctx, func() {
+		if writer, err := os.OpenFile(f.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			writer.Close()
+		}
+	})
+	defer stop()
+
+	return os.Open(f.Path)
+}
+
+// play plays the stream of the writer file was opened for. A stream that
+// can't be played is read to its end, to wait for the next writer.
+func (f *Fifo) play(ctx context.Context, file *os.File, size image.Point, framesChannel chan Frame) error {
+	// reads are unblocked by closing the file
+	stop := context.AfterFunc(ctx, func() { file.Close() })
+	defer stop()
+
+	stream := bufio.NewReaderSize(fifoReader{f, file}, 1<<16)
+
+	source, err := OpenPipe(stream)
+	if err == nil {
+		err = source.Runner(ctx, size, 0, framesChannel)
+	}
+
+	if err != nil {
+		io.Copy(io.Discard, stream)
+	}
+
+	return err
+}
+
+// fifoReader notes when data comes, for Status.
+type fifoReader struct {
+	fifo *Fifo
+	file *os.File
+}
+
+func (r fifoReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+
+	if n > 0 {
+		r.fifo.mu.Lock()
+		r.fifo.read = time.Now()
+		r.fifo.mu.Unlock()
+	}
+
+	return n, err
+}
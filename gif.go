@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"time"
+)
+
+// GIF_CELL_WIDTH and GIF_CELL_HEIGHT are the pixels of a cell in the images
+// of RasterizeScreen, the 5 by 7 glyphs drawn doubled in height.
+const (
+	GIF_CELL_WIDTH  = 8
+	GIF_CELL_HEIGHT = 16
+)
+
+// The colors of cells left at the defaults, those of a dark terminal.
+var (
+	defaultForeground = color.NRGBA{229, 229, 229, 255}
+	defaultBackground = color.NRGBA{0, 0, 0, 255}
+)
+
+// RasterizeScreen draws the first rows of screen into an image, each cell a
+// rectangle of its background with its character over it in its foreground,
+// block characters as the parts of the cell they cover. The colors are cut
+// down to the 256 a gif frame holds with MedianCut.
+func RasterizeScreen(screen *Screen, rows int) *image.Paletted {
+	rows = min(rows, screen.Rows)
+	cols := screen.Cols
+
+	// each cell's foreground then background, to pick the palette from
+	colors := image.NewNRGBA(image.Rect(0, 0, cols*2, rows))
+	for y := 0; y < rows; y++ {
+		for x, cell := range screen.Cells[y] {
+			colors.SetNRGBA(x*2, y, cellColor(cell.Fg, defaultForeground))
+			colors.SetNRGBA(x*2+1, y, cellColor(cell.Bg, defaultBackground))
+		}
+	}
+
+	boxes, indexes := MedianCut(colors, 256)
+	palette := make(color.Palette, len(boxes))
+	for i, c := range boxes {
+		palette[i] = c
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, cols*GIF_CELL_WIDTH, rows*GIF_CELL_HEIGHT), palette)
+
+	fill := func(x, y, top, bottom int, index uint8) {
+		for py := y*GIF_CELL_HEIGHT + top; py < y*GIF_CELL_HEIGHT+bottom; py++ {
+			row := img.Pix[py*img.Stride+x*GIF_CELL_WIDTH:][:GIF_CELL_WIDTH]
+			for i := range row {
+				row[i] = index
+			}
+		}
+	}
+
+	for y := 0; y < rows; y++ {
+		for x, cell := range screen.Cells[y] {
+			fg, bg := indexes[y*cols*2+x*2], indexes[y*cols*2+x*2+1]
+
+			fill(x, y, 0, GIF_CELL_HEIGHT, bg)
+
+			switch cell.Rune {
+			case 0, ' ':
+			case '▀':
+				fill(x, y, 0, GIF_CELL_HEIGHT/2, fg)
+			case '▄':
+				fill(x, y, GIF_CELL_HEIGHT/2, GIF_CELL_HEIGHT, fg)
+			case '█':
+				fill(x, y, 0, GIF_CELL_HEIGHT, fg)
+			default:
+				// a pixel of margin left and above, each glyph row two high
+				glyph := Glyph(cell.Rune)
+				for gy, bits := range glyph {
+					for gx := 0; gx < 5; gx++ {
+						if bits&(0x10>>gx) == 0 {
+							continue
+						}
+						px, py := x*GIF_CELL_WIDTH+1+gx, y*GIF_CELL_HEIGHT+1+gy*2
+						img.Pix[py*img.Stride+px] = fg
+						img.Pix[(py+1)*img.Stride+px] = fg
+					}
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// cellColor is c, or fallback for the default color.
+func cellColor(c, fallback color.NRGBA) color.NRGBA {
+	if c == (color.NRGBA{}) {
+		return fallback
+	}
+	return c
+}
+
+// ExportGif writes an animated gif of source as the renderer draws it, which
+// loops.
+func ExportGif(source *Source, options ExportOptions, path string) error {
+	animation := &gif.GIF{}
+	var positions []time.Duration
+
+	err := RenderScreens(source, options, func(screen *Screen, position time.Duration) error {
+		// the last row is kept free for the cursor
+		animation.Image = append(animation.Image, RasterizeScreen(screen, options.Rows-1))
+		positions = append(positions, position)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// each frame stays up until the next, the last for as long as the
+	// others are apart, in hundredths of a second
+	for i := range positions {
+		delay := time.Duration(float64(time.Second) / options.Fps)
+		if i+1 < len(positions) {
+			delay = positions[i+1] - positions[i]
+		}
+		animation.Delay = append(animation.Delay, max(int(delay/(10*time.Millisecond)), 1))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(file, animation); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
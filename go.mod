@@ -1,3 +1,9 @@
 module termtv
 
 go 1.22.0
+
+require golang.org/x/term v0.25.0
+
+require golang.org/x/sys v0.26.0
+
+require github.com/klauspost/compress v1.17.11
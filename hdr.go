@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Tonemaps are the curves --tonemap takes, as ffmpeg's tonemap filter names
+// them, and none to leave HDR as it is.
+var Tonemaps = []string{"hable", "reinhard", "mobius", "clip", "none"}
+
+// Tonemap is --tonemap, the curve HDR video is brought into the SDR range of
+// the terminal's colors with.
+var Tonemap = "hable"
+
+func RegisterTonemapFlag(flags *flag.FlagSet) {
+	flags.Func("tonemap", "curve to tonemap HDR video with: "+strings.Join(Tonemaps, ", ")+" (default hable)", func(value string) error {
+		if !slices.Contains(Tonemaps, value) {
+			return fmt.Errorf("unknown tonemap %s, expected one of %s", value, strings.Join(Tonemaps, ", "))
+		}
+		Tonemap = value
+		return nil
+	})
+}
+
+// HDR reports whether c has a PQ (HDR10) or HLG transfer, which look washed
+// out and gray when shown as SDR.
+func (c ColorInfo) HDR() bool {
+	return c.Transfer == "smpte2084" || c.Transfer == "arib-std-b67"
+}
+
+// Tonemapped reports whether c is tonemapped to SDR: it's HDR and ffmpeg has
+// the zscale filter to linearize it with.
+func (c ColorInfo) Tonemapped() bool {
+	return c.HDR() && Tonemap != "none" && hasZscale()
+}
+
+// TonemapFilter takes HDR video to linear light, tonemaps it with the
+// --tonemap curve and leaves it as BT.709, limited range, which is what
+// the ColorInfo of the result is.
+func TonemapFilter() (string, ColorInfo) {
+	filter := "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709," +
+		"tonemap=tonemap=" + Tonemap + ":desat=0," +
+		"zscale=t=bt709:m=bt709:r=tv,format=yuv444p"
+
+	return filter, ColorInfo{Space: "bt709", Range: "tv", Transfer: "bt709", Primaries: "bt709"}
+}
+
+// hasZscale reports whether ffmpeg was built with zimg, which most builds
+// are, asking it once.
+var hasZscale = sync.OnceValue(func() bool {
+	cmd := ChildCommand(context.Background(), ToolPath("ffmpeg"), "-hide_banner", "-filters")
+	out, err := ChildOutput(cmd)
+	return err == nil && strings.Contains(string(out), " zscale ")
+})
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Histogram counts the pixels of a picture at each level of red, green,
+// blue and luma.
+type Histogram struct {
+	RGB  [3][256]int
+	Luma [256]int
+}
+
+func MeasureHistogram(picture *image.NRGBA) *Histogram {
+	h := &Histogram{}
+
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		pixel := picture.Pix[i : i+3]
+		h.RGB[0][pixel[0]]++
+		h.RGB[1][pixel[1]]++
+		h.RGB[2][pixel[2]]++
+		h.Luma[luma(pixel)]++
+	}
+
+	return h
+}
+
+// DrawHistogram draws the histogram of picture in its bottom left corner,
+// a quarter of it wide and high: red, green and blue added up over a
+// darkened background, so where they overlap is white, and luma as a white
+// outline over them. Heights go by the square root of the counts.
+func DrawHistogram(picture *image.NRGBA) {
+	size := picture.Rect.Size()
+	width, height := size.X/4, size.Y/4
+	if width < 8 || height < 4 {
+		return
+	}
+
+	h := MeasureHistogram(picture)
+
+	// levels are grouped into one bin per column
+	bin := func(counts *[256]int, x int) int {
+		total := 0
+		for level := x * 256 / width; level < (x+1)*256/width; level++ {
+			total += counts[level]
+		}
+		return total
+	}
+
+	var columns [4][]int
+	peak := 1
+	for c := range columns {
+		counts := &h.Luma
+		if c < 3 {
+			counts = &h.RGB[c]
+		}
+
+		columns[c] = make([]int, width)
+		for x := range columns[c] {
+			// on a square root scale, so a spike leaves the rest
+			// visible
+			columns[c][x] = int(math.Sqrt(float64(bin(counts, x))) * 16)
+			// black bars and clipped highlights would dwarf the rest,
+			// the columns at either end go off the top instead
+			if x > 0 && x < width-1 {
+				peak = max(peak, columns[c][x])
+			}
+		}
+	}
+
+	top := picture.Rect.Min.Y + size.Y - height
+	left := picture.Rect.Min.X
+
+	for x := 0; x < width; x++ {
+		var bars [4]int
+		for c := range bars {
+			bars[c] = min(columns[c][x]*height/peak, height)
+		}
+
+		for y := 0; y < height; y++ {
+			level := height - 1 - y
+			i := picture.PixOffset(left+x, top+y)
+			pixel := picture.Pix[i : i+3]
+
+			pen := color.NRGBA{pixel[0] / 4, pixel[1] / 4, pixel[2] / 4, 255}
+			if level < bars[0] {
+				pen.R = 255
+			}
+			if level < bars[1] {
+				pen.G = 255
+			}
+			if level < bars[2] {
+				pen.B = 255
+			}
+			if level == min(bars[3], height-1) {
+				pen = color.NRGBA{255, 255, 255, 255}
+			}
+
+			pixel[0], pixel[1], pixel[2] = pen.R, pen.G, pen.B
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+type HookKind string
+
+const (
+	HOOK_START = HookKind("start")
+	HOOK_END   = HookKind("end")
+	HOOK_ERROR = HookKind("error")
+)
+
+// Hooks are shell commands run around playback. They get the details of the
+// event through TERMTV_* environment variables.
+type Hooks struct {
+	OnStart string
+	OnEnd   string
+	OnError string
+}
+
+type HookEvent struct {
+	Source   string
+	Title    string
+	Frame    int
+	Position time.Duration
+	Err      error
+}
+
+func (e HookEvent) Env(kind HookKind) []string {
+	env := []string{
+		"TERMTV_EVENT=" + string(kind),
+		"TERMTV_SOURCE=" + e.Source,
+		"TERMTV_TITLE=" + e.Title,
+		"TERMTV_FRAME=" + strconv.Itoa(e.Frame),
+		fmt.Sprintf("TERMTV_POSITION=%.3f", e.Position.Seconds()),
+	}
+
+	if e.Err != nil {
+		env = append(env, "TERMTV_ERROR="+e.Err.Error())
+	}
+
+	return env
+}
+
+// Run executes the hook for kind, if any. The start hook runs in the
+// background so it doesn't hold up playback, the others are waited for since
+// termtv is about to exit.
+func (h Hooks) Run(kind HookKind, event HookEvent) {
+	command := map[HookKind]string{
+		HOOK_START: h.OnStart,
+		HOOK_END:   h.OnEnd,
+		HOOK_ERROR: h.OnError,
+	}[kind]
+
+	if command == "" {
+		return
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), event.Env(kind)...)
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to run %s hook: %v", kind, err)
+		return
+	}
+
+	if kind == HOOK_START {
+		go cmd.Wait()
+		return
+	}
+
+	cmd.Wait()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// HwAccels are the hardware decoders --hwaccel takes, as ffmpeg names them.
+var HwAccels = []string{"auto", "vaapi", "videotoolbox", "nvdec", "qsv", "d3d11va"}
+
+// HwAccel is --hwaccel, the hardware decoder ffmpeg is asked for, so that big
+// sources don't take a core to decode only to be shrunk to the terminal.
+var HwAccel string
+
+// hwaccelFailed is set once the hardware decoder failed to start, after which
+// everything is decoded in software.
+var hwaccelFailed atomic.Bool
+
+func RegisterHwaccelFlag(flags *flag.FlagSet) {
+	flags.Func("hwaccel", "hardware decoder for ffmpeg to use: "+strings.Join(HwAccels, ", ")+", falling back to software when it fails", func(value string) error {
+		if !slices.Contains(HwAccels, value) {
+			return fmt.Errorf("unknown hwaccel %s, expected one of %s", value, strings.Join(HwAccels, ", "))
+		}
+		HwAccel = value
+		return nil
+	})
+}
+
+// HwaccelArgs are the ffmpeg input options for HwAccel. Frames are copied
+// back from the device to be scaled as before.
+func HwaccelArgs() []string {
+	if HwAccel == "" || hwaccelFailed.Load() {
+		return nil
+	}
+	return []string{"-hwaccel", HwAccel}
+}
+
+// withoutHwaccel returns args with the HwaccelArgs taken out, or nil when
+// they had none.
+func withoutHwaccel(args []string) []string {
+	i := slices.Index(args, "-hwaccel")
+	if i < 0 || i+1 >= len(args) {
+		return nil
+	}
+	return slices.Delete(slices.Clone(args), i, i+2)
+}
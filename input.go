@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var escapeKeys = map[string]string{
+	"\x1b[A":  "up",
+	"\x1b[B":  "down",
+	"\x1b[C":  "right",
+	"\x1b[D":  "left",
+	"\x1bOA":  "up",
+	"\x1bOB":  "down",
+	"\x1bOC":  "right",
+	"\x1bOD":  "left",
+	"\x1b[H":  "home",
+	"\x1b[F":  "end",
+	"\x1b[1~": "home",
+	"\x1b[2~": "insert",
+	"\x1b[3~": "delete",
+	"\x1b[4~": "end",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdown",
+}
+
+// ReadKeys reads raw terminal input from r and delivers it as key names, see
+// ParseKeys. The channel is closed when r fails.
+func ReadKeys(r io.Reader) <-chan string {
+	keys := make(chan string)
+
+	go func() {
+		defer close(keys)
+
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for _, key := range ParseKeys(buf[:n]) {
+				keys <- key
+			}
+		}
+	}()
+
+	return keys
+}
+
+// ParseKeys turns a chunk of raw input into key names: printable characters
+// stand for themselves, others are named like "space", "enter", "left" or
+// "ctrl-c". Unknown escape sequences are dropped.
+func ParseKeys(input []byte) []string {
+	var keys []string
+
+	for len(input) > 0 {
+		b := input[0]
+
+		if b == 0x1b && len(input) > 1 {
+			n, key := parseEscape(input)
+			if key != "" {
+				keys = append(keys, key)
+			}
+			input = input[n:]
+			continue
+		}
+
+		switch {
+		case b == 0x1b:
+			keys = append(keys, "esc")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == '\t':
+			keys = append(keys, "tab")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "backspace")
+		case b == ' ':
+			keys = append(keys, "space")
+		case b >= 1 && b <= 26:
+			keys = append(keys, "ctrl-"+string(rune('a'+b-1)))
+		case b < 0x20:
+			// remaining control characters have no useful name
+		default:
+			r, size := utf8.DecodeRune(input)
+			keys = append(keys, string(r))
+			input = input[size:]
+			continue
+		}
+
+		input = input[1:]
+	}
+
+	return keys
+}
+
+func parseEscape(input []byte) (int, string) {
+	for seq, key := range escapeKeys {
+		if strings.HasPrefix(string(input), seq) {
+			return len(seq), key
+		}
+	}
+
+	if strings.HasPrefix(string(input), "\x1b[<") {
+		return parseMouse(input)
+	}
+
+	if input[1] == '[' {
+		// skip an unknown CSI sequence up to its final byte
+		for i := 2; i < len(input); i++ {
+			if input[i] >= 0x40 && input[i] <= 0x7e {
+				return i + 1, ""
+			}
+		}
+		return len(input), ""
+	}
+
+	keys := ParseKeys(input[1:2])
+	if len(keys) == 0 {
+		return 2, ""
+	}
+
+	return 2, "alt-" + keys[0]
+}
+
+// parseMouse parses an SGR mouse report, ESC [ < button ; x ; y M, or m
+// for a release. A press of the left button is "click X Y", in cells from
+// 1, and the wheel "wheel-up" or "wheel-down"; other buttons and releases
+// have no name.
+func parseMouse(input []byte) (int, string) {
+	end := bytes.IndexAny(input, "Mm")
+	if end < 0 {
+		return len(input), ""
+	}
+
+	fields := strings.Split(string(input[3:end]), ";")
+	if len(fields) != 3 || input[end] == 'm' {
+		return end + 1, ""
+	}
+
+	button, _ := strconv.Atoi(fields[0])
+	switch button {
+	case 0:
+		return end + 1, "click " + fields[1] + " " + fields[2]
+	case 64:
+		return end + 1, "wheel-up"
+	case 65:
+		return end + 1, "wheel-down"
+	}
+
+	return end + 1, ""
+}
+
+// ParseClick returns the cell of a "click X Y" key.
+func ParseClick(key string) (x, y int, ok bool) {
+	_, err := fmt.Sscanf(key, "click %d %d", &x, &y)
+	return x, y, err == nil
+}
+
+// KeyBindings maps key names to player commands, see ParseCommand.
+type KeyBindings map[string]string
+
+func DefaultKeyBindings() KeyBindings {
+	return KeyBindings{
+		"q":          "quit",
+		"ctrl-c":     "quit",
+		"space":      "pause",
+		"p":          "pause",
+		"i":          "stats",
+		"v":          "meters",
+		"h":          "histogram",
+		"r":          "replay",
+		"b":          "reverse",
+		"o":          "pip-move",
+		"]":          "pip-size 0.05",
+		"[":          "pip-size -0.05",
+		"w":          "pip-swap",
+		"tab":        "split",
+		",":          "wipe -0.05",
+		".":          "wipe 0.05",
+		"left":       "seek -5",
+		"right":      "seek 5",
+		"down":       "seek -60",
+		"up":         "seek 60",
+		"+":          "audio-delay 0.05",
+		"-":          "audio-delay -0.05",
+		"9":          "volume -5",
+		"0":          "volume 5",
+		"wheel-down": "volume -5",
+		"wheel-up":   "volume 5",
+		"x":          "sub-delay 0.1",
+		"z":          "sub-delay -0.1",
+	}
+}
+
+// Apply overrides bindings with the [keys] section of the config file. An
+// empty command or "none" unbinds the key.
+func (b KeyBindings) Apply(section map[string]string) error {
+	for key, command := range section {
+		if command == "" || command == "none" {
+			delete(b, key)
+			continue
+		}
+
+		if _, err := ParseCommand(command); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+
+		b[key] = command
+	}
+
+	return nil
+}
+
+func (b KeyBindings) Print(w io.Writer) {
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%-10s %s\n", key, b[key])
+	}
+}
+
+type Command struct {
+	Name string
+	Arg  float64
+}
+
+func ParseCommand(command string) (Command, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return Command{}, fmt.Errorf("empty command")
+	}
+
+	c := Command{Name: fields[0]}
+
+	switch c.Name {
+	case "quit", "pause", "stats", "meters", "histogram", "replay", "reverse", "pip-move", "pip-swap", "split":
+		if len(fields) != 1 {
+			return c, fmt.Errorf("%s takes no arguments", c.Name)
+		}
+
+	case "seek":
+		if len(fields) != 2 {
+			return c, fmt.Errorf("seek takes the number of seconds to seek by")
+		}
+
+		arg, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid seek amount %s", fields[1])
+		}
+		c.Arg = arg
+
+	case "audio-delay":
+		if len(fields) != 2 {
+			return c, fmt.Errorf("audio-delay takes the number of seconds to delay the sound by")
+		}
+
+		arg, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid audio-delay amount %s", fields[1])
+		}
+		c.Arg = arg
+
+	case "volume":
+		if len(fields) != 2 {
+			return c, fmt.Errorf("volume takes the percentage points to change the volume by")
+		}
+
+		arg, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid volume amount %s", fields[1])
+		}
+		c.Arg = arg
+
+	case "sub-delay":
+		if len(fields) != 2 {
+			return c, fmt.Errorf("sub-delay takes the number of seconds to delay the subtitles by")
+		}
+
+		arg, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid sub-delay amount %s", fields[1])
+		}
+		c.Arg = arg
+
+	case "pip-size", "wipe":
+		if len(fields) != 2 {
+			return c, fmt.Errorf("%s takes the part of the width to move by", c.Name)
+		}
+
+		arg, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid %s amount %s", c.Name, fields[1])
+		}
+		c.Arg = arg
+
+	default:
+		return c, fmt.Errorf("unknown command %s", c.Name)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "image"
+
+// BlendPictures returns the even blend of pictures a and b, the same size,
+// in blended, which is allocated if it's nil or another size. It's the
+// frame halfway between them, without estimating any motion: what moves
+// shows in both places, faded, which reads as smoother at a few frames a
+// second.
+func BlendPictures(blended, a, b *image.NRGBA) *image.NRGBA {
+	if blended == nil || blended.Rect != a.Rect {
+		blended = image.NewNRGBA(a.Rect)
+	}
+
+	for i := range blended.Pix {
+		blended.Pix[i] = uint8((uint16(a.Pix[i]) + uint16(b.Pix[i]) + 1) / 2)
+	}
+
+	return blended
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"strconv"
+	"time"
+)
+
+// Audio fingerprints follow Haitsma and Kalker: every FINGERPRINT_HOP samples
+// a frame of FINGERPRINT_FRAME samples is split into 33 bands between 300 and
+// 2000Hz, and each of the 32 bits says whether the energy difference between
+// neighbouring bands grew since the previous frame.
+const (
+	FINGERPRINT_RATE  = 5512
+	FINGERPRINT_FRAME = 2048
+	FINGERPRINT_HOP   = 128
+
+	// how far into each episode to look for the intro
+	INTRO_SEARCH = 5 * time.Minute
+	// shorter matches are more likely a recap or a jingle
+	INTRO_MIN = 10 * time.Second
+)
+
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// FingerprintDuration converts a number of fingerprint hashes to time.
+func FingerprintDuration(hashes int) time.Duration {
+	return time.Duration(hashes) * FINGERPRINT_HOP * time.Second / FINGERPRINT_RATE
+}
+
+// AudioFingerprint fingerprints the first length of the audio of input.
+func AudioFingerprint(input string, length time.Duration) ([]uint32, error) {
+	args := HttpArgs(input, nil)
+	args = append(args,
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64),
+		"-i", input,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(FINGERPRINT_RATE),
+		"-f", "s16le",
+	)
+	args = append(args, LogArgs()...)
+	args = append(args, "-")
+
+	out, err := ChildOutput(ChildCommand(context.Background(), ToolPath("ffmpeg"), args...))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]float64, len(out)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(out[i*2:]))) / 32768
+	}
+
+	return Fingerprint(samples), nil
+}
+
+// Fingerprint hashes mono samples at FINGERPRINT_RATE.
+func Fingerprint(samples []float64) []uint32 {
+	var edges [34]int
+	for i := range edges {
+		hz := 300 * math.Pow(2000.0/300, float64(i)/33)
+		edges[i] = int(hz * FINGERPRINT_FRAME / FINGERPRINT_RATE)
+	}
+
+	window := make([]float64, FINGERPRINT_FRAME)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/FINGERPRINT_FRAME)
+	}
+
+	var hashes []uint32
+	var previous [33]float64
+	frame := make([]complex128, FINGERPRINT_FRAME)
+
+	for start := 0; start+FINGERPRINT_FRAME <= len(samples); start += FINGERPRINT_HOP {
+		for i := range frame {
+			frame[i] = complex(samples[start+i]*window[i], 0)
+		}
+		FFT(frame)
+
+		var energy [33]float64
+		for band := range energy {
+			for bin := edges[band]; bin < edges[band+1]; bin++ {
+				energy[band] += cmplx.Abs(frame[bin])
+			}
+		}
+
+		if start > 0 {
+			var hash uint32
+			for b := 0; b < 32; b++ {
+				if energy[b]-energy[b+1]-(previous[b]-previous[b+1]) > 0 {
+					hash |= 1 << b
+				}
+			}
+			hashes = append(hashes, hash)
+		}
+
+		previous = energy
+	}
+
+	return hashes
+}
+
+// FFT transforms x in place, its length must be a power of two.
+func FFT(x []complex128) {
+	n := len(x)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// MatchIntro finds the longest stretch of audio the two fingerprints share,
+// returning where it starts in each and its length in hashes.
+func MatchIntro(a, b []uint32) (int, int, int) {
+	bestA, bestB, bestLength := 0, 0, 0
+
+	for shift := -len(a) + 1; shift < len(b); shift++ {
+		// a run survives a few noisy hashes in a row
+		start, misses := -1, 0
+
+		for i := max(0, -shift); i < len(a) && i+shift < len(b); i++ {
+			if bits.OnesCount32(a[i]^b[i+shift]) <= 8 {
+				if start < 0 {
+					start = i
+				}
+				misses = 0
+
+				if length := i - start + 1; length > bestLength {
+					bestA, bestB, bestLength = start, start+shift, length
+				}
+				continue
+			}
+
+			if misses++; misses > 3 {
+				start = -1
+			}
+		}
+	}
+
+	return bestA, bestB, bestLength
+}
+
+// FindSegment returns where segment appears in hashes, or -1 when no place
+// comes close enough.
+func FindSegment(segment, hashes []uint32) int {
+	best, bestErrors := -1, len(segment)*32*35/100
+
+	for start := 0; start+len(segment) <= len(hashes); start++ {
+		errors := 0
+		for i, hash := range segment {
+			errors += bits.OnesCount32(hash ^ hashes[start+i])
+			if errors >= bestErrors {
+				break
+			}
+		}
+
+		if errors < bestErrors {
+			best, bestErrors = start, errors
+		}
+	}
+
+	return best
+}
+
+// IntroDetector finds the intro shared by the episodes it is shown. The first
+// episode is only remembered, the second is compared to it to find the
+// intro, which is then looked for in the rest.
+type IntroDetector struct {
+	first []uint32
+	intro []uint32
+}
+
+// Detect returns where the intro is in source, if it has one.
+func (d *IntroDetector) Detect(source *Source) (Segment, bool) {
+	if source.Input == "" {
+		return Segment{}, false
+	}
+
+	hashes, err := AudioFingerprint(source.Input, INTRO_SEARCH)
+	if err != nil || len(hashes) == 0 {
+		return Segment{}, false
+	}
+
+	if d.first == nil && d.intro == nil {
+		d.first = hashes
+		return Segment{}, false
+	}
+
+	if d.intro == nil {
+		start, matched, length := MatchIntro(d.first, hashes)
+		if FingerprintDuration(length) < INTRO_MIN {
+			return Segment{}, false
+		}
+
+		d.intro = d.first[start : start+length]
+		d.first = nil
+
+		return Segment{FingerprintDuration(matched), FingerprintDuration(matched + length)}, true
+	}
+
+	start := FindSegment(d.intro, hashes)
+	if start < 0 {
+		return Segment{}, false
+	}
+
+	return Segment{FingerprintDuration(start), FingerprintDuration(start + len(d.intro))}, true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lyricTag matches the tags at the start of an LRC line: times like
+// [01:23.45] and metadata like [offset:+250].
+var lyricTag = regexp.MustCompile(`^\[([^\]]*)\]`)
+
+type LyricLine struct {
+	At   time.Duration
+	Text string
+}
+
+// Lyrics are timed lines of an LRC file, in time order.
+type Lyrics struct {
+	Lines []LyricLine
+}
+
+func ReadLyrics(path string) (*Lyrics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseLyrics(file)
+}
+
+// ParseLyrics parses LRC. A line may have several times, for a chorus that
+// repeats, and [offset:ms] shifts every line earlier by ms. Lines without a
+// time, and tags other than offset, are skipped.
+func ParseLyrics(r io.Reader) (*Lyrics, error) {
+	lyrics := &Lyrics{}
+	var offset time.Duration
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		var times []time.Duration
+		for {
+			match := lyricTag.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			line = line[len(match[0]):]
+
+			key, value, _ := strings.Cut(match[1], ":")
+			if key == "offset" {
+				ms, _ := strconv.Atoi(strings.TrimSpace(value))
+				offset = time.Duration(ms) * time.Millisecond
+			} else if at, ok := lyricTime(match[1]); ok {
+				times = append(times, at)
+			}
+		}
+
+		for _, at := range times {
+			lyrics.Lines = append(lyrics.Lines, LyricLine{At: at, Text: strings.TrimSpace(line)})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range lyrics.Lines {
+		lyrics.Lines[i].At -= offset
+	}
+	sort.SliceStable(lyrics.Lines, func(i, j int) bool { return lyrics.Lines[i].At < lyrics.Lines[j].At })
+
+	return lyrics, nil
+}
+
+// lyricTime parses mm:ss, mm:ss.xx or mm:ss:xx.
+func lyricTime(tag string) (time.Duration, bool) {
+	minutes, seconds, found := strings.Cut(tag, ":")
+	if !found {
+		return 0, false
+	}
+	// some files put a colon before the hundredths
+	if whole, fraction, found := strings.Cut(seconds, ":"); found {
+		seconds = whole + "." + fraction
+	}
+
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, false
+	}
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), true
+}
+
+// At returns the index of the line sung at position, -1 before the first.
+func (l *Lyrics) At(position time.Duration) int {
+	return sort.Search(len(l.Lines), func(i int) bool { return l.Lines[i].At > position }) - 1
+}
+
+// LyricsFor finds the LRC file next to a media file, song.lrc for song.mp3.
+func LyricsFor(path string) string {
+	if IsUrl(path) {
+		return ""
+	}
+
+	lrc := strings.TrimSuffix(path, filepath.Ext(path)) + ".lrc"
+	if _, err := os.Stat(lrc); err != nil {
+		return ""
+	}
+
+	return lrc
+}
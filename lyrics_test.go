@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLyrics(t *testing.T) {
+	s := time.Second
+
+	tests := []struct {
+		name  string
+		input string
+		want  []LyricLine
+	}{
+		{"lines", "[00:01.50]one\n[00:03.00] two \n", []LyricLine{{1500 * time.Millisecond, "one"}, {3 * s, "two"}}},
+		{"minutes", "[02:03.25]late", []LyricLine{{2*time.Minute + 3250*time.Millisecond, "late"}}},
+		{"colon hundredths", "[00:04:50]x", []LyricLine{{4500 * time.Millisecond, "x"}}},
+		{"whole seconds", "[00:04]x", []LyricLine{{4 * s, "x"}}},
+		{"repeated", "[00:10.00][00:01.00]chorus\n[00:05.00]verse", []LyricLine{{1 * s, "chorus"}, {5 * s, "verse"}, {10 * s, "chorus"}}},
+		{"offset", "[offset:+500]\n[00:02.00]x", []LyricLine{{1500 * time.Millisecond, "x"}}},
+		{"offset after the lines", "[00:02.00]x\n[offset:-500]", []LyricLine{{2500 * time.Millisecond, "x"}}},
+		{"metadata", "[ar:Someone]\n[ti:Song]\n[length:03:20]\n[00:01.00]x", []LyricLine{{1 * s, "x"}}},
+		{"gap", "[00:01.00]x\n[00:02.00]\n", []LyricLine{{1 * s, "x"}, {2 * s, ""}}},
+		{"untimed", "plain text\n[00:01.00]x\n", []LyricLine{{1 * s, "x"}}},
+		{"unclosed", "[00:01.00\n[00:02.00]x", []LyricLine{{2 * s, "x"}}},
+		{"bad time", "[aa:bb]x\n[00:xx]y\n[1:2:3:4]z", nil},
+		{"crlf", "[00:01.00]x\r\n[00:02.00]y\r\n", []LyricLine{{1 * s, "x"}, {2 * s, "y"}}},
+		{"empty", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lyrics, err := ParseLyrics(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(lyrics.Lines, test.want) {
+				t.Errorf("lines are %v, want %v", lyrics.Lines, test.want)
+			}
+		})
+	}
+
+	t.Run("line too long", func(t *testing.T) {
+		if _, err := ParseLyrics(strings.NewReader("[00:01.00]" + strings.Repeat("x", 1<<17))); err == nil {
+			t.Error("parsed without an error")
+		}
+	})
+}
+
+func TestLyricsAt(t *testing.T) {
+	lyrics, _ := ParseLyrics(strings.NewReader("[00:01.00]one\n[00:02.00]two\n[00:02.00]also two\n"))
+
+	for position, want := range map[time.Duration]int{
+		0:                       -1,
+		time.Second - 1:         -1,
+		time.Second:             0,
+		1500 * time.Millisecond: 0,
+		2 * time.Second:         2,
+		time.Hour:               2,
+	} {
+		if got := lyrics.At(position); got != want {
+			t.Errorf("line at %v is %d, want %d", position, got, want)
+		}
+	}
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"bytes"
-	"flag"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
-	"log"
 	"math"
 	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
 const (
@@ -85,6 +87,44 @@ func Downscale(original *image.NRGBA, resized *image.NRGBA) {
 	}
 }
 
+// FitSize is the part of target a picture of size fills keeping its aspect
+// ratio, rounded up to whole cells, or target when size isn't known.
+// Downscaling to it leaves no black bars.
+func FitSize(size, target, cell image.Point) image.Point {
+	if size.X <= 0 || size.Y <= 0 {
+		return target
+	}
+
+	ratio := max(float64(size.X)/float64(target.X), float64(size.Y)/float64(target.Y))
+	fit := func(pixels float64, cell, target int) int {
+		n := int(math.Ceil(pixels/ratio - 0.001))
+		return max(min((n+cell-1)/cell*cell, target), 1)
+	}
+
+	return image.Pt(fit(float64(size.X), cell.X, target.X), fit(float64(size.Y), cell.Y, target.Y))
+}
+
+// Fit returns frame downscaled into resized, or frame itself when it already
+// is the right size.
+func Fit(frame *image.NRGBA, resized *image.NRGBA) *image.NRGBA {
+	if frame.Rect == resized.Rect {
+		return frame
+	}
+
+	Downscale(frame, resized)
+	return resized
+}
+
+// DrawImage draws img, decoded from a file, into frame, over black where it
+// is transparent. frame has to be the size of img.
+func DrawImage(frame *image.NRGBA, img image.Image) {
+	// frames are opaque, so drawing as RGBA takes the fast paths of
+	// image/draw and leaves the same bytes
+	rgba := &image.RGBA{Pix: frame.Pix, Stride: frame.Stride, Rect: frame.Rect}
+	draw.Draw(rgba, rgba.Rect, image.Black, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Over)
+}
+
 type Parameter int
 
 const (
@@ -106,181 +146,365 @@ func StackPixels(top color.NRGBA, bottom color.NRGBA) string {
 	return EscSequence(BACKGROUND, bottom, fg)
 }
 
-func GetDimensions(path string) (*image.Point, error) {
-	cmd := exec.Command(
-		"ffprobe",
-		"-i", path,
+// WriteHalfBlocks draws picture where it is on the grid, two pixels per cell.
+func WriteHalfBlocks(buffer *bytes.Buffer, picture *image.NRGBA) {
+	bounds := picture.Rect
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		// rows are placed rather than separated by newlines, which would
+		// scroll the screen after a row that fills its last line
+		writePosition(buffer, image.Pt(bounds.Min.X, y), image.Pt(1, 2))
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top := picture.NRGBAAt(x, y)
+			bot := picture.NRGBAAt(x, y+1)
+			buffer.WriteString(StackPixels(top, bot))
+		}
+	}
+}
+
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+type ProbeInfo struct {
+	Size      image.Point
+	FrameRate float64
+	Duration  time.Duration
+	Chapters  []Chapter
+	// Audio is set when there is an audio stream
+	Audio bool
+	Color ColorInfo
+	// Codec is ffprobe's name for the codec of the video, like h264
+	Codec string
+}
+
+func Probe(input string) (*ProbeInfo, error) {
+	args := HttpArgs(input, nil)
+	args = append(args,
+		"-i", input,
 		"-show_streams",
-		"-select_streams", "v",
-		"-loglevel", "quiet",
+		"-show_chapters",
 		"-output_format", "compact",
 	)
+	args = append(args, LogArgs()...)
 
-	out, err := cmd.Output()
+	out, err := ChildOutput(ChildCommand(context.Background(), ToolPath("ffprobe"), args...))
 	if err != nil {
 		return nil, err
 	}
 
-	pattern := regexp.MustCompile(`width=(\d+)\|height=(\d+)`)
-	matches := pattern.FindStringSubmatch(string(out))
+	info := &ProbeInfo{}
+	stream := false
+	// the duration of audio only sources comes from their audio
+	var audio time.Duration
+
+	// compact output is a line per section: stream|key=value|key=value
+	for _, line := range strings.Split(string(out), "\n") {
+		section, rest, _ := strings.Cut(line, "|")
+		fields := map[string]string{}
 
-	size := image.Point{}
+		for _, field := range strings.Split(rest, "|") {
+			key, value, _ := strings.Cut(field, "=")
+			fields[key] = value
+		}
+
+		switch {
+		case section == "stream" && fields["codec_type"] == "audio":
+			if !info.Audio {
+				info.Audio = true
+				audio = ParseSeconds(fields["duration"])
+			}
+
+		case section == "stream" && (fields["codec_type"] != "video" || fields["disposition:attached_pic"] == "1"):
+			// subtitles, data and cover art
+
+		case section == "stream" && !stream:
+			stream = true
+
+			info.Size.X, _ = strconv.Atoi(fields["width"])
+			info.Size.Y, _ = strconv.Atoi(fields["height"])
+			info.Color = ProbeColor(fields, info.Size.Y)
+			info.Codec = fields["codec_name"]
+
+			info.FrameRate = ParseRate(fields["avg_frame_rate"])
+			if info.FrameRate == 0 {
+				info.FrameRate = ParseRate(fields["r_frame_rate"])
+			}
 
-	if len(matches) >= 3 {
-		size.X, _ = strconv.Atoi(matches[1])
-		size.Y, _ = strconv.Atoi(matches[2])
+			// live streams report N/A
+			info.Duration = ParseSeconds(fields["duration"])
+
+		case section == "chapter":
+			info.Chapters = append(info.Chapters, Chapter{
+				Start: ParseSeconds(fields["start_time"]),
+				Title: fields["tag:title"],
+			})
+		}
+	}
+
+	if !stream {
+		info.Duration = audio
 	}
 
-	return &size, nil
+	return info, nil
 }
 
-func UrlFrameRunner(url string, size image.Point, framesChannel chan []byte) {
-	ytdl := exec.Command(
-		"youtube-dl",
-		"-o", "-",
-		url,
-		"-f", "worst",
-	)
+func ParseSeconds(seconds string) time.Duration {
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return 0
+	}
 
-	ffmpeg := exec.Command(
-		"ffmpeg",
-		"-i", "pipe:0",
-		"-s", fmt.Sprintf("%dx%d", size.X, size.Y),
-		"-loglevel", "quiet",
-		"-pix_fmt", "rgb0",
-		"-vcodec", "rawvideo",
-		"-f", "image2pipe",
-		"-",
-	)
+	return time.Duration(s * float64(time.Second))
+}
 
-	in, out := io.Pipe()
-	defer out.Close()
+// ParseRate parses ffprobe rationals like 30000/1001, returning 0 when the
+// rate is unknown.
+func ParseRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
 
-	ytdl.Stdout = out
-	ffmpeg.Stdin = in
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
 
-	stdout, _ := ffmpeg.StdoutPipe()
+	if !found {
+		return n
+	}
 
-	ytdl.Start()
-	ffmpeg.Start()
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
 
-	frame := make([]byte, size.X*size.Y*4)
+	return n / d
+}
 
-	for {
-		_, err := io.ReadFull(stdout, frame)
-		if err != nil {
-			break
-		}
+// ScaleFilter fits the video into size keeping its aspect ratio and pads the
+// rest with black, the same way Downscale leaves it, converting from color
+// to RGB on the way. HDR is tonemapped once scaled down, where it's cheap.
+func ScaleFilter(size image.Point, color ColorInfo) string {
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", size.X, size.Y)
+
+	if color.Tonemapped() {
+		tonemap, sdr := TonemapFilter()
+		return fmt.Sprintf("%s,pad=%d:%d,%s,scale=%s", scale, size.X, size.Y, tonemap, sdr.ScaleOptions())
+	}
 
-		framesChannel <- frame
+	if options := color.ScaleOptions(); options != "" {
+		scale += ":" + options
 	}
 
-	ytdl.Wait()
-	ffmpeg.Wait()
-	close(framesChannel)
+	return fmt.Sprintf("%s,pad=%d:%d", scale, size.X, size.Y)
 }
 
-func FileFrameRunner(path string, size image.Point, framesChannel chan []byte) {
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", path,
-		"-loglevel", "quiet",
-		"-pix_fmt", "rgb0",
-		"-vcodec", "rawvideo",
-		"-f", "image2pipe",
-		"-",
-	)
+// ColorFilter only converts from color to RGB, for video kept at its size.
+func ColorFilter(color ColorInfo) string {
+	filter := ""
+	if color.Tonemapped() {
+		filter, color = TonemapFilter()
+		filter += ","
+	}
+
+	if options := color.ScaleOptions(); options != "" {
+		filter += "scale=" + options + ","
+	}
+	return filter
+}
+
+func SeekArgs(offset time.Duration) []string {
+	if offset <= 0 {
+		return nil
+	}
+
+	return []string{"-ss", fmt.Sprintf("%.3f", offset.Seconds())}
+}
+
+// FFMPEG_LOG makes ffmpeg log just enough for the showinfo filter that ends
+// every filter chain, see ShowinfoMatcher, with lines tagged for ChildLog.
+var FFMPEG_LOG = []string{"-loglevel", "level+info", "-hide_banner", "-nostats"}
+
+// FfmpegFrameRunner runs ffmpeg with args and delivers the frames it writes,
+// along with their FrameMeta. offset is where ffmpeg was asked to start, its
+// timestamps start at 0 from there. Runners ask for frames as they're
+// decoded, with "-fps_mode passthrough", rather than duplicated or dropped to
+// a constant rate, so sources with a variable frame rate keep their timing.
+func FfmpegFrameRunner(ctx context.Context, args []string, stdin io.Reader, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	cmd := ChildCommand(ctx, ToolPath("ffmpeg"), args...)
+	cmd.Stdin = stdin
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Fatalf("Failed to connect stdout pipe for ffmpeg")
+		return fmt.Errorf("failed to connect stdout pipe for ffmpeg: %w", err)
 	}
 
-	err = cmd.Start()
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		log.Fatalf("Failed to start ffmpeg command")
+		return fmt.Errorf("failed to connect stderr pipe for ffmpeg: %w", err)
 	}
 
-	frame := make([]byte, size.X*size.Y*4)
+	err = StartChild(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start ffmpeg command: %w", err)
+	}
 
-	for {
-		_, err := io.ReadFull(stdout, frame)
+	log := NewChildLog("ffmpeg")
+	showinfo := ReadShowinfo(stderr, log)
+	infos := ShowinfoMatcher{Infos: showinfo}
+	ring := newFrameRing(framesChannel)
+	decoded := 0
+
+	for i := 0; ; i++ {
+		picture := ring.Next(size)
+		_, err := io.ReadFull(stdout, picture.Pix)
 		if err != nil {
 			break
 		}
 
-		framesChannel <- frame
+		meta := FrameMeta{Index: i}
+		if info, ok := infos.Match(i); ok {
+			meta.PTS = offset + info.PTS
+			meta.Keyframe = info.Keyframe
+			meta.Timed = true
+		}
+
+		frame := ring.Measure(picture, meta)
+		decoded++
+
+		select {
+		case framesChannel <- frame:
+		case <-ctx.Done():
+		}
 	}
 
-	cmd.Wait()
-	close(framesChannel)
-}
+	// the log has to be read to the end before waiting
+	for range showinfo {
+	}
 
-var path string
-var url string
+	err = WaitChild(cmd)
+	if ctx.Err() != nil {
+		return nil
+	}
 
-func init() {
-	flag.StringVar(&path, "path", "", "path to video file")
-	flag.StringVar(&url, "url", "", "url of a video source")
-}
+	failed := err != nil || (decoded == 0 && log.Errors() != "")
 
-func main() {
-	flag.Parse()
+	// a hardware decoder that can't start fails before the first frame,
+	// inputs that can be read again are then decoded in software
+	if software := withoutHwaccel(args); failed && decoded == 0 && stdin == nil && software != nil {
+		hwaccelFailed.Store(true)
+		return FfmpegFrameRunner(ctx, software, stdin, size, offset, framesChannel)
+	}
 
-	var size image.Point
-	framesChannel := make(chan []byte)
+	if err != nil {
+		return log.Wrap(err)
+	}
 
-	clear := exec.Command("clear")
-	clear.Stdout = os.Stdout
-	clear.Run()
+	// a corrupt file can end without an error, but without a frame either
+	if failed {
+		return fmt.Errorf("ffmpeg decoded no frames\n%s", log.Errors())
+	}
 
-	if path != "" {
-		dim, _ := GetDimensions(path)
-		size.X = dim.X
-		size.Y = dim.Y
+	return nil
+}
 
-		go FileFrameRunner(path, size, framesChannel)
-	} else if url != "" {
-		size.X = WIDTH
-		size.Y = HEIGHT
+func UrlFrameRunner(ctx context.Context, media *Media, color ColorInfo, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	args := HttpArgs(media.URL, media.Headers)
+	args = append(args, HwaccelArgs()...)
+	args = append(args, SeekArgs(offset)...)
+	args = append(args,
+		"-i", media.URL,
+		"-vf", ScaleFilter(size, color)+",showinfo",
+	)
+	args = append(args, FFMPEG_LOG...)
+	args = append(args,
+		"-fps_mode", "passthrough",
+		"-pix_fmt", "rgb0",
+		"-vcodec", "rawvideo",
+		"-f", "image2pipe",
+		"-",
+	)
 
-		go UrlFrameRunner(url, size, framesChannel)
+	return FfmpegFrameRunner(ctx, args, nil, size, offset, framesChannel)
+}
+
+// FileFrameRunner decodes path at its original size unless scale is set, in
+// which case ffmpeg fits it into size.
+func FileFrameRunner(ctx context.Context, path string, color ColorInfo, size image.Point, scale bool, offset time.Duration, framesChannel chan Frame) error {
+	args := append(HwaccelArgs(), SeekArgs(offset)...)
+	args = append(args, "-i", path)
+
+	if scale {
+		args = append(args, "-vf", ScaleFilter(size, color)+",showinfo")
 	} else {
-		log.Println("Incorrect usage")
-		flag.Usage()
-		os.Exit(1)
+		args = append(args, "-vf", ColorFilter(color)+"showinfo")
 	}
 
-	white := color.NRGBA{255, 255, 255, 255}
-	frameBuffer := bytes.NewBuffer(
-		make([]byte, 0, len(StackPixels(white, white))*WIDTH*HEIGHT/2),
+	args = append(args, FFMPEG_LOG...)
+	args = append(args,
+		"-fps_mode", "passthrough",
+		"-pix_fmt", "rgb0",
+		"-vcodec", "rawvideo",
+		"-f", "image2pipe",
+		"-",
 	)
 
-	original := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
-	resized := image.NewNRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
-	bounds := resized.Rect
+	return FfmpegFrameRunner(ctx, args, nil, size, offset, framesChannel)
+}
 
-	for {
-		fmt.Print("\u001b[H")
+// StdinFrameRunner decodes whatever is piped into termtv, scaled by ffmpeg
+// since the video can't be probed up front.
+func StdinFrameRunner(ctx context.Context, stdin io.Reader, size image.Point, framesChannel chan Frame) error {
+	args := append(HwaccelArgs(),
+		"-i", "pipe:0",
+		"-vf", ScaleFilter(size, ColorInfo{})+",showinfo",
+	)
+	args = append(args, FFMPEG_LOG...)
+	args = append(args,
+		"-fps_mode", "passthrough",
+		"-pix_fmt", "rgb0",
+		"-vcodec", "rawvideo",
+		"-f", "image2pipe",
+		"-",
+	)
 
-		frame, ok := <-framesChannel
-		if !ok {
-			break
-		}
+	return FfmpegFrameRunner(ctx, args, stdin, size, 0, framesChannel)
+}
 
-		original.Pix = frame
-		Downscale(original, resized)
+// plainOutput is set when the terminal gets no escape sequences at all, see
+// Capabilities.
+var plainOutput bool
 
-		for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				top := resized.NRGBAAt(x, y)
-				bot := resized.NRGBAAt(x, y+1)
-				frameBuffer.WriteString(StackPixels(top, bot))
-			}
-			frameBuffer.WriteByte('\n')
-		}
+// terminalMaxBandwidth is the max_bandwidth of the terminal in terminals.toml,
+// which --max-bandwidth defaults to, see SelectRenderer.
+var terminalMaxBandwidth float64
+
+// CLEAR_SCREEN clears the screen and the scrollback.
+const CLEAR_SCREEN = "\u001b[H\u001b[2J\u001b[3J"
 
-		io.Copy(os.Stdout, frameBuffer)
-		frameBuffer.Reset()
+func ClearScreen() {
+	if plainOutput {
+		return
 	}
+
+	os.Stdout.WriteString(CLEAR_SCREEN)
+}
+
+func main() {
+	EnableVirtualTerminal()
+
+	args := os.Args[1:]
+
+	name := "play"
+	if len(args) == 0 && term.IsTerminal(int(os.Stdin.Fd())) {
+		name = "browse"
+	}
+
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+
+	commands[name].Run(args)
 }
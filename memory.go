@@ -0,0 +1,17 @@
+package main
+
+// MEMORY_DECODE_SHARE is the part of --max-memory the frames decoded ahead
+// may take, the replay cache gets what they leave.
+const MEMORY_DECODE_SHARE = 0.5
+
+// MemoryShares splits limit bytes between the frames decoded ahead, each
+// frameBytes, and the replay cache: as many of buffer frames as fit in
+// MEMORY_DECODE_SHARE of it, but at least one, and the rest for replay.
+func MemoryShares(limit, frameBytes int64, buffer int) (int, int64) {
+	frames := min(int64(buffer), int64(float64(limit)*MEMORY_DECODE_SHARE)/max(frameBytes, 1))
+	if buffer > 0 {
+		frames = max(frames, 1)
+	}
+
+	return int(frames), max(limit-frames*frameBytes, 0)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// FrameMeta describes a decoded frame. PTS and Keyframe come from ffmpeg's
+// showinfo filter, the rest is measured on the picture.
+type FrameMeta struct {
+	Index    int
+	PTS      time.Duration
+	Keyframe bool
+	// Timed is set when PTS is the frame's own timestamp, from the
+	// container, rather than counted at the frame rate.
+	Timed bool
+	// SceneScore is how much the frame differs from the one before it,
+	// from 0 for the same picture to 1 for black after white.
+	SceneScore float64
+	// Luma is the average brightness, from 0 to 1.
+	Luma float64
+	// Levels are those of the audio going with the frame, for sources
+	// whose audio termtv decodes.
+	Levels *AudioLevels
+}
+
+// MarshalJSON writes times in seconds, for scripts reading `termtv frames`.
+func (m FrameMeta) MarshalJSON() ([]byte, error) {
+	levels := ""
+	if m.Levels != nil {
+		levels = fmt.Sprintf(
+			`,"peak":[%.4f,%.4f],"rms":[%.4f,%.4f]`,
+			m.Levels.Peak[0], m.Levels.Peak[1], m.Levels.RMS[0], m.Levels.RMS[1],
+		)
+	}
+
+	return []byte(fmt.Sprintf(
+		`{"index":%d,"pts":%.3f,"keyframe":%t,"scene_score":%.4f,"luma":%.4f%s}`,
+		m.Index, m.PTS.Seconds(), m.Keyframe, m.SceneScore, m.Luma, levels,
+	)), nil
+}
+
+// Frame is a picture a FrameRunner delivers, with its FrameMeta. Runners
+// reuse the picture once the frame after it is taken.
+type Frame struct {
+	Picture *image.NRGBA
+	Meta    FrameMeta
+}
+
+// FrameMeter measures the frames a runner delivers, filling in the rest of
+// their FrameMeta.
+type FrameMeter struct {
+	frames    int
+	thumbnail LumaThumbnail
+}
+
+func (m *FrameMeter) Measure(picture *image.NRGBA, meta FrameMeta) Frame {
+	previous := m.thumbnail
+	meta.Luma, m.thumbnail = MeasureLuma(picture)
+
+	meta.SceneScore = 1
+	if m.frames > 0 {
+		meta.SceneScore = SceneScore(previous, m.thumbnail)
+	}
+	m.frames++
+
+	return Frame{picture, meta}
+}
+
+// frameRing is the pictures a runner draws its frames into. Besides the
+// frames queued in the channel, one is held by the receiver until it takes
+// the next, and one is being drawn, so a picture is reused once that many
+// more were handed out. Pictures are allocated as the queue first fills up.
+type frameRing struct {
+	pictures []*image.NRGBA
+	next     int
+	meter    FrameMeter
+}
+
+func newFrameRing(framesChannel chan Frame) *frameRing {
+	return &frameRing{pictures: make([]*image.NRGBA, cap(framesChannel)+2)}
+}
+
+// Next returns the picture to draw the next frame into, of size.
+func (r *frameRing) Next(size image.Point) *image.NRGBA {
+	picture := r.pictures[r.next]
+	if picture == nil || picture.Rect != (image.Rectangle{Max: size}) {
+		picture = image.NewNRGBA(image.Rectangle{Max: size})
+		r.pictures[r.next] = picture
+	}
+	r.next = (r.next + 1) % len(r.pictures)
+
+	return picture
+}
+
+// Measure makes the frame to send of a picture Next returned, see
+// FrameMeter.
+func (r *frameRing) Measure(picture *image.NRGBA, meta FrameMeta) Frame {
+	return r.meter.Measure(picture, meta)
+}
+
+// Showinfo is what ffmpeg's showinfo filter logs about a frame.
+type Showinfo struct {
+	Index    int
+	PTS      time.Duration
+	Keyframe bool
+}
+
+var showinfoLine = regexp.MustCompile(`Parsed_showinfo.*\bn:\s*(\d+)\s.*\bpts_time:\s*(\S+).*\biskey:\s*(\d)`)
+
+// ReadShowinfo parses showinfo lines out of ffmpeg's log on r, passing the
+// rest to log. The channel is closed when r ends. Reading never blocks on the
+// channel, so ffmpeg can't get stuck on its log: lines that don't fit are
+// dropped, which only happens once they are no longer being received.
+func ReadShowinfo(r io.Reader, log *ChildLog) <-chan Showinfo {
+	infos := make(chan Showinfo, 64)
+
+	go func() {
+		defer close(infos)
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			match := showinfoLine.FindStringSubmatch(scanner.Text())
+			if match == nil {
+				log.Add(scanner.Text())
+				continue
+			}
+
+			index, _ := strconv.Atoi(match[1])
+			select {
+			case infos <- Showinfo{index, ParseSeconds(match[2]), match[3] == "1"}:
+			default:
+			}
+		}
+
+		// past a line too long to scan, keep reading so ffmpeg doesn't block
+		io.Copy(io.Discard, r)
+	}()
+
+	return infos
+}
+
+// SHOWINFO_WAIT is how long a frame waits for its showinfo line, which
+// ffmpeg logs before writing the frame but may be read a little after it.
+const SHOWINFO_WAIT = 100 * time.Millisecond
+
+// ShowinfoMatcher matches the lines of ReadShowinfo to the frames they're
+// about by their index. A line that comes too late leaves its frame untimed
+// and is skipped once it comes. When no line came at all the filter was left
+// out, and frames stop waiting for them.
+type ShowinfoMatcher struct {
+	Infos <-chan Showinfo
+
+	// ahead is a line read before its frame
+	ahead *Showinfo
+	seen  bool
+}
+
+// Match returns the line about frame index, if it comes in time.
+func (m *ShowinfoMatcher) Match(index int) (Showinfo, bool) {
+	for {
+		if m.ahead != nil {
+			info := *m.ahead
+			if info.Index > index {
+				return Showinfo{}, false
+			}
+			m.ahead = nil
+			if info.Index == index {
+				return info, true
+			}
+			// the line of a frame that went without
+			continue
+		}
+
+		if m.Infos == nil {
+			return Showinfo{}, false
+		}
+
+		select {
+		case info, ok := <-m.Infos:
+			if !ok {
+				m.Infos = nil
+				return Showinfo{}, false
+			}
+			m.ahead, m.seen = &info, true
+		case <-time.After(SHOWINFO_WAIT):
+			if !m.seen {
+				m.Infos = nil
+			}
+			return Showinfo{}, false
+		}
+	}
+}
+
+// LumaThumbnail is the average brightness of a 16x9 grid over a picture,
+// enough to tell scenes apart.
+type LumaThumbnail [16 * 9]float64
+
+func Luma(r, g, b uint8) float64 {
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 255
+}
+
+// MeasureLuma returns the average brightness of picture and its thumbnail.
+func MeasureLuma(picture *image.NRGBA) (float64, LumaThumbnail) {
+	var sums, counts LumaThumbnail
+	var total float64
+
+	size := picture.Rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return 0, sums
+	}
+
+	for y := 0; y < size.Y; y++ {
+		row := y * 9 / size.Y * 16
+
+		for x := 0; x < size.X; x++ {
+			c := picture.NRGBAAt(picture.Rect.Min.X+x, picture.Rect.Min.Y+y)
+			luma := Luma(c.R, c.G, c.B)
+
+			total += luma
+			sums[row+x*16/size.X] += luma
+			counts[row+x*16/size.X]++
+		}
+	}
+
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= counts[i]
+		}
+	}
+
+	return total / float64(size.X*size.Y), sums
+}
+
+// SceneScore is the mean difference between two thumbnails.
+func SceneScore(previous, current LumaThumbnail) float64 {
+	var diff float64
+	for i := range current {
+		diff += math.Abs(current[i] - previous[i])
+	}
+	return diff / float64(len(current))
+}
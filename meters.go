@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// METER_FLOOR is the quietest level the meters show, in dB.
+const METER_FLOOR = -48
+
+// AudioLevels are the peak and RMS levels of the left and right channel of
+// the audio going with a frame, from 0 to 1 of full scale.
+type AudioLevels struct {
+	Peak [2]float64
+	RMS  [2]float64
+}
+
+// MeasureLevels measures a block of stereo samples.
+func MeasureLevels(block [][2]float64) *AudioLevels {
+	levels := &AudioLevels{}
+	if len(block) == 0 {
+		return levels
+	}
+
+	var squares [2]float64
+	for _, sample := range block {
+		for channel, value := range sample {
+			levels.Peak[channel] = max(levels.Peak[channel], math.Abs(value))
+			squares[channel] += value * value
+		}
+	}
+
+	for channel := range squares {
+		levels.RMS[channel] = math.Sqrt(squares[channel] / float64(len(block)))
+	}
+
+	return levels
+}
+
+// meterHeight converts a level to the fraction of the meter it fills, on a
+// dB scale from METER_FLOOR.
+func meterHeight(level float64) float64 {
+	db := 20 * math.Log10(level+1e-9)
+	return min(max((db-METER_FLOOR)/-METER_FLOOR, 0), 1)
+}
+
+// DrawMeters draws a level meter per channel along the right edge of
+// picture, the RMS level as a bar green to red with its height and the peak
+// as a white line over it.
+func DrawMeters(picture *image.NRGBA, levels *AudioLevels) {
+	size := picture.Rect.Size()
+	width := max(size.X/80, 1)
+	if size.X < width*6 || size.Y < 4 {
+		return
+	}
+
+	track := color.NRGBA{32, 32, 32, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+
+	for channel := range levels.RMS {
+		left := size.X - (2-channel)*(width*2) - width
+
+		rms := int(meterHeight(levels.RMS[channel]) * float64(size.Y))
+		peak := min(int(meterHeight(levels.Peak[channel])*float64(size.Y)), size.Y-1)
+
+		for y := 0; y < size.Y; y++ {
+			height := size.Y - 1 - y
+
+			pen := track
+			switch {
+			case height == peak && peak > 0:
+				pen = white
+			case height < rms:
+				pen = Hue((1 - float64(height)/float64(size.Y)) / 3)
+			}
+
+			for x := left; x < left+width; x++ {
+				picture.SetNRGBA(picture.Rect.Min.X+x, picture.Rect.Min.Y+y, pen)
+			}
+		}
+	}
+}
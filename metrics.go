@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ServerMetrics count what a headless-encode server does, served for
+// Prometheus to scrape by StartMetrics.
+type ServerMetrics struct {
+	Clients       atomic.Int64
+	Connections   atomic.Int64
+	Reconnects    atomic.Int64
+	FramesSent    atomic.Int64
+	FramesDropped atomic.Int64
+	BytesSent     atomic.Int64
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *ServerMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+
+	metric("termtv_clients", "gauge", "Clients connected now.", m.Clients.Load())
+	metric("termtv_connections_total", "counter", "Clients that connected, resumed sessions included.", m.Connections.Load())
+	metric("termtv_reconnects_total", "counter", "Clients that resumed a session after dropping.", m.Reconnects.Load())
+	metric("termtv_frames_sent_total", "counter", "Frames sent to clients.", m.FramesSent.Load())
+	metric("termtv_frames_dropped_total", "counter", "Frames dropped to keep up with slow clients.", m.FramesDropped.Load())
+	metric("termtv_bytes_sent_total", "counter", "Bytes sent to clients, after compression.", m.BytesSent.Load())
+}
+
+// StartMetrics serves metrics at /metrics on addr in the background, over
+// TLS with config unless it's nil.
+func StartMetrics(addr string, metrics http.Handler, config *tls.Config) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go http.Serve(listener, mux)
+
+	return nil
+}
+
+// countingWriter adds the bytes written through it to a counter.
+type countingWriter struct {
+	io.Writer
+	count *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MJPEG is decoded in Go with image/jpeg, so IP cameras and simple recordings
+// play without ffmpeg: multipart/x-mixed-replace HTTP streams, .mjpeg files of
+// concatenated JPEGs, and AVI files holding MJPEG.
+
+// MJPEG_RATE is the frame rate of .mjpeg files, which carry no timing. It's
+// what ffmpeg assumes for them too.
+const MJPEG_RATE = 25
+
+// MJPEG_PROBE_TIMEOUT limits how long a url is given to show it's an MJPEG
+// stream before it's handed to the extractors.
+const MJPEG_PROBE_TIMEOUT = 5 * time.Second
+
+// MJPEG_MAX_CHUNK limits the size of the AVI chunks read into memory, far
+// over what a JPEG frame takes, as their sizes come from the file.
+const MJPEG_MAX_CHUNK = 64 << 20
+
+// mjpegFrames returns the JPEGs of a stream one by one, io.EOF after the last.
+type mjpegFrames func() ([]byte, error)
+
+func IsMjpegFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mjpeg", ".mjpg":
+		return true
+	}
+	return false
+}
+
+// IsMjpegAvi reports whether path is an AVI file whose video is MJPEG.
+func IsMjpegAvi(path string) bool {
+	if strings.ToLower(filepath.Ext(path)) != ".avi" {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	avi := &aviReader{r: bufio.NewReader(file)}
+	_, err = avi.Next()
+	return err == nil && avi.Mjpeg()
+}
+
+// OpenMjpegFile opens a .mjpeg file or an MJPEG AVI file.
+func OpenMjpegFile(path string) (*Source, error) {
+	// avi is nil for .mjpeg files
+	open := func() (mjpegFrames, *aviReader, io.Closer, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		r := bufio.NewReader(file)
+		if IsMjpegFile(path) {
+			return func() ([]byte, error) { return readJpeg(r) }, nil, file, nil
+		}
+
+		avi := &aviReader{r: r}
+		return avi.Next, avi, file, nil
+	}
+
+	frames, avi, closer, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	first, err := frames()
+	if err != nil {
+		return nil, fmt.Errorf("%s: no frames: %w", path, err)
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(first))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	info := ProbeInfo{Size: image.Pt(config.Width, config.Height), FrameRate: MJPEG_RATE}
+	if avi != nil {
+		info.FrameRate = avi.FrameRate()
+		info.Duration = time.Duration(float64(avi.frames) / info.FrameRate * float64(time.Second))
+	}
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		frames, _, closer, err := open()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+
+		// frames up to the offset are skipped without decoding them
+		skip := int(offset.Seconds() * info.FrameRate)
+		for i := 0; i < skip; i++ {
+			if _, err := frames(); err != nil {
+				return nil
+			}
+		}
+
+		return MjpegFrameRunner(ctx, frames, offset, info.FrameRate, framesChannel)
+	}
+
+	return &Source{
+		Name:        path,
+		Title:       filepath.Base(path),
+		Info:        info,
+		Seekable:    true,
+		Restartable: true,
+		Runner:      runner,
+	}, nil
+}
+
+// OpenMjpegUrl opens url if it serves an MJPEG stream, as IP cameras do,
+// returning false otherwise. The stream has no frame rate, frames are shown as
+// they come.
+func OpenMjpegUrl(url string) (*Source, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), MJPEG_PROBE_TIMEOUT)
+	defer cancel()
+
+	frames, body, err := getMjpeg(ctx, url)
+	if err != nil {
+		return nil, false
+	}
+	defer body.Close()
+
+	first, err := frames()
+	if err != nil {
+		return nil, false
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(first))
+	if err != nil {
+		return nil, false
+	}
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		frames, body, err := getMjpeg(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		return MjpegFrameRunner(ctx, frames, 0, 0, framesChannel)
+	}
+
+	return &Source{
+		Name:  url,
+		Title: filepath.Base(url),
+		Info:  ProbeInfo{Size: image.Pt(config.Width, config.Height)},
+		// a restart reconnects to the live stream
+		Restartable: true,
+		Reconnect:   true,
+		Runner:      runner,
+	}, true
+}
+
+// streamCandidate reports whether url is worth checking for an MJPEG or radio
+// stream, which is any http url that would otherwise go to yt-dlp, as camera
+// and station urls look like those of pages.
+func streamCandidate(url string) bool {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return false
+	}
+
+	for _, extractor := range extractors {
+		if extractor.Match(url) {
+			_, ytdl := extractor.(*YtdlExtractor)
+			return ytdl
+		}
+	}
+
+	return false
+}
+
+// getMjpeg requests url, failing unless it answers with a multipart stream.
+func getMjpeg(ctx context.Context, url string) (mjpegFrames, io.Closer, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := Network.Client().Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if response.StatusCode != http.StatusOK || err != nil || mediaType != "multipart/x-mixed-replace" {
+		response.Body.Close()
+		return nil, nil, fmt.Errorf("%s: not an MJPEG stream", url)
+	}
+
+	// some cameras put the dashes of the delimiter in the boundary
+	parts := multipart.NewReader(response.Body, strings.TrimPrefix(params["boundary"], "--"))
+
+	frames := func() ([]byte, error) {
+		part, err := parts.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(part)
+	}
+
+	return frames, response.Body, nil
+}
+
+// MjpegFrameRunner decodes frames until they run out. Frames are numbered from
+// offset at rate, or by arrival when the rate is 0.
+func MjpegFrameRunner(ctx context.Context, frames mjpegFrames, offset time.Duration, rate float64, framesChannel chan Frame) error {
+	ring := newFrameRing(framesChannel)
+	started := time.Now()
+
+	for i := 0; ; i++ {
+		data, err := frames()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			// a damaged frame is skipped, as ffmpeg does
+			i--
+			continue
+		}
+
+		frame := ring.Next(decoded.Bounds().Size())
+		DrawImage(frame, decoded)
+
+		meta := FrameMeta{Index: i, PTS: time.Since(started), Keyframe: true}
+		if rate > 0 {
+			meta.PTS = offset + time.Duration(float64(i)/rate*float64(time.Second))
+		}
+		measured := ring.Measure(frame, meta)
+
+		select {
+		case framesChannel <- measured:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// readJpeg reads the next JPEG from r, skipping anything before it. Markers
+// are followed by their lengths up to the image data, which is scanned for
+// the marker ending it, so that bytes in headers and thumbnails can't end a
+// frame early.
+func readJpeg(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if next, err := r.Peek(1); b == 0xff && err == nil && next[0] == 0xd8 {
+			r.ReadByte()
+			break
+		}
+	}
+
+	jpeg := []byte{0xff, 0xd8}
+	// set when the image data ended on the FF of a marker
+	marked := false
+
+	for {
+		if !marked {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if b != 0xff {
+				return nil, errors.New("malformed jpeg")
+			}
+		}
+		marked = false
+
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xff {
+			// fill bytes
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		jpeg = append(jpeg, 0xff, marker)
+
+		switch {
+		case marker == 0xd9:
+			return jpeg, nil
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			// restart markers and TEM have no length
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		// the length counts its own two bytes
+		if binary.BigEndian.Uint16(length[:]) < 2 {
+			return nil, errors.New("malformed jpeg")
+		}
+		segment := make([]byte, binary.BigEndian.Uint16(length[:]))
+		copy(segment, length[:])
+		if _, err := io.ReadFull(r, segment[2:]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		jpeg = append(jpeg, segment...)
+
+		if marker != 0xda {
+			continue
+		}
+
+		// image data, in which FF is followed by 00 or a restart marker
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if b != 0xff {
+				jpeg = append(jpeg, b)
+				continue
+			}
+
+			next, err := r.Peek(1)
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if next[0] == 0x00 || next[0] >= 0xd0 && next[0] <= 0xd7 {
+				r.ReadByte()
+				jpeg = append(jpeg, b, next[0])
+				continue
+			}
+
+			marked = true
+			break
+		}
+	}
+}
+
+// aviReader walks the chunks of an AVI file, reading the main header on the
+// way and returning the frames of its video stream.
+type aviReader struct {
+	r *bufio.Reader
+
+	// from the main header
+	microsecondsPerFrame uint32
+	frames               uint32
+
+	// streams counts the stream headers read, video is the number of the
+	// first video stream, from 1
+	streams int
+	video   int
+	codec   string
+}
+
+func (a *aviReader) Mjpeg() bool {
+	switch strings.ToUpper(a.codec) {
+	case "MJPG", "AVRN", "LJPG", "JPGL", "DMB1":
+		return true
+	}
+	return false
+}
+
+func (a *aviReader) FrameRate() float64 {
+	if a.microsecondsPerFrame == 0 {
+		return MJPEG_RATE
+	}
+	return 1e6 / float64(a.microsecondsPerFrame)
+}
+
+// Next returns the next frame of the video stream.
+func (a *aviReader) Next() ([]byte, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(a.r, header[:]); err != nil {
+			return nil, err
+		}
+		id, size := string(header[:4]), int(binary.LittleEndian.Uint32(header[4:]))
+
+		// lists are walked into, as the chunks in them
+		if id == "RIFF" || id == "LIST" {
+			if _, err := a.r.Discard(4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// frames are the bulk of the file, other chunks are skipped unless
+		// they are headers
+		frame := a.video > 0 && (id == fmt.Sprintf("%02ddc", a.video-1) || id == fmt.Sprintf("%02ddb", a.video-1))
+		if !frame && id != "avih" && id != "strh" && id != "strf" {
+			if _, err := a.r.Discard(size + size%2); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if size > MJPEG_MAX_CHUNK {
+			return nil, fmt.Errorf("avi chunk of %d bytes is too large", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(a.r, data); err != nil {
+			return nil, err
+		}
+		if size%2 == 1 {
+			a.r.Discard(1)
+		}
+
+		switch id {
+		case "avih":
+			if size >= 20 {
+				a.microsecondsPerFrame = binary.LittleEndian.Uint32(data)
+				a.frames = binary.LittleEndian.Uint32(data[16:])
+			}
+		case "strh":
+			a.streams++
+			if size >= 8 && string(data[:4]) == "vids" && a.video == 0 {
+				a.video = a.streams
+				a.codec = string(data[4:8])
+			}
+		case "strf":
+			// the compression of the video's BITMAPINFOHEADER is more
+			// reliable than the handler of its stream header
+			if size >= 20 && a.video == a.streams {
+				if codec := string(data[16:20]); codec != "\x00\x00\x00\x00" {
+					a.codec = codec
+				}
+			}
+		default:
+			if size > 0 {
+				return data, nil
+			}
+		}
+	}
+}
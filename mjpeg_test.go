@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+func testJpeg(t *testing.T, n int) []byte {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, TestPattern(image.Pt(16, 8), n), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestReadJpeg(t *testing.T) {
+	first, second := testJpeg(t, 0), testJpeg(t, 1)
+
+	// an APP1 segment holding an end of image marker, as thumbnails do
+	app := []byte{0xff, 0xe1, 0x00, 0x06, 0xff, 0xd9, 0xff, 0xd9}
+	withApp := join(first[:2], app, first[2:])
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  [][]byte
+		err   string
+	}{
+		{"one", first, [][]byte{first}, "EOF"},
+		{"two", join(first, second), [][]byte{first, second}, "EOF"},
+		{"garbage before", join([]byte("--boundary\r\n\r\n\xff\x00"), first), [][]byte{first}, "EOF"},
+		{"marker in a segment", withApp, [][]byte{withApp}, "EOF"},
+		{"empty", nil, nil, "EOF"},
+		{"no start", []byte("not a jpeg"), nil, "EOF"},
+		{"truncated", first[:len(first)/2], nil, "unexpected EOF"},
+		{"truncated length", []byte{0xff, 0xd8, 0xff, 0xe0, 0x00}, nil, "unexpected EOF"},
+		{"truncated segment", []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 1, 2}, nil, "unexpected EOF"},
+		{"short length", []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x01, 1, 2, 3}, nil, "malformed jpeg"},
+		{"zero length", []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x00, 1, 2, 3}, nil, "malformed jpeg"},
+		{"no marker", []byte{0xff, 0xd8, 0x12, 0x34}, nil, "malformed jpeg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(test.input))
+
+			for i, want := range test.want {
+				got, err := readJpeg(r)
+				if err != nil {
+					t.Fatalf("jpeg %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("jpeg %d is %d bytes, want %d", i, len(got), len(want))
+				}
+			}
+
+			if _, err := readJpeg(r); err == nil || err.Error() != test.err {
+				t.Errorf("error is %v, want %s", err, test.err)
+			}
+		})
+	}
+}
+
+func aviChunk(id string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func aviList(id, kind string, chunks ...[]byte) []byte {
+	return aviChunk(id, join(append([][]byte{[]byte(kind)}, chunks...)...))
+}
+
+// testAvi is an AVI of frames at 40ms each, its video in codec.
+func testAvi(codec string, frames ...[]byte) []byte {
+	avih := make([]byte, 56)
+	binary.LittleEndian.PutUint32(avih, 40000)
+	binary.LittleEndian.PutUint32(avih[16:], uint32(len(frames)))
+
+	strh := append([]byte("vids"+codec), make([]byte, 48)...)
+	strf := make([]byte, 40)
+	copy(strf[16:], codec)
+
+	var movi [][]byte
+	for _, frame := range frames {
+		movi = append(movi, aviChunk("00dc", frame))
+	}
+
+	return aviList("RIFF", "AVI ",
+		aviList("LIST", "hdrl",
+			aviChunk("avih", avih),
+			aviList("LIST", "strl", aviChunk("strh", strh), aviChunk("strf", strf)),
+		),
+		aviChunk("JUNK", []byte{1, 2, 3}),
+		aviList("LIST", "movi", movi...),
+	)
+}
+
+func TestAviReader(t *testing.T) {
+	first, second := testJpeg(t, 0), []byte{0xff, 0xd8, 0xff, 0xd9, 0}
+
+	huge := binary.LittleEndian.AppendUint32([]byte("00dc"), MJPEG_MAX_CHUNK+1)
+	whole := testAvi("MJPG", first, second)
+
+	tests := []struct {
+		name  string
+		input []byte
+		mjpeg bool
+		want  [][]byte
+		err   error
+	}{
+		{"mjpeg", whole, true, [][]byte{first, second}, io.EOF},
+		{"other codec", testAvi("H264", second), false, [][]byte{second}, io.EOF},
+		{"no frames", testAvi("MJPG"), true, nil, io.EOF},
+		{"empty", nil, false, nil, io.EOF},
+		{"truncated header", whole[:6], false, nil, io.ErrUnexpectedEOF},
+		{"truncated frame", whole[:len(whole)-8], true, [][]byte{first}, io.ErrUnexpectedEOF},
+		{"huge chunk", join(testAvi("MJPG"), huge), true, nil, errors.New("avi chunk of 67108865 bytes is too large")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			avi := &aviReader{r: bufio.NewReader(bytes.NewReader(test.input))}
+
+			for i, want := range test.want {
+				got, err := avi.Next()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("frame %d is %d bytes, want %d", i, len(got), len(want))
+				}
+			}
+
+			_, err := avi.Next()
+			if err == nil || err.Error() != test.err.Error() {
+				t.Errorf("error is %v, want %v", err, test.err)
+			}
+			if avi.Mjpeg() != test.mjpeg {
+				t.Errorf("Mjpeg is %t, want %t", avi.Mjpeg(), test.mjpeg)
+			}
+		})
+	}
+
+	avi := &aviReader{r: bufio.NewReader(bytes.NewReader(whole))}
+	avi.Next()
+	if rate := avi.FrameRate(); rate != 25 {
+		t.Errorf("frame rate is %g, want 25", rate)
+	}
+}
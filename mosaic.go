@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MOSAIC_RATE caps how often the mosaic is redrawn, however many tiles have
+// new frames.
+const MOSAIC_RATE = 30
+
+type MosaicOptions struct {
+	// Layout is the tiles across and down, 0x0 to fit the sources in a
+	// square as near as can be.
+	Layout     image.Point
+	Renderer   Renderer
+	Bindings   KeyBindings
+	Buffer     int
+	MaxRetries int
+}
+
+// mosaicTile is a source shown in a tile, played by a goroutine of its own
+// so one that stalls or drops holds none of the others up.
+type mosaicTile struct {
+	item   string
+	source *Source
+
+	// rect is where the tile is in the picture, in pixels, and cell its top
+	// left cell, for the label
+	rect image.Rectangle
+	cell image.Point
+	// title and status are shown in the label, status is "" while frames
+	// come
+	title  string
+	status string
+}
+
+// mosaicUpdate is a new picture or status from a tile. done is set when the
+// tile gave up.
+type mosaicUpdate struct {
+	tile    int
+	picture *image.NRGBA
+	title   string
+	status  string
+	done    bool
+}
+
+// Mosaic plays items side by side in a grid of tiles, like a wall of
+// security cameras: each has its own stream and reconnects on its own, and
+// files start over when they end. It returns once the user quits or every
+// tile has given up.
+func Mosaic(items []string, options MosaicOptions) {
+	tiles := make([]*mosaicTile, len(items))
+	for i, item := range items {
+		tiles[i] = &mosaicTile{item: item, title: item, status: "connecting…"}
+	}
+
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+	defer KillChildren()
+
+	ClearScreen()
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	updates := make(chan mosaicUpdate)
+
+	var canvas, drawn *image.NRGBA
+	var cancel context.CancelFunc
+	var running sync.WaitGroup
+	done := 0
+
+	start := func() {
+		grid := TerminalGrid(options.Renderer)
+		cols, rows := TerminalSize()
+		LayoutMosaic(tiles, options.Layout, cols, rows-1, grid)
+
+		canvas = image.NewNRGBA(image.Rectangle{Max: grid})
+		drawn = nil
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		for i, tile := range tiles {
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				tile.run(ctx, i, options, updates)
+			}()
+		}
+	}
+
+	stop := func() {
+		cancel()
+		running.Wait()
+		done = 0
+	}
+	defer func() {
+		stop()
+
+		for _, tile := range tiles {
+			if tile.source != nil && tile.source.Close != nil {
+				tile.source.Close()
+			}
+		}
+	}()
+
+	buffer := &bytes.Buffer{}
+	draw := func() {
+		if diff, ok := options.Renderer.(DiffRenderer); ok && drawn != nil {
+			diff.RenderDiff(buffer, drawn, canvas)
+		} else {
+			options.Renderer.Render(buffer, canvas)
+			drawn = image.NewNRGBA(canvas.Rect)
+		}
+		copy(drawn.Pix, canvas.Pix)
+
+		cols, _ := TerminalSize()
+		for _, tile := range tiles {
+			tile.writeLabel(buffer, cols*tile.rect.Dx()/max(canvas.Rect.Dx(), 1))
+		}
+
+		os.Stdout.Write(buffer.Bytes())
+		buffer.Reset()
+	}
+
+	start()
+
+	var redraw <-chan time.Time
+	lastDraw := time.Time{}
+
+	for {
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			stop()
+			ClearScreen()
+			start()
+
+		case <-interrupt:
+			return
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			if options.Bindings[key] == "quit" {
+				return
+			}
+
+		case update := <-updates:
+			tile := tiles[update.tile]
+
+			if update.done {
+				if done++; done == len(tiles) {
+					draw()
+					return
+				}
+			}
+
+			if update.picture != nil {
+				copyInto(canvas, update.picture, tile.rect.Min)
+			}
+			if update.title != "" {
+				tile.title = update.title
+			}
+			if update.status != tile.status {
+				tile.status = update.status
+				// the picture under the old label is drawn again
+				drawn = nil
+			}
+
+			if redraw == nil {
+				redraw = time.After(time.Until(lastDraw.Add(time.Second / MOSAIC_RATE)))
+			}
+
+		case <-redraw:
+			redraw = nil
+			lastDraw = time.Now()
+			draw()
+		}
+	}
+}
+
+// LayoutMosaic places the tiles in a terminal of cols x rows cells, filled
+// by a picture of grid pixels, leaving a column and a row of cells between
+// them.
+func LayoutMosaic(tiles []*mosaicTile, layout image.Point, cols, rows int, grid image.Point) {
+	if layout.X <= 0 || layout.Y <= 0 {
+		layout.X = int(math.Ceil(math.Sqrt(float64(len(tiles)))))
+		layout.Y = (len(tiles) + layout.X - 1) / layout.X
+	}
+
+	// pixels per cell
+	scale := func(cell image.Point) image.Point {
+		return image.Pt(cell.X*grid.X/max(cols, 1), cell.Y*grid.Y/max(rows, 1))
+	}
+
+	for i, tile := range tiles {
+		if i >= layout.X*layout.Y {
+			tile.rect = image.Rectangle{}
+			continue
+		}
+
+		across, down := i%layout.X, i/layout.X
+		min := image.Pt(across*(cols+1)/layout.X, down*(rows+1)/layout.Y)
+		max := image.Pt((across+1)*(cols+1)/layout.X-1, (down+1)*(rows+1)/layout.Y-1)
+
+		tile.cell = min
+		tile.rect = image.Rectangle{scale(min), scale(max)}
+	}
+}
+
+// writeLabel writes the name of the tile and its status over its top left
+// corner, cut to width cells.
+func (t *mosaicTile) writeLabel(buffer *bytes.Buffer, width int) {
+	if t.rect.Empty() || plainOutput {
+		return
+	}
+
+	label := t.title
+	if t.status != "" {
+		label += " · " + t.status
+	}
+
+	text := []rune(" " + label + " ")
+	text = text[:min(len(text), max(width, 1))]
+
+	fmt.Fprintf(buffer, "\u001b[%d;%dH\u001b[%sm%s\u001b[0m", t.cell.Y+1, t.cell.X+1, CAPTION_STYLE, string(text))
+}
+
+// run plays the tile until ctx is done or it gives up, opening the source
+// again, with a backoff, when that fails or its stream drops.
+func (t *mosaicTile) run(ctx context.Context, index int, options MosaicOptions, updates chan<- mosaicUpdate) {
+	send := func(update mosaicUpdate) bool {
+		update.tile = index
+		select {
+		case updates <- update:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if t.rect.Empty() {
+		send(mosaicUpdate{done: true})
+		return
+	}
+
+	retries := 0
+	for {
+		var err error
+		if t.source == nil {
+			t.source, err = Sniff(t.item, true)
+			if err == nil && !send(mosaicUpdate{title: t.source.Title, status: "connecting…"}) {
+				return
+			}
+		}
+
+		played := false
+		if err == nil {
+			played, err = t.play(ctx, options.Buffer, send)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if played {
+			retries = 0
+		}
+
+		// files start over, streams that ended or dropped reconnect
+		if err == nil && played && t.source.Seekable {
+			continue
+		}
+
+		if err == nil && !t.source.Restartable {
+			send(mosaicUpdate{status: "ended", done: true})
+			return
+		}
+
+		if retries >= options.MaxRetries {
+			status := "offline"
+			if err != nil {
+				status += ": " + err.Error()
+			}
+			send(mosaicUpdate{status: status, done: true})
+			return
+		}
+
+		backoff := min(RECONNECT_BACKOFF<<retries, RECONNECT_MAX_BACKOFF)
+		retries++
+		if !send(mosaicUpdate{status: fmt.Sprintf("reconnecting… (%d/%d)", retries, options.MaxRetries)}) {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// play plays the source once through, fitting its frames to the tile,
+// returning whether any came and the error it ended with.
+func (t *mosaicTile) play(ctx context.Context, buffer int, send func(mosaicUpdate) bool) (bool, error) {
+	size := t.rect.Size()
+
+	playback := Playback{Source: t.source, Buffer: buffer}
+	playback.Start(size, 0)
+	defer playback.Stop()
+
+	var pacer Pacer
+	pacer.Reset(0)
+
+	// a picture is copied out by the time the next is sent
+	pictures := [2]*image.NRGBA{image.NewNRGBA(image.Rectangle{Max: size}), image.NewNRGBA(image.Rectangle{Max: size})}
+	played := false
+	stall := time.NewTimer(RECONNECT_STALL)
+	defer stall.Stop()
+
+	for i := 0; ; i++ {
+		var frame Frame
+		select {
+		case f, ok := <-playback.Frames():
+			if !ok {
+				return played, playback.Wait()
+			}
+			frame = f
+		case <-stall.C:
+			return played, fmt.Errorf("no frames for %s", RECONNECT_STALL)
+		case <-ctx.Done():
+			return played, nil
+		}
+		stall.Reset(RECONNECT_STALL)
+
+		playback.Advance(frame.Meta)
+
+		wait, drop := pacer.Schedule(playback.Position(), playback.FrameInterval())
+		if drop {
+			continue
+		}
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return played, nil
+			}
+		}
+
+		picture := pictures[i%len(pictures)]
+		if Fit(frame.Picture, picture) == frame.Picture {
+			copy(picture.Pix, frame.Picture.Pix)
+		}
+
+		played = true
+		if !send(mosaicUpdate{picture: picture}) {
+			return played, nil
+		}
+	}
+}
+
+// copyInto copies picture into canvas with its top left corner at at.
+func copyInto(canvas, picture *image.NRGBA, at image.Point) {
+	size := picture.Rect.Size()
+	for y := 0; y < size.Y; y++ {
+		if at.Y+y >= canvas.Rect.Max.Y {
+			break
+		}
+		from := picture.Pix[y*picture.Stride:][:size.X*4]
+		to := canvas.Pix[canvas.PixOffset(at.X, at.Y+y):]
+		copy(to[:min(len(from), len(to))], from)
+	}
+}
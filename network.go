@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkOptions are what requests for remote sources go out with, whether
+// termtv, ffmpeg or yt-dlp makes them: a proxy, the cookies of a login and
+// headers, for sources behind logins or region gates.
+type NetworkOptions struct {
+	// Proxy is from --proxy, otherwise HTTP_PROXY and HTTPS_PROXY are
+	// used as Go uses them
+	Proxy       string
+	CookiesPath string
+	Cookies     []*http.Cookie
+	// Headers are "Key: Value"
+	Headers []string
+
+	client     *http.Client
+	clientOnce sync.Once
+}
+
+var Network NetworkOptions
+
+func RegisterNetworkFlags(flags *flag.FlagSet) {
+	flags.Func("proxy", "proxy like http://host:3128 for remote sources (default from HTTP_PROXY and HTTPS_PROXY)", func(value string) error {
+		proxy, err := neturl.Parse(value)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("invalid proxy %s", value)
+		}
+
+		Network.Proxy = value
+		return nil
+	})
+
+	flags.Func("cookies", "cookies file in Netscape format, as browsers export them, for remote sources; ffmpeg is handed them on its command line, which other local users can read", func(value string) error {
+		cookies, err := ReadCookies(value)
+		if err != nil {
+			return err
+		}
+
+		Network.CookiesPath, Network.Cookies = value, cookies
+		return nil
+	})
+
+	flags.Func("http-header", "header like 'Referer: https://example.com/' for remote sources, may be repeated; ffmpeg is handed them on its command line, which other local users can read", func(value string) error {
+		key, _, found := strings.Cut(value, ":")
+		if !found || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid header %s, expected 'Key: Value'", value)
+		}
+
+		Network.Headers = append(Network.Headers, value)
+		return nil
+	})
+}
+
+// Client is the client for requests termtv makes itself, going through the
+// proxy with the cookies and headers. It's built on first use, once the
+// flags are parsed.
+func (options *NetworkOptions) Client() *http.Client {
+	options.clientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = options.proxy
+
+		jar, _ := cookiejar.New(nil)
+		for _, cookie := range options.Cookies {
+			scheme := "http"
+			if cookie.Secure {
+				scheme = "https"
+			}
+			jar.SetCookies(&neturl.URL{Scheme: scheme, Host: strings.TrimPrefix(cookie.Domain, "."), Path: cookie.Path}, []*http.Cookie{cookie})
+		}
+
+		options.client = &http.Client{
+			Transport: headerTransport{transport, options.Headers},
+			Jar:       jar,
+		}
+	})
+
+	return options.client
+}
+
+// proxy is --proxy if given, otherwise the one from the environment.
+func (options *NetworkOptions) proxy(request *http.Request) (*neturl.URL, error) {
+	if options.Proxy != "" {
+		return neturl.Parse(options.Proxy)
+	}
+
+	return http.ProxyFromEnvironment(request)
+}
+
+// ReadCookies reads a cookies file in the Netscape format curl and yt-dlp
+// take: tab separated domain, subdomains flag, path, secure flag, expiry,
+// name and value.
+func ReadCookies(path string) ([]*http.Cookie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cookies []*http.Cookie
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// HttpOnly cookies are marked with a prefix that looks like a
+		// comment
+		line := strings.TrimPrefix(scanner.Text(), "#HttpOnly_")
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s: invalid cookie line %q", path, line)
+		}
+
+		cookie := &http.Cookie{
+			Domain: fields[0],
+			Path:   fields[2],
+			Secure: fields[3] == "TRUE",
+			Name:   fields[5],
+			Value:  fields[6],
+		}
+		if expiry, _ := strconv.ParseInt(fields[4], 10, 64); expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		cookies = append(cookies, cookie)
+	}
+
+	return cookies, scanner.Err()
+}
+
+// headerTransport adds the --http-header headers to requests termtv makes
+// itself.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers []string
+}
+
+func (t headerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		request = request.Clone(request.Context())
+		for _, header := range t.headers {
+			key, value, _ := strings.Cut(header, ":")
+			request.Header.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+
+	return t.base.RoundTrip(request)
+}
+
+// HttpArgs are the ffmpeg, ffprobe and ffplay input options for input when
+// it's an http url: the headers an extractor asked for with those of
+// --http-header, the cookies and the proxy. ffmpeg only reads http_proxy
+// from the environment, so the proxy Go would use is passed on. The headers
+// and cookies end up on the command line, where ps shows them to everyone.
+func HttpArgs(input string, headers map[string]string) []string {
+	url, err := neturl.Parse(input)
+	if err != nil || (url.Scheme != "http" && url.Scheme != "https") {
+		return nil
+	}
+
+	var args []string
+
+	joined := ""
+	for key, value := range headers {
+		joined += key + ": " + value + "\r\n"
+	}
+	for _, header := range Network.Headers {
+		joined += header + "\r\n"
+	}
+	if joined != "" {
+		args = append(args, "-headers", joined)
+	}
+
+	if len(Network.Cookies) > 0 {
+		lines := make([]string, len(Network.Cookies))
+		for i, cookie := range Network.Cookies {
+			lines[i] = fmt.Sprintf("%s=%s; path=%s; domain=%s", cookie.Name, cookie.Value, cookie.Path, cookie.Domain)
+		}
+		args = append(args, "-cookies", strings.Join(lines, "\n"))
+	}
+
+	proxy, err := Network.proxy(&http.Request{URL: url})
+	if err == nil && proxy != nil {
+		args = append(args, "-http_proxy", proxy.String())
+	}
+
+	return args
+}
+
+// YtdlArgs pass the proxy, cookies and headers on to yt-dlp.
+func YtdlArgs() []string {
+	var args []string
+
+	if Network.Proxy != "" {
+		args = append(args, "--proxy", Network.Proxy)
+	}
+	if Network.CookiesPath != "" {
+		args = append(args, "--cookies", Network.CookiesPath)
+	}
+	for _, header := range Network.Headers {
+		key, value, _ := strings.Cut(header, ":")
+		args = append(args, "--add-header", strings.TrimSpace(key)+":"+strings.TrimSpace(value))
+	}
+
+	return args
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WriteOverlay writes lines of text over the top rows of the picture, in
+// white on black so they stay readable on any frame, cut to cols.
+func WriteOverlay(buffer *bytes.Buffer, cols int, lines []string) {
+	for i, line := range lines {
+		line = " " + line + " "
+		if len(line) > cols {
+			line = line[:max(cols, 0)]
+		}
+
+		fmt.Fprintf(buffer, "\u001b[%d;1H\u001b[0;97;40m%s\u001b[0m", i+1, line)
+	}
+}
+
+// Caption is a line of text under the picture, with the SGR parameters it's
+// drawn with.
+type Caption struct {
+	Text  string
+	Style string
+}
+
+const (
+	CAPTION_STYLE = "0;97;40"
+	// lyrics are dim but for the current line
+	LYRIC_STYLE         = "0;37;40"
+	LYRIC_CURRENT_STYLE = "0;1;93;40"
+)
+
+// WriteCaptions writes captions centered over the bottom rows, the last one
+// on the bottom row, each cut to cols. Empty ones leave their row alone.
+func WriteCaptions(buffer *bytes.Buffer, cols, rows int, captions []Caption) {
+	WriteCaptionsAt(buffer, cols, rows-len(captions)+1, captions)
+}
+
+// WriteCaptionsAt writes captions centered over the rows from first down.
+func WriteCaptionsAt(buffer *bytes.Buffer, cols, first int, captions []Caption) {
+	for i, caption := range captions {
+		if caption.Text == "" {
+			continue
+		}
+
+		text := []rune(" " + caption.Text + " ")
+		if len(text) > cols {
+			text = text[:max(cols, 0)]
+		}
+
+		fmt.Fprintf(buffer, "\u001b[%d;%dH\u001b[%sm%s\u001b[0m", first+i, (cols-len(text))/2+1, caption.Style, string(text))
+	}
+}
+
+const (
+	PAUSED_TEXT    = "⏸ paused"
+	BUFFERING_TEXT = "buffering…"
+	// BUFFERING_AFTER is how long a source that should be sending frames
+	// has to stay quiet, and at least three frame intervals, before the
+	// picture says it's buffering.
+	BUFFERING_AFTER = time.Second
+
+	INDICATOR_STYLE = "0;1;97;40"
+	// INDICATOR_DIM is how bright the picture under an indicator is left.
+	INDICATOR_DIM = 0.6
+)
+
+// WriteIndicator writes text in the middle of cols by rows cells, to say
+// what the player is doing over a picture that doesn't move.
+func WriteIndicator(buffer *bytes.Buffer, cols, rows int, text string) {
+	line := []rune("  " + text + "  ")
+	if len(line) > cols {
+		line = line[:max(cols, 0)]
+	}
+
+	fmt.Fprintf(buffer, "\u001b[%d;%dH\u001b[%sm%s\u001b[0m", max((rows+1)/2, 1), (cols-len(line))/2+1, INDICATOR_STYLE, string(line))
+}
+
+// DimPicture returns picture at INDICATOR_DIM of its brightness, in dimmed
+// unless it's nil or another size.
+func DimPicture(dimmed, picture *image.NRGBA) *image.NRGBA {
+	if dimmed == nil || dimmed.Rect != picture.Rect {
+		dimmed = image.NewNRGBA(picture.Rect)
+	}
+
+	for i := 0; i < len(picture.Pix); i += 4 {
+		dimmed.Pix[i] = uint8(float64(picture.Pix[i]) * INDICATOR_DIM)
+		dimmed.Pix[i+1] = uint8(float64(picture.Pix[i+1]) * INDICATOR_DIM)
+		dimmed.Pix[i+2] = uint8(float64(picture.Pix[i+2]) * INDICATOR_DIM)
+		dimmed.Pix[i+3] = picture.Pix[i+3]
+	}
+
+	return dimmed
+}
+
+// StatsOverlay keeps the rates shown by the stats overlay, which are taken
+// over about the last second so they follow what playback is doing now.
+type StatsOverlay struct {
+	Visible bool
+
+	// Rate has the Stats of the last second
+	Rate Stats
+	// FrameRate is the rendered frames per second over it
+	FrameRate float64
+
+	last   Stats
+	lastAt time.Time
+}
+
+// Update takes the running Stats, updating the rates once a second.
+func (o *StatsOverlay) Update(stats Stats) {
+	elapsed := time.Since(o.lastAt)
+
+	if o.lastAt.IsZero() {
+		o.last, o.lastAt = stats, time.Now()
+		return
+	}
+
+	if elapsed < time.Second {
+		return
+	}
+
+	o.Rate = Stats{
+		Decoded:    stats.Decoded - o.last.Decoded,
+		Rendered:   stats.Rendered - o.last.Rendered,
+		Dropped:    stats.Dropped - o.last.Dropped,
+		RenderTime: stats.RenderTime - o.last.RenderTime,
+		Bytes:      stats.Bytes - o.last.Bytes,
+	}
+	o.FrameRate = float64(o.Rate.Rendered) / elapsed.Seconds()
+
+	o.last, o.lastAt = stats, time.Now()
+}
+
+// Corners are the names of the corners, in the order of
+// PictureInPicture.Corner.
+var Corners = []string{"bottom-right", "bottom-left", "top-left", "top-right"}
+
+// ParseCorner parses a corner named in Corners.
+func ParseCorner(value string) (int, error) {
+	corner := slices.Index(Corners, value)
+	if corner < 0 {
+		return 0, fmt.Errorf("unknown corner %s, expected one of %s", value, strings.Join(Corners, ", "))
+	}
+	return corner, nil
+}
+
+// CLOCK_LAYOUT is how TextOverlay shows the time.
+const CLOCK_LAYOUT = "15:04:05"
+
+// TextOverlay is text kept in a corner over the picture, like the name of a
+// kiosk or a clock on a stream monitor. It's written as terminal text rather
+// than drawn into the picture, so it stays sharp at any size.
+type TextOverlay struct {
+	Text  string
+	Clock bool
+	// Corner is numbered like PictureInPicture.Corner.
+	Corner int
+}
+
+// Write writes the overlay in its corner of a picture of cols by rows
+// cells.
+func (o *TextOverlay) Write(buffer *bytes.Buffer, cols, rows int) {
+	var parts []string
+	if o.Text != "" {
+		parts = append(parts, o.Text)
+	}
+	if o.Clock {
+		parts = append(parts, time.Now().Format(CLOCK_LAYOUT))
+	}
+	if len(parts) == 0 || cols <= 0 || rows <= 0 {
+		return
+	}
+
+	text := []rune(" " + strings.Join(parts, "  ") + " ")
+	if len(text) > cols {
+		text = text[:cols]
+	}
+
+	row, col := rows, cols-len(text)+1
+	if o.Corner == 1 || o.Corner == 2 {
+		col = 1
+	}
+	if o.Corner == 2 || o.Corner == 3 {
+		row = 1
+	}
+
+	fmt.Fprintf(buffer, "\u001b[%d;%dH\u001b[%sm%s\u001b[0m", row, col, CAPTION_STYLE, string(text))
+}
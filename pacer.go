@@ -0,0 +1,55 @@
+package main
+
+import "time"
+
+// Pacer keeps frames in step with the wall clock. Frames that decode early
+// wait for their time, frames that come late are dropped while writing them
+// out is what holds playback up, e.g. over a slow ssh link where output
+// would otherwise queue up and lag further and further behind.
+type Pacer struct {
+	// epoch is the wall time of media time 0
+	epoch   time.Time
+	latency time.Duration
+}
+
+// Reset starts the clock at position, after starting, seeking or pausing.
+func (pc *Pacer) Reset(position time.Duration) {
+	pc.epoch = time.Now().Add(-position)
+}
+
+// Schedule returns how long to wait before showing the frame at position, or
+// whether to drop it. interval is the time between frames, 0 if unknown.
+func (pc *Pacer) Schedule(position, interval time.Duration) (time.Duration, bool) {
+	if interval <= 0 {
+		return 0, false
+	}
+
+	wait := pc.epoch.Add(position).Sub(time.Now())
+	if wait >= -interval {
+		return max(wait, 0), false
+	}
+
+	if pc.latency > interval/2 {
+		return 0, true
+	}
+
+	// decoding rather than output can't keep up, dropping wouldn't help
+	// so fall behind the clock instead
+	pc.Reset(position)
+	return 0, false
+}
+
+// Lag is how far the frame at position is behind the clock.
+func (pc *Pacer) Lag(position time.Duration) time.Duration {
+	return time.Since(pc.epoch) - position
+}
+
+// Latency is how long writing a frame takes, on average over the last few.
+func (pc *Pacer) Latency() time.Duration {
+	return pc.latency
+}
+
+// Wrote records how long writing a frame took.
+func (pc *Pacer) Wrote(d time.Duration) {
+	pc.latency = (pc.latency*7 + d) / 8
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+)
+
+// PALETTE_FIRST is the first color an adaptive palette redefines. The 16
+// below are left alone, the captions, overlays and the rest of the
+// terminal use them.
+const PALETTE_FIRST = 16
+
+// AdaptivePalette is set by --adaptive-palette, which has the 256 color
+// renderer redefine the terminal's palette for each frame.
+var AdaptivePalette bool
+
+// paletteChanged is set once the terminal's palette was redefined, for
+// RestoreTerminal to reset it.
+var paletteChanged bool
+
+// PaletteRenderer draws half blocks in 256 color terminals with the 240
+// colors that best fit each frame, found by median cut and set with OSC 4,
+// rather than those of the xterm cube. Redefining a color changes the cells
+// already drawn in it, so every frame is drawn whole.
+type PaletteRenderer struct {
+	// palette is what the terminal has, to only send colors that changed
+	palette [256 - PALETTE_FIRST]color.NRGBA
+	defined [256 - PALETTE_FIRST]bool
+}
+
+func (*PaletteRenderer) Name() string { return "256" }
+
+func (*PaletteRenderer) Grid(cols, rows int) image.Point {
+	return image.Pt(cols, (rows-1)*2)
+}
+
+func (r *PaletteRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	colors, indices := MedianCut(picture, len(r.palette))
+
+	// the terminal has its own palette back since the last frame, see
+	// ResetPalette
+	if !paletteChanged {
+		clear(r.defined[:])
+	}
+
+	for i, c := range colors {
+		if r.defined[i] && r.palette[i] == c {
+			continue
+		}
+		fmt.Fprintf(buffer, "\u001b]4;%d;rgb:%02x/%02x/%02x\u001b\\", PALETTE_FIRST+i, c.R, c.G, c.B)
+		r.palette[i], r.defined[i] = c, true
+	}
+	paletteChanged = true
+
+	size := picture.Rect.Size()
+
+	for y := 0; y < size.Y; y += 2 {
+		writePosition(buffer, picture.Rect.Min.Add(image.Pt(0, y)), image.Pt(1, 2))
+		for x := 0; x < size.X; x++ {
+			top := PALETTE_FIRST + int(indices[y*size.X+x])
+			bottom := top
+			if y+1 < size.Y {
+				bottom = PALETTE_FIRST + int(indices[(y+1)*size.X+x])
+			}
+			fmt.Fprintf(buffer, "\u001b[38;5;%d;48;5;%dm▀", top, bottom)
+		}
+		buffer.WriteString("\u001b[0m")
+	}
+}
+
+// ResetPalette gives the terminal its own palette back, with OSC 104 written
+// to w.
+func ResetPalette(w io.Writer) {
+	if paletteChanged {
+		io.WriteString(w, "\u001b]104\u001b\\")
+		paletteChanged = false
+	}
+}
+
+// colorBox is a box of the color space median cut splits, holding pixels by
+// index.
+type colorBox struct {
+	pixels []int32
+	// channel is the one the box spans the most of, by span
+	channel int
+	span    int
+}
+
+// MedianCut picks up to n colors for picture by splitting the box holding
+// its colors in two at the median, the box that spans the most first, until
+// there are n. Returns the average color of each box and the box of each
+// pixel, row by row.
+func MedianCut(picture *image.NRGBA, n int) ([]color.NRGBA, []uint8) {
+	size := picture.Rect.Size()
+
+	pixels := make([][3]uint8, 0, size.X*size.Y)
+	for y := picture.Rect.Min.Y; y < picture.Rect.Max.Y; y++ {
+		for x := picture.Rect.Min.X; x < picture.Rect.Max.X; x++ {
+			c := picture.NRGBAAt(x, y)
+			pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+		}
+	}
+
+	measure := func(box *colorBox) {
+		low, high := [3]uint8{255, 255, 255}, [3]uint8{}
+		for _, i := range box.pixels {
+			for c, v := range pixels[i] {
+				low[c], high[c] = min(low[c], v), max(high[c], v)
+			}
+		}
+
+		box.channel, box.span = 0, 0
+		for c := range low {
+			if span := int(high[c]) - int(low[c]); span > box.span {
+				box.channel, box.span = c, span
+			}
+		}
+	}
+
+	all := make([]int32, len(pixels))
+	for i := range all {
+		all[i] = int32(i)
+	}
+	boxes := []*colorBox{{pixels: all}}
+	measure(boxes[0])
+
+	for len(boxes) < min(n, 256) {
+		widest := -1
+		for i, box := range boxes {
+			if box.span > 0 && (widest < 0 || box.span > boxes[widest].span) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		channel := box.channel
+		slices.SortFunc(box.pixels, func(a, b int32) int {
+			return int(pixels[a][channel]) - int(pixels[b][channel])
+		})
+
+		half := len(box.pixels) / 2
+		upper := &colorBox{pixels: box.pixels[half:]}
+		box.pixels = box.pixels[:half]
+		measure(box)
+		measure(upper)
+
+		boxes = append(boxes, upper)
+	}
+
+	colors := make([]color.NRGBA, len(boxes))
+	indices := make([]uint8, len(pixels))
+
+	for b, box := range boxes {
+		var sum [3]int
+		for _, i := range box.pixels {
+			indices[i] = uint8(b)
+			for c, v := range pixels[i] {
+				sum[c] += int(v)
+			}
+		}
+
+		count := max(len(box.pixels), 1)
+		colors[b] = color.NRGBA{uint8(sum[0] / count), uint8(sum[1] / count), uint8(sum[2] / count), 255}
+	}
+
+	return colors, indices
+}
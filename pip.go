@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+)
+
+const (
+	// PIP_SIZE is the width of the inset to start with, as a part of the
+	// picture's, and PIP_MIN_SIZE and PIP_MAX_SIZE how small and large
+	// pip-size makes it.
+	PIP_SIZE     = 0.3
+	PIP_MIN_SIZE = 0.1
+	PIP_MAX_SIZE = 0.6
+	// PIP_MARGIN is the space between the inset and the edges, as a part of
+	// the picture's width.
+	PIP_MARGIN = 0.02
+	// PIP_IDLE is how long the main source may go without frames before
+	// the inset is drawn on its own.
+	PIP_IDLE = 250 * time.Millisecond
+)
+
+var pipBorder = color.NRGBA{128, 128, 128, 255}
+
+// PictureInPicture plays a second source in an inset in a corner of the
+// main one, like a webcam over a screen capture. It is played like a tile of
+// a Mosaic: on its own, starting over when it ends and reconnecting when it
+// drops. Swapped, it fills the picture and the main source goes in the inset.
+type PictureInPicture struct {
+	Item string
+	// Corner is 0 to 3 for bottom right, bottom left, top left and top
+	// right.
+	Corner int
+	// Size is the width of the inset as a part of the picture's.
+	Size    float64
+	Swapped bool
+
+	Buffer     int
+	MaxRetries int
+
+	// Updates gets the pictures and status of the inset's source, for
+	// Receive
+	Updates chan mosaicUpdate
+
+	tile    *mosaicTile
+	grid    image.Point
+	inset   image.Rectangle
+	picture *image.NRGBA
+	// scaled is the main picture fitted into the inset, while swapped
+	scaled  *image.NRGBA
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func NewPictureInPicture(item string, buffer, maxRetries int) *PictureInPicture {
+	return &PictureInPicture{
+		Item:       item,
+		Size:       PIP_SIZE,
+		Buffer:     buffer,
+		MaxRetries: maxRetries,
+		Updates:    make(chan mosaicUpdate),
+		tile:       &mosaicTile{item: item, title: item, status: "connecting…"},
+	}
+}
+
+// Start plays the source for a picture of grid pixels, in place of what was
+// played before.
+func (pip *PictureInPicture) Start(grid image.Point) {
+	pip.Stop()
+
+	pip.grid = grid
+	pip.inset = pip.insetRect()
+	pip.picture = nil
+	pip.scaled = nil
+
+	pip.tile.rect = pip.inset
+	if pip.Swapped {
+		pip.tile.rect = image.Rectangle{Max: grid}
+	}
+
+	var ctx context.Context
+	ctx, pip.cancel = context.WithCancel(context.Background())
+
+	pip.running.Add(1)
+	go func() {
+		defer pip.running.Done()
+		pip.tile.run(ctx, 0, MosaicOptions{Buffer: pip.Buffer, MaxRetries: pip.MaxRetries}, pip.Updates)
+	}()
+}
+
+// Stop stops playing the source, the source itself stays open.
+func (pip *PictureInPicture) Stop() {
+	if pip.cancel == nil {
+		return
+	}
+
+	pip.cancel()
+	pip.running.Wait()
+	pip.cancel = nil
+}
+
+// Close stops playing and closes the source.
+func (pip *PictureInPicture) Close() {
+	pip.Stop()
+
+	if source := pip.tile.source; source != nil && source.Close != nil {
+		source.Close()
+	}
+}
+
+// insetRect is where the inset goes in the picture.
+func (pip *PictureInPicture) insetRect() image.Rectangle {
+	size := image.Pt(int(float64(pip.grid.X)*pip.Size), int(float64(pip.grid.Y)*pip.Size))
+	margin := int(float64(pip.grid.X) * PIP_MARGIN)
+
+	min := image.Pt(pip.grid.X-margin-size.X, pip.grid.Y-margin-size.Y)
+	if pip.Corner == 1 || pip.Corner == 2 {
+		min.X = margin
+	}
+	if pip.Corner == 2 || pip.Corner == 3 {
+		min.Y = margin
+	}
+
+	return image.Rectangle{min, min.Add(size)}
+}
+
+// Receive takes an update from Updates, returning true when the status
+// shown over the inset changed.
+func (pip *PictureInPicture) Receive(update mosaicUpdate) bool {
+	if update.picture != nil {
+		pip.picture = update.picture
+	}
+	if update.title != "" {
+		pip.tile.title = update.title
+	}
+
+	changed := update.status != pip.tile.status
+	pip.tile.status = update.status
+
+	return changed
+}
+
+// Move moves the inset to the next corner, clockwise.
+func (pip *PictureInPicture) Move() {
+	pip.Corner = (pip.Corner + 1) % 4
+	pip.Start(pip.grid)
+}
+
+// Resize grows or shrinks the inset by by, a part of the picture's width.
+func (pip *PictureInPicture) Resize(by float64) {
+	pip.Size = min(max(pip.Size+by, PIP_MIN_SIZE), PIP_MAX_SIZE)
+	pip.Start(pip.grid)
+}
+
+// Swap puts the source in the inset and the main source in its place, or
+// back.
+func (pip *PictureInPicture) Swap() {
+	pip.Swapped = !pip.Swapped
+	pip.Start(pip.grid)
+}
+
+// Draw puts the inset over picture, the main picture, in place. Until the
+// source has a frame the inset shows the main picture under it, or black
+// while swapped.
+func (pip *PictureInPicture) Draw(picture *image.NRGBA) {
+	if pip.inset.Empty() || picture.Rect.Size() != pip.grid {
+		return
+	}
+
+	if !pip.Swapped {
+		if pip.picture != nil {
+			copyInto(picture, pip.picture, pip.inset.Min)
+			drawBorder(picture, pip.inset)
+		}
+		return
+	}
+
+	if pip.scaled == nil {
+		pip.scaled = image.NewNRGBA(image.Rectangle{Max: pip.inset.Size()})
+	}
+	if Fit(picture, pip.scaled) == picture {
+		copy(pip.scaled.Pix, picture.Pix)
+	}
+
+	if pip.picture != nil && len(pip.picture.Pix) == len(picture.Pix) {
+		copy(picture.Pix, pip.picture.Pix)
+	} else {
+		clear(picture.Pix)
+	}
+
+	copyInto(picture, pip.scaled, pip.inset.Min)
+	drawBorder(picture, pip.inset)
+}
+
+// WriteLabel writes the status of the source over the inset while it has
+// one, like "reconnecting…".
+func (pip *PictureInPicture) WriteLabel(buffer *bytes.Buffer, cols, rows int) {
+	if pip.tile.status == "" || pip.grid.X == 0 || pip.grid.Y == 0 {
+		return
+	}
+
+	rect := pip.inset
+	if pip.Swapped {
+		rect = image.Rectangle{Max: pip.grid}
+	}
+
+	// the tile is laid out in pixels, its label in cells
+	pip.tile.cell = image.Pt((rect.Min.X*cols+pip.grid.X-1)/pip.grid.X, (rect.Min.Y*rows+pip.grid.Y-1)/pip.grid.Y)
+	pip.tile.writeLabel(buffer, cols*rect.Dx()/pip.grid.X)
+}
+
+// drawBorder draws a line around rect, inside it.
+func drawBorder(picture *image.NRGBA, rect image.Rectangle) {
+	rect = rect.Intersect(picture.Rect)
+	if rect.Empty() {
+		return
+	}
+
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		picture.SetNRGBA(x, rect.Min.Y, pipBorder)
+		picture.SetNRGBA(x, rect.Max.Y-1, pipBorder)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		picture.SetNRGBA(rect.Min.X, y, pipBorder)
+		picture.SetNRGBA(rect.Max.X-1, y, pipBorder)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"image"
+	"io"
+	"time"
+)
+
+// The player runs as stages connected by bounded channels: the runner
+// decodes, a Scaler fits its frames to the terminal, the player loop
+// encodes them and an OutputWriter writes them out. Each stage works on its
+// own frame, so the next one is scaled and encoded while the last is still
+// being written.
+
+// OUTPUT_QUEUE is how many writes may wait for the terminal before the
+// player blocks on it.
+const OUTPUT_QUEUE = 2
+
+// FrameFilter changes the pictures the scale stage hands on, in place.
+// Filters carry their state from frame to frame of a stream and are Reset
+// when another starts.
+type FrameFilter interface {
+	Filter(picture *image.NRGBA)
+	Reset()
+}
+
+// scaledFrame is a frame of the stream fitted to the terminal, with the
+// FrameMeta of the frame it came from.
+type scaledFrame struct {
+	picture *image.NRGBA
+	meta    FrameMeta
+}
+
+// Scaler fits the frames of a stream to the terminal in a goroutine of its
+// own. Frames is closed when the stream's frames are.
+type Scaler struct {
+	Frames chan scaledFrame
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartScaler fits frames to size, passing them through filters, until they
+// run out or Stop is called.
+func StartScaler(frames <-chan Frame, size image.Point, filters []FrameFilter) *Scaler {
+	s := &Scaler{
+		Frames: make(chan scaledFrame, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	for _, filter := range filters {
+		filter.Reset()
+	}
+
+	go s.run(frames, size, filters)
+
+	return s
+}
+
+func (s *Scaler) run(frames <-chan Frame, size image.Point, filters []FrameFilter) {
+	defer close(s.done)
+	defer close(s.Frames)
+
+	// one picture being scaled, one queued and one waiting to be shown
+	pictures := make([]*image.NRGBA, cap(s.Frames)+2)
+
+	for i := 0; ; i++ {
+		var frame Frame
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				return
+			}
+			frame = f
+		case <-s.stop:
+			return
+		}
+
+		picture := pictures[i%len(pictures)]
+		if picture == nil {
+			picture = image.NewNRGBA(image.Rectangle{Max: size})
+			pictures[i%len(pictures)] = picture
+		}
+
+		// frames the right size are copied too, the runner reuses them
+		// before the player is done with the picture
+		if Fit(frame.Picture, picture) == frame.Picture {
+			copy(picture.Pix, frame.Picture.Pix)
+		}
+
+		for _, filter := range filters {
+			filter.Filter(picture)
+		}
+
+		select {
+		case s.Frames <- scaledFrame{picture, frame.Meta}:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the scaler, dropping the frames it holds.
+func (s *Scaler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// OutputWriter writes to the terminal from a goroutine of its own, in the
+// order written, up to OUTPUT_QUEUE writes behind. Written gets how long
+// each frame took to write, for the Pacer.
+type OutputWriter struct {
+	Written chan time.Duration
+
+	w     io.Writer
+	queue chan outputWrite
+	free  chan []byte
+	done  chan struct{}
+}
+
+// outputWrite is data to write, or, with flushed set, a marker closed once
+// everything before it is written.
+type outputWrite struct {
+	data    []byte
+	frame   bool
+	flushed chan struct{}
+}
+
+func NewOutputWriter(w io.Writer) *OutputWriter {
+	o := &OutputWriter{
+		Written: make(chan time.Duration, OUTPUT_QUEUE),
+		w:       w,
+		queue:   make(chan outputWrite, OUTPUT_QUEUE),
+		free:    make(chan []byte, OUTPUT_QUEUE+1),
+		done:    make(chan struct{}),
+	}
+
+	go o.run()
+
+	return o
+}
+
+func (o *OutputWriter) run() {
+	defer close(o.done)
+
+	for write := range o.queue {
+		if write.flushed != nil {
+			close(write.flushed)
+			continue
+		}
+
+		start := time.Now()
+		// a terminal that fails to take output has nowhere to say so
+		o.w.Write(write.data)
+
+		if write.frame {
+			select {
+			case o.Written <- time.Since(start):
+			default:
+			}
+		}
+
+		select {
+		case o.free <- write.data:
+		default:
+		}
+	}
+}
+
+// Write queues a copy of p.
+func (o *OutputWriter) Write(p []byte) (int, error) {
+	o.queue <- outputWrite{data: o.copy(p)}
+	return len(p), nil
+}
+
+// WriteFrame queues a copy of a frame, reporting on Written how long it took
+// to write.
+func (o *OutputWriter) WriteFrame(p []byte) {
+	o.queue <- outputWrite{data: o.copy(p), frame: true}
+}
+
+func (o *OutputWriter) copy(p []byte) []byte {
+	var data []byte
+	select {
+	case data = <-o.free:
+	default:
+	}
+	return append(data[:0], p...)
+}
+
+// Flush waits for everything queued to be written, before writing to the
+// terminal some other way.
+func (o *OutputWriter) Flush() {
+	flushed := make(chan struct{})
+	o.queue <- outputWrite{flushed: flushed}
+	<-flushed
+}
+
+// Close writes what's queued and stops the writer.
+func (o *OutputWriter) Close() {
+	close(o.queue)
+	<-o.done
+}
+
+// Resizer is a writer that's told the size of the terminal when it changes,
+// between the output of either size, like a recording.
+type Resizer interface {
+	Resize(cols, rows int) error
+}
+
+// TeeWriter writes to several writers at once, like io.MultiWriter, but a
+// writer that fails is left out from then on rather than stopping the
+// others, so a dropped connection doesn't take the terminal down with it.
+type TeeWriter struct {
+	writers []io.Writer
+}
+
+func NewTeeWriter(writers ...io.Writer) *TeeWriter {
+	return &TeeWriter{writers: writers}
+}
+
+// Write writes p to the writers left, failing only once none are.
+func (t *TeeWriter) Write(p []byte) (int, error) {
+	var err error
+
+	writers := t.writers[:0]
+	for _, w := range t.writers {
+		if _, err = w.Write(p); err == nil {
+			writers = append(writers, w)
+		}
+	}
+	t.writers = writers
+
+	if len(writers) == 0 {
+		return 0, cmp.Or(err, io.ErrClosedPipe)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"image"
+	"time"
+)
+
+// Playback drives the stream of a source and keeps track of the position in
+// it, independently of where the frames end up.
+type Playback struct {
+	Source *Source
+	// Buffer is how many frames may be decoded ahead.
+	Buffer int
+
+	stream  *Stream
+	size    image.Point
+	started time.Time
+
+	// offset is where the current stream started, frames how many frames
+	// it has delivered since
+	offset time.Duration
+	frames int
+	// pts is the timestamp of the last frame for streams whose frames
+	// are Timed, interval the time since the one before it
+	pts      time.Duration
+	interval time.Duration
+	timed    bool
+	// reversed is set while the stream plays backwards, see ReverseRunner
+	reversed bool
+}
+
+// Start starts the stream at offset, asking for frames of size if the source
+// scales. Sources that can't seek start at the beginning.
+func (pb *Playback) Start(size image.Point, offset time.Duration) {
+	if !pb.Source.Seekable {
+		offset = 0
+	}
+
+	pb.size = size
+	pb.started = time.Now()
+	pb.offset = offset
+	pb.frames = 0
+	pb.timed = false
+	pb.interval = 0
+	pb.stream = StartStream(pb.runner(), pb.frameSize(), offset, pb.Buffer)
+}
+
+func (pb *Playback) runner() FrameRunner {
+	if pb.reversed {
+		return ReverseRunner(pb.Source)
+	}
+	return pb.Source.Runner
+}
+
+// frameSize is the size frames are asked for. Reversed frames are kept in
+// memory, so at the size they're shown at.
+func (pb *Playback) frameSize() image.Point {
+	if pb.Source.Scale || pb.reversed {
+		return pb.size
+	}
+	return pb.Source.Info.Size
+}
+
+// Reverse plays the stream backwards from the position, or forwards again.
+// Only seekable sources with a frame rate can, returns false for others.
+func (pb *Playback) Reverse(reversed bool) bool {
+	if !pb.Source.Seekable || !pb.Source.Restartable || pb.Source.Info.FrameRate <= 0 || reversed == pb.reversed {
+		return false
+	}
+
+	offset := pb.Position()
+	pb.stream.Stop()
+	pb.reversed = reversed
+	pb.Start(pb.size, offset)
+
+	return true
+}
+
+// Reversed is set while the stream plays backwards.
+func (pb *Playback) Reversed() bool {
+	return pb.reversed
+}
+
+// Frames is the channel of the current stream, it changes on restarts.
+func (pb *Playback) Frames() chan Frame {
+	return pb.stream.Frames
+}
+
+// Advance is called with the FrameMeta of each frame taken from Frames.
+func (pb *Playback) Advance(meta FrameMeta) {
+	pb.frames++
+
+	if !meta.Timed {
+		return
+	}
+
+	if pb.timed && meta.PTS > pb.pts {
+		pb.interval = meta.PTS - pb.pts
+	}
+	pb.pts = meta.PTS
+	pb.timed = true
+}
+
+// Position is the media time of the last frame taken: its timestamp, or for
+// frames without one as many frames in at the frame rate. Sources with an
+// unknown frame rate fall back to wall clock time.
+func (pb *Playback) Position() time.Duration {
+	if pb.timed {
+		return pb.pts
+	}
+	if rate := pb.Source.Info.FrameRate; rate > 0 {
+		return pb.offset + time.Duration(float64(pb.frames)/rate*float64(time.Second))
+	}
+	return time.Since(pb.started)
+}
+
+// FrameInterval is the time between frames, the last gap between timestamps
+// when the frame rate is unknown, or 0 without either.
+func (pb *Playback) FrameInterval() time.Duration {
+	if rate := pb.Source.Info.FrameRate; rate > 0 {
+		return time.Duration(float64(time.Second) / rate)
+	}
+	if pb.timed {
+		return pb.interval
+	}
+	return 0
+}
+
+// Restart restarts the stream at offset, or at the start for sources that
+// can't seek. Returns false for sources that can't be restarted at all.
+func (pb *Playback) Restart(offset time.Duration) bool {
+	if !pb.Source.Restartable {
+		return false
+	}
+
+	if !pb.Source.Seekable {
+		offset = 0
+	}
+
+	pb.stream.Restart(pb.frameSize(), offset)
+	pb.offset = offset
+	pb.frames = 0
+	pb.timed = false
+	pb.interval = 0
+
+	return true
+}
+
+func (pb *Playback) Seek(by time.Duration) bool {
+	if !pb.Source.Seekable {
+		return false
+	}
+
+	target := max(pb.Position()+by, 0)
+	if duration := pb.Source.Info.Duration; duration > 0 {
+		target = min(target, duration)
+	}
+
+	return pb.Restart(target)
+}
+
+// Resize changes the size frames are asked for. Rather than leaving frames of
+// the old size to be stretched, sources that scale are restarted at the new
+// size from the current position.
+func (pb *Playback) Resize(size image.Point) bool {
+	if size == pb.size {
+		return false
+	}
+
+	pb.size = size
+
+	if !pb.Source.Scale && !pb.reversed {
+		return false
+	}
+
+	return pb.Restart(pb.Position())
+}
+
+func (pb *Playback) Stop() {
+	pb.stream.Stop()
+}
+
+// Wait returns the error the stream ended with once Frames is drained.
+func (pb *Playback) Wait() error {
+	return pb.stream.Wait()
+}
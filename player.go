@@ -0,0 +1,1470 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Player struct {
+	Source   *Source
+	Bindings KeyBindings
+	Renderer Renderer
+	// MinSize is the smallest terminal, in cells, worth playing in. Below
+	// it playback waits for the terminal to be resized.
+	MinSize image.Point
+	// Buffer is how many frames may be decoded ahead.
+	Buffer int
+	// MaxMemory, unless 0, bounds the frames decoded ahead and the replay
+	// cache together, see MemoryShares.
+	MaxMemory int64
+	// Skip are parts, like an intro, to seek past once playback reaches
+	// them. Intro finds them, see PlayItem.
+	Skip  []Segment
+	Intro *IntroDetector
+	// Bandwidth, when set, caps the bytes per second written, see
+	// Bandwidth.
+	Bandwidth *Bandwidth
+
+	// Recorder, when set, gets a copy of the output along with markers of
+	// the media time every MarkerInterval, on seeks and on chapters.
+	Recorder       *CastRecorder
+	MarkerInterval time.Duration
+
+	// Audio, when set, plays the sound along.
+	Audio *AudioPlayer
+	// Lyrics, when set, are shown under the picture following the position.
+	Lyrics *Lyrics
+	// Subtitles, when set, are shown SubtitleDelay after their times the
+	// way SubtitleStyle says.
+	Subtitles     *Subtitles
+	SubtitleDelay time.Duration
+	SubtitleStyle SubtitleStyle
+	// Replay, when set, keeps the last pictures for the replay command.
+	Replay *ReplayCache
+	// MaxRetries is how many times in a row a network source that dropped
+	// is reconnected.
+	MaxRetries int
+	// Filters change the pictures once they're fitted to the terminal.
+	Filters []FrameFilter
+	// Pip, when set, plays another source in an inset over the picture.
+	Pip *PictureInPicture
+	// Screensaver quits on any key and shows nothing over the picture: no
+	// captions, status, stats or labels.
+	Screensaver bool
+	// Overlay, when set, is text kept in a corner over the picture.
+	Overlay *TextOverlay
+	// Interpolate shows a blend of each frame and the next halfway between
+	// them, doubling the frame rate of low-fps sources like GIFs.
+	Interpolate bool
+	// Output gets what the player draws, os.Stdout when nil, and Tee gets
+	// a copy. The picture is sized for the terminal either way, and a Tee
+	// that's a Resizer is told when it's resized.
+	Output io.Writer
+	Tee    []io.Writer
+	// Adaptive shrinks the picture a step at a time while the terminal
+	// takes longer to write a frame than the frames are apart, see
+	// ADAPTIVE_SCALES.
+	Adaptive bool
+	// Viewport keeps the picture within the terminal after it shrank.
+	Viewport Viewport
+	// Center draws the picture in the middle of the terminal, only as big
+	// as the video rather than padded to the terminal with black.
+	Center bool
+	// TitleLine keeps the top row for the title of the source, its size and
+	// codec, after a pause button and before a progress bar.
+	TitleLine bool
+	// Mouse has the terminal report the mouse: a click on the progress bar
+	// seeks, on the pause button or the picture pauses, and the wheel is
+	// bound like a key.
+	Mouse bool
+	// Volume is the AudioPlayer.Volume, kept here for the next one.
+	Volume int
+	// Shell, when set, lays a playlist pane, volume slider and key help
+	// out around the picture, which gets the rest of the terminal.
+	Shell *Shell
+	// Backdrop, when set, fills the rest of the terminal around the picture
+	// with its color, rather than black bars and the terminal's background.
+	Backdrop *Backdrop
+
+	Stats Stats
+	Pacer Pacer
+	// Quit is set when playback was stopped by the user rather than by
+	// reaching the end of the source.
+	Quit bool
+
+	playback Playback
+	scaler   *Scaler
+	grid     image.Point
+	buffer   *bytes.Buffer
+	writer   *OutputWriter
+	out      io.Writer
+	paused   bool
+	small    bool
+
+	// pending is the frame waiting to be shown when due fires, or with
+	// halfway set the blend of it and the last one before it is
+	pending *scaledFrame
+	due     <-chan time.Time
+	halfway bool
+	blended *image.NRGBA
+	// cut is set from a restart until the first frame after it, which
+	// isn't blended with the last one before
+	cut bool
+
+	overlay StatsOverlay
+	// meters is set while audio level meters are drawn, see DrawMeters
+	meters bool
+	// histogram is set while the histogram is drawn, see DrawHistogram
+	histogram bool
+	// status is the Source.Status shown over the last frame
+	status string
+	// audioDelayed is when the audio delay was last changed, to show it
+	// for a while
+	audioDelayed time.Time
+	// subtitlesDelayed and volumeChanged are the same for the subtitle
+	// delay and the volume
+	subtitlesDelayed time.Time
+	volumeChanged    time.Time
+	// titleRow is the title row as it was last written
+	titleRow string
+	// preview shows where the seeks made while paused land
+	preview *SeekPreview
+	// reverseAudio is the Audio, set aside while playing backwards
+	reverseAudio *AudioPlayer
+	// windowTitle is what the terminal's window title was set to
+	windowTitle string
+	// indicator is PAUSED_TEXT or BUFFERING_TEXT while it's shown over
+	// the picture, dimmed into dimmed
+	indicator string
+	dimmed    *image.NRGBA
+	// captions are under the picture: lyrics and the Source.Caption
+	captions []Caption
+	// subtitles are the captions over the top of the picture, when
+	// subtitles are there
+	subtitles []Caption
+
+	// replay are the pictures being replayed, the next one shown when
+	// replayDue fires
+	replay    []replayPicture
+	replayDue <-chan time.Time
+
+	// retries are the reconnects since the last frame, the next one made
+	// when retryDue fires
+	retries   int
+	retryDue  <-chan time.Time
+	lastFrame time.Time
+
+	// drawn is what the terminal shows, for renderers that can update it
+	// with only what changed
+	drawn *image.NRGBA
+	// last is the last picture of the source without the Pip inset, to
+	// draw it over again
+	last *image.NRGBA
+
+	nextMarker time.Duration
+	chapter    int
+
+	// scale is the step of ADAPTIVE_SCALES the picture is at, and slow
+	// when writing frames started falling behind
+	scale int
+	slow  time.Time
+}
+
+// ADAPTIVE_SCALES are the sizes an Adaptive player steps the picture down
+// through, as parts of the terminal's, once writing frames took longer than
+// they're apart for ADAPTIVE_WINDOW. Resizing the terminal starts over.
+var ADAPTIVE_SCALES = []float64{1, 0.75, 0.5, 0.35}
+
+const ADAPTIVE_WINDOW = 2 * time.Second
+
+// DELAY_SHOWN is how long the audio or subtitle delay, or the volume, is
+// shown under the picture after it changed.
+const DELAY_SHOWN = 2 * time.Second
+
+const (
+	PAUSE_BUTTON = " ⏸ "
+	PLAY_BUTTON  = " ▶ "
+	// PROGRESS_WIDTH is the part of the title row the progress bar takes.
+	PROGRESS_WIDTH = 0.4
+	// PROGRESS_TIME is how the time after the progress bar is laid out.
+	PROGRESS_TIME = " %s/%s "
+)
+
+// Position is the media time of the last rendered frame.
+func (p *Player) Position() time.Duration {
+	return p.playback.Position()
+}
+
+// clock is the time the Pacer keeps frames in step with: the position, or
+// while playing backwards the position going the other way.
+func (p *Player) clock(position time.Duration) time.Duration {
+	if p.playback.Reversed() {
+		return -position
+	}
+	return position
+}
+
+// reverse plays backwards, or forwards again, from the position. Only local
+// files do, network sources would download every chunk again. Going
+// backwards the sound is left out, ffplay can't play it that way.
+func (p *Player) reverse(reversed bool) {
+	if IsUrl(p.Source.Name) || p.small {
+		return
+	}
+
+	p.endReplay()
+	if !p.playback.Reverse(reversed) {
+		return
+	}
+	p.restarted()
+
+	if reversed {
+		p.reverseAudio, p.Audio = p.Audio, nil
+		if p.reverseAudio != nil {
+			p.reverseAudio.Stop()
+		}
+	} else if p.reverseAudio != nil {
+		p.Audio, p.reverseAudio = p.reverseAudio, nil
+		if !p.paused {
+			p.Audio.Start(p.Position())
+		}
+	}
+}
+
+// restarted marks the new position, and the chapter it lands in, after the
+// stream was restarted.
+func (p *Player) restarted() {
+	offset := p.Position()
+
+	p.startScaler()
+
+	p.Pacer.Reset(p.clock(offset))
+	p.pending = nil
+	p.due = nil
+	p.halfway = false
+	p.cut = true
+
+	p.nextMarker = 0
+	p.chapter = 0
+
+	chapters := p.Source.Info.Chapters
+	for p.chapter+1 < len(chapters) && chapters[p.chapter+1].Start <= offset {
+		p.chapter++
+	}
+}
+
+func (p *Player) Seek(by time.Duration) {
+	p.endReplay()
+
+	if p.playback.Seek(by) {
+		p.restarted()
+
+		if p.Audio != nil && !p.paused {
+			p.Audio.Start(p.Position())
+		}
+	}
+}
+
+// seek seeks by by, or while paused previews it to make on resuming.
+func (p *Player) seek(by time.Duration) {
+	if p.paused && p.previewSeek(by) {
+		return
+	}
+	p.Seek(by)
+}
+
+// click acts on a click at cell x, y from 1: on the title row it pauses on
+// the button and seeks to where the progress bar was clicked, on the picture
+// it pauses. On the Shell's pane it picks the item to play next, returning
+// true when the player should stop for it, and on the slider it sets the
+// volume.
+func (p *Player) click(x, y int) bool {
+	if p.Screensaver {
+		return false
+	}
+
+	if p.Shell != nil {
+		cols, rows := TerminalSize()
+		if item, ok := p.Shell.ItemAt(x, y, cols, rows); ok {
+			p.Shell.Jump = item
+			return true
+		}
+		if volume, ok := p.Shell.VolumeAt(x, y, cols, rows); ok {
+			p.execute(fmt.Sprintf("volume %d", volume-p.Volume))
+		}
+		if p.Shell.Contains(x, y, cols, rows) {
+			return false
+		}
+	}
+
+	if !p.TitleLine || y > 1 {
+		p.execute("pause")
+		return false
+	}
+
+	if x <= len([]rune(PAUSE_BUTTON)) {
+		p.execute("pause")
+		return false
+	}
+
+	cols, _ := p.screenSize()
+	if start, width := p.progressBar(cols); width > 0 && x > start && x <= start+width {
+		target := time.Duration((float64(x-start) - 0.5) / float64(width) * float64(p.Source.Info.Duration))
+		p.seek(target - p.shownPosition())
+	}
+	return false
+}
+
+// shownPosition is where the progress bar says playback is: the position,
+// or the SeekPreview's target while there is one.
+func (p *Player) shownPosition() time.Duration {
+	if p.preview != nil {
+		return p.preview.Target
+	}
+	return p.Position()
+}
+
+// progressBar is where the progress bar goes on a title row of cols cells:
+// width cells after the first start, with the time after it. Sources that
+// can't seek have none, nor do rows too narrow for one.
+func (p *Player) progressBar(cols int) (start, width int) {
+	if !p.Source.Seekable || p.Source.Info.Duration <= 0 {
+		return 0, 0
+	}
+
+	width = int(float64(cols) * PROGRESS_WIDTH)
+	start = cols - width - len(fmt.Sprintf(PROGRESS_TIME, FormatDuration(0), FormatDuration(0)))
+	if width < 4 || start < len([]rune(PAUSE_BUTTON)) {
+		return 0, 0
+	}
+
+	return start, width
+}
+
+// writeTitleRow writes the title row, cols wide, when it changed or full is
+// set: the pause button, the title and the progress bar.
+func (p *Player) writeTitleRow(cols int, full bool) {
+	button := PAUSE_BUTTON
+	if p.paused {
+		button = PLAY_BUTTON
+	}
+
+	start, width := p.progressBar(cols)
+	if width == 0 {
+		start = cols
+	}
+
+	title := fitText(" "+p.titleText(), start-len([]rune(button)))
+
+	bar := ""
+	if width > 0 {
+		duration := p.Source.Info.Duration
+		position := min(p.shownPosition(), duration)
+		filled := int(float64(width) * float64(position) / float64(duration))
+		bar = strings.Repeat("━", filled) + strings.Repeat("─", width-filled)
+		bar += fmt.Sprintf(PROGRESS_TIME, FormatDuration(position), FormatDuration(duration))
+	}
+
+	row := button + title + bar
+	if row == p.titleRow && !full {
+		return
+	}
+	p.titleRow = row
+
+	// urls link to the page they were played from
+	if IsUrl(p.Source.Name) {
+		text := strings.TrimRight(title, " ")
+		title = Hyperlink(p.Source.Name, text) + title[len(text):]
+	}
+	fmt.Fprintf(p.buffer, "\u001b[1;1H\u001b[%sm%s%s%s\u001b[0m", CAPTION_STYLE, button, title, bar)
+}
+
+// previewSeek moves the SeekPreview by by, returning false for sources it
+// can't decode a frame of on its own.
+func (p *Player) previewSeek(by time.Duration) bool {
+	if !p.Source.Seekable || !p.Source.Restartable || p.small || p.Screensaver || plainOutput {
+		return false
+	}
+
+	target := p.Position()
+	if p.preview == nil {
+		p.preview = NewSeekPreview()
+	} else {
+		target = p.preview.Target
+	}
+
+	target = max(target+by, 0)
+	text := "⏸ " + FormatDuration(target)
+	if duration := p.Source.Info.Duration; duration > 0 {
+		target = min(target, duration)
+		text = "⏸ " + FormatDuration(target) + " / " + FormatDuration(duration)
+	}
+
+	p.preview.Show(p.Source, target, p.grid)
+	p.showIndicator(text)
+
+	return true
+}
+
+// endPreview seeks to where the SeekPreview is, on resuming.
+func (p *Player) endPreview() {
+	if p.preview == nil {
+		return
+	}
+
+	target := p.preview.Target
+	p.preview.Stop()
+	p.preview = nil
+
+	p.endReplay()
+	if p.playback.Seek(target - p.Position()) {
+		p.restarted()
+	}
+}
+
+func (p *Player) tooSmall() bool {
+	cols, rows := TerminalSize()
+	return cols < p.MinSize.X || rows < p.MinSize.Y
+}
+
+func (p *Player) drawPlaceholder() {
+	cols, rows := TerminalSize()
+
+	message := fmt.Sprintf("resize to at least %dx%d", p.MinSize.X, p.MinSize.Y)
+	if len(message) > cols {
+		message = message[:cols]
+	}
+
+	if plainOutput {
+		fmt.Fprintf(p.out, "%s\r\n", message)
+		return
+	}
+
+	fmt.Fprintf(
+		p.out,
+		"\u001b[H\u001b[2J\u001b[%d;%dH%s",
+		(rows+1)/2, (cols-len(message))/2+1,
+		message,
+	)
+}
+
+func (p *Player) resize() {
+	p.small = p.tooSmall()
+
+	p.drawn = nil
+	p.last = nil
+
+	if p.small {
+		p.drawPlaceholder()
+		return
+	}
+
+	p.grid = p.scaledGrid()
+
+	if p.Pip != nil {
+		p.Pip.Start(p.grid)
+	}
+
+	if p.playback.Resize(p.grid) {
+		p.restarted()
+	} else {
+		p.startScaler()
+	}
+
+	// the pictures kept no longer fit
+	p.endReplay()
+	if p.Replay != nil {
+		p.Replay.Reset()
+	}
+
+	// recordings are resized between the frames of either size
+	var resizers []Resizer
+	for _, w := range p.Tee {
+		if resizer, ok := w.(Resizer); ok {
+			resizers = append(resizers, resizer)
+		}
+	}
+	if p.Recorder != nil {
+		resizers = append(resizers, p.Recorder)
+	}
+	if len(resizers) > 0 {
+		p.writer.Flush()
+		for _, resizer := range resizers {
+			resizer.Resize(TerminalSize())
+		}
+	}
+	p.clearScreen()
+}
+
+// clearScreen clears the screen through the output, after what was written
+// before.
+func (p *Player) clearScreen() {
+	if !plainOutput {
+		p.out.Write([]byte(CLEAR_SCREEN))
+	}
+}
+
+func (p *Player) mark() {
+	if p.Recorder == nil {
+		return
+	}
+
+	position := p.Position()
+	chapters := p.Source.Info.Chapters
+
+	for p.chapter < len(chapters) && chapters[p.chapter].Start <= position {
+		p.Recorder.Marker(ChapterMarker(chapters[p.chapter], position))
+		p.chapter++
+	}
+
+	if position >= p.nextMarker {
+		p.Recorder.Marker(MediaMarker(position))
+
+		p.nextMarker = math.MaxInt64
+		if p.MarkerInterval > 0 {
+			p.nextMarker = position.Truncate(p.MarkerInterval) + p.MarkerInterval
+		}
+	}
+}
+
+func (p *Player) toggleStats() {
+	if plainOutput {
+		return
+	}
+
+	p.overlay.Visible = !p.overlay.Visible
+	if p.overlay.Visible {
+		return
+	}
+
+	// the rows under the overlay are redrawn with the next frame
+	for row := range p.statsLines() {
+		fmt.Fprintf(p.out, "\u001b[%d;1H\u001b[2K", row+1)
+	}
+	p.drawn = nil
+}
+
+// showStatus shows what a quiet source is waiting for over the last frame,
+// or clears it once frames flow again.
+func (p *Player) showStatus(status string) {
+	if status == p.status || p.small || p.Screensaver {
+		return
+	}
+	p.status = status
+
+	if plainOutput {
+		if status != "" {
+			fmt.Fprintf(p.out, "%s\r\n", status)
+		}
+		return
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("\u001b[1;1H\u001b[2K")
+	if status != "" {
+		cols, _ := p.screenSize()
+		WriteOverlay(&buffer, cols, []string{status})
+	}
+	p.out.Write(buffer.Bytes())
+
+	// the row is redrawn with the next frame
+	p.drawn = nil
+}
+
+// screenSize is the cells of the terminal the player draws in, all of them
+// but for the Shell's.
+func (p *Player) screenSize() (int, int) {
+	cols, rows := TerminalSize()
+	if p.Shell != nil {
+		return p.Shell.Area(cols, rows)
+	}
+	return cols, rows
+}
+
+// terminalGrid is the grid of the terminal the picture can have, below the
+// title line when there is one.
+func (p *Player) terminalGrid() image.Point {
+	cols, rows := p.screenSize()
+	if p.TitleLine {
+		rows--
+	}
+	return p.Renderer.Grid(cols, rows)
+}
+
+// titleText is what the title line says about the source.
+func (p *Player) titleText() string {
+	parts := []string{cmp.Or(p.Source.Title, filepath.Base(p.Source.Name))}
+
+	info := p.Source.Info
+	if info.Size.X > 0 && info.Size.Y > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", info.Size.X, info.Size.Y))
+	}
+	if info.Codec != "" {
+		parts = append(parts, info.Codec)
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// updateWindowTitle sets the terminal's window title to the title of the
+// source and the position in it, as it changes.
+func (p *Player) updateWindowTitle() {
+	if plainOutput || p.Screensaver {
+		return
+	}
+
+	position := FormatDuration(p.Position())
+	if duration := p.Source.Info.Duration; duration > 0 {
+		position += "/" + FormatDuration(duration)
+	}
+
+	title := fmt.Sprintf("termtv — %s [%s]", cmp.Or(p.Source.Title, filepath.Base(p.Source.Name)), position)
+	if title != p.windowTitle {
+		p.windowTitle = title
+		p.out.Write([]byte(WindowTitle(title)))
+	}
+}
+
+// showIndicator dims the picture and writes text over it, or brings it back
+// when text is empty, so a paused or buffering player can be told from a
+// hung terminal.
+func (p *Player) showIndicator(text string) {
+	if text == p.indicator || p.Screensaver {
+		return
+	}
+	p.indicator = text
+	// the text is only gone once the picture is drawn whole
+	p.drawn = nil
+
+	if text == "" || p.small {
+		return
+	}
+
+	if plainOutput {
+		fmt.Fprintf(p.out, "%s\r\n", text)
+		return
+	}
+
+	if p.last != nil && p.replay == nil {
+		p.redraw()
+		return
+	}
+
+	// nothing was drawn yet to go under it
+	var buffer bytes.Buffer
+	cols, rows := p.screenSize()
+	WriteIndicator(&buffer, cols, rows-1, text)
+	p.out.Write(buffer.Bytes())
+}
+
+// buffering is whether the source has been quiet for long while frames
+// are wanted from it.
+func (p *Player) buffering() bool {
+	// sources with a Status say what they wait for themselves
+	if p.paused || p.small || p.replay != nil || p.pending != nil || p.retryDue != nil || p.Source.Status != nil {
+		return false
+	}
+
+	return time.Since(p.lastFrame) > max(BUFFERING_AFTER, 3*p.playback.FrameInterval())
+}
+
+// statsLines are the lines of the stats overlay.
+func (p *Player) statsLines() []string {
+	rate := p.overlay.Rate
+	info := p.Source.Info
+
+	// sources drawn at any size, like the test pattern, have none of their own
+	source := "source"
+	if info.Size.X > 0 && info.Size.Y > 0 {
+		source = fmt.Sprintf("source %dx%d", info.Size.X, info.Size.Y)
+	}
+
+	return []string{
+		fmt.Sprintf(
+			"%.1f fps  %d dropped  render %.2fms  %.1fKB/frame",
+			p.overlay.FrameRate, rate.Dropped,
+			float64(rate.AverageRenderTime().Microseconds())/1000, rate.AverageBytes()/1000,
+		),
+		fmt.Sprintf(
+			"%s at %.3g fps  %s at %dx%d",
+			source, info.FrameRate, p.Renderer.Name(), p.grid.X, p.grid.Y,
+		),
+		fmt.Sprintf("A/V offset %+.3fs", p.Pacer.Lag(p.clock(p.Position())).Seconds()),
+	}
+}
+
+// execute runs a bound command, returning true when the player should quit.
+func (p *Player) execute(command string) bool {
+	c, err := ParseCommand(command)
+	if err != nil {
+		return false
+	}
+
+	switch c.Name {
+	case "quit":
+		return true
+	case "pause":
+		p.paused = !p.paused
+		if !p.paused {
+			p.endPreview()
+		}
+		p.Pacer.Reset(p.clock(p.Position()))
+
+		if p.paused {
+			p.showIndicator(PAUSED_TEXT)
+		} else {
+			p.showIndicator("")
+		}
+
+		if p.Audio != nil && p.paused {
+			p.Audio.Stop()
+		} else if p.Audio != nil && (p.replay == nil || !p.Source.Seekable) {
+			p.Audio.Start(p.Position())
+		}
+	case "seek":
+		p.seek(time.Duration(c.Arg * float64(time.Second)))
+	case "audio-delay":
+		if p.Audio == nil {
+			break
+		}
+
+		p.Audio.Delay += time.Duration(c.Arg * float64(time.Second))
+		p.audioDelayed = time.Now()
+		if !p.paused && (p.replay == nil || !p.Source.Seekable) {
+			p.Audio.Start(p.Position())
+		}
+	case "volume":
+		p.Volume = min(max(p.Volume+int(c.Arg), 0), 100)
+		// the slider moves while the picture doesn't
+		if p.Shell != nil && p.paused {
+			p.redraw()
+		}
+		if p.Audio == nil {
+			break
+		}
+
+		// ffplay takes no commands, it's started over at the new volume
+		p.Audio.Volume = p.Volume
+		p.volumeChanged = time.Now()
+		if !p.paused && (p.replay == nil || !p.Source.Seekable) {
+			p.Audio.Start(p.Position())
+		}
+	case "sub-delay":
+		if p.Subtitles == nil {
+			break
+		}
+
+		p.SubtitleDelay += time.Duration(c.Arg * float64(time.Second))
+		p.subtitlesDelayed = time.Now()
+		p.redraw()
+	case "reverse":
+		p.reverse(!p.playback.Reversed())
+	case "stats":
+		p.toggleStats()
+	case "meters":
+		p.meters = !p.meters
+		p.drawn = nil
+	case "histogram":
+		p.histogram = !p.histogram
+		p.drawn = nil
+	case "replay":
+		p.startReplay()
+	case "pip-move", "pip-size", "pip-swap":
+		if p.Pip == nil || p.small {
+			break
+		}
+
+		switch c.Name {
+		case "pip-move":
+			p.Pip.Move()
+		case "pip-size":
+			p.Pip.Resize(c.Arg)
+		case "pip-swap":
+			p.Pip.Swap()
+		}
+		p.drawn = nil
+	}
+
+	return false
+}
+
+// Run plays until the source ends or the user quits. keys may be nil when
+// there is no terminal to read from.
+func (p *Player) Run(keys <-chan string) error {
+	p.grid = p.scaledGrid()
+	p.playback = Playback{Source: p.Source, Buffer: p.Buffer}
+	if p.MaxMemory > 0 {
+		// files are decoded at their own size and scaled after
+		frame := p.grid
+		if !p.Source.Scale {
+			frame = p.Source.Info.Size
+		}
+
+		var replay int64
+		p.playback.Buffer, replay = MemoryShares(p.MaxMemory, int64(frame.X*frame.Y*4), p.Buffer)
+		if p.Replay != nil {
+			p.Replay.MaxBytes = replay
+		}
+	}
+	p.playback.Start(p.grid, 0)
+	p.startScaler()
+	defer func() { p.scaler.Stop() }()
+	p.Pacer.Reset(0)
+
+	var pip chan mosaicUpdate
+	if p.Pip != nil {
+		p.Pip.Start(p.grid)
+		defer p.Pip.Stop()
+		pip = p.Pip.Updates
+	}
+
+	writers := []io.Writer{cmp.Or[io.Writer](p.Output, os.Stdout)}
+	writers = append(writers, p.Tee...)
+	if p.Recorder != nil {
+		writers = append(writers, p.Recorder)
+	}
+	p.writer = NewOutputWriter(NewTeeWriter(writers...))
+	defer p.writer.Close()
+	p.out = p.writer
+
+	// the terminal gets its palette, title and mouse back through the
+	// same writer, for Output and Tee to see them too
+	defer func() {
+		ResetPalette(p.writer)
+		ResetWindowTitle(p.writer)
+		DisableMouse(p.writer)
+	}()
+
+	white := color.NRGBA{255, 255, 255, 255}
+	p.buffer = bytes.NewBuffer(
+		make([]byte, 0, len(StackPixels(white, white))*WIDTH*HEIGHT/2),
+	)
+
+	p.clearScreen()
+	if p.Mouse && !plainOutput {
+		p.out.Write([]byte(EnableMouse()))
+	}
+
+	if p.Audio != nil {
+		p.Audio.Start(0)
+		defer p.Audio.Stop()
+	}
+
+	if p.small = p.tooSmall(); p.small {
+		p.drawPlaceholder()
+	}
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var status <-chan time.Time
+	if p.Source.Status != nil {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		status = ticker.C
+	}
+
+	var stall <-chan time.Time
+	if p.Source.Reconnect {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		stall = ticker.C
+	}
+	p.lastFrame = time.Now()
+
+	buffering := time.NewTicker(250 * time.Millisecond)
+	defer buffering.Stop()
+
+	defer func() {
+		if p.preview != nil {
+			p.preview.Stop()
+		}
+	}()
+
+	for {
+		frames := p.scaler.Frames
+		if p.paused || p.small || p.pending != nil {
+			frames = nil
+		}
+		// live sources go on while replaying, others wait for it
+		if p.replay != nil && p.Source.Seekable {
+			frames = nil
+		}
+		// only time spent waiting for the source counts towards a stall
+		if frames == nil {
+			p.lastFrame = time.Now()
+		}
+		if p.retryDue != nil {
+			frames = nil
+		}
+
+		due := p.due
+		if p.paused || p.small || p.replay != nil {
+			due = nil
+		}
+
+		replayDue := p.replayDue
+		if p.paused || p.small {
+			replayDue = nil
+		}
+
+		var previews chan previewFrame
+		if p.preview != nil {
+			previews = p.preview.Frames
+		}
+
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			p.scale = 0
+			p.resize()
+
+		case <-interrupt:
+			p.playback.Stop()
+			p.Quit = true
+			return nil
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			if p.Screensaver {
+				p.playback.Stop()
+				p.Quit = true
+				return nil
+			}
+
+			if x, y, ok := ParseClick(key); ok && p.Mouse {
+				if p.click(x, y) {
+					p.playback.Stop()
+					return nil
+				}
+				continue
+			}
+
+			if command, bound := p.Bindings[key]; bound && p.execute(command) {
+				p.playback.Stop()
+				p.Quit = true
+				return nil
+			}
+
+		case frame, ok := <-frames:
+			if !ok {
+				err := p.playback.Wait()
+				// back at the start it plays forwards again
+				if err == nil && p.playback.Reversed() {
+					p.reverse(false)
+					continue
+				}
+				if p.dropped(err) && p.reconnect() {
+					continue
+				}
+				return err
+			}
+
+			p.playback.Advance(frame.meta)
+			p.Stats.Decoded++
+			p.retries = 0
+			p.lastFrame = time.Now()
+			if p.replay != nil {
+				continue
+			}
+			p.schedule(&frame)
+
+		case <-due:
+			if p.halfway {
+				p.halfway = false
+				p.due = time.After(p.playback.FrameInterval() / 2)
+				p.renderBlend(p.pending.picture)
+				continue
+			}
+
+			frame := p.pending
+			p.pending = nil
+			p.due = nil
+			p.render(frame)
+
+		case d := <-p.writer.Written:
+			p.Pacer.Wrote(d)
+			p.adapt()
+
+		case <-replayDue:
+			p.replayNext()
+
+		case frame := <-previews:
+			if p.preview.Receive(frame) {
+				p.redraw()
+			}
+
+		case <-p.retryDue:
+			p.retry()
+
+		case <-stall:
+			if p.stalled() && !p.reconnect() {
+				p.playback.Stop()
+				return fmt.Errorf("no frames from %s for %s", p.Source.Name, RECONNECT_STALL)
+			}
+
+		case <-status:
+			p.showStatus(p.Source.Status())
+
+		case <-buffering.C:
+			if p.buffering() {
+				p.showIndicator(BUFFERING_TEXT)
+			}
+
+		case update := <-pip:
+			if p.Pip.Receive(update) {
+				p.drawn = nil
+			}
+			// the inset goes on while the picture under it doesn't move
+			if p.paused || time.Since(p.lastFrame) > PIP_IDLE {
+				p.redraw()
+			}
+		}
+	}
+}
+
+func (p *Player) schedule(frame *scaledFrame) {
+	position := p.Position()
+
+	for i, segment := range p.Skip {
+		if position >= segment.Start && position < segment.End {
+			p.Skip = append(p.Skip[:i], p.Skip[i+1:]...)
+			p.Seek(segment.End - position)
+			return
+		}
+	}
+
+	interval := p.playback.FrameInterval()
+	wait, drop := p.Pacer.Schedule(p.clock(p.Position()), interval)
+
+	// the blend goes halfway to the frame, if there's time before it
+	blend := p.Interpolate && !p.cut && p.last != nil && p.last.Rect == frame.picture.Rect
+
+	switch {
+	case drop:
+		p.Stats.Dropped++
+	case blend && wait > interval/2:
+		p.pending = frame
+		p.halfway = true
+		p.due = time.After(wait - interval/2)
+	case wait > 0:
+		p.pending = frame
+		p.due = time.After(wait)
+	default:
+		p.render(frame)
+	}
+}
+
+// startReplay shows the pictures the ReplayCache kept again, as they were
+// timed, then resumes playback.
+func (p *Player) startReplay() {
+	if p.Replay == nil || p.replay != nil || p.small {
+		return
+	}
+
+	p.replay = p.Replay.Take()
+	if len(p.replay) == 0 {
+		p.replay = nil
+		return
+	}
+
+	// the sound of live sources goes on, like their frames
+	if p.Audio != nil && p.Source.Seekable {
+		p.Audio.Stop()
+	}
+
+	p.replayNext()
+}
+
+func (p *Player) replayNext() {
+	if len(p.replay) == 0 {
+		p.endReplay()
+		return
+	}
+
+	shown := p.replay[0]
+	p.replay = p.replay[1:]
+	p.draw(shown.picture)
+
+	wait := time.Duration(0)
+	if len(p.replay) > 0 {
+		wait = p.replay[0].at.Sub(shown.at)
+	}
+	p.replayDue = time.After(wait)
+}
+
+func (p *Player) endReplay() {
+	if p.replay == nil {
+		return
+	}
+
+	p.replay = nil
+	p.replayDue = nil
+	p.Pacer.Reset(p.clock(p.Position()))
+	p.drawn = nil
+
+	if p.Audio != nil && p.Source.Seekable && !p.paused {
+		p.Audio.Start(p.Position())
+	}
+}
+
+// redraw draws the last picture again with what goes over it, for when no
+// frame of the main source is coming to draw the inset or an indicator with.
+func (p *Player) redraw() {
+	if p.small || p.replay != nil || p.last == nil {
+		return
+	}
+
+	picture := image.NewNRGBA(p.last.Rect)
+	copy(picture.Pix, p.last.Pix)
+	p.decorate(picture)
+
+	p.draw(picture)
+}
+
+// renderBlend draws the blend of the last picture and next, the frame due
+// after it, see Interpolate.
+func (p *Player) renderBlend(next *image.NRGBA) {
+	p.blended = BlendPictures(p.blended, p.last, next)
+	p.decorate(p.blended)
+
+	p.draw(p.blended)
+}
+
+// decorate draws what goes over the picture of the source into it: the
+// Pip inset, subtitles and the histogram.
+func (p *Player) decorate(picture *image.NRGBA) {
+	if p.Pip != nil {
+		p.Pip.Draw(picture)
+	}
+
+	p.drawSubtitles(picture)
+
+	if p.histogram {
+		DrawHistogram(picture)
+	}
+}
+
+// startScaler scales the frames of the current stream to the grid, in
+// place of the scaler of the last one.
+func (p *Player) startScaler() {
+	if p.scaler != nil {
+		p.scaler.Stop()
+	}
+	p.scaler = StartScaler(p.playback.Frames(), p.grid, p.Filters)
+}
+
+// captionLines are the lyrics around the position, the current line
+// highlighted, and the subtitles, above the Source.Caption.
+func (p *Player) captionLines() []Caption {
+	var captions []Caption
+	if p.Screensaver {
+		return captions
+	}
+
+	if p.Lyrics != nil {
+		current := p.Lyrics.At(p.Position())
+		for i := current - 1; i <= current+1; i++ {
+			caption := Caption{Style: LYRIC_STYLE}
+			if i >= 0 && i < len(p.Lyrics.Lines) {
+				caption.Text = p.Lyrics.Lines[i].Text
+			}
+			if i == current {
+				caption.Style = LYRIC_CURRENT_STYLE
+			}
+			captions = append(captions, caption)
+		}
+	}
+
+	if !p.SubtitleStyle.Top {
+		captions = append(captions, p.subtitleCaptions()...)
+	}
+
+	if p.Source.Caption != nil {
+		if caption := p.Source.Caption(); caption != "" {
+			captions = append(captions, Caption{Text: caption, Style: CAPTION_STYLE})
+		}
+	}
+
+	if p.replay != nil {
+		captions = append(captions, Caption{Text: "replay", Style: CAPTION_STYLE})
+	}
+
+	if p.playback.Reversed() {
+		captions = append(captions, Caption{Text: "◀ reverse", Style: CAPTION_STYLE})
+	}
+
+	if p.Audio != nil && time.Since(p.audioDelayed) < DELAY_SHOWN {
+		text := fmt.Sprintf("audio delay %+.2fs", p.Audio.Delay.Seconds())
+		captions = append(captions, Caption{Text: text, Style: CAPTION_STYLE})
+	}
+
+	if p.Audio != nil && time.Since(p.volumeChanged) < DELAY_SHOWN {
+		text := fmt.Sprintf("volume %d%%", p.Audio.Volume)
+		captions = append(captions, Caption{Text: text, Style: CAPTION_STYLE})
+	}
+
+	if p.Subtitles != nil && time.Since(p.subtitlesDelayed) < DELAY_SHOWN {
+		text := fmt.Sprintf("subtitle delay %+.2fs", p.SubtitleDelay.Seconds())
+		captions = append(captions, Caption{Text: text, Style: CAPTION_STYLE})
+	}
+
+	if p.scale > 0 {
+		text := fmt.Sprintf("picture at %.0f%% to keep up with the terminal", ADAPTIVE_SCALES[p.scale]*100)
+		captions = append(captions, Caption{Text: text, Style: CAPTION_STYLE})
+	}
+
+	return captions
+}
+
+// subtitleCaptions are the subtitles at the position, when they're written
+// as text.
+func (p *Player) subtitleCaptions() []Caption {
+	if p.Subtitles == nil || p.Screensaver || p.SubtitleStyle.Dot(CellSize(p.Renderer)) > 0 {
+		return nil
+	}
+
+	var captions []Caption
+	style := p.SubtitleStyle.TextStyle(p.Renderer.Name())
+	for _, line := range p.Subtitles.At(p.Position() - p.SubtitleDelay) {
+		captions = append(captions, Caption{Text: line, Style: style})
+	}
+
+	return captions
+}
+
+// drawSubtitles draws the subtitles at the position into picture, when
+// they're drawn rather than written as text.
+func (p *Player) drawSubtitles(picture *image.NRGBA) {
+	if p.Subtitles == nil || p.Screensaver {
+		return
+	}
+
+	if dot := p.SubtitleStyle.Dot(CellSize(p.Renderer)); dot > 0 {
+		if lines := p.Subtitles.At(p.Position() - p.SubtitleDelay); len(lines) > 0 {
+			DrawSubtitles(picture, lines, dot, p.SubtitleStyle)
+		}
+	}
+}
+
+// scaledGrid is the grid of the terminal at the scale the picture is at.
+func (p *Player) scaledGrid() image.Point {
+	grid := p.terminalGrid()
+	if p.scale > 0 {
+		// half blocks keep their pairs of pixels
+		scale := ADAPTIVE_SCALES[p.scale]
+		grid = image.Pt(max(int(float64(grid.X)*scale), 1), max(int(float64(grid.Y)*scale)&^1, 2))
+	}
+
+	// the bars are left to the terminal or the backdrop
+	if p.Center || p.Backdrop != nil {
+		grid = FitSize(p.Source.Info.Size, grid, CellSize(p.Renderer))
+	}
+
+	return grid
+}
+
+// adapt steps the picture down once writing frames has taken longer than
+// they're apart for ADAPTIVE_WINDOW.
+func (p *Player) adapt() {
+	interval := p.playback.FrameInterval()
+	if !p.Adaptive || p.paused || p.small || interval <= 0 || p.scale+1 >= len(ADAPTIVE_SCALES) {
+		return
+	}
+
+	if p.Pacer.Latency() <= interval {
+		p.slow = time.Time{}
+		return
+	}
+
+	if p.slow.IsZero() {
+		p.slow = time.Now()
+	}
+	if time.Since(p.slow) < ADAPTIVE_WINDOW {
+		return
+	}
+
+	p.slow = time.Time{}
+	p.scale++
+	p.resize()
+}
+
+func (p *Player) render(frame *scaledFrame) {
+	p.showStatus("")
+	p.updateWindowTitle()
+	if p.indicator == BUFFERING_TEXT {
+		p.showIndicator("")
+	}
+
+	picture := frame.picture
+
+	if p.last == nil || p.last.Rect != picture.Rect {
+		p.last = image.NewNRGBA(picture.Rect)
+	}
+	copy(p.last.Pix, picture.Pix)
+	p.cut = false
+
+	p.decorate(picture)
+
+	if p.meters && frame.meta.Levels != nil {
+		DrawMeters(picture, frame.meta.Levels)
+	}
+
+	if p.Replay != nil {
+		p.Replay.Add(picture, time.Now())
+	}
+
+	p.draw(picture)
+}
+
+// draw writes picture to the terminal, with the captions and overlay.
+func (p *Player) draw(picture *image.NRGBA) {
+	if p.Bandwidth != nil {
+		// the picture under an indicator is drawn once, it has to show
+		if p.indicator == "" && !p.Bandwidth.Allow() {
+			p.Stats.Dropped++
+			return
+		}
+
+		picture = p.Bandwidth.Quantize(picture)
+	}
+
+	if p.indicator != "" {
+		p.dimmed = DimPicture(p.dimmed, picture)
+		picture = p.dimmed
+
+		// over the dimmed picture, to be seen at its own brightness
+		if p.preview != nil {
+			p.preview.Draw(picture)
+		}
+	}
+
+	grid := p.terminalGrid()
+	picture = p.Viewport.Clip(picture, grid)
+	if p.Center {
+		picture = CenterPicture(picture, grid, CellSize(p.Renderer))
+	}
+	if p.Backdrop != nil {
+		picture = p.Backdrop.Draw(picture, grid)
+	}
+	if p.TitleLine {
+		picture = MovePicture(picture, image.Pt(0, CellSize(p.Renderer).Y))
+	}
+	// the title is written again whenever the screen is, and the Shell's
+	// rows too when captions were cleared across them
+	full := p.drawn == nil
+	cleared := full
+
+	captions := p.captionLines()
+	if !slices.Equal(captions, p.captions) {
+		// the old captions are cleared and the picture under them redrawn
+		_, rows := p.screenSize()
+		for row := rows - max(len(captions), len(p.captions)) + 1; row <= rows; row++ {
+			fmt.Fprintf(p.buffer, "\u001b[%d;1H\u001b[2K", row)
+		}
+		p.captions = captions
+		p.drawn = nil
+		cleared = true
+	}
+
+	// subtitles at the top go under the title
+	top := 1
+	if p.TitleLine {
+		top = 2
+	}
+	var subtitles []Caption
+	if p.SubtitleStyle.Top {
+		subtitles = p.subtitleCaptions()
+	}
+	if !slices.Equal(subtitles, p.subtitles) {
+		for row := top; row < top+max(len(subtitles), len(p.subtitles)); row++ {
+			fmt.Fprintf(p.buffer, "\u001b[%d;1H\u001b[2K", row)
+		}
+		p.subtitles = subtitles
+		p.drawn = nil
+		cleared = true
+	}
+
+	start := time.Now()
+
+	if diff, ok := p.Renderer.(DiffRenderer); ok {
+		if p.drawn != nil && p.drawn.Rect == picture.Rect {
+			diff.RenderDiff(p.buffer, p.drawn, picture)
+		} else {
+			p.Renderer.Render(p.buffer, picture)
+			p.drawn = image.NewNRGBA(picture.Rect)
+		}
+		copy(p.drawn.Pix, picture.Pix)
+	} else {
+		p.Renderer.Render(p.buffer, picture)
+	}
+
+	p.Stats.RenderTime += time.Since(start)
+	p.Stats.Bytes += int64(p.buffer.Len())
+
+	if p.Bandwidth != nil {
+		p.Bandwidth.Spend(p.buffer.Len(), p.playback.FrameInterval())
+	}
+
+	if p.Overlay != nil && !plainOutput {
+		cols, rows := p.screenSize()
+		// at the bottom it goes over the picture, above any captions
+		p.Overlay.Write(p.buffer, cols, rows-max(len(captions), 1))
+	}
+
+	p.overlay.Update(p.Stats)
+	if p.overlay.Visible {
+		cols, _ := p.screenSize()
+		WriteOverlay(p.buffer, cols, p.statsLines())
+	}
+	if len(captions) > 0 {
+		cols, rows := p.screenSize()
+		WriteCaptions(p.buffer, cols, rows, captions)
+	}
+	if len(subtitles) > 0 {
+		cols, _ := p.screenSize()
+		WriteCaptionsAt(p.buffer, cols, top, subtitles)
+	}
+	if p.Pip != nil && !p.Screensaver {
+		cols, rows := p.screenSize()
+		p.Pip.WriteLabel(p.buffer, cols, rows-1)
+	}
+	if p.indicator != "" {
+		cols, rows := p.screenSize()
+		WriteIndicator(p.buffer, cols, rows-1, p.indicator)
+	}
+	if p.TitleLine {
+		cols, _ := p.screenSize()
+		p.writeTitleRow(cols, full)
+	}
+	if p.Shell != nil {
+		cols, rows := TerminalSize()
+		p.Shell.Write(p.buffer, cols, rows, p.Volume, cleared)
+	}
+
+	p.writer.WriteFrame(p.buffer.Bytes())
+	p.buffer.Reset()
+
+	p.Stats.Rendered++
+	p.mark()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// StartPprof serves the net/http/pprof handlers on addr in the background,
+// to profile playback with `go tool pprof http://addr/debug/pprof/profile`.
+// Profiles can't be asked a token for, so addr has to be a loopback one.
+func StartPprof(addr string) error {
+	if !IsLoopback(addr) {
+		return fmt.Errorf("%s isn't a loopback address like localhost:6060, and profiles are served to anyone who asks", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go http.Serve(listener, nil)
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Presets are the looks --preset takes, posterize with its number of levels
+// per channel, like posterize-4.
+var Presets = []string{"sepia", "invert", "posterize-N", "vhs", "matrix-green", "crt-scanlines"}
+
+// PixelFilter is a FrameFilter without state.
+type PixelFilter func(picture *image.NRGBA)
+
+func (f PixelFilter) Filter(picture *image.NRGBA) { f(picture) }
+func (PixelFilter) Reset()                        {}
+
+// ParsePresets parses a comma separated list of presets, applied in order.
+func ParsePresets(value string) ([]FrameFilter, error) {
+	var filters []FrameFilter
+
+	for _, name := range strings.Split(value, ",") {
+		filter, err := ParsePreset(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+func ParsePreset(name string) (FrameFilter, error) {
+	switch name {
+	case "sepia":
+		return PixelFilter(sepia), nil
+	case "invert":
+		return PixelFilter(invert), nil
+	case "vhs":
+		return &vhsFilter{}, nil
+	case "matrix-green":
+		return PixelFilter(matrixGreen), nil
+	case "crt-scanlines":
+		return PixelFilter(scanlines), nil
+	}
+
+	if levels, found := strings.CutPrefix(name, "posterize-"); found {
+		n, err := strconv.Atoi(levels)
+		if err != nil || n < 2 || n > 128 {
+			return nil, fmt.Errorf("invalid preset %s, posterize takes 2 to 128 levels", name)
+		}
+		return posterize(n), nil
+	}
+
+	return nil, fmt.Errorf("unknown preset %s, expected one of %s", name, strings.Join(Presets, ", "))
+}
+
+func clampByte(v int) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+func luma(pix []uint8) int {
+	return (int(pix[0])*77 + int(pix[1])*150 + int(pix[2])*29) >> 8
+}
+
+func sepia(picture *image.NRGBA) {
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		r, g, b := int(picture.Pix[i]), int(picture.Pix[i+1]), int(picture.Pix[i+2])
+		picture.Pix[i] = clampByte((r*101 + g*197 + b*48) >> 8)
+		picture.Pix[i+1] = clampByte((r*89 + g*176 + b*43) >> 8)
+		picture.Pix[i+2] = clampByte((r*70 + g*137 + b*34) >> 8)
+	}
+}
+
+func invert(picture *image.NRGBA) {
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		picture.Pix[i] = 255 - picture.Pix[i]
+		picture.Pix[i+1] = 255 - picture.Pix[i+1]
+		picture.Pix[i+2] = 255 - picture.Pix[i+2]
+	}
+}
+
+// posterize cuts each channel down to levels evenly spread levels.
+func posterize(levels int) PixelFilter {
+	var table [256]uint8
+	for v := range table {
+		level := v * levels / 256
+		table[v] = uint8(level * 255 / (levels - 1))
+	}
+
+	return func(picture *image.NRGBA) {
+		for i := 0; i+3 < len(picture.Pix); i += 4 {
+			picture.Pix[i] = table[picture.Pix[i]]
+			picture.Pix[i+1] = table[picture.Pix[i+1]]
+			picture.Pix[i+2] = table[picture.Pix[i+2]]
+		}
+	}
+}
+
+// matrixGreen shows the brightness in shades of phosphor green.
+func matrixGreen(picture *image.NRGBA) {
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		l := luma(picture.Pix[i : i+3])
+		picture.Pix[i] = uint8(l / 8)
+		picture.Pix[i+1] = uint8(l)
+		picture.Pix[i+2] = uint8(l / 4)
+	}
+}
+
+// scanlines darkens every other row, the bottom halves of the cells, like
+// the lines of a CRT.
+func scanlines(picture *image.NRGBA) {
+	bounds := picture.Rect
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y; y += 2 {
+		row := picture.Pix[picture.PixOffset(bounds.Min.X, y):picture.PixOffset(bounds.Max.X, y)]
+		for i := 0; i+3 < len(row); i += 4 {
+			row[i], row[i+1], row[i+2] = row[i]/2, row[i+1]/2, row[i+2]/2
+		}
+	}
+}
+
+// vhsFilter looks like a worn tape: washed out colors, red bleeding to the
+// right, noise, and now and then a band of tracking trouble rolling down.
+type vhsFilter struct {
+	frame int
+	rng   *rand.Rand
+}
+
+func (f *vhsFilter) Reset() {
+	f.frame = 0
+	f.rng = rand.New(rand.NewSource(1))
+}
+
+func (f *vhsFilter) Filter(picture *image.NRGBA) {
+	bounds := picture.Rect
+	height := bounds.Dy()
+	f.frame++
+
+	// the band rolls down over 2 seconds at 25 fps, every 8 seconds
+	band := -1
+	if phase := f.frame % 200; phase < 50 && height > 0 {
+		band = bounds.Min.Y + phase*height/50
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := picture.Pix[picture.PixOffset(bounds.Min.X, y):picture.PixOffset(bounds.Max.X, y)]
+
+		shift := 0
+		if band >= 0 && y >= band && y < band+max(height/12, 1) {
+			shift = 2 + f.rng.Intn(3)
+		}
+
+		// from the right, so the red bled is the one from before
+		for i := len(row) - 4; i >= 0; i -= 4 {
+			red := row[i]
+			if i >= 8 {
+				red = uint8((int(row[i-4]) + int(row[i-8])) / 2)
+			}
+			if j := i - shift*4; shift > 0 && j >= 0 {
+				row[i+1], row[i+2] = row[j+1], row[j+2]
+			}
+
+			noise := f.rng.Intn(25) - 12
+			l := luma(row[i : i+3])
+			// halfway to gray, a little warm
+			row[i] = clampByte((int(red)+l)/2 + noise + 8)
+			row[i+1] = clampByte((int(row[i+1])+l)/2 + noise)
+			row[i+2] = clampByte((int(row[i+2])+l)/2 + noise - 6)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+const (
+	// PREVIEW_SIZE is the width and height of the seek preview, as parts
+	// of the picture's.
+	PREVIEW_SIZE = 0.4
+	// PREVIEW_MARGIN is the space above the preview, as a part of the
+	// picture's height, which keeps it clear of the indicator under it.
+	PREVIEW_MARGIN = 0.05
+)
+
+// previewFrame is a frame decoded for a SeekPreview, fitted to its inset.
+type previewFrame struct {
+	picture *image.NRGBA
+	target  time.Duration
+}
+
+// SeekPreview shows where seeking while paused would land: the seeks add up
+// to Target, and the frame there is decoded on its own and shown in an inset
+// over the top middle of the picture until playback resumes from it.
+type SeekPreview struct {
+	Target time.Duration
+	// Frames gets the frame at each Target once it's decoded, see Receive.
+	Frames chan previewFrame
+
+	picture *image.NRGBA
+	cancel  context.CancelFunc
+}
+
+func NewSeekPreview() *SeekPreview {
+	return &SeekPreview{Frames: make(chan previewFrame, 1)}
+}
+
+// insetRect is where the preview goes in a picture of grid pixels.
+func (s *SeekPreview) insetRect(grid image.Point) image.Rectangle {
+	size := image.Pt(int(float64(grid.X)*PREVIEW_SIZE), int(float64(grid.Y)*PREVIEW_SIZE))
+	min := image.Pt((grid.X-size.X)/2, int(float64(grid.Y)*PREVIEW_MARGIN))
+
+	return image.Rectangle{min, min.Add(size)}
+}
+
+// Show decodes the frame of source at target for a picture of grid pixels,
+// in place of the one being decoded before.
+func (s *SeekPreview) Show(source *Source, target time.Duration, grid image.Point) {
+	s.Stop()
+	s.Target = target
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	size := s.insetRect(grid).Size()
+	decode := size
+	if !source.Scale {
+		decode = source.Info.Size
+	}
+
+	go func() {
+		decoding, stop := context.WithCancel(ctx)
+		frames := make(chan Frame, 1)
+		go func() {
+			source.Runner(decoding, decode, target, frames)
+			close(frames)
+		}()
+		// only the first frame is wanted, the rest go with the runner
+		defer func() {
+			stop()
+			for range frames {
+			}
+		}()
+
+		frame, ok := <-frames
+		if !ok || size.X <= 0 || size.Y <= 0 {
+			return
+		}
+
+		picture := image.NewNRGBA(image.Rectangle{Max: size})
+		if Fit(frame.Picture, picture) == frame.Picture {
+			copy(picture.Pix, frame.Picture.Pix)
+		}
+
+		// a frame not yet received is replaced, and one for a target
+		// since changed isn't sent at all
+		select {
+		case <-s.Frames:
+		default:
+		}
+		select {
+		case s.Frames <- previewFrame{picture, target}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Receive takes a frame from Frames, returning true when it's the one at
+// Target and has to be drawn.
+func (s *SeekPreview) Receive(frame previewFrame) bool {
+	if frame.target != s.Target {
+		return false
+	}
+
+	s.picture = frame.picture
+	return true
+}
+
+// Draw puts the preview over picture, in place, or its border while the
+// frame is being decoded.
+func (s *SeekPreview) Draw(picture *image.NRGBA) {
+	inset := s.insetRect(picture.Rect.Size()).Add(picture.Rect.Min)
+	if inset.Empty() {
+		return
+	}
+
+	if s.picture != nil && s.picture.Rect.Size() == inset.Size() {
+		copyInto(picture, s.picture, inset.Min)
+	}
+	drawBorder(picture, inset)
+}
+
+// Stop stops decoding.
+func (s *SeekPreview) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PROCESS_GRACE is how long a child has to exit after being asked to before
+// it's killed.
+const PROCESS_GRACE = 2 * time.Second
+
+// children are the process groups of the children running, which
+// KillChildren takes down, with the timers killing those asked to exit.
+var children struct {
+	sync.Mutex
+	groups map[int]*time.Timer
+}
+
+// ChildCommand is exec.CommandContext for the ffmpeg, ffprobe, ffplay and yt-dlp
+// children of termtv. Each runs in its own process group, so that whatever it
+// runs in turn goes with it, and when ctx is done the group is asked to exit
+// and killed PROCESS_GRACE later. Start it with StartChild or ChildOutput,
+// and wait for it with WaitChild.
+func ChildCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+
+	cmd.Cancel = func() error {
+		terminateGroup(cmd.Process, PROCESS_GRACE)
+		return nil
+	}
+	// pipes a grandchild holds open don't keep Wait from returning
+	cmd.WaitDelay = PROCESS_GRACE + time.Second
+
+	return cmd
+}
+
+// StartChild starts cmd, keeping its group for KillChildren.
+func StartChild(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	children.Lock()
+	defer children.Unlock()
+
+	if children.groups == nil {
+		children.groups = map[int]*time.Timer{}
+	}
+	children.groups[cmd.Process.Pid] = nil
+
+	return nil
+}
+
+// WaitChild is cmd.Wait for a cmd started by StartChild. Once it's done the
+// child's group is forgotten and no longer killed, as its pid may be reused.
+func WaitChild(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	children.Lock()
+	defer children.Unlock()
+
+	if timer := children.groups[cmd.Process.Pid]; timer != nil {
+		timer.Stop()
+	}
+	delete(children.groups, cmd.Process.Pid)
+
+	return err
+}
+
+// killGroupLater kills group after grace, unless it's waited for first.
+func killGroupLater(group int, grace time.Duration) {
+	children.Lock()
+	defer children.Unlock()
+
+	if _, running := children.groups[group]; running {
+		children.groups[group] = time.AfterFunc(grace, func() { killGroup(group) })
+	}
+}
+
+// ChildOutput is cmd.Output for a cmd made by ChildCommand. Its log goes to
+// a ChildLog, whose errors say why it failed.
+func ChildOutput(cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	log := NewChildLog(filepath.Base(cmd.Path))
+	cmd.Stderr = log
+
+	if err := StartChild(cmd); err != nil {
+		return nil, err
+	}
+
+	if err := WaitChild(cmd); err != nil {
+		return stdout.Bytes(), log.Wrap(err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Fatalf is log.Fatalf for once children may be running: it kills them
+// first, as exiting doesn't.
+func Fatalf(format string, args ...any) {
+	KillChildren()
+	log.Fatalf(format, args...)
+}
+
+// KillChildren kills whatever children are left, before termtv exits.
+func KillChildren() {
+	children.Lock()
+	defer children.Unlock()
+
+	for group, timer := range children.groups {
+		if timer != nil {
+			timer.Stop()
+		}
+		killGroup(group)
+	}
+	children.groups = nil
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateGroup sends SIGTERM to the group of process, and SIGKILL after
+// grace for anything still in it.
+func terminateGroup(process *os.Process, grace time.Duration) {
+	group := process.Pid
+
+	syscall.Kill(-group, syscall.SIGTERM)
+	killGroupLater(group, grace)
+}
+
+func killGroup(group int) {
+	syscall.Kill(-group, syscall.SIGKILL)
+}
+
+// KillChildrenOnHangup takes the children down when the terminal goes away,
+// which would otherwise leave them running, as they're in groups of their
+// own that don't get the terminal's SIGHUP.
+func KillChildrenOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		<-hangup
+		KillChildren()
+		os.Exit(1)
+	}()
+}
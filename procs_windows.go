@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Windows has no process groups to signal, children are killed on their own.
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateGroup(process *os.Process, grace time.Duration) {
+	process.Kill()
+}
+
+func killGroup(group int) {
+	if process, err := os.FindProcess(group); err == nil {
+		process.Kill()
+	}
+}
+
+func KillChildrenOnHangup() {}
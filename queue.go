@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QUEUE_TIMEOUT is how long termtv add waits for the playing termtv.
+const QUEUE_TIMEOUT = 2 * time.Second
+
+// ErrNoQueue is returned by Enqueue when no termtv is playing.
+var ErrNoQueue = errors.New("no termtv is playing")
+
+// QueueSocketPath is the socket a playing termtv takes items to add to its
+// playlist on, in a directory of each user's own.
+func QueueSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	name := "termtv"
+	if uid := os.Getuid(); uid >= 0 {
+		name = fmt.Sprintf("termtv-%d", uid)
+	}
+
+	return filepath.Join(dir, name, "queue.sock")
+}
+
+// makeQueueDir makes the directory of the socket at path, which only the user
+// gets into. One left by someone else can't be made the user's and fails.
+func makeQueueDir(path string) error {
+	dir := filepath.Dir(path)
+
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return os.Chmod(dir, 0o700)
+}
+
+// QueueServer takes items to play next from termtv add, over a unix socket
+// that takes a line "add <item>" for each and answers it with "ok" or
+// "error <reason>".
+type QueueServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	items []string
+}
+
+// ListenQueue listens on QueueSocketPath, returning nil when another termtv
+// already does. A socket left behind by one that's gone is taken over.
+func ListenQueue() (*QueueServer, error) {
+	path := QueueSocketPath()
+
+	if conn, err := net.DialTimeout("unix", path, QUEUE_TIMEOUT); err == nil {
+		conn.Close()
+		return nil, nil
+	}
+	if err := makeQueueDir(path); err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// only the user gets to queue
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &QueueServer{listener: listener}
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *QueueServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *QueueServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command, item, _ := strings.Cut(scanner.Text(), " ")
+		if command != "add" || item == "" {
+			fmt.Fprintf(conn, "error unknown command %q\n", command)
+			continue
+		}
+
+		s.mu.Lock()
+		s.items = append(s.items, item)
+		s.mu.Unlock()
+
+		fmt.Fprintln(conn, "ok")
+	}
+}
+
+// Take returns the items added since the last call.
+func (s *QueueServer) Take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.items
+	s.items = nil
+	return items
+}
+
+// Close stops listening and removes the socket.
+func (s *QueueServer) Close() {
+	s.listener.Close()
+}
+
+// Enqueue adds items to the playlist of the termtv that's playing, returning
+// ErrNoQueue when there's none. Paths are made absolute, the playing termtv
+// may be in another directory.
+func Enqueue(items []string) error {
+	for i, item := range items {
+		if item == "-" {
+			return fmt.Errorf("stdin can't be queued")
+		}
+
+		if !IsUrl(item) {
+			path, err := filepath.Abs(item)
+			if err != nil {
+				return err
+			}
+			items[i] = path
+		}
+	}
+
+	conn, err := net.DialTimeout("unix", QueueSocketPath(), QUEUE_TIMEOUT)
+	if err != nil {
+		return ErrNoQueue
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(QUEUE_TIMEOUT))
+	replies := bufio.NewScanner(conn)
+
+	for _, item := range items {
+		fmt.Fprintf(conn, "add %s\n", item)
+
+		if !replies.Scan() {
+			return fmt.Errorf("no answer from the playing termtv")
+		}
+		if reply := replies.Text(); reply != "ok" {
+			return errors.New(strings.TrimPrefix(reply, "error "))
+		}
+	}
+
+	return nil
+}
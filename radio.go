@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Icecast and Shoutcast stations are played as radio: the sound with ffplay,
+// the visualizer from a stream read in Go, which also carries the ICY
+// metadata that says what's playing.
+
+// RADIO_PROBE_TIMEOUT bounds the request that checks a url for a station.
+const RADIO_PROBE_TIMEOUT = 5 * time.Second
+
+// Radio is a station being played, with the title of its current track.
+type Radio struct {
+	URL string
+
+	mu    sync.Mutex
+	track string
+}
+
+// OpenRadio opens url if it serves a live audio stream, returning false
+// otherwise. Files served over http have a length and are left to the
+// extractors, which make them seekable.
+func OpenRadio(url string) (*Source, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), RADIO_PROBE_TIMEOUT)
+	defer cancel()
+
+	response, err := getRadio(ctx, url)
+	if err != nil {
+		return nil, false
+	}
+	response.Body.Close()
+
+	radio := &Radio{URL: url}
+
+	title := response.Header.Get("Icy-Name")
+	if title == "" {
+		title = url
+	}
+
+	source := OpenVisualizer(url, title, url, ProbeInfo{Audio: true})
+	source.Runner = radio.Run
+	source.Caption = radio.Caption
+	// a restart reconnects to the live stream
+	source.Seekable = false
+	source.Reconnect = true
+
+	return source, true
+}
+
+// getRadio requests url with ICY metadata, failing unless it answers with a
+// live audio stream.
+func getRadio(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Icy-MetaData", "1")
+
+	response, err := Network.Client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	audio := strings.HasPrefix(mediaType, "audio/") || mediaType == "application/ogg"
+	icy := response.Header.Get("Icy-Metaint") != "" || response.Header.Get("Icy-Name") != ""
+
+	if response.StatusCode != http.StatusOK || !audio || (!icy && response.ContentLength >= 0) {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s: not a radio stream", url)
+	}
+
+	return response, nil
+}
+
+// Caption is the title of the track playing, or "" before the station
+// sends one.
+func (r *Radio) Caption() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.track
+}
+
+// Run connects to the station and visualizes its stream, taking the track
+// titles out of it on the way to ffmpeg.
+func (r *Radio) Run(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	response, err := getRadio(ctx, r.URL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var stream io.Reader = response.Body
+	if metaint, err := strconv.Atoi(response.Header.Get("Icy-Metaint")); err == nil && metaint > 0 {
+		stream = &icyReader{radio: r, stream: bufio.NewReader(response.Body), metaint: metaint, left: metaint}
+	}
+
+	return VisualizerFrameRunner(ctx, "pipe:0", stream, size, 0, framesChannel)
+}
+
+// icyReader reads the audio of a stream with ICY metadata, which comes in a
+// block after every metaint bytes of audio: a length byte counting 16 byte
+// units, then text like StreamTitle='Artist - Title';
+type icyReader struct {
+	radio   *Radio
+	stream  *bufio.Reader
+	metaint int
+	// left is the audio before the next block
+	left int
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		if err := r.metadata(); err != nil {
+			return 0, err
+		}
+		r.left = r.metaint
+	}
+
+	n, err := r.stream.Read(p[:min(len(p), r.left)])
+	r.left -= n
+	return n, err
+}
+
+func (r *icyReader) metadata() error {
+	length, err := r.stream.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	block := make([]byte, int(length)*16)
+	if _, err := io.ReadFull(r.stream, block); err != nil {
+		return err
+	}
+
+	if track, ok := StreamTitle(string(block)); ok {
+		r.radio.mu.Lock()
+		r.radio.track = track
+		r.radio.mu.Unlock()
+	}
+
+	return nil
+}
+
+// StreamTitle finds the track title in an ICY metadata block. Titles can
+// hold quotes, so the value runs to the last "';" before the next field.
+func StreamTitle(metadata string) (string, bool) {
+	metadata = strings.TrimRight(metadata, "\x00")
+
+	_, value, found := strings.Cut(metadata, "StreamTitle='")
+	if !found {
+		return "", false
+	}
+
+	if end := strings.Index(value, "';StreamUrl='"); end >= 0 {
+		value = value[:end]
+	} else if end := strings.LastIndex(value, "';"); end >= 0 {
+		value = value[:end]
+	}
+
+	return strings.TrimSpace(value), true
+}
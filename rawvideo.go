@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frames piped into stdin by other programs, encoders, simulators or game
+// engines, are read in Go when they are y4m, which is recognized by its
+// header, or raw pixels described by --size and --pix-fmt. Anything else
+// goes to ffmpeg.
+
+// RawVideo describes the raw frames on stdin.
+type RawVideo struct {
+	Size   image.Point
+	PixFmt string
+}
+
+// RawInput is set by --size and --pix-fmt, stdin is read as ffmpeg would
+// read it otherwise.
+var RawInput RawVideo
+
+// InputRate is the frame rate set by --fps for inputs that don't have one,
+// image sequences and raw frames. 0 leaves raw frames to be shown as they
+// come, and image sequences at SEQUENCE_RATE.
+var InputRate float64
+
+// PixFmts are the raw pixel formats, named as in ffmpeg, by their bytes per
+// pixel.
+var PixFmts = map[string]int{
+	"rgb24": 3, "bgr24": 3, "rgba": 4, "bgra": 4, "rgb0": 4, "bgr0": 4, "gray": 1,
+}
+
+// RegisterInputFlags adds the flags describing inputs that don't describe
+// themselves.
+func RegisterInputFlags(flags *flag.FlagSet) {
+	flags.Func("size", "size of raw frames on stdin as WIDTHxHEIGHT", func(value string) (err error) {
+		RawInput.Size, err = ParseSize(value)
+		return err
+	})
+	flags.Func("pix-fmt", "pixel format of raw frames on stdin: rgb24, bgr24, rgba, bgra, rgb0, bgr0 or gray (default rgb24)", func(value string) error {
+		if _, ok := PixFmts[value]; !ok {
+			return fmt.Errorf("unknown pixel format %s", value)
+		}
+		RawInput.PixFmt = value
+		return nil
+	})
+	flags.Func("fps", "frame rate of image sequences (default 25) and raw frames on stdin (default as they come)", func(value string) (err error) {
+		InputRate, err = strconv.ParseFloat(value, 64)
+		if err == nil && InputRate <= 0 {
+			err = fmt.Errorf("invalid frame rate %s", value)
+		}
+		return err
+	})
+}
+
+// OpenRawVideo reads frames of format from r, at InputRate or otherwise as fast
+// as they are written.
+func OpenRawVideo(r io.Reader, format RawVideo) (*Source, error) {
+	if format.Size.X <= 0 || format.Size.Y <= 0 {
+		return nil, errors.New("raw video on stdin needs --size")
+	}
+	if format.PixFmt == "" {
+		format.PixFmt = "rgb24"
+	}
+
+	depth := PixFmts[format.PixFmt]
+	data := make([]byte, format.Size.X*format.Size.Y*depth)
+
+	frames := func(frame *image.NRGBA) error {
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		ConvertPixels(frame, data, format.PixFmt)
+		return nil
+	}
+
+	return &Source{
+		Name:   "-",
+		Title:  fmt.Sprintf("stdin (%s)", format.PixFmt),
+		Info:   ProbeInfo{Size: format.Size, FrameRate: InputRate},
+		Runner: pipeRunner(format.Size, InputRate, frames),
+	}, nil
+}
+
+// ConvertPixels converts data of pixFmt to the NRGBA pixels of frame.
+func ConvertPixels(frame *image.NRGBA, data []byte, pixFmt string) {
+	pix := frame.Pix
+	depth := PixFmts[pixFmt]
+
+	for i, j := 0, 0; j+depth <= len(data) && i+4 <= len(pix); i, j = i+4, j+depth {
+		switch pixFmt {
+		case "rgb24", "rgb0":
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = data[j], data[j+1], data[j+2], 255
+		case "bgr24", "bgr0":
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = data[j+2], data[j+1], data[j], 255
+		case "rgba":
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = data[j], data[j+1], data[j+2], data[j+3]
+		case "bgra":
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = data[j+2], data[j+1], data[j], data[j+3]
+		case "gray":
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = data[j], data[j], data[j], 255
+		}
+	}
+}
+
+// IsY4m reports whether r starts with a yuv4mpegpipe header.
+func IsY4m(r *bufio.Reader) bool {
+	magic, err := r.Peek(len("YUV4MPEG2 "))
+	return err == nil && string(magic) == "YUV4MPEG2 "
+}
+
+// Y4M_MAX_SIZE is the largest width or height a y4m header may give, well
+// over 8K, so that a malformed one can't have frames of any size allocated.
+const Y4M_MAX_SIZE = 16384
+
+// Y4mHeader is what the stream header of a y4m stream says about its frames.
+type Y4mHeader struct {
+	Size      image.Point
+	FrameRate float64
+	// Colorspace is the chroma subsampling, 420, 422, 444 or mono
+	Colorspace string
+	// Full is set for full range YUV, it is limited to 16-235 by default
+	Full bool
+	// Matrix is BT.709 for HD, the y4m header can't say
+	Matrix YuvMatrix
+}
+
+func ReadY4mHeader(r *bufio.Reader) (Y4mHeader, error) {
+	header := Y4mHeader{Colorspace: "420"}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return header, fmt.Errorf("y4m header: %w", err)
+	}
+
+	for _, field := range strings.Fields(line)[1:] {
+		value := field[1:]
+
+		switch field[0] {
+		case 'W':
+			header.Size.X, _ = strconv.Atoi(value)
+		case 'H':
+			header.Size.Y, _ = strconv.Atoi(value)
+		case 'F':
+			numerator, denominator, _ := strings.Cut(value, ":")
+			n, _ := strconv.Atoi(numerator)
+			d, _ := strconv.Atoi(denominator)
+			if n > 0 && d > 0 {
+				header.FrameRate = float64(n) / float64(d)
+			}
+		case 'C':
+			header.Colorspace = value
+		case 'X':
+			header.Full = header.Full || value == "COLORRANGE=FULL"
+		}
+	}
+
+	switch header.Colorspace {
+	case "420jpeg", "420paldv", "420mpeg2":
+		header.Colorspace = "420"
+	case "420", "422", "444", "mono":
+	default:
+		return header, fmt.Errorf("unsupported y4m colorspace %s, only 8 bit 420, 422, 444 and mono are", header.Colorspace)
+	}
+
+	if header.Size.X <= 0 || header.Size.Y <= 0 {
+		return header, errors.New("y4m header without a size")
+	}
+	if header.Size.X > Y4M_MAX_SIZE || header.Size.Y > Y4M_MAX_SIZE {
+		return header, fmt.Errorf("y4m size %dx%d is too large", header.Size.X, header.Size.Y)
+	}
+	header.Matrix = MatrixFor(header.Size.Y)
+
+	return header, nil
+}
+
+// OpenY4m reads a y4m stream from r, whose header has only been peeked at.
+func OpenY4m(r *bufio.Reader) (*Source, error) {
+	header, err := ReadY4mHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	size := header.Size
+	chroma := image.Point{}
+	switch header.Colorspace {
+	case "420":
+		chroma = image.Pt((size.X+1)/2, (size.Y+1)/2)
+	case "422":
+		chroma = image.Pt((size.X+1)/2, size.Y)
+	case "444":
+		chroma = size
+	}
+
+	planes := make([]byte, size.X*size.Y+2*chroma.X*chroma.Y)
+	y, cb, cr := planes[:size.X*size.Y], planes[size.X*size.Y:][:chroma.X*chroma.Y], planes[size.X*size.Y+chroma.X*chroma.Y:]
+
+	frames := func(frame *image.NRGBA) error {
+		// each frame has a header of its own, its parameters are ignored
+		if _, err := r.ReadString('\n'); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, planes); err != nil {
+			return err
+		}
+
+		for row := 0; row < size.Y; row++ {
+			for x := 0; x < size.X; x++ {
+				i := frame.PixOffset(x, row)
+				luma := y[row*size.X+x]
+
+				u, v := uint8(128), uint8(128)
+				if chroma.X > 0 {
+					c := (row*chroma.Y/size.Y)*chroma.X + x*chroma.X/size.X
+					u, v = cb[c], cr[c]
+				}
+
+				red, green, blue := yuvToRgb(luma, u, v, header.Full, header.Matrix)
+				frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3] = red, green, blue, 255
+			}
+		}
+
+		return nil
+	}
+
+	return &Source{
+		Name:   "-",
+		Title:  "stdin (y4m)",
+		Info:   ProbeInfo{Size: size, FrameRate: header.FrameRate},
+		Runner: pipeRunner(size, header.FrameRate, frames),
+	}, nil
+}
+
+// yuvToRgb converts with matrix, in 16.16 fixed point.
+func yuvToRgb(y, cb, cr uint8, full bool, matrix YuvMatrix) (uint8, uint8, uint8) {
+	l := int32(y) << 16
+	if !full {
+		// 255/219
+		l = (int32(y) - 16) * 76309
+	}
+
+	u, v := int32(cb)-128, int32(cr)-128
+	if !full {
+		// 255/224
+		u, v = u*74711>>16, v*74711>>16
+	}
+
+	clamp := func(x int32) uint8 {
+		x = (x + 1<<15) >> 16
+		return uint8(min(max(x, 0), 255))
+	}
+
+	return clamp(l + matrix.rv*v), clamp(l - matrix.gu*u - matrix.gv*v), clamp(l + matrix.bu*u)
+}
+
+// pipeRunner delivers the frames read by read until they run out. A pipe can
+// only be read once, so the offset is ignored.
+func pipeRunner(size image.Point, rate float64, read func(*image.NRGBA) error) FrameRunner {
+	return func(ctx context.Context, _ image.Point, _ time.Duration, framesChannel chan Frame) error {
+		ring := newFrameRing(framesChannel)
+		started := time.Now()
+
+		for i := 0; ; i++ {
+			frame := ring.Next(size)
+			if err := read(frame); err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return nil
+				}
+				return err
+			}
+
+			meta := FrameMeta{Index: i, PTS: time.Since(started), Keyframe: true}
+			if rate > 0 {
+				meta.PTS = time.Duration(float64(i) / rate * float64(time.Second))
+			}
+			measured := ring.Measure(frame, meta)
+
+			select {
+			case framesChannel <- measured:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
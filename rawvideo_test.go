@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"image"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadY4mHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   Y4mHeader
+		err    string
+	}{
+		{"420", "YUV4MPEG2 W64 H36 F25:1 Ip A1:1\n", Y4mHeader{Size: image.Pt(64, 36), FrameRate: 25, Colorspace: "420"}, ""},
+		{"444 full range", "YUV4MPEG2 W4 H2 F30000:1001 C444 XCOLORRANGE=FULL\n", Y4mHeader{Size: image.Pt(4, 2), FrameRate: 30000.0 / 1001, Colorspace: "444", Full: true}, ""},
+		{"420 variant", "YUV4MPEG2 W4 H2 C420jpeg\n", Y4mHeader{Size: image.Pt(4, 2), Colorspace: "420"}, ""},
+		{"mono", "YUV4MPEG2 W4 H2 Cmono\n", Y4mHeader{Size: image.Pt(4, 2), Colorspace: "mono"}, ""},
+		{"bad rate", "YUV4MPEG2 W4 H2 F25:0\n", Y4mHeader{Size: image.Pt(4, 2), Colorspace: "420"}, ""},
+		{"no size", "YUV4MPEG2 F25:1\n", Y4mHeader{}, "y4m header without a size"},
+		{"bad size", "YUV4MPEG2 Wx H-2\n", Y4mHeader{}, "y4m header without a size"},
+		{"too large", "YUV4MPEG2 W1000000 H1000000\n", Y4mHeader{}, "y4m size 1000000x1000000 is too large"},
+		{"10 bit", "YUV4MPEG2 W4 H2 C420p10\n", Y4mHeader{}, "unsupported y4m colorspace 420p10, only 8 bit 420, 422, 444 and mono are"},
+		{"truncated", "YUV4MPEG2 W4 H2", Y4mHeader{}, "y4m header: EOF"},
+		{"empty", "", Y4mHeader{}, "y4m header: EOF"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadY4mHeader(bufio.NewReader(strings.NewReader(test.header)))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("error is %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.want.Matrix = MatrixFor(test.want.Size.Y)
+			if got != test.want {
+				t.Errorf("header is %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestOpenY4m reads full range mono frames, a black one and a white one,
+// then a truncated one that ends the stream.
+func TestOpenY4m(t *testing.T) {
+	stream := "YUV4MPEG2 W2 H2 F25:1 Cmono XCOLORRANGE=FULL\n" +
+		"FRAME\n\x00\x00\x00\x00" +
+		"FRAME Ixyz\n\xff\xff\xff\xff" +
+		"FRAME\n\xff"
+
+	r := bufio.NewReader(strings.NewReader(stream))
+	if !IsY4m(r) {
+		t.Fatal("not recognized as y4m")
+	}
+
+	source, err := OpenY4m(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Info.Size != image.Pt(2, 2) || source.Info.FrameRate != 25 {
+		t.Errorf("info is %+v", source.Info)
+	}
+
+	frames := make(chan Frame, 4)
+	if err := source.Runner(context.Background(), source.Info.Size, 0, frames); err != nil {
+		t.Fatal(err)
+	}
+	close(frames)
+
+	var got []Frame
+	for frame := range frames {
+		got = append(got, frame)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d frames, want 2", len(got))
+	}
+
+	for i, want := range []uint8{0, 255} {
+		if c := got[i].Picture.NRGBAAt(1, 1); c.R != want || c.G != want || c.B != want {
+			t.Errorf("frame %d is %v, want %d", i, c, want)
+		}
+		if pts := time.Duration(i) * 40 * time.Millisecond; got[i].Meta.PTS != pts {
+			t.Errorf("frame %d is at %v, want %v", i, got[i].Meta.PTS, pts)
+		}
+	}
+}
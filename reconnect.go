@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RECONNECT_STALL is how long a network source can go without a frame
+	// before it's taken to have dropped.
+	RECONNECT_STALL = 10 * time.Second
+	// reconnects wait RECONNECT_BACKOFF, doubling on each failed one up to
+	// RECONNECT_MAX_BACKOFF
+	RECONNECT_BACKOFF     = time.Second
+	RECONNECT_MAX_BACKOFF = 30 * time.Second
+	// RECONNECT_END is how close to the end of a source with a duration
+	// its stream may stop and still be taken to have ended.
+	RECONNECT_END = 2 * time.Second
+)
+
+// dropped tells the end of a network stream, with err, from a dropped
+// connection. Streams with a duration end near it, live ones never do, so
+// they're reconnected until the retries run out.
+func (p *Player) dropped(err error) bool {
+	if !p.Source.Reconnect || !p.Source.Restartable {
+		return false
+	}
+
+	if duration := p.Source.Info.Duration; err == nil && duration > 0 {
+		return p.Position() < duration-RECONNECT_END
+	}
+
+	return true
+}
+
+// reconnect schedules the stream to be restarted where it dropped, after a
+// backoff, returning false once MaxRetries reconnects in a row have failed.
+func (p *Player) reconnect() bool {
+	if p.retries >= p.MaxRetries {
+		return false
+	}
+
+	backoff := min(RECONNECT_BACKOFF<<p.retries, RECONNECT_MAX_BACKOFF)
+	p.retries++
+	p.retryDue = time.After(backoff)
+
+	p.showStatus(fmt.Sprintf("reconnecting… (%d/%d)", p.retries, p.MaxRetries))
+
+	return true
+}
+
+// retry restarts the stream once the backoff is over.
+func (p *Player) retry() {
+	p.retryDue = nil
+	p.lastFrame = time.Now()
+
+	if p.playback.Restart(p.Position()) {
+		p.restarted()
+	}
+}
+
+// stalled reports whether a network source has gone quiet for too long
+// while frames are wanted.
+func (p *Player) stalled() bool {
+	if !p.Source.Reconnect || p.retryDue != nil || p.paused || p.small || p.replay != nil {
+		return false
+	}
+
+	return time.Since(p.lastFrame) > RECONNECT_STALL
+}
@@ -0,0 +1,1023 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The remote protocol carries the cell grid between `termtv headless-encode`,
+// which decodes and scales, and `termtv connect`, which only draws. Every
+// message is a kind byte, a big endian uint32 payload length and the payload.
+// The server answers the client's first size with MSG_ACCEPT, after which
+// everything it sends is in the compression it accepted, or MSG_REJECT when
+// the size doesn't carry the token the server wants, is out of bounds or the
+// server is full. A size out of bounds later is rejected too, ending the
+// session.
+const (
+	PROTOCOL_VERSION = 1
+
+	// server to client
+	MSG_ACCEPT = byte('A') // json RemoteAccept, uncompressed
+	MSG_REJECT = byte('R') // why the client was turned away, uncompressed
+	MSG_HELLO  = byte('H') // json RemoteHello
+	MSG_FULL   = byte('F') // every cell, see CellEncoder
+	MSG_DIFF   = byte('D') // runs of changed cells, see CellEncoder
+	MSG_END    = byte('E') // end of an item, payload is an error if it failed
+
+	// client to server
+	MSG_SIZE    = byte('S') // json RemoteSize, first message and on resize
+	MSG_COMMAND = byte('C') // a player command, see ParseCommand
+)
+
+// MAX_MESSAGE is the largest message the server sends.
+const MAX_MESSAGE = 64 << 20
+
+// Limits on what a client can have the server hold on to.
+const (
+	// MAX_CLIENT_MESSAGE is the largest message a client sends, sizes and
+	// commands being small, so a connection that isn't let in yet can't have
+	// the server allocate more.
+	MAX_CLIENT_MESSAGE = 4 << 10
+
+	// MAX_GRID is the largest width or height of a client's grid, in pixels,
+	// the first MSG_SIZE and any later one alike, so a client can't have the
+	// server allocate pictures of any size. Its sides fit the uint16 sizes of
+	// CellEncoder, and a full frame of it fits in MAX_MESSAGE.
+	MAX_GRID = 4096
+
+	// HANDSHAKE_TIMEOUT is how long the server waits for a client's first
+	// size, so connections that never send one don't hold a slot.
+	HANDSHAKE_TIMEOUT = 10 * time.Second
+
+	// REMOTE_WRITE_TIMEOUT is how long a write to a client may take, so one
+	// that stops reading is dropped instead of holding its session and slot.
+	REMOTE_WRITE_TIMEOUT = 10 * time.Second
+)
+
+type RemoteHello struct {
+	Version int    `json:"version"`
+	Title   string `json:"title"`
+}
+
+// RemoteAccept carries the token the client can reconnect with, see
+// RemoteServer, and whether it did so.
+type RemoteAccept struct {
+	Version     int    `json:"version"`
+	Compression string `json:"compression"`
+	Session     string `json:"session"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// RemoteSize is the client's pixel grid, see TerminalGrid. Compression lists
+// the compressions the client can read, Session the session it resumes and
+// Token the one of the server's --token, only the first size needs them.
+type RemoteSize struct {
+	Version     int      `json:"version"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Compression []string `json:"compression,omitempty"`
+	Session     string   `json:"session,omitempty"`
+	Token       string   `json:"token,omitempty"`
+}
+
+// Grid is the size as a grid, or an error when either side is outside 1 to
+// MAX_GRID.
+func (s RemoteSize) Grid() (image.Point, error) {
+	if s.Width < 1 || s.Height < 1 || s.Width > MAX_GRID || s.Height > MAX_GRID {
+		return image.Point{}, fmt.Errorf("invalid size %dx%d", s.Width, s.Height)
+	}
+	return image.Pt(s.Width, s.Height), nil
+}
+
+func WriteMessage(w io.Writer, kind byte, payload []byte) error {
+	header := [5]byte{kind}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+func WriteJsonMessage(w io.Writer, kind byte, value any) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return WriteMessage(w, kind, payload)
+}
+
+// ReadMessage reads a message of at most limit bytes.
+func ReadMessage(r io.Reader, limit uint32) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > limit {
+		return 0, nil, fmt.Errorf("message of %d bytes is too large", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+type RemoteMessage struct {
+	Kind    byte
+	Payload []byte
+}
+
+// ReadMessages delivers messages of at most limit bytes read from r until it
+// fails, then sends the error and closes the channel. It stops once ctx is
+// done, when nothing takes the messages anymore.
+func ReadMessages(ctx context.Context, r io.Reader, limit uint32) (<-chan RemoteMessage, <-chan error) {
+	messages := make(chan RemoteMessage)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(messages)
+
+		for {
+			kind, payload, err := ReadMessage(r, limit)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case messages <- RemoteMessage{kind, payload}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, errs
+}
+
+// CellEncoder encodes pictures as a grid of cells of two stacked pixels, six
+// bytes of RGB each. A full frame is the size as two uint16 followed by every
+// cell. A diff frame is the size followed by runs of changed cells, each a
+// uint32 index of the first cell, a uint16 count and the cells.
+type CellEncoder struct {
+	previous []byte
+	size     image.Point
+}
+
+func Cells(picture *image.NRGBA) []byte {
+	bounds := picture.Rect
+	cells := make([]byte, 0, bounds.Dx()*(bounds.Dy()+1)/2*6)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top := picture.NRGBAAt(x, y)
+			bot := picture.NRGBAAt(x, y+1)
+			cells = append(cells, top.R, top.G, top.B, bot.R, bot.G, bot.B)
+		}
+	}
+
+	return cells
+}
+
+func (e *CellEncoder) Encode(picture *image.NRGBA) (byte, []byte) {
+	cells := Cells(picture)
+	size := picture.Rect.Size()
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(size.X))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(size.Y))
+
+	if size != e.size || len(cells) != len(e.previous) {
+		e.size = size
+		e.previous = cells
+		return MSG_FULL, append(payload, cells...)
+	}
+
+	count := len(cells) / 6
+	changed := func(i int) bool {
+		return !bytes.Equal(cells[i*6:i*6+6], e.previous[i*6:i*6+6])
+	}
+
+	for i := 0; i < count; {
+		if !changed(i) {
+			i++
+			continue
+		}
+
+		// a run header costs about a cell, so bridge single unchanged cells,
+		// up to the most cells a run's uint16 count takes
+		end := i + 1
+		for end < count && end-i < math.MaxUint16 && (changed(end) || end+1 < count && changed(end+1)) {
+			end++
+		}
+
+		payload = binary.BigEndian.AppendUint32(payload, uint32(i))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(end-i))
+		payload = append(payload, cells[i*6:end*6]...)
+
+		i = end
+	}
+
+	e.previous = cells
+	return MSG_DIFF, payload
+}
+
+// CellDecoder rebuilds pictures from the output of a CellEncoder.
+type CellDecoder struct {
+	picture *image.NRGBA
+}
+
+func (d *CellDecoder) Decode(kind byte, payload []byte) (*image.NRGBA, error) {
+	if len(payload) < 4 {
+		return nil, errors.New("truncated frame")
+	}
+
+	size := image.Pt(
+		int(binary.BigEndian.Uint16(payload)),
+		int(binary.BigEndian.Uint16(payload[2:])),
+	)
+	payload = payload[4:]
+
+	if kind == MSG_FULL || d.picture == nil || d.picture.Rect.Size() != size {
+		if kind != MSG_FULL {
+			return nil, errors.New("diff frame without a full frame")
+		}
+		d.picture = image.NewNRGBA(image.Rectangle{Max: size})
+		return d.picture, d.apply(0, payload)
+	}
+
+	for len(payload) > 0 {
+		if len(payload) < 6 {
+			return nil, errors.New("truncated run")
+		}
+
+		index := int(binary.BigEndian.Uint32(payload))
+		count := int(binary.BigEndian.Uint16(payload[4:]))
+		payload = payload[6:]
+
+		if len(payload) < count*6 {
+			return nil, errors.New("truncated run")
+		}
+
+		if err := d.apply(index, payload[:count*6]); err != nil {
+			return nil, err
+		}
+		payload = payload[count*6:]
+	}
+
+	return d.picture, nil
+}
+
+func (d *CellDecoder) apply(index int, cells []byte) error {
+	width := d.picture.Rect.Dx()
+	height := d.picture.Rect.Dy()
+	if width == 0 && len(cells) >= 6 {
+		return errors.New("cell out of range")
+	}
+
+	for ; len(cells) >= 6; cells = cells[6:] {
+		x, y := index%width, index/width*2
+		if y >= height {
+			return errors.New("cell out of range")
+		}
+
+		d.picture.Pix[d.picture.PixOffset(x, y)+0] = cells[0]
+		d.picture.Pix[d.picture.PixOffset(x, y)+1] = cells[1]
+		d.picture.Pix[d.picture.PixOffset(x, y)+2] = cells[2]
+
+		if y+1 < height {
+			d.picture.Pix[d.picture.PixOffset(x, y+1)+0] = cells[3]
+			d.picture.Pix[d.picture.PixOffset(x, y+1)+1] = cells[4]
+			d.picture.Pix[d.picture.PixOffset(x, y+1)+2] = cells[5]
+		}
+
+		index++
+	}
+
+	return nil
+}
+
+// RemoteServer plays items for every client that connects. Clients that drop
+// without quitting are kept for ResumeWindow, so they can reconnect with
+// their session token and carry on where they were. Past MaxClients, or
+// MaxClientsPerIP from one address, clients are turned away, 0 for no limit.
+type RemoteServer struct {
+	Items           []string
+	Options         PlayOptions
+	ResumeWindow    time.Duration
+	MaxClients      int
+	MaxClientsPerIP int
+	Metrics         ServerMetrics
+
+	mu        sync.Mutex
+	resumable map[string]*RemoteClient
+	// connected counts the connections from each address
+	connected map[string]int
+	total     int
+}
+
+// RemoteClient is the state of a client that outlives its connection.
+type RemoteClient struct {
+	Token       string
+	Compression string
+	Grid        image.Point
+	Item        int
+	Position    time.Duration
+	Paused      bool
+}
+
+// Serve accepts clients on listener, each getting its own playback of items.
+func Serve(listener net.Listener, items []string, options PlayOptions) error {
+	server := &RemoteServer{
+		Items:           items,
+		Options:         options,
+		ResumeWindow:    options.ResumeWindow,
+		MaxClients:      options.MaxClients,
+		MaxClientsPerIP: options.MaxClientsPerIP,
+		resumable:       map[string]*RemoteClient{},
+		connected:       map[string]int{},
+	}
+
+	if options.MetricsAddr != "" {
+		if err := StartMetrics(options.MetricsAddr, RequireToken(options.Token, &server.Metrics), options.TLS); err != nil {
+			return err
+		}
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		conn = deadlineConn{conn}
+
+		go func() {
+			defer conn.Close()
+
+			release, err := server.admit(conn)
+			if err != nil {
+				WriteMessage(conn, MSG_REJECT, []byte(err.Error()))
+				log.Printf("%s: rejected, %v", conn.RemoteAddr(), err)
+				return
+			}
+			defer release()
+
+			if err := server.ServeClient(conn); err != nil && !errors.Is(err, io.EOF) {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// admit counts conn against the limits on clients, returning a func to call
+// when it's closed, or why it's turned away.
+func (s *RemoteServer) admit(conn net.Conn) (func(), error) {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		ip = conn.RemoteAddr().String()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.MaxClients > 0 && s.total >= s.MaxClients {
+		return nil, errors.New("the server is full")
+	}
+	if s.MaxClientsPerIP > 0 && s.connected[ip] >= s.MaxClientsPerIP {
+		return nil, errors.New("too many connections from your address")
+	}
+
+	s.total++
+	s.connected[ip]++
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.total--
+		if s.connected[ip]--; s.connected[ip] == 0 {
+			delete(s.connected, ip)
+		}
+	}, nil
+}
+
+func NewSessionToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// resume takes the client with token out of the resumable ones, or returns
+// nil if there is none.
+func (s *RemoteServer) resume(token string) *RemoteClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := s.resumable[token]
+	delete(s.resumable, token)
+	return client
+}
+
+func (s *RemoteServer) keep(client *RemoteClient) {
+	if s.ResumeWindow <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resumable[client.Token] = client
+
+	time.AfterFunc(s.ResumeWindow, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.resumable[client.Token] == client {
+			delete(s.resumable, client.Token)
+		}
+	})
+}
+
+// deadlineConn is a client's connection, each write to which has to be done
+// within REMOTE_WRITE_TIMEOUT.
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c deadlineConn) Write(p []byte) (int, error) {
+	c.SetWriteDeadline(time.Now().Add(REMOTE_WRITE_TIMEOUT))
+	return c.Conn.Write(p)
+}
+
+func (s *RemoteServer) ServeClient(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	kind, payload, err := ReadMessage(conn, MAX_CLIENT_MESSAGE)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var size RemoteSize
+	if kind != MSG_SIZE || json.Unmarshal(payload, &size) != nil {
+		return errors.New("expected the client size")
+	}
+
+	if size.Version != PROTOCOL_VERSION {
+		return fmt.Errorf("unsupported protocol version %d", size.Version)
+	}
+
+	if !TokenMatches(s.Options.Token, size.Token) {
+		WriteMessage(conn, MSG_REJECT, []byte("invalid token"))
+		return errors.New("rejected, invalid token")
+	}
+
+	grid, err := size.Grid()
+	if err != nil {
+		WriteMessage(conn, MSG_REJECT, []byte(err.Error()))
+		return fmt.Errorf("rejected, %w", err)
+	}
+
+	client := s.resume(size.Session)
+	resumed := client != nil
+
+	if !resumed {
+		client = &RemoteClient{
+			Token:       NewSessionToken(),
+			Compression: ChooseCompression(s.Options.Compressions, size.Compression),
+		}
+	}
+	client.Grid = grid
+
+	accept := RemoteAccept{PROTOCOL_VERSION, client.Compression, client.Token, resumed}
+	if err := WriteJsonMessage(conn, MSG_ACCEPT, accept); err != nil {
+		return err
+	}
+
+	s.Metrics.Connections.Add(1)
+	s.Metrics.Clients.Add(1)
+	defer s.Metrics.Clients.Add(-1)
+
+	if resumed {
+		s.Metrics.Reconnects.Add(1)
+		log.Printf("%s: resumed at item %d, %s", conn.RemoteAddr(), client.Item+1, FormatDuration(client.Position))
+	} else {
+		log.Printf("%s: connected, compression %s", conn.RemoteAddr(), client.Compression)
+	}
+
+	// sent counts this client's bytes, for its own --max-bandwidth
+	var sent atomic.Int64
+	out, err := NewStreamWriter(countingWriter{countingWriter{conn, &s.Metrics.BytesSent}, &sent}, client.Compression)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var bandwidth *Bandwidth
+	if s.Options.MaxBandwidth > 0 {
+		bandwidth = NewBandwidth(s.Options.MaxBandwidth)
+	}
+
+	// a resumed session is recorded in casts of its own
+	var recorder *SessionRecorder
+	if s.Options.RecordDir != "" {
+		recorder = NewSessionRecorder(s.Options.RecordDir, conn.RemoteAddr().String(), client.Token, s.Options.RecordMaxSize, s.Options.RecordKeep)
+		defer recorder.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, readErr := ReadMessages(ctx, conn, MAX_CLIENT_MESSAGE)
+
+	for ; client.Item < len(s.Items); client.Item++ {
+		source, err := Sniff(s.Items[client.Item], s.Options.FfmpegScale)
+		if err != nil {
+			WriteMessage(out, MSG_END, []byte(err.Error()))
+			out.Flush()
+			return err
+		}
+
+		if err := WriteJsonMessage(out, MSG_HELLO, RemoteHello{PROTOCOL_VERSION, source.Title}); err != nil {
+			s.keep(client)
+			return err
+		}
+
+		if recorder != nil {
+			recorder.Item(s.Items[client.Item])
+		}
+
+		session := &RemoteSession{
+			Source:    source,
+			Grid:      client.Grid,
+			Offset:    client.Position,
+			Buffer:    s.Options.Buffer,
+			paused:    client.Paused,
+			out:       out,
+			messages:  messages,
+			readErr:   readErr,
+			metrics:   &s.Metrics,
+			bandwidth: bandwidth,
+			sent:      &sent,
+			recorder:  recorder,
+		}
+
+		quit, err := session.Run()
+
+		client.Grid = session.Grid
+		client.Paused = session.paused
+		client.Position = session.playback.Position()
+
+		if err != nil {
+			s.keep(client)
+			return err
+		}
+		if quit {
+			return nil
+		}
+
+		client.Position = 0
+	}
+
+	return nil
+}
+
+// RemoteSession plays a source for a single client, encoding frames as cells
+// of the client's grid.
+type RemoteSession struct {
+	Source *Source
+	Grid   image.Point
+	// Offset is where playback starts, for clients resuming.
+	Offset time.Duration
+	Buffer int
+
+	playback Playback
+	encoder  CellEncoder
+	resized  *image.NRGBA
+	paused   bool
+
+	// frames are paced like the player's, a slow client link shows up as
+	// slow writes
+	pacer   Pacer
+	pending *image.NRGBA
+	due     <-chan time.Time
+
+	out      *StreamWriter
+	messages <-chan RemoteMessage
+	readErr  <-chan error
+	metrics  *ServerMetrics
+
+	// bandwidth caps the bytes a second this client is sent, counted by
+	// sent, when set
+	bandwidth *Bandwidth
+	sent      *atomic.Int64
+	// recorder, when set, records what the client is sent
+	recorder *SessionRecorder
+}
+
+// Run plays the source, returning true if the client went away or quit.
+func (s *RemoteSession) Run() (bool, error) {
+	s.resized = image.NewNRGBA(image.Rectangle{Max: s.Grid})
+	s.playback = Playback{Source: s.Source, Buffer: s.Buffer}
+	s.playback.Start(s.Grid, s.Offset)
+	s.restarted()
+
+	for {
+		frames := s.playback.Frames()
+		if s.paused || s.pending != nil {
+			frames = nil
+		}
+
+		due := s.due
+		if s.paused {
+			due = nil
+		}
+
+		select {
+		case message, ok := <-s.messages:
+			if !ok {
+				s.playback.Stop()
+				return true, <-s.readErr
+			}
+
+			if quit := s.handle(message); quit {
+				s.playback.Stop()
+				return true, nil
+			}
+
+		case frame, ok := <-frames:
+			if !ok {
+				err := s.playback.Wait()
+
+				end := []byte{}
+				if err != nil {
+					end = []byte(err.Error())
+				}
+
+				WriteMessage(s.out, MSG_END, end)
+				return false, s.out.Flush()
+			}
+
+			s.playback.Advance(frame.Meta)
+
+			wait, drop := s.pacer.Schedule(s.playback.Position(), s.playback.FrameInterval())
+			if drop {
+				s.metrics.FramesDropped.Add(1)
+				continue
+			}
+
+			if wait > 0 {
+				s.pending = frame.Picture
+				s.due = time.After(wait)
+				continue
+			}
+
+			if err := s.send(frame.Picture); err != nil {
+				s.playback.Stop()
+				return true, err
+			}
+
+		case <-due:
+			frame := s.pending
+			s.pending = nil
+			s.due = nil
+
+			if err := s.send(frame); err != nil {
+				s.playback.Stop()
+				return true, err
+			}
+		}
+	}
+}
+
+func (s *RemoteSession) restarted() {
+	s.pacer.Reset(s.playback.Position())
+	s.pending = nil
+	s.due = nil
+}
+
+func (s *RemoteSession) send(frame *image.NRGBA) error {
+	picture := Fit(frame, s.resized)
+	if s.bandwidth != nil {
+		if !s.bandwidth.Allow() {
+			s.metrics.FramesDropped.Add(1)
+			return nil
+		}
+
+		picture = s.bandwidth.Quantize(picture)
+	}
+
+	start := time.Now()
+	defer func() { s.pacer.Wrote(time.Since(start)) }()
+	sent := s.sent.Load()
+
+	kind, payload := s.encoder.Encode(picture)
+	if err := WriteMessage(s.out, kind, payload); err != nil {
+		return err
+	}
+	s.metrics.FramesSent.Add(1)
+
+	if err := s.out.Flush(); err != nil {
+		return err
+	}
+
+	if s.bandwidth != nil {
+		s.bandwidth.Spend(int(s.sent.Load()-sent), s.playback.FrameInterval())
+	}
+
+	if s.recorder != nil {
+		s.recorder.Frame(picture)
+	}
+
+	return nil
+}
+
+func (s *RemoteSession) handle(message RemoteMessage) bool {
+	switch message.Kind {
+	case MSG_SIZE:
+		var size RemoteSize
+		if json.Unmarshal(message.Payload, &size) != nil {
+			return false
+		}
+
+		// a client asking for a grid out of bounds is turned away
+		grid, err := size.Grid()
+		if err != nil {
+			WriteMessage(s.out, MSG_REJECT, []byte(err.Error()))
+			s.out.Flush()
+			return true
+		}
+
+		s.Grid = grid
+		s.resized = image.NewNRGBA(image.Rectangle{Max: s.Grid})
+		if s.playback.Resize(s.Grid) {
+			s.restarted()
+		}
+
+	case MSG_COMMAND:
+		c, err := ParseCommand(string(message.Payload))
+		if err != nil {
+			return false
+		}
+
+		switch c.Name {
+		case "quit":
+			return true
+		case "pause":
+			s.paused = !s.paused
+			s.pacer.Reset(s.playback.Position())
+		case "seek":
+			if s.playback.Seek(time.Duration(c.Arg * float64(time.Second))) {
+				s.restarted()
+			}
+		}
+	}
+
+	return false
+}
+
+type ConnectOptions struct {
+	Bindings KeyBindings
+	Renderer Renderer
+	// Compressions are offered to the server, most preferred first.
+	Compressions []string
+	// TLS is the config to connect over TLS with, nil for plain TCP, and
+	// Token the server's --token.
+	TLS   *tls.Config
+	Token string
+	// Reconnect is how long to keep trying to resume the session after
+	// the connection drops, 0 to give up right away.
+	Reconnect time.Duration
+	// ClipCenter shows the middle of frames sent for a bigger terminal
+	// than this one has become, see Viewport.
+	ClipCenter bool
+}
+
+// RemoteConnection is a connection to a headless-encode server past the
+// handshake.
+type RemoteConnection struct {
+	net.Conn
+	Accept   RemoteAccept
+	Messages <-chan RemoteMessage
+	ReadErr  <-chan error
+
+	// cancel stops reading Messages
+	cancel context.CancelFunc
+}
+
+func (c *RemoteConnection) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// Dial connects to the server at addr, resuming session unless it's empty.
+func Dial(addr string, grid image.Point, session string, options ConnectOptions) (*RemoteConnection, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if options.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, options.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size := RemoteSize{PROTOCOL_VERSION, grid.X, grid.Y, options.Compressions, session, options.Token}
+	if err := WriteJsonMessage(conn, MSG_SIZE, size); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	in := bufio.NewReader(conn)
+
+	kind, payload, err := ReadMessage(in, MAX_MESSAGE)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if kind == MSG_REJECT {
+		conn.Close()
+		return nil, fmt.Errorf("the server refused: %s", payload)
+	}
+
+	var accept RemoteAccept
+	if kind != MSG_ACCEPT || json.Unmarshal(payload, &accept) != nil {
+		conn.Close()
+		return nil, errors.New("expected the server to accept")
+	}
+
+	stream, err := NewStreamReader(in, accept.Compression)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, readErr := ReadMessages(ctx, stream, MAX_MESSAGE)
+	return &RemoteConnection{conn, accept, messages, readErr, cancel}, nil
+}
+
+// Connect draws what a headless-encode server at addr sends, forwarding
+// resizes and bound commands to it. When the connection drops in the middle
+// of an item it reconnects and resumes the session.
+func Connect(addr string, options ConnectOptions) error {
+	grid := TerminalGrid(options.Renderer)
+
+	conn, err := Dial(addr, grid, "", options)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close() }()
+
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+
+	ClearScreen()
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var decoder CellDecoder
+	viewport := Viewport{Center: options.ClipCenter}
+	buffer := &bytes.Buffer{}
+
+	messages := conn.Messages
+	ended := false
+
+	// while disconnected messages is nil and reconnect ticks
+	var reconnect <-chan time.Time
+	var lost time.Time
+
+	for {
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+
+			grid = TerminalGrid(options.Renderer)
+			ClearScreen()
+
+			if messages != nil {
+				// a failed write shows up as a failed read too
+				WriteJsonMessage(conn, MSG_SIZE, RemoteSize{Version: PROTOCOL_VERSION, Width: grid.X, Height: grid.Y})
+			}
+
+		case <-interrupt:
+			return nil
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			command, bound := options.Bindings[key]
+			if !bound {
+				continue
+			}
+
+			if command == "quit" {
+				// let the server forget the session
+				WriteMessage(conn, MSG_COMMAND, []byte(command))
+				return nil
+			}
+
+			if messages != nil {
+				WriteMessage(conn, MSG_COMMAND, []byte(command))
+			}
+
+		case <-reconnect:
+			resumed, err := Dial(addr, grid, conn.Accept.Session, options)
+			if err != nil {
+				if time.Since(lost) > options.Reconnect {
+					return fmt.Errorf("failed to reconnect: %w", err)
+				}
+				continue
+			}
+
+			if !resumed.Accept.Resumed {
+				resumed.Close()
+				return errors.New("failed to reconnect: the session expired")
+			}
+
+			conn.Close()
+			conn = resumed
+			messages = conn.Messages
+			reconnect = nil
+
+		case message, ok := <-messages:
+			if !ok {
+				err := <-conn.ReadErr
+				if errors.Is(err, io.EOF) && ended {
+					return nil
+				}
+
+				if options.Reconnect <= 0 || conn.Accept.Session == "" {
+					if errors.Is(err, io.EOF) {
+						return nil
+					}
+					return err
+				}
+
+				messages = nil
+				lost = time.Now()
+				reconnect = time.Tick(time.Second)
+				continue
+			}
+
+			ended = message.Kind == MSG_END
+
+			switch message.Kind {
+			case MSG_FULL, MSG_DIFF:
+				picture, err := decoder.Decode(message.Kind, message.Payload)
+				if err != nil {
+					return err
+				}
+
+				picture = viewport.Clip(picture, TerminalGrid(options.Renderer))
+				options.Renderer.Render(buffer, picture)
+				io.Copy(os.Stdout, buffer)
+				buffer.Reset()
+
+			case MSG_END:
+				if len(message.Payload) > 0 {
+					return fmt.Errorf("server: %s", message.Payload)
+				}
+
+			case MSG_REJECT:
+				return fmt.Errorf("the server refused: %s", message.Payload)
+			}
+		}
+	}
+}
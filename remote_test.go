@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// TestCellRoundTrip encodes a full frame and diffs to later ones, and checks
+// the decoder gets the same pictures back. Changing every cell of a large
+// grid takes runs of more cells than a run's count holds.
+func TestCellRoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {5, 3}, {64, 36}, {600, 400}, {MAX_GRID, 64}} {
+		t.Run(size.String(), func(t *testing.T) {
+			var encoder CellEncoder
+			var decoder CellDecoder
+
+			for n, frame := range []*image.NRGBA{TestPattern(size, 0), TestPattern(size, 1), inverted(TestPattern(size, 1))} {
+				kind, payload := encoder.Encode(frame)
+				if n > 0 && kind != MSG_DIFF {
+					t.Fatalf("frame %d is %c, want a diff", n, kind)
+				}
+
+				got, err := decoder.Decode(kind, payload)
+				if err != nil {
+					t.Fatalf("frame %d: %v", n, err)
+				}
+
+				// cells carry RGB only
+				for i := 0; i < len(frame.Pix); i += 4 {
+					if got.Pix[i] != frame.Pix[i] || got.Pix[i+1] != frame.Pix[i+1] || got.Pix[i+2] != frame.Pix[i+2] {
+						t.Fatalf("frame %d: pixel %d is %v, want %v", n, i/4, got.Pix[i:i+3], frame.Pix[i:i+3])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCellDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    byte
+		payload []byte
+	}{
+		{"empty", MSG_FULL, nil},
+		{"diff first", MSG_DIFF, []byte{0, 2, 0, 2}},
+		{"zero width", MSG_FULL, []byte{0, 0, 0, 2, 1, 2, 3, 4, 5, 6}},
+		{"too many cells", MSG_FULL, []byte{0, 1, 0, 2, 1, 2, 3, 4, 5, 6, 1, 2, 3, 4, 5, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var decoder CellDecoder
+			if _, err := decoder.Decode(test.kind, test.payload); err == nil {
+				t.Error("decoded without an error")
+			}
+		})
+	}
+
+	t.Run("truncated run", func(t *testing.T) {
+		var decoder CellDecoder
+		if _, err := decoder.Decode(MSG_FULL, []byte{0, 1, 0, 2, 1, 2, 3, 4, 5, 6}); err != nil {
+			t.Fatal(err)
+		}
+
+		// a run of 2 cells at 0 with one cell of data
+		run := []byte{0, 1, 0, 2, 0, 0, 0, 0, 0, 2, 1, 2, 3, 4, 5, 6}
+		if _, err := decoder.Decode(MSG_DIFF, run); err == nil {
+			t.Error("decoded without an error")
+		}
+	})
+}
+
+func inverted(picture *image.NRGBA) *image.NRGBA {
+	for i := 0; i < len(picture.Pix); i += 4 {
+		picture.Pix[i], picture.Pix[i+1], picture.Pix[i+2] = 255-picture.Pix[i], 255-picture.Pix[i+1], 255-picture.Pix[i+2]
+	}
+	return picture
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Renderer draws pictures on the terminal where their Rect.Min is on the grid,
+// which is the top left corner for pictures from the origin.
+type Renderer interface {
+	Name() string
+	// Grid is the size of the picture that fills a terminal of cols x rows
+	// cells. The last row is kept free for the cursor.
+	Grid(cols, rows int) image.Point
+	Render(buffer *bytes.Buffer, picture *image.NRGBA)
+}
+
+// DiffRenderer is a Renderer that can update the terminal from the previous
+// picture it drew, rewriting only the cells that changed.
+type DiffRenderer interface {
+	Renderer
+	RenderDiff(buffer *bytes.Buffer, previous, picture *image.NRGBA)
+}
+
+// CellSize is the pixels of the grid of renderer that take up a cell.
+func CellSize(renderer Renderer) image.Point {
+	cell := renderer.Grid(2, 3).Sub(renderer.Grid(1, 2))
+	return image.Pt(max(cell.X, 1), max(cell.Y, 1))
+}
+
+// writePosition moves the cursor to the cell of the pixel at, with cells of
+// cell pixels.
+func writePosition(buffer *bytes.Buffer, at, cell image.Point) {
+	fmt.Fprintf(buffer, "\u001b[%d;%dH", at.Y/cell.Y+1, at.X/cell.X+1)
+}
+
+// Renderers lists the renderers from best to worst, the order in which auto
+// picks them.
+var Renderers = []string{"kitty", "sixel", "truecolor", "256", "16", "ascii"}
+
+// NewRenderer returns the named renderer for a terminal with caps. Inside a
+// multiplexer, graphics it doesn't draw itself are passed through to the
+// terminal it runs in.
+func NewRenderer(name string, caps Capabilities) (Renderer, error) {
+	renderer, err := newRenderer(name, caps)
+	if err != nil || !caps.Synchronized || caps.Plain {
+		return renderer, err
+	}
+
+	return SynchronizedRenderer{renderer}, nil
+}
+
+func newRenderer(name string, caps Capabilities) (Renderer, error) {
+	passthrough := ""
+	if caps.Multiplexer != "" {
+		passthrough = caps.Multiplexer
+	}
+
+	switch name {
+	case "kitty":
+		return KittyRenderer{Passthrough: passthrough}, nil
+	case "sixel":
+		if caps.Sixel {
+			passthrough = ""
+		}
+		return SixelRenderer{Passthrough: passthrough, Cell: caps.CellPixels}, nil
+	case "truecolor":
+		return HalfBlockRenderer{Colors: 1 << 24}, nil
+	case "256":
+		if AdaptivePalette {
+			return &PaletteRenderer{}, nil
+		}
+		return HalfBlockRenderer{Colors: 256}, nil
+	case "16":
+		return HalfBlockRenderer{Colors: 16}, nil
+	case "ascii":
+		return AsciiRenderer{Plain: caps.Plain}, nil
+	}
+
+	return nil, fmt.Errorf("unknown renderer %s, expected auto or one of %s", name, strings.Join(Renderers, ", "))
+}
+
+// SynchronizedRenderer wraps the frames of a renderer in synchronized
+// updates, so the terminal draws each one at once.
+type SynchronizedRenderer struct {
+	Renderer
+}
+
+func (r SynchronizedRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	buffer.WriteString("\u001b[?2026h")
+	r.Renderer.Render(buffer, picture)
+	buffer.WriteString("\u001b[?2026l")
+}
+
+func (r SynchronizedRenderer) RenderDiff(buffer *bytes.Buffer, previous, picture *image.NRGBA) {
+	diff, ok := r.Renderer.(DiffRenderer)
+	if !ok {
+		r.Render(buffer, picture)
+		return
+	}
+
+	buffer.WriteString("\u001b[?2026h")
+	diff.RenderDiff(buffer, previous, picture)
+	buffer.WriteString("\u001b[?2026l")
+}
+
+// HalfBlockRenderer draws two pixels per cell with the upper half block
+// character, in truecolor or the nearest of 256 or 16 colors.
+type HalfBlockRenderer struct {
+	Colors int
+}
+
+func (r HalfBlockRenderer) Name() string {
+	if r.Colors > 256 {
+		return "truecolor"
+	}
+	return fmt.Sprint(r.Colors)
+}
+
+func (r HalfBlockRenderer) Grid(cols, rows int) image.Point {
+	return image.Pt(cols, (rows-1)*2)
+}
+
+func (r HalfBlockRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	if r.Colors > 256 {
+		WriteHalfBlocks(buffer, picture)
+		return
+	}
+
+	bounds := picture.Rect
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		writePosition(buffer, image.Pt(bounds.Min.X, y), image.Pt(1, 2))
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r.writeCell(buffer, picture.NRGBAAt(x, y), picture.NRGBAAt(x, y+1))
+		}
+		buffer.WriteString("\u001b[0m")
+	}
+}
+
+func (r HalfBlockRenderer) writeCell(buffer *bytes.Buffer, top, bot color.NRGBA) {
+	switch {
+	case r.Colors > 256:
+		buffer.WriteString(StackPixels(top, bot))
+	case r.Colors == 256:
+		fmt.Fprintf(buffer, "\u001b[38;5;%d;48;5;%dm▀", Color256(top), Color256(bot))
+	default:
+		fg, bg := Color16(top), Color16(bot)
+		fmt.Fprintf(buffer, "\u001b[%d;%dm▀", 30+fg%8+fg/8*60, 40+bg%8+bg/8*60)
+	}
+}
+
+// RenderDiff moves the cursor to each run of changed cells and rewrites it.
+func (r HalfBlockRenderer) RenderDiff(buffer *bytes.Buffer, previous, picture *image.NRGBA) {
+	bounds := picture.Rect
+
+	changed := func(x, y int) bool {
+		return previous.NRGBAAt(x, y) != picture.NRGBAAt(x, y) ||
+			previous.NRGBAAt(x, y+1) != picture.NRGBAAt(x, y+1)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !changed(x, y) {
+				continue
+			}
+
+			writePosition(buffer, image.Pt(x, y), image.Pt(1, 2))
+			for ; x < bounds.Max.X && changed(x, y); x++ {
+				r.writeCell(buffer, picture.NRGBAAt(x, y), picture.NRGBAAt(x, y+1))
+			}
+			buffer.WriteString("\u001b[0m")
+		}
+	}
+}
+
+// Color256 returns the nearest color of the xterm 6x6x6 cube or gray ramp.
+func Color256(c color.NRGBA) int {
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	r, g, b := level(c.R), level(c.G), level(c.B)
+
+	cube := color.NRGBA{cubeLevel(r), cubeLevel(g), cubeLevel(b), 255}
+
+	gray := (int(c.R) + int(c.G) + int(c.B)) / 3
+	step := min(max((gray-8+5)/10, 0), 23)
+	ramp := uint8(8 + step*10)
+
+	if colorDistance(c, color.NRGBA{ramp, ramp, ramp, 255}) < colorDistance(c, cube) {
+		return 232 + step
+	}
+
+	return 16 + r*36 + g*6 + b
+}
+
+func cubeLevel(level int) uint8 {
+	if level == 0 {
+		return 0
+	}
+	return uint8(55 + level*40)
+}
+
+var palette16 = []color.NRGBA{
+	{0, 0, 0, 255}, {205, 0, 0, 255}, {0, 205, 0, 255}, {205, 205, 0, 255},
+	{0, 0, 238, 255}, {205, 0, 205, 255}, {0, 205, 205, 255}, {229, 229, 229, 255},
+	{127, 127, 127, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {255, 255, 0, 255},
+	{92, 92, 255, 255}, {255, 0, 255, 255}, {0, 255, 255, 255}, {255, 255, 255, 255},
+}
+
+// Color16 returns the index of the nearest of the 16 standard colors, as
+// xterm shows them.
+func Color16(c color.NRGBA) int {
+	best := 0
+	for i, p := range palette16 {
+		if colorDistance(c, p) < colorDistance(c, palette16[best]) {
+			best = i
+		}
+	}
+
+	return best
+}
+
+func colorDistance(a, b color.NRGBA) int {
+	dr := int(a.R) - int(b.R)
+	dg := int(a.G) - int(b.G)
+	db := int(a.B) - int(b.B)
+	return dr*dr*3 + dg*dg*4 + db*db*2
+}
+
+// AsciiRenderer draws a pixel per cell as a character of matching brightness,
+// for terminals without colors. Plain output has no escape sequences, frames
+// simply follow each other separated by an empty line.
+type AsciiRenderer struct {
+	Plain bool
+}
+
+const ASCII_RAMP = " .:-=+*#%@"
+
+func (AsciiRenderer) Name() string { return "ascii" }
+
+func (AsciiRenderer) Grid(cols, rows int) image.Point {
+	return image.Pt(cols, rows-1)
+}
+
+func (r AsciiRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	bounds := picture.Rect
+
+	if r.Plain {
+		defer buffer.WriteString("\r\n")
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if !r.Plain {
+			writePosition(buffer, image.Pt(bounds.Min.X, y), image.Pt(1, 1))
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := picture.NRGBAAt(x, y)
+			luma := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+			buffer.WriteByte(ASCII_RAMP[luma*len(ASCII_RAMP)/256])
+		}
+		if r.Plain {
+			buffer.WriteString("\r\n")
+		}
+	}
+}
+
+// KittyRenderer sends pictures as images of the kitty graphics protocol, two
+// by four pixels per cell, which the terminal scales to fill the cells.
+type KittyRenderer struct {
+	// Passthrough is the multiplexer to wrap the images for, if any.
+	Passthrough string
+}
+
+func (KittyRenderer) Name() string { return "kitty" }
+
+func (KittyRenderer) Grid(cols, rows int) image.Point {
+	return image.Pt(cols*2, (rows-1)*4)
+}
+
+func (r KittyRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	size := picture.Rect.Size()
+
+	writePosition(buffer, picture.Rect.Min, image.Pt(2, 4))
+
+	// reusing the image id replaces the previous frame, which is drawn
+	// below text so overlays show over it
+	r.transmit(buffer, fmt.Sprintf(
+		"a=T,f=24,i=1,q=2,C=1,z=-1,s=%d,v=%d,c=%d,r=%d",
+		size.X, size.Y, (size.X+1)/2, (size.Y+3)/4,
+	), picture, picture.Rect)
+}
+
+// KITTY_STRIP is the height of the strips changes are sent in, so a change at
+// the top and one at the bottom don't send everything in between.
+const KITTY_STRIP = 16
+
+// RenderDiff edits the image in place with kitty's animation commands, sending
+// the box around the changes in each strip of the picture.
+func (r KittyRenderer) RenderDiff(buffer *bytes.Buffer, previous, picture *image.NRGBA) {
+	bounds := picture.Rect
+
+	for top := bounds.Min.Y; top < bounds.Max.Y; top += KITTY_STRIP {
+		changed := image.Rectangle{}
+
+		for y := top; y < min(top+KITTY_STRIP, bounds.Max.Y); y++ {
+			row := picture.PixOffset(bounds.Min.X, y)
+			for x := 0; x < bounds.Dx(); x++ {
+				i := row + x*4
+				if !bytes.Equal(picture.Pix[i:i+3], previous.Pix[i:i+3]) {
+					changed = changed.Union(image.Rect(bounds.Min.X+x, y, bounds.Min.X+x+1, y+1))
+				}
+			}
+		}
+
+		if changed.Empty() {
+			continue
+		}
+
+		// r=1 edits the root frame, which is the one on screen
+		r.transmit(buffer, fmt.Sprintf(
+			"a=f,i=1,r=1,f=24,q=2,x=%d,y=%d,s=%d,v=%d",
+			changed.Min.X-bounds.Min.X, changed.Min.Y-bounds.Min.Y, changed.Dx(), changed.Dy(),
+		), picture, changed)
+	}
+}
+
+// transmit sends the pixels of area with the control keys in header, in
+// chunks of at most 4096 bytes of payload.
+func (r KittyRenderer) transmit(buffer *bytes.Buffer, header string, picture *image.NRGBA, area image.Rectangle) {
+	rgb := make([]byte, 0, area.Dx()*area.Dy()*3)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			c := picture.NRGBAAt(x, y)
+			rgb = append(rgb, c.R, c.G, c.B)
+		}
+	}
+
+	data := base64.StdEncoding.EncodeToString(rgb)
+
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data[:min(len(data), 4096)]
+		data = data[len(chunk):]
+
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+
+		sequence := fmt.Sprintf("\u001b_Gm=%d;%s\u001b\\", more, chunk)
+		if first {
+			sequence = fmt.Sprintf("\u001b_G%s,m=%d;%s\u001b\\", header, more, chunk)
+		}
+
+		WritePassthrough(buffer, r.Passthrough, sequence)
+	}
+}
+
+// SixelRenderer draws pictures at the terminal's own resolution as sixels,
+// in the colors of the 6x6x6 cube.
+type SixelRenderer struct {
+	// Passthrough is the multiplexer to wrap the images for, if any.
+	Passthrough string
+	// Cell is the size of a cell in pixels, asked from the terminal if zero
+	Cell image.Point
+}
+
+func (SixelRenderer) Name() string { return "sixel" }
+
+func (r SixelRenderer) Grid(cols, rows int) image.Point {
+	cell := r.cell()
+	return image.Pt(cols*cell.X, (rows-1)*cell.Y)
+}
+
+func (r SixelRenderer) cell() image.Point {
+	cell := r.Cell
+	if cell.X <= 0 || cell.Y <= 0 {
+		cell = CellPixels()
+	}
+	if cell.X <= 0 || cell.Y <= 0 {
+		cell = image.Pt(10, 20)
+	}
+
+	return cell
+}
+
+func (r SixelRenderer) Render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	writePosition(buffer, picture.Rect.Min, r.cell())
+
+	if r.Passthrough == "" {
+		r.render(buffer, picture)
+		return
+	}
+
+	var sixels bytes.Buffer
+	r.render(&sixels, picture)
+
+	WritePassthrough(buffer, r.Passthrough, sixels.String())
+}
+
+func (SixelRenderer) render(buffer *bytes.Buffer, picture *image.NRGBA) {
+	size := picture.Rect.Size()
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+
+	indices := make([]byte, size.X*size.Y)
+	var used [216]bool
+
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := picture.NRGBAAt(picture.Rect.Min.X+x, picture.Rect.Min.Y+y)
+			i := level(c.R)*36 + level(c.G)*6 + level(c.B)
+			indices[y*size.X+x] = byte(i)
+			used[i] = true
+		}
+	}
+
+	fmt.Fprintf(buffer, "\u001bPq\"1;1;%d;%d", size.X, size.Y)
+
+	for i, ok := range used {
+		if ok {
+			fmt.Fprintf(buffer, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+		}
+	}
+
+	sixels := make([]byte, size.X)
+
+	for band := 0; band < size.Y; band += 6 {
+		var present [216]bool
+		for y := band; y < min(band+6, size.Y); y++ {
+			for _, i := range indices[y*size.X : (y+1)*size.X] {
+				present[i] = true
+			}
+		}
+
+		for i, ok := range present {
+			if !ok {
+				continue
+			}
+
+			for x := range sixels {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < size.Y; dy++ {
+					if int(indices[(band+dy)*size.X+x]) == i {
+						bits |= 1 << dy
+					}
+				}
+				sixels[x] = '?' + bits
+			}
+
+			fmt.Fprintf(buffer, "#%d", i)
+			writeSixelRuns(buffer, sixels)
+			buffer.WriteByte('$')
+		}
+
+		buffer.WriteByte('-')
+	}
+
+	buffer.WriteString("\u001b\\")
+}
+
+func writeSixelRuns(buffer *bytes.Buffer, sixels []byte) {
+	for i := 0; i < len(sixels); {
+		n := 1
+		for i+n < len(sixels) && sixels[i+n] == sixels[i] {
+			n++
+		}
+
+		if n > 3 {
+			fmt.Fprintf(buffer, "!%d%c", n, sixels[i])
+		} else {
+			buffer.Write(sixels[i : i+n])
+		}
+
+		i += n
+	}
+}
+
+// WritePassthrough writes sequence wrapped for multiplexer, so that it hands
+// it on to the terminal it runs in rather than interpreting it.
+func WritePassthrough(buffer *bytes.Buffer, multiplexer string, sequence string) {
+	switch multiplexer {
+	case "tmux":
+		buffer.WriteString("\u001bPtmux;")
+		buffer.WriteString(strings.ReplaceAll(sequence, "\u001b", "\u001b\u001b"))
+		buffer.WriteString("\u001b\\")
+
+	case "screen":
+		// screen cuts passthrough short at its own string terminator and
+		// after 768 bytes, so split after every escape and well before that
+		for len(sequence) > 0 {
+			n := min(len(sequence), 512)
+			if i := strings.IndexByte(sequence[:n], 0x1b); i >= 0 {
+				n = i + 1
+			}
+
+			buffer.WriteString("\u001bP")
+			buffer.WriteString(sequence[:n])
+			buffer.WriteString("\u001b\\")
+			sequence = sequence[n:]
+		}
+
+	default:
+		buffer.WriteString(sequence)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"image"
+	"time"
+)
+
+// ReplayCache keeps copies of the pictures drawn over the last Length, at
+// the terminal's resolution, so they can be shown again at once: the only
+// way back on live streams and webcams. Pictures that fall out are reused.
+// MaxBytes, unless 0, drops the oldest pictures sooner to keep the copies
+// under it.
+type ReplayCache struct {
+	Length   time.Duration
+	MaxBytes int64
+
+	pictures []replayPicture
+	spare    []*image.NRGBA
+}
+
+// replayPicture is a picture and when it was drawn.
+type replayPicture struct {
+	picture *image.NRGBA
+	at      time.Time
+}
+
+// Add keeps a copy of picture, drawn at at.
+func (c *ReplayCache) Add(picture *image.NRGBA, at time.Time) {
+	for len(c.pictures) > 0 && at.Sub(c.pictures[0].at) > c.Length {
+		c.spare = append(c.spare, c.pictures[0].picture)
+		c.pictures = c.pictures[1:]
+	}
+
+	if c.MaxBytes > 0 {
+		size := int64(len(picture.Pix))
+		if size > c.MaxBytes {
+			c.Reset()
+			return
+		}
+
+		// spares count too, they're only kept to save allocations
+		for int64(len(c.pictures)+len(c.spare)+1)*size > c.MaxBytes {
+			if n := len(c.spare); n > 0 {
+				c.spare = c.spare[:n-1]
+				continue
+			}
+			c.pictures = c.pictures[1:]
+		}
+	}
+
+	var kept *image.NRGBA
+	if n := len(c.spare); n > 0 {
+		kept, c.spare = c.spare[n-1], c.spare[:n-1]
+	}
+	if kept == nil || kept.Rect != picture.Rect {
+		kept = image.NewNRGBA(picture.Rect)
+	}
+	copy(kept.Pix, picture.Pix)
+
+	c.pictures = append(c.pictures, replayPicture{kept, at})
+}
+
+// Take returns the pictures kept, oldest first. They stay valid until the
+// next Add.
+func (c *ReplayCache) Take() []replayPicture {
+	return append([]replayPicture(nil), c.pictures...)
+}
+
+// Reset forgets the pictures, after the terminal was resized.
+func (c *ReplayCache) Reset() {
+	c.pictures = nil
+	c.spare = nil
+}
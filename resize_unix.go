@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"image"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// NotifyResize sends on ch whenever the terminal is resized, until the
+// returned function is called.
+func NotifyResize(ch chan<- os.Signal) func() {
+	signal.Notify(ch, syscall.SIGWINCH)
+	return func() { signal.Stop(ch) }
+}
+
+func EnableVirtualTerminal() {}
+
+// CellPixels returns the size of a terminal cell in pixels, or zero when the
+// terminal doesn't say.
+func CellPixels() image.Point {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 || size.Row == 0 {
+		return image.Point{}
+	}
+
+	return image.Pt(int(size.Xpixel/size.Col), int(size.Ypixel/size.Row))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// NotifyResize sends on ch whenever the console is resized, until the
+// returned function is called. The console has no resize signal, so its size
+// is polled.
+func NotifyResize(ch chan<- os.Signal) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+
+		cols, rows := TerminalSize()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			c, r := TerminalSize()
+			if c == cols && r == rows {
+				continue
+			}
+			cols, rows = c, r
+
+			select {
+			case ch <- syscall.Signal(0):
+			default:
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// EnableVirtualTerminal turns on escape sequence processing for the console,
+// without which Windows prints them verbatim.
+func EnableVirtualTerminal() {
+	handle := windows.Handle(os.Stdout.Fd())
+
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return
+	}
+
+	windows.SetConsoleMode(handle, mode|windows.ENABLE_PROCESSED_OUTPUT|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+}
+
+// CellPixels returns zero, the console doesn't report its font size.
+func CellPixels() image.Point {
+	return image.Point{}
+}
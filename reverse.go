@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+// REVERSE_CHUNK is how much of a source is decoded at a time to play it
+// backwards, see ReverseRunner.
+const REVERSE_CHUNK = 2 * time.Second
+
+// ReverseRunner plays source backwards from the offset it's started at:
+// the REVERSE_CHUNK before it is decoded forwards into memory, at size,
+// and delivered last frame first, then the chunk before that, down to the
+// start. Frames are Timed at their position, which goes down.
+func ReverseRunner(source *Source) FrameRunner {
+	return func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		for end := offset; end > 0; end -= REVERSE_CHUNK {
+			chunk, err := decodeChunk(ctx, source, size, max(end-REVERSE_CHUNK, 0), end)
+			if err != nil || ctx.Err() != nil {
+				return err
+			}
+
+			for i := len(chunk) - 1; i >= 0; i-- {
+				select {
+				case framesChannel <- chunk[i]:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// decodeChunk returns copies of the frames of source from start up to end,
+// fitted to size, Timed at their positions.
+func decodeChunk(ctx context.Context, source *Source, size image.Point, start, end time.Duration) ([]Frame, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	decode := size
+	if !source.Scale {
+		decode = source.Info.Size
+	}
+
+	frames := make(chan Frame, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Runner(ctx, decode, start, frames)
+		close(frames)
+	}()
+
+	var chunk []Frame
+	for frame := range frames {
+		meta := frame.Meta
+		if !meta.Timed {
+			meta.PTS = start + time.Duration(float64(len(chunk))/source.Info.FrameRate*float64(time.Second))
+			meta.Timed = true
+		}
+		// the rest are drained while the runner stops
+		if meta.PTS >= end {
+			cancel()
+			continue
+		}
+
+		picture := image.NewNRGBA(image.Rectangle{Max: size})
+		if Fit(frame.Picture, picture) == frame.Picture {
+			copy(picture.Pix, frame.Picture.Pix)
+		}
+		chunk = append(chunk, Frame{picture, meta})
+	}
+
+	// stopping it early isn't an error
+	if err := <-done; err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
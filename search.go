@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// SearchResult is a video found by Search.
+type SearchResult struct {
+	Title    string
+	Url      string
+	Channel  string
+	Duration time.Duration
+}
+
+// Search asks yt-dlp's YouTube search for up to n videos matching query.
+func Search(query string, n int) ([]SearchResult, error) {
+	bin, err := YtdlBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// flat lists the results without resolving each one
+	args := append(YtdlArgs(), "-J", "--flat-playlist", fmt.Sprintf("ytsearch%d:%s", n, query))
+	out, err := ChildOutput(ChildCommand(context.Background(), bin, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist struct {
+		Entries []struct {
+			Id       string   `json:"id"`
+			Url      string   `json:"url"`
+			Title    string   `json:"title"`
+			Channel  string   `json:"channel"`
+			Uploader string   `json:"uploader"`
+			Duration *float64 `json:"duration"`
+		} `json:"entries"`
+	}
+
+	if err := json.Unmarshal(out, &playlist); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, entry := range playlist.Entries {
+		result := SearchResult{
+			Title:   entry.Title,
+			Url:     entry.Url,
+			Channel: entry.Channel,
+		}
+
+		if result.Url == "" && entry.Id != "" {
+			result.Url = "https://www.youtube.com/watch?v=" + entry.Id
+		}
+		if result.Channel == "" {
+			result.Channel = entry.Uploader
+		}
+		if entry.Duration != nil {
+			result.Duration = time.Duration(*entry.Duration * float64(time.Second))
+		}
+
+		if result.Url != "" {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// PickResult lists results for the user to pick one to play, with selected
+// highlighted to start with. It returns the index of the one picked, or false
+// when they quit instead.
+func PickResult(query string, results []SearchResult, selected int) (int, bool) {
+	keys := MakeInputRaw()
+	defer RestoreTerminal()
+
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	top := 0
+	var buffer bytes.Buffer
+
+	draw := func() {
+		cols, rows := TerminalSize()
+		height := max(rows-2, 1)
+
+		top = min(top, selected)
+		top = max(top, selected-height+1)
+
+		fmt.Fprintf(&buffer, "\u001b[1;1H\u001b[%sm%s\u001b[0m", CAPTION_STYLE, fitText(fmt.Sprintf(" %d results for %q", len(results), query), cols))
+
+		for i := top; i < top+height; i++ {
+			if i >= len(results) {
+				fmt.Fprintf(&buffer, "\u001b[%d;1H\u001b[2K", i-top+2)
+				continue
+			}
+			result := results[i]
+
+			length := "live"
+			if result.Duration > 0 {
+				length = FormatDuration(result.Duration)
+			}
+
+			// the title gets what the channel and length leave
+			details := "  " + result.Channel + "  " + length + " "
+			line := fitText(" "+result.Title, max(cols-len([]rune(details)), 0)) + details
+
+			style := CAPTION_STYLE
+			if i == selected {
+				style = BROWSE_SELECTED_STYLE
+			}
+			fmt.Fprintf(&buffer, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", i-top+2, style, fitText(line, cols))
+		}
+
+		fmt.Fprintf(&buffer, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", rows, CAPTION_STYLE, fitText(" enter play  q quit", cols))
+
+		os.Stdout.Write(buffer.Bytes())
+		buffer.Reset()
+	}
+
+	ClearScreen()
+	draw()
+
+	for {
+		select {
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			ClearScreen()
+			draw()
+
+		case <-interrupt:
+			ClearScreen()
+			return 0, false
+
+		case key, ok := <-keys:
+			if !ok {
+				return 0, false
+			}
+
+			_, rows := TerminalSize()
+			page := max(rows-2, 1)
+
+			switch key {
+			case "q", "esc", "ctrl-c":
+				ClearScreen()
+				return 0, false
+			case "enter", "right", "l":
+				ClearScreen()
+				return selected, true
+			case "up", "k":
+				selected--
+			case "down", "j":
+				selected++
+			case "pgup":
+				selected -= page
+			case "pgdown":
+				selected += page
+			case "home":
+				selected = 0
+			case "end":
+				selected = len(results) - 1
+			}
+
+			selected = min(max(selected, 0), len(results)-1)
+			draw()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	SELFTEST_COLS = 40
+	SELFTEST_ROWS = 12
+
+	// golden files are kept small
+	GOLDEN_COLS = 16
+	GOLDEN_ROWS = 6
+)
+
+// renderTo renders pictures one after the other onto screen, each but the
+// first as a diff when diff is set.
+func renderTo(screen *Screen, renderer Renderer, diff bool, pictures ...*image.NRGBA) {
+	var buffer bytes.Buffer
+
+	for i, picture := range pictures {
+		if differ, ok := renderer.(DiffRenderer); ok && diff && i > 0 {
+			differ.RenderDiff(&buffer, pictures[i-1], picture)
+		} else {
+			renderer.Render(&buffer, picture)
+		}
+
+		screen.Write(buffer.Bytes())
+		buffer.Reset()
+	}
+}
+
+// TestRender draws a frame and reads it back off the screen, in the colors
+// each renderer has for its pixels.
+func TestRender(t *testing.T) {
+	for _, name := range []string{"truecolor", "256", "16"} {
+		t.Run(name, func(t *testing.T) {
+			renderer, _ := NewRenderer(name, Capabilities{})
+			size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+			frame := TestPattern(size, 0)
+
+			screen := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+			renderTo(screen, renderer, false, frame)
+
+			want := image.NewNRGBA(frame.Rect)
+			for i := 0; i < len(frame.Pix); i += 4 {
+				c := color.NRGBA{frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], 255}
+
+				switch name {
+				case "256":
+					c = Palette256(Color256(c))
+				case "16":
+					c = palette16[Color16(c)]
+				}
+
+				copy(want.Pix[i:], []uint8{c.R, c.G, c.B, c.A})
+			}
+
+			if err := comparePictures(screen.HalfBlocks(size), want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("ascii", func(t *testing.T) {
+		renderer := AsciiRenderer{}
+		size := renderer.Grid(SELFTEST_COLS, SELFTEST_ROWS)
+
+		// black on the left, white on the right
+		frame := image.NewNRGBA(image.Rectangle{Max: size})
+		for y := 0; y < size.Y; y++ {
+			for x := size.X / 2; x < size.X; x++ {
+				frame.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+
+		screen := NewScreen(SELFTEST_COLS, SELFTEST_ROWS)
+		renderTo(screen, renderer, false, frame)
+
+		row := strings.Repeat(" ", size.X/2) + strings.Repeat("@", size.X-size.X/2)
+		want := strings.TrimRight(strings.Repeat(row+"\n", size.Y), "\n") + "\n"
+
+		if got := screen.Text(); got != want {
+			t.Errorf("screen is\n%s\nwant\n%s", got, want)
+		}
+	})
+}
+
+// TestGolden compares what each renderer writes to the files in
+// testdata/golden, so that changes to it can't go unnoticed.
+func TestGolden(t *testing.T) {
+	for _, name := range Renderers {
+		t.Run(name, func(t *testing.T) {
+			if err := checkGolden(name, filepath.Join("testdata", "golden", name+".golden")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// checkGolden renders a frame of the test pattern and a diff to a later one,
+// and compares the output byte for byte to the golden file at path. With
+// UPDATE_GOLDEN set the golden file is rewritten instead.
+func checkGolden(name, path string) error {
+	renderer, err := NewRenderer(name, Capabilities{})
+	if err != nil {
+		return err
+	}
+
+	// the cell size sixels are drawn for comes from the terminal otherwise
+	if sixel, ok := renderer.(SixelRenderer); ok {
+		sixel.Cell = image.Pt(4, 8)
+		renderer = sixel
+	}
+
+	size := renderer.Grid(GOLDEN_COLS, GOLDEN_ROWS)
+	frames := []*image.NRGBA{TestPattern(size, 0), TestPattern(size, 3)}
+
+	var got bytes.Buffer
+	renderer.Render(&got, frames[0])
+	if diff, ok := renderer.(DiffRenderer); ok {
+		diff.RenderDiff(&got, frames[0], frames[1])
+	} else {
+		renderer.Render(&got, frames[1])
+	}
+
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, got.Bytes(), 0o644)
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w, run with UPDATE_GOLDEN=1 to create it", err)
+	}
+
+	if bytes.Equal(got.Bytes(), want) {
+		return nil
+	}
+
+	at := 0
+	for at < min(got.Len(), len(want)) && got.Bytes()[at] == want[at] {
+		at++
+	}
+
+	excerpt := func(b []byte) string {
+		return fmt.Sprintf("%q", b[max(at-16, 0):min(at+16, len(b))])
+	}
+
+	return fmt.Errorf(
+		"output differs from %s at byte %d: %s, want %s (UPDATE_GOLDEN=1 accepts it)",
+		path, at, excerpt(got.Bytes()), excerpt(want),
+	)
+}
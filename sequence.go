@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SEQUENCE_RATE is the frame rate of image sequences without --fps.
+const SEQUENCE_RATE = 25
+
+// sequenceNumber matches the printf style number in a pattern like
+// frames/%04d.png, as ffmpeg takes them.
+var sequenceNumber = regexp.MustCompile(`%(0?\d*)d`)
+
+// IsImageSequence reports whether arg names images rather than a file:
+// frames/%04d.png or a glob like 'frames/*.jpg'.
+func IsImageSequence(arg string) bool {
+	if IsUrl(arg) {
+		return false
+	}
+
+	if _, err := os.Stat(arg); err == nil {
+		return false
+	}
+
+	return sequenceNumber.MatchString(filepath.Base(arg)) || strings.ContainsAny(arg, "*?[")
+}
+
+// SequencePaths returns the images pattern names, in number order for a
+// printf style pattern and in name order for a glob.
+func SequencePaths(pattern string) ([]string, error) {
+	dir, base := filepath.Split(pattern)
+
+	if !sequenceNumber.MatchString(base) {
+		paths, err := filepath.Glob(pattern)
+		sort.Strings(paths)
+		return paths, err
+	}
+
+	// the number is captured, %04d takes exactly four digits and %d any
+	expression := sequenceNumber.ReplaceAllStringFunc(regexp.QuoteMeta(base), func(number string) string {
+		if width, _ := strconv.Atoi(strings.Trim(number, "%d")); width > 0 {
+			return fmt.Sprintf(`(\d{%d})`, width)
+		}
+		return `(\d+)`
+	})
+	matcher := regexp.MustCompile("^" + expression + "$")
+
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := map[string]int{}
+	var paths []string
+	for _, entry := range entries {
+		match := matcher.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		numbers[path], _ = strconv.Atoi(match[1])
+		paths = append(paths, path)
+	}
+
+	sort.Slice(paths, func(i, j int) bool { return numbers[paths[i]] < numbers[paths[j]] })
+	return paths, nil
+}
+
+// OpenImageSequence plays the images pattern names, decoded in Go, at
+// InputRate.
+func OpenImageSequence(pattern string) (*Source, error) {
+	paths, err := SequencePaths(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no images match %s", pattern)
+	}
+
+	first, err := os.Open(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(first)
+	first.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", paths[0], err)
+	}
+
+	rate := InputRate
+	if rate <= 0 {
+		rate = SEQUENCE_RATE
+	}
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		return SequenceFrameRunner(ctx, paths, rate, offset, framesChannel)
+	}
+
+	return &Source{
+		Name:  pattern,
+		Title: pattern,
+		Info: ProbeInfo{
+			Size:      image.Pt(config.Width, config.Height),
+			FrameRate: rate,
+			Duration:  time.Duration(float64(len(paths)) / rate * float64(time.Second)),
+		},
+		Seekable:    true,
+		Restartable: true,
+		Runner:      runner,
+	}, nil
+}
+
+// SequenceFrameRunner decodes the images of paths from offset on. Images that
+// fail to decode are skipped.
+func SequenceFrameRunner(ctx context.Context, paths []string, rate float64, offset time.Duration, framesChannel chan Frame) error {
+	ring := newFrameRing(framesChannel)
+	first := int(offset.Seconds() * rate)
+
+	for i := 0; first+i < len(paths); i++ {
+		file, err := os.Open(paths[first+i])
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+
+		frame := ring.Next(img.Bounds().Size())
+		DrawImage(frame, img)
+
+		measured := ring.Measure(frame, FrameMeta{
+			Index:    i,
+			PTS:      time.Duration(float64(first+i) / rate * float64(time.Second)),
+			Keyframe: true,
+		})
+
+		select {
+		case framesChannel <- measured:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pruneMu keeps sessions rotating at once from pruning the same casts.
+var pruneMu sync.Mutex
+
+// SessionRecorder records what a headless-encode client is sent as asciinema
+// casts in Dir, drawn with the truecolor renderer at the client's size. A
+// cast that grows past MaxSize is closed and the session carries on in a
+// new one, and past Keep casts in Dir the oldest are removed, 0 for no limit
+// on either. The first failure is logged and stops the recording.
+type SessionRecorder struct {
+	Dir string
+	// Name is the start of the names of the casts, which are numbered
+	// from the second on.
+	Name    string
+	MaxSize int64
+	Keep    int
+
+	cast     *CastRecorder
+	parts    int
+	renderer Renderer
+	drawn    *image.NRGBA
+	buffer   bytes.Buffer
+	item     string
+	failed   bool
+}
+
+// NewSessionRecorder records the session of the client at addr with token.
+func NewSessionRecorder(dir string, addr string, token string, maxSize int64, keep int) *SessionRecorder {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	renderer, _ := NewRenderer("truecolor", Capabilities{})
+
+	return &SessionRecorder{
+		Dir:      dir,
+		Name:     fmt.Sprintf("%s-%s-%s", time.Now().Format("20060102-150405"), strings.ReplaceAll(host, ":", "_"), token[:8]),
+		MaxSize:  maxSize,
+		Keep:     keep,
+		renderer: renderer,
+	}
+}
+
+// Item marks the start of item in the cast.
+func (r *SessionRecorder) Item(item string) {
+	r.item = item
+	if r.cast != nil {
+		r.cast.Marker("item=" + item)
+	}
+}
+
+// Frame records picture, the cells the client was sent.
+func (r *SessionRecorder) Frame(picture *image.NRGBA) {
+	if r.failed {
+		return
+	}
+
+	if r.cast == nil || r.MaxSize > 0 && r.cast.Size() >= r.MaxSize {
+		if err := r.rotate(picture.Rect.Size()); err != nil {
+			r.fail(err)
+			return
+		}
+	}
+
+	if r.drawn != nil && r.drawn.Rect != picture.Rect {
+		cols, rows := r.size(picture.Rect.Size())
+		r.cast.Resize(cols, rows)
+		r.buffer.WriteString(CLEAR_SCREEN)
+		r.drawn = nil
+	}
+
+	if diff, ok := r.renderer.(DiffRenderer); ok && r.drawn != nil {
+		diff.RenderDiff(&r.buffer, r.drawn, picture)
+	} else {
+		r.renderer.Render(&r.buffer, picture)
+		r.drawn = image.NewNRGBA(picture.Rect)
+	}
+	copy(r.drawn.Pix, picture.Pix)
+
+	_, err := r.cast.Write(r.buffer.Bytes())
+	r.buffer.Reset()
+	if err != nil {
+		r.fail(err)
+	}
+}
+
+// size is the terminal the cells of a grid fill, with the last row free
+// like the client's.
+func (r *SessionRecorder) size(grid image.Point) (int, int) {
+	return grid.X, (grid.Y+1)/2 + 1
+}
+
+// rotate closes the cast being written, if any, and starts the next.
+func (r *SessionRecorder) rotate(grid image.Point) error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	r.parts++
+	name := r.Name
+	if r.parts > 1 {
+		name = fmt.Sprintf("%s-%d", name, r.parts)
+	}
+
+	cols, rows := r.size(grid)
+	cast, err := NewCastRecorderSized(filepath.Join(r.Dir, name+".cast"), r.item, "", cols, rows)
+	if err != nil {
+		return err
+	}
+	r.cast = cast
+	r.drawn = nil
+
+	if r.item != "" {
+		r.cast.Marker("item=" + r.item)
+	}
+
+	if r.Keep > 0 {
+		pruneCasts(r.Dir, r.Keep)
+	}
+
+	return nil
+}
+
+func (r *SessionRecorder) fail(err error) {
+	log.Printf("Failed to record session %s: %v", r.Name, err)
+	r.failed = true
+	r.Close()
+}
+
+func (r *SessionRecorder) Close() error {
+	if r.cast == nil {
+		return nil
+	}
+
+	err := r.cast.Close()
+	r.cast = nil
+	return err
+}
+
+// pruneCasts removes the oldest casts in dir past keep.
+func pruneCasts(dir string, keep int) {
+	pruneMu.Lock()
+	defer pruneMu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil || len(paths) <= keep {
+		return
+	}
+
+	type cast struct {
+		path     string
+		modified time.Time
+	}
+
+	var casts []cast
+	for _, path := range paths {
+		if stat, err := os.Stat(path); err == nil {
+			casts = append(casts, cast{path, stat.ModTime()})
+		}
+	}
+
+	slices.SortFunc(casts, func(a, b cast) int {
+		return a.modified.Compare(b.modified)
+	})
+
+	for _, c := range casts[:max(len(casts)-keep, 0)] {
+		os.Remove(c.path)
+	}
+}
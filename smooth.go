@@ -0,0 +1,58 @@
+package main
+
+import "image"
+
+// Smoother evens out the tiny changes of color from frame to frame that are
+// lost among a video's pixels but make cells the size of a letter flicker.
+// Each cell is blended with its last color, and with a threshold left as it
+// was until its color really changes.
+type Smoother struct {
+	// Strength is how much of the last color is kept, from 0 to 1
+	Strength float64
+	// Threshold is how much, from 0 to 255, a channel has to change for
+	// the cell to change
+	Threshold int
+
+	last *image.NRGBA
+}
+
+func (s *Smoother) Reset() {
+	s.last = nil
+}
+
+func (s *Smoother) Filter(picture *image.NRGBA) {
+	if s.last == nil || s.last.Rect != picture.Rect {
+		s.last = image.NewNRGBA(picture.Rect)
+		copy(s.last.Pix, picture.Pix)
+		return
+	}
+
+	// in 8.8 fixed point
+	keep := int(s.Strength * 256)
+
+	for i := 0; i+3 < len(picture.Pix); i += 4 {
+		pixel, last := picture.Pix[i:i+3:i+3], s.last.Pix[i:i+3:i+3]
+
+		changed := false
+		for c := range pixel {
+			if abs(int(pixel[c])-int(last[c])) > s.Threshold {
+				changed = true
+				break
+			}
+		}
+
+		for c := range pixel {
+			if changed {
+				last[c] = uint8((int(last[c])*keep + int(pixel[c])*(256-keep) + 128) >> 8)
+			}
+			pixel[c] = last[c]
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
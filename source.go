@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Source is something to play: a runner producing its frames along with
+// what is known about it.
+type Source struct {
+	// Name is the path or url as given by the user
+	Name  string
+	Title string
+	Info  ProbeInfo
+	// Input is what ffmpeg reads the source from, the path or the
+	// resolved media url. Empty for stdin, which can only be read once,
+	// and for sources decoded without ffmpeg.
+	Input string
+
+	Seekable bool
+	// Scale is set when the runner scales frames to the size it is asked
+	// for, otherwise frames come at Info.Size.
+	Scale bool
+	// Restartable sources can have their runner restarted at a new size
+	// or offset, which a pipe can't.
+	Restartable bool
+	Runner      FrameRunner
+	// Audio is the input to play the sound of, for sources termtv plays
+	// sound for, see AudioPlayer.
+	Audio string
+	// Status, for sources that can go quiet like FIFOs, says what they
+	// are waiting for, or returns "" while frames flow.
+	Status func() string
+	// Reconnect is set for network sources, which are restarted when their
+	// connection drops, see Player.dropped.
+	Reconnect bool
+	// Close, when set, releases what the source holds once it's played.
+	Close func()
+	// Caption, when set, is a line shown under the picture, like the track
+	// a radio station is playing.
+	Caption func() string
+}
+
+// Sniff opens arg as a url, as stdin for "-", or as a file. MJPEG is decoded
+// without ffmpeg, other sources need it. Live audio urls are played as radio.
+func Sniff(arg string, scale bool) (*Source, error) {
+	switch {
+	case arg == TESTPATTERN:
+		return OpenTestPattern(), nil
+	case arg == "-":
+		return OpenStdin()
+	case IsFifo(arg):
+		return OpenFifo(arg), nil
+	case IsImageSequence(arg):
+		return OpenImageSequence(arg)
+	case IsMjpegFile(arg), IsMjpegAvi(arg):
+		return OpenMjpegFile(arg)
+	case streamCandidate(arg):
+		if source, ok := OpenMjpegUrl(arg); ok {
+			return source, nil
+		}
+	}
+
+	if err := Preflight(arg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case streamCandidate(arg):
+		if source, ok := OpenRadio(arg); ok {
+			return source, nil
+		}
+		return OpenUrl(arg)
+	case IsUrl(arg):
+		return OpenUrl(arg)
+	default:
+		return OpenFile(arg, scale)
+	}
+}
+
+func IsUrl(arg string) bool {
+	scheme, _, found := strings.Cut(arg, "://")
+	return found && scheme != "" && !strings.ContainsAny(scheme, `/\.`)
+}
+
+// Expand turns a directory argument into the media files in it, in name
+// order. Other arguments are returned as is.
+func Expand(arg string) ([]string, error) {
+	if arg == "-" || IsUrl(arg) {
+		return []string{arg}, nil
+	}
+
+	stat, err := os.Stat(arg)
+	if err != nil || !stat.IsDir() {
+		return []string{arg}, nil
+	}
+
+	entries, err := os.ReadDir(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if MediaExtensions[strings.ToLower(filepath.Ext(name))] {
+			paths = append(paths, filepath.Join(arg, name))
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no media files in %s", arg)
+	}
+
+	return paths, nil
+}
+
+func OpenFile(path string, scale bool) (*Source, error) {
+	info, err := Probe(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if Visualize(info) {
+		return OpenVisualizer(path, filepath.Base(path), path, *info), nil
+	}
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		return FileFrameRunner(ctx, path, info.Color, size, scale, offset, framesChannel)
+	}
+
+	return &Source{
+		Name:        path,
+		Title:       filepath.Base(path),
+		Info:        *info,
+		Input:       path,
+		Seekable:    true,
+		Scale:       scale,
+		Restartable: true,
+		Runner:      runner,
+	}, nil
+}
+
+func OpenUrl(url string) (*Source, error) {
+	media, err := ResolveMedia(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// set once probed
+	var color ColorInfo
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		return UrlFrameRunner(ctx, media, color, size, offset, framesChannel)
+	}
+
+	source := &Source{
+		Name:        url,
+		Title:       media.Title,
+		Input:       media.URL,
+		Scale:       true,
+		Restartable: true,
+		Reconnect:   true,
+		Runner:      runner,
+	}
+
+	// live streams and some hosts can't be probed, the player then falls
+	// back to wall clock time for the position
+	if info, err := Probe(media.URL); err == nil {
+		if Visualize(info) {
+			visualizer := OpenVisualizer(url, media.Title, media.URL, *info)
+			visualizer.Reconnect = true
+			return visualizer, nil
+		}
+
+		source.Info = *info
+		source.Seekable = info.Duration > 0
+		color = info.Color
+	}
+
+	// positions in the spool are counted in frames
+	if TimeshiftSize > 0 && !source.Seekable && source.Info.FrameRate > 0 {
+		return OpenTimeshift(source, media, TimeshiftSize)
+	}
+
+	return source, nil
+}
+
+func OpenStdin() (*Source, error) {
+	return OpenPipe(bufio.NewReaderSize(os.Stdin, 1<<16))
+}
+
+// OpenPipe reads y4m and raw video from stdin itself, anything else is
+// decoded by ffmpeg.
+func OpenPipe(stdin *bufio.Reader) (*Source, error) {
+	switch {
+	case RawInput != RawVideo{}:
+		return OpenRawVideo(stdin, RawInput)
+	case IsY4m(stdin):
+		return OpenY4m(stdin)
+	}
+
+	if err := RequireTools(FFMPEG); err != nil {
+		return nil, err
+	}
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		return StdinFrameRunner(ctx, stdin, size, framesChannel)
+	}
+
+	return &Source{
+		Name:   "-",
+		Title:  "stdin",
+		Scale:  true,
+		Runner: runner,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Stats counts what became of the frames decoded during playback, to tune
+// renderers and terminal settings by.
+type Stats struct {
+	Decoded  int
+	Rendered int
+	// Dropped counts the frames dropped to keep up with the clock or under
+	// a bandwidth budget.
+	Dropped int
+
+	// RenderTime and Bytes are totals over the rendered frames, RenderTime
+	// not counting the time to write them out.
+	RenderTime time.Duration
+	Bytes      int64
+}
+
+func (s *Stats) Add(other Stats) {
+	s.Decoded += other.Decoded
+	s.Rendered += other.Rendered
+	s.Dropped += other.Dropped
+	s.RenderTime += other.RenderTime
+	s.Bytes += other.Bytes
+}
+
+// AverageRenderTime is the time it took to render a frame.
+func (s Stats) AverageRenderTime() time.Duration {
+	if s.Rendered == 0 {
+		return 0
+	}
+	return s.RenderTime / time.Duration(s.Rendered)
+}
+
+// AverageBytes is the output per rendered frame.
+func (s Stats) AverageBytes() float64 {
+	if s.Rendered == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Rendered)
+}
+
+func (s Stats) String() string {
+	dropped := 0.0
+	if s.Decoded > 0 {
+		dropped = float64(s.Dropped) / float64(s.Decoded) * 100
+	}
+
+	return fmt.Sprintf(
+		"%d frames decoded, %d rendered, %d dropped (%.1f%%), %.2fms and %.1fKB per frame",
+		s.Decoded, s.Rendered, s.Dropped, dropped,
+		float64(s.AverageRenderTime().Microseconds())/1000, s.AverageBytes()/1000,
+	)
+}
+
+// MarshalJSON writes times in milliseconds, for scripts comparing settings.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(
+		`{"decoded":%d,"rendered":%d,"dropped":%d,"render_ms":%.3f,"bytes":%d,"bytes_per_frame":%.1f}`,
+		s.Decoded, s.Rendered, s.Dropped,
+		float64(s.AverageRenderTime().Microseconds())/1000, s.Bytes, s.AverageBytes(),
+	)), nil
+}
+
+func WriteStatsJson(path string, stats Stats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
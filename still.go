@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+)
+
+// hexColor is c as CSS and SVG write it.
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// cellColors are the colors a cell shows, the defaults resolved.
+func cellColors(cell Cell) (fg, bg color.NRGBA) {
+	return cellColor(cell.Fg, defaultForeground), cellColor(cell.Bg, defaultBackground)
+}
+
+// WriteHTML writes the first rows of screen as a standalone html page, a
+// pre of spans with the colors of each run of cells, to embed a still.
+func WriteHTML(w io.Writer, screen *Screen, rows int) error {
+	rows = min(rows, screen.Rows)
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>termtv</title>\n</head>\n<body style=\"margin:0;background:%s\">\n", hexColor(defaultBackground))
+	fmt.Fprintf(out, "<pre style=\"margin:0;font-family:monospace;line-height:1;color:%s;background:%s\">", hexColor(defaultForeground), hexColor(defaultBackground))
+
+	for y := 0; y < rows; y++ {
+		cells := screen.Cells[y]
+		for x := 0; x < len(cells); {
+			fg, bg := cellColors(cells[x])
+
+			// a span for each run of cells of the same colors
+			end := x
+			var text []rune
+			for ; end < len(cells); end++ {
+				if f, b := cellColors(cells[end]); f != fg || b != bg {
+					break
+				}
+				text = append(text, max(cells[end].Rune, ' '))
+			}
+
+			fmt.Fprintf(out, "<span style=\"color:%s;background:%s\">%s</span>", hexColor(fg), hexColor(bg), html.EscapeString(string(text)))
+			x = end
+		}
+		out.WriteString("\n")
+	}
+
+	out.WriteString("</pre>\n</body>\n</html>\n")
+	return out.Flush()
+}
+
+// WriteSVG writes the first rows of screen as an svg image, with a rect for
+// each run of cells of the same background, rects for the halves of half
+// blocks and text for other characters, each cell GIF_CELL_WIDTH by
+// GIF_CELL_HEIGHT like the frames of export-gif.
+func WriteSVG(w io.Writer, screen *Screen, rows int) error {
+	rows = min(rows, screen.Rows)
+	out := bufio.NewWriter(w)
+
+	width, height := screen.Cols*GIF_CELL_WIDTH, rows*GIF_CELL_HEIGHT
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" shape-rendering=\"crispEdges\">\n", width, height, width, height)
+	fmt.Fprintf(out, "<rect width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", width, height, hexColor(defaultBackground))
+
+	rect := func(x, y, cells, top, bottom int, c color.NRGBA) {
+		fmt.Fprintf(out, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", x*GIF_CELL_WIDTH, y*GIF_CELL_HEIGHT+top, cells*GIF_CELL_WIDTH, bottom-top, hexColor(c))
+	}
+
+	for y := 0; y < rows; y++ {
+		cells := screen.Cells[y]
+
+		for x := 0; x < len(cells); {
+			_, bg := cellColors(cells[x])
+			end := x + 1
+			for end < len(cells) {
+				if _, b := cellColors(cells[end]); b != bg {
+					break
+				}
+				end++
+			}
+			if bg != defaultBackground {
+				rect(x, y, end-x, 0, GIF_CELL_HEIGHT, bg)
+			}
+			x = end
+		}
+
+		for x := 0; x < len(cells); {
+			fg, _ := cellColors(cells[x])
+
+			switch r := cells[x].Rune; r {
+			case 0, ' ':
+				x++
+			case '▀', '▄', '█':
+				// a run of the same block is one rect
+				end := x + 1
+				for end < len(cells) && cells[end].Rune == r {
+					if f, _ := cellColors(cells[end]); f != fg {
+						break
+					}
+					end++
+				}
+
+				top, bottom := 0, GIF_CELL_HEIGHT
+				if r == '▀' {
+					bottom = GIF_CELL_HEIGHT / 2
+				} else if r == '▄' {
+					top = GIF_CELL_HEIGHT / 2
+				}
+				rect(x, y, end-x, top, bottom, fg)
+				x = end
+			default:
+				// a run of text of the same color, stretched to its cells
+				end := x
+				var text []rune
+				for end < len(cells) {
+					r := cells[end].Rune
+					if f, _ := cellColors(cells[end]); f != fg || r == 0 || r == '▀' || r == '▄' || r == '█' {
+						break
+					}
+					text = append(text, r)
+					end++
+				}
+
+				fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" fill=\"%s\" font-family=\"monospace\" font-size=\"%d\" textLength=\"%d\" lengthAdjust=\"spacingAndGlyphs\" xml:space=\"preserve\">%s</text>\n",
+					x*GIF_CELL_WIDTH, (y+1)*GIF_CELL_HEIGHT-GIF_CELL_HEIGHT/4, hexColor(fg), GIF_CELL_HEIGHT*7/8, (end-x)*GIF_CELL_WIDTH, html.EscapeString(string(text)))
+				x = end
+			}
+		}
+	}
+
+	out.WriteString("</svg>\n")
+	return out.Flush()
+}
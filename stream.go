@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+type FrameRunner func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error
+
+// Stream runs a FrameRunner in the background and can restart it, e.g. to
+// renegotiate the output size after the terminal is resized. Up to buffer
+// frames are decoded ahead, so a stall in rendering doesn't stall decoding.
+type Stream struct {
+	Frames chan Frame
+
+	runner FrameRunner
+	buffer int
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func StartStream(runner FrameRunner, size image.Point, offset time.Duration, buffer int) *Stream {
+	s := &Stream{runner: runner, buffer: buffer}
+	s.start(size, offset)
+	return s
+}
+
+func (s *Stream) start(size image.Point, offset time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	frames := make(chan Frame, s.buffer)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.runner(ctx, size, offset, frames)
+		close(frames)
+	}()
+
+	s.Frames = frames
+	s.cancel = cancel
+	s.done = done
+}
+
+// Stop cancels the runner and waits for it to exit, discarding any frame it
+// was about to deliver.
+func (s *Stream) Stop() {
+	s.cancel()
+	for range s.Frames {
+	}
+	<-s.done
+}
+
+func (s *Stream) Restart(size image.Point, offset time.Duration) {
+	s.Stop()
+	s.start(size, offset)
+}
+
+// Wait returns the runner's error once Frames has been drained. The stream
+// can still be restarted after.
+func (s *Stream) Wait() error {
+	err := <-s.done
+	s.cancel()
+	// put back for Stop, which a restart calls
+	s.done <- err
+	return err
+}
+
+// Settle waits until no signal has arrived on ch for d, since resizing a
+// window by dragging delivers a burst of them.
+func Settle[T any](ch <-chan T, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ch:
+			timer.Reset(d)
+		case <-timer.C:
+			return
+		}
+	}
+}
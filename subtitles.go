@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subtitleTiming matches the timing line of a cue, 00:01:02,500 --> ...
+// in SRT and 01:02.500 --> ... in WebVTT, where the hours are optional.
+var subtitleTiming = regexp.MustCompile(`^((?:\d+:)?\d+:\d+[,.]\d+)\s*-->\s*((?:\d+:)?\d+:\d+[,.]\d+)`)
+
+// subtitleMarkup matches the <i>, <font ...> and {\an8} styling some
+// files have, which is left out.
+var subtitleMarkup = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+
+type SubtitleCue struct {
+	Start, End time.Duration
+	Lines      []string
+}
+
+// Subtitles are the cues of an SRT or WebVTT file, in time order. Closed
+// captions add theirs as they're decoded, see ExtractCaptions.
+type Subtitles struct {
+	Cues []SubtitleCue
+
+	mu sync.Mutex
+}
+
+func ReadSubtitles(path string) (*Subtitles, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseSubtitles(file)
+}
+
+// ParseSubtitles parses SRT and WebVTT alike, see scanCues.
+func ParseSubtitles(r io.Reader) (*Subtitles, error) {
+	subtitles := &Subtitles{}
+	if err := scanCues(r, func(cue SubtitleCue) { subtitles.Cues = append(subtitles.Cues, cue) }); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(subtitles.Cues, func(i, j int) bool { return subtitles.Cues[i].Start < subtitles.Cues[j].Start })
+
+	return subtitles, nil
+}
+
+// scanCues calls add with each cue of r as it ends: a timing line and the
+// lines of text up to a blank one. Numbers, headers and notes outside cues
+// are skipped.
+func scanCues(r io.Reader, add func(SubtitleCue)) error {
+	var cue *SubtitleCue
+	flush := func() {
+		if cue != nil {
+			add(*cue)
+			cue = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+
+		if match := subtitleTiming.FindStringSubmatch(line); match != nil {
+			start, errStart := subtitleTime(match[1])
+			end, errEnd := subtitleTime(match[2])
+			if errStart != nil || errEnd != nil {
+				return fmt.Errorf("invalid timing %q", line)
+			}
+
+			flush()
+			cue = &SubtitleCue{Start: start, End: end}
+			continue
+		}
+
+		if line == "" {
+			flush()
+			continue
+		}
+		if cue != nil {
+			if text := strings.TrimSpace(subtitleMarkup.ReplaceAllString(line, "")); text != "" {
+				cue.Lines = append(cue.Lines, text)
+			}
+		}
+	}
+	flush()
+
+	return scanner.Err()
+}
+
+// subtitleTime parses hh:mm:ss,mmm, with a dot or without the hours too.
+func subtitleTime(value string) (time.Duration, error) {
+	fields := strings.Split(strings.Replace(value, ",", ".", 1), ":")
+
+	var at time.Duration
+	for _, field := range fields[:len(fields)-1] {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, err
+		}
+		at = at*60 + time.Duration(n)
+	}
+
+	seconds, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return at*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// At returns the lines of the cues shown at position, none between them.
+func (s *Subtitles) At(position time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	for _, cue := range s.Cues {
+		if cue.Start > position {
+			break
+		}
+		if position < cue.End {
+			lines = append(lines, cue.Lines...)
+		}
+	}
+
+	return lines
+}
+
+// Add adds a cue that starts no earlier than those before it.
+func (s *Subtitles) Add(cue SubtitleCue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Cues = append(s.Cues, cue)
+}
+
+// SubtitlesFor finds the subtitles next to a media file, movie.srt or
+// movie.vtt for movie.mkv.
+func SubtitlesFor(path string) string {
+	if IsUrl(path) {
+		return ""
+	}
+
+	for _, ext := range []string{".srt", ".vtt"} {
+		sub := strings.TrimSuffix(path, filepath.Ext(path)) + ext
+		if _, err := os.Stat(sub); err == nil {
+			return sub
+		}
+	}
+
+	return ""
+}
+
+// SubtitleStyle is how subtitles are shown.
+type SubtitleStyle struct {
+	// Scale is the height of a line in rows. At 1 lines are text in the
+	// terminal's font, above it they're drawn into the picture with the
+	// built-in one, as near that size as the picture's pixels allow.
+	Scale float64
+	Color color.NRGBA
+	Top   bool
+}
+
+// SUBTITLE_FONT_HEIGHT is the height of a line of the built-in font in its
+// dots, the glyph and the gap under it.
+const SUBTITLE_FONT_HEIGHT = 8
+
+var SUBTITLE_COLOR = color.NRGBA{255, 255, 255, 255}
+
+// TextStyle are the SGR parameters of subtitles written as text by a
+// renderer called name: the nearest color it has, on black.
+func (s SubtitleStyle) TextStyle(name string) string {
+	switch name {
+	case "256":
+		return fmt.Sprintf("0;38;5;%d;40", Color256(s.Color))
+	case "16", "ascii":
+		return "0;97;40"
+	}
+	return fmt.Sprintf("0;38;2;%d;%d;%d;40", s.Color.R, s.Color.G, s.Color.B)
+}
+
+// Dot is the size in pixels of a dot of the built-in font for a line to
+// be Scale rows of cell high, 0 when lines are text.
+func (s SubtitleStyle) Dot(cell image.Point) int {
+	if s.Scale <= 1 {
+		return 0
+	}
+	return max(int(s.Scale*float64(cell.Y)/SUBTITLE_FONT_HEIGHT+0.5), 1)
+}
+
+// DrawSubtitles draws lines into picture in the built-in font with dots
+// of dot pixels, centered at its top or bottom on a black box, each line
+// cut to the width.
+func DrawSubtitles(picture *image.NRGBA, lines []string, dot int, style SubtitleStyle) {
+	bounds := picture.Rect
+	advance := 6 * dot
+	height := SUBTITLE_FONT_HEIGHT * dot
+
+	y := bounds.Min.Y + dot
+	if !style.Top {
+		y = bounds.Max.Y - len(lines)*height - dot
+	}
+
+	for _, line := range lines {
+		text := []rune(line)
+		if fit := (bounds.Dx() - 2*dot) / advance; len(text) > fit {
+			text = text[:max(fit, 0)]
+		}
+		width := len(text) * advance
+		x := bounds.Min.X + (bounds.Dx()-width)/2
+
+		box := image.Rect(x-dot, y-dot, x+width+dot, y+height)
+		draw.Draw(picture, box, image.Black, image.Point{}, draw.Src)
+
+		ink := image.NewUniform(style.Color)
+		for i, r := range text {
+			for row, bits := range Glyph(r) {
+				for col := 0; col < 5; col++ {
+					if bits&(0x10>>col) == 0 {
+						continue
+					}
+					at := image.Rect(0, 0, dot, dot).Add(image.Pt(x+i*advance+col*dot, y+row*dot))
+					draw.Draw(picture, at, ink, image.Point{}, draw.Src)
+				}
+			}
+		}
+
+		y += height
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSubtitles(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name  string
+		input string
+		want  []SubtitleCue
+		err   string
+	}{
+		{
+			"srt",
+			"1\n00:00:01,000 --> 00:00:02,500\nHello\nthere\n\n2\n01:02:03,004 --> 01:02:04,000\nLater\n",
+			[]SubtitleCue{
+				{1000 * ms, 2500 * ms, []string{"Hello", "there"}},
+				{time.Hour + 2*time.Minute + 3004*ms, time.Hour + 2*time.Minute + 4*time.Second, []string{"Later"}},
+			},
+			"",
+		},
+		{
+			"webvtt",
+			"\ufeffWEBVTT\n\nNOTE a comment\nspanning lines\n\nintro\n00:01.000 --> 00:02.000 align:start line:0\n<i>Hi</i> {\\an8}you\n",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, []string{"Hi you"}}},
+			"",
+		},
+		{
+			"out of order",
+			"00:00:05,000 --> 00:00:06,000\nsecond\n\n00:00:01,000 --> 00:00:02,000\nfirst\n",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, []string{"first"}}, {5000 * ms, 6000 * ms, []string{"second"}}},
+			"",
+		},
+		{
+			"crlf",
+			"1\r\n00:00:01,000 --> 00:00:02,000\r\nx\r\n\r\n",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, []string{"x"}}},
+			"",
+		},
+		{
+			"markup only",
+			"00:00:01,000 --> 00:00:02,000\n<i></i>\n",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, nil}},
+			"",
+		},
+		{
+			"no blank line between cues",
+			"00:00:01,000 --> 00:00:02,000\na\n00:00:03,000 --> 00:00:04,000\nb",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, []string{"a"}}, {3000 * ms, 4000 * ms, []string{"b"}}},
+			"",
+		},
+		{
+			"truncated cue",
+			"00:00:01,000 --> 00:00:02,000\n",
+			[]SubtitleCue{{1000 * ms, 2000 * ms, nil}},
+			"",
+		},
+		{
+			"truncated timing",
+			"1\n00:00:01,000 -->",
+			nil,
+			"",
+		},
+		{"text without timing", "just some text\n\nmore\n", nil, ""},
+		{"empty", "", nil, ""},
+		{"huge hours", "99999999999999999999:00:00,000 --> 00:00:01,000\nx\n", nil, `invalid timing "99999999999999999999:00:00,000 --> 00:00:01,000"`},
+		{"line too long", "00:00:01,000 --> 00:00:02,000\n" + strings.Repeat("x", 1<<17), nil, "bufio.Scanner: token too long"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subtitles, err := ParseSubtitles(strings.NewReader(test.input))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("error is %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(subtitles.Cues, test.want) {
+				t.Errorf("cues are %v, want %v", subtitles.Cues, test.want)
+			}
+		})
+	}
+}
+
+func TestSubtitlesAt(t *testing.T) {
+	subtitles, _ := ParseSubtitles(strings.NewReader(
+		"00:00:01,000 --> 00:00:03,000\none\n\n00:00:02,000 --> 00:00:04,000\ntwo\n",
+	))
+
+	tests := []struct {
+		at   time.Duration
+		want []string
+	}{
+		{0, nil},
+		{time.Second, []string{"one"}},
+		{2500 * time.Millisecond, []string{"one", "two"}},
+		{3 * time.Second, []string{"two"}},
+		{4 * time.Second, nil},
+	}
+
+	for _, test := range tests {
+		if got := subtitles.At(test.at); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("lines at %v are %q, want %q", test.at, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// TerminalGrid returns the pixel grid with which renderer fills the terminal.
+func TerminalGrid(renderer Renderer) image.Point {
+	return renderer.Grid(TerminalSize())
+}
+
+// Viewport cuts pictures down to the grid of the terminal while it's
+// smaller than them, as it is between being shrunk and the next frame being
+// scaled to it, so rows longer than the terminal don't wrap.
+type Viewport struct {
+	// Center shows the middle of pictures, rather than their top left.
+	Center bool
+
+	clipped *image.NRGBA
+}
+
+// Clip returns the part of picture that fits grid, or picture when it all
+// does. The part is only valid until the next call.
+func (v *Viewport) Clip(picture *image.NRGBA, grid image.Point) *image.NRGBA {
+	size := picture.Rect.Size()
+	if grid.X <= 0 || grid.Y <= 0 || size.X <= grid.X && size.Y <= grid.Y {
+		return picture
+	}
+
+	fits := image.Pt(min(size.X, grid.X), min(size.Y, grid.Y))
+	from := picture.Rect.Min
+	if v.Center {
+		// even rows keep the pixels half blocks pair up together
+		from = from.Add(image.Pt((size.X-fits.X)/2, (size.Y-fits.Y)/2&^1))
+	}
+
+	if v.clipped == nil || v.clipped.Rect.Size() != fits {
+		v.clipped = image.NewNRGBA(image.Rectangle{Max: fits})
+	}
+	for y := 0; y < fits.Y; y++ {
+		i := picture.PixOffset(from.X, from.Y+y)
+		copy(v.clipped.Pix[y*v.clipped.Stride:], picture.Pix[i:i+fits.X*4])
+	}
+
+	return v.clipped
+}
+
+// CenterPicture places picture in the middle of grid, on whole cells of
+// cell. It shares the pixels of picture.
+func CenterPicture(picture *image.NRGBA, grid, cell image.Point) *image.NRGBA {
+	offset := grid.Sub(picture.Rect.Size()).Div(2)
+	offset = image.Pt(max(offset.X/cell.X*cell.X, 0), max(offset.Y/cell.Y*cell.Y, 0))
+
+	return MovePicture(picture, offset.Sub(picture.Rect.Min))
+}
+
+// MovePicture is picture moved by offset on the grid. It shares the pixels
+// of picture.
+func MovePicture(picture *image.NRGBA, offset image.Point) *image.NRGBA {
+	moved := *picture
+	moved.Rect = picture.Rect.Add(offset)
+	return &moved
+}
+
+// Backdrop draws pictures over a color that fills the grid of the terminal,
+// for the bars around the picture and the cells it leaves.
+type Backdrop struct {
+	Color color.NRGBA
+
+	canvas *image.NRGBA
+}
+
+// Draw returns picture over the color where its Rect places it on grid. The
+// result is only valid until the next call.
+func (b *Backdrop) Draw(picture *image.NRGBA, grid image.Point) *image.NRGBA {
+	if grid.X <= 0 || grid.Y <= 0 {
+		return picture
+	}
+
+	if b.canvas == nil || b.canvas.Rect.Size() != grid {
+		b.canvas = image.NewNRGBA(image.Rectangle{Max: grid})
+	}
+
+	fill := []byte{b.Color.R, b.Color.G, b.Color.B, 255}
+	for i := 0; i < len(b.canvas.Pix); i += 4 {
+		copy(b.canvas.Pix[i:], fill)
+	}
+
+	area := picture.Rect.Intersect(b.canvas.Rect)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		copy(b.canvas.Pix[b.canvas.PixOffset(area.Min.X, y):], picture.Pix[picture.PixOffset(area.Min.X, y):picture.PixOffset(area.Max.X, y)])
+	}
+
+	return b.canvas
+}
+
+// windowTitleSaved is set once the terminal's own window title was saved,
+// for RestoreTerminal to put it back.
+var windowTitleSaved bool
+
+// WindowTitle is what sets the title of the terminal's window and tab to
+// title, with OSC 0. The first one saves the terminal's own title with
+// XTWINOPS before.
+func WindowTitle(title string) string {
+	sequence := "\u001b]0;" + stripControls(title) + "\u001b\\"
+	if !windowTitleSaved {
+		windowTitleSaved = true
+		sequence = "\u001b[22;0t" + sequence
+	}
+
+	return sequence
+}
+
+// Hyperlink is text linked to url with OSC 8, for terminals to open it on a
+// click. Others show the text alone.
+func Hyperlink(url, text string) string {
+	return "\u001b]8;;" + stripControls(url) + "\u001b\\" + text + "\u001b]8;;\u001b\\"
+}
+
+// stripControls leaves out control characters, which would end the string
+// of an OSC early.
+func stripControls(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// ResetWindowTitle gives the terminal its own window title back, writing to
+// w.
+func ResetWindowTitle(w io.Writer) {
+	if windowTitleSaved {
+		io.WriteString(w, "\u001b[23;0t")
+		windowTitleSaved = false
+	}
+}
+
+// mouseEnabled is set while the terminal reports the mouse, for
+// RestoreTerminal to stop it.
+var mouseEnabled bool
+
+// EnableMouse is what has the terminal report presses of the mouse buttons
+// and the wheel as input, in the SGR encoding, see parseMouse.
+func EnableMouse() string {
+	mouseEnabled = true
+	return "\u001b[?1000h\u001b[?1006h"
+}
+
+// DisableMouse stops the terminal reporting the mouse, giving it back for
+// selecting text, writing to w.
+func DisableMouse(w io.Writer) {
+	if mouseEnabled {
+		io.WriteString(w, "\u001b[?1006l\u001b[?1000l")
+		mouseEnabled = false
+	}
+}
+
+// TerminalSize returns the size of the terminal in cells, or the size
+// WIDTH x HEIGHT pixels take up when stdout isn't a terminal.
+func TerminalSize() (int, int) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 1 {
+		return WIDTH, HEIGHT/2 + 1
+	}
+
+	return cols, rows
+}
+
+var restoreTerminal = func() {}
+
+// input and inputKeys are what MakeInputRaw reads keys from and delivers
+// them on, kept for the next call so two readers don't take turns at keys.
+var (
+	input     *os.File
+	inputKeys <-chan string
+)
+
+// MakeInputRaw puts the terminal into raw mode so key presses arrive as they
+// are typed, returning them as read by ReadKeys. When stdin is piped, e.g.
+// because it carries the video, keys are read from the controlling terminal
+// instead. Returns nil when there is no terminal. Called again, after
+// RestoreTerminal, it makes the terminal raw again and returns the same keys.
+func MakeInputRaw() <-chan string {
+	if input == nil {
+		input = os.Stdin
+
+		if !term.IsTerminal(int(input.Fd())) {
+			tty, err := OpenTty()
+			if err != nil {
+				return nil
+			}
+			input = tty
+		}
+	}
+
+	fd := int(input.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil
+	}
+
+	restoreTerminal = func() { term.Restore(fd, state) }
+
+	if inputKeys == nil {
+		inputKeys = ReadKeys(input)
+	}
+	return inputKeys
+}
+
+func OpenTty() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.OpenFile("CONIN$", os.O_RDWR, 0)
+	}
+
+	return os.Open("/dev/tty")
+}
+
+func RestoreTerminal() {
+	ResetPalette(os.Stdout)
+	ResetWindowTitle(os.Stdout)
+	DisableMouse(os.Stdout)
+	restoreTerminal()
+	restoreTerminal = func() {}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Terminals whose capabilities are misdetected can be described in
+// terminals.toml, next to the config file, in sections named after their
+// TERM or TERM_PROGRAM:
+//
+//	[term.xterm-256color]
+//	truecolor = true
+//
+//	[program.WezTerm]
+//	sixel = true
+//	synchronized_output = true
+//	cell_pixels = "9x18"
+//	renderer = "256"
+//	max_bandwidth = "2MB/s"
+//
+// Program sections apply after term sections, keys left out keep what was
+// detected. renderer and max_bandwidth are what termtv calibrate --apply
+// sets.
+
+func DefaultTerminalsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "termtv", "terminals.toml")
+}
+
+// OverrideCapabilities applies the sections of the terminals file at path
+// that match the terminal to caps.
+func OverrideCapabilities(caps Capabilities, path string) (Capabilities, error) {
+	// NO_COLOR and dumb terminals are not up for overriding
+	if caps.Plain {
+		return caps, nil
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return caps, err
+	}
+
+	matches := []struct {
+		prefix, name string
+	}{
+		{"term", os.Getenv("TERM")},
+		{"program", os.Getenv("TERM_PROGRAM")},
+	}
+
+	for _, match := range matches {
+		if match.name == "" {
+			continue
+		}
+
+		for name, values := range config.Sections(match.prefix) {
+			if strings.Trim(name, `"`) != match.name {
+				continue
+			}
+
+			if err := caps.override(values); err != nil {
+				return caps, fmt.Errorf("%s: [%s.%s]: %w", path, match.prefix, name, err)
+			}
+		}
+	}
+
+	return caps, nil
+}
+
+func (c *Capabilities) override(values map[string]string) error {
+	for key, value := range values {
+		var err error
+
+		switch key {
+		case "kitty":
+			c.Kitty, err = strconv.ParseBool(value)
+		case "sixel":
+			c.Sixel, err = strconv.ParseBool(value)
+		case "passthrough":
+			c.Passthrough, err = strconv.ParseBool(value)
+		case "synchronized_output":
+			c.Synchronized, err = strconv.ParseBool(value)
+		case "colors":
+			c.Colors, err = strconv.Atoi(value)
+		case "truecolor":
+			var truecolor bool
+			truecolor, err = strconv.ParseBool(value)
+			if truecolor {
+				c.Colors = 1 << 24
+			} else {
+				c.Colors = min(c.Colors, 256)
+			}
+		case "cell_pixels":
+			c.CellPixels, err = ParseSize(value)
+		case "renderer":
+			if !slices.Contains(Renderers, value) {
+				err = fmt.Errorf("unknown renderer %s", value)
+			}
+			c.Renderer = value
+		case "max_bandwidth":
+			c.MaxBandwidth, err = ParseBandwidth(value)
+		default:
+			err = fmt.Errorf("unknown capability")
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// TerminalSection is the section of the terminals file for this terminal,
+// named after TERM_PROGRAM, or TERM without it.
+func TerminalSection() (string, error) {
+	if program := os.Getenv("TERM_PROGRAM"); program != "" {
+		return "program." + program, nil
+	}
+	if name := os.Getenv("TERM"); name != "" {
+		return "term." + name, nil
+	}
+
+	return "", fmt.Errorf("neither TERM_PROGRAM nor TERM is set")
+}
+
+// SaveTerminalSettings sets keys to values, strings all, in section of the
+// terminals file at path, leaving the rest of the file as it was. Keys with
+// empty values are removed.
+func SaveTerminalSettings(path string, section string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var settings []string
+	for _, key := range keys {
+		if values[key] == "" {
+			continue
+		}
+		settings = append(settings, fmt.Sprintf("%s = %s", key, strconv.Quote(values[key])))
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	// the section's lines setting the keys are dropped and the settings
+	// go right under its header
+	var out []string
+	found, inside := false, false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "[") {
+			name, _, _ := strings.Cut(strings.TrimPrefix(trimmed, "["), "]")
+			prefix, rest, _ := strings.Cut(strings.TrimSpace(name), ".")
+			inside = prefix+"."+strings.Trim(rest, `"`) == section
+
+			out = append(out, line)
+			if inside && !found {
+				out = append(out, settings...)
+				found = true
+			}
+			continue
+		}
+
+		if inside {
+			key, _, _ := strings.Cut(trimmed, "=")
+			if _, ok := values[strings.Trim(strings.TrimSpace(key), `"`)]; ok {
+				continue
+			}
+		}
+
+		out = append(out, line)
+	}
+
+	if !found {
+		prefix, rest, _ := strings.Cut(section, ".")
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, fmt.Sprintf("[%s.%s]", prefix, strconv.Quote(rest)))
+		out = append(out, settings...)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(out, "\n")+"\n"), 0o644)
+}
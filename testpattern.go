@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"time"
+)
+
+// TESTPATTERN is the item of the built-in test pattern source, which is drawn
+// in Go without ffmpeg, see `--source testpattern`.
+const TESTPATTERN = "testpattern://"
+
+const TESTPATTERN_RATE = 25
+
+var (
+	smpteBars = []color.NRGBA{
+		{191, 191, 191, 255}, {191, 191, 0, 255}, {0, 191, 191, 255}, {0, 191, 0, 255},
+		{191, 0, 191, 255}, {191, 0, 0, 255}, {0, 0, 191, 255},
+	}
+	smpteReverse = []color.NRGBA{
+		{0, 0, 191, 255}, {19, 19, 19, 255}, {191, 0, 191, 255}, {19, 19, 19, 255},
+		{0, 191, 191, 255}, {19, 19, 19, 255}, {191, 191, 191, 255},
+	}
+)
+
+// OpenTestPattern opens the test pattern, an endless source that scales to
+// any size and seeks anywhere.
+func OpenTestPattern() *Source {
+	return &Source{
+		Name:        TESTPATTERN,
+		Title:       "test pattern",
+		Info:        ProbeInfo{FrameRate: TESTPATTERN_RATE},
+		Seekable:    true,
+		Scale:       true,
+		Restartable: true,
+		Runner:      TestPatternRunner,
+	}
+}
+
+// TestPatternRunner delivers TestPattern frames from offset on, as fast as
+// they are taken.
+func TestPatternRunner(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	ring := newFrameRing(framesChannel)
+	first := int(offset.Seconds() * TESTPATTERN_RATE)
+
+	for i := 0; ; i++ {
+		frame := ring.Next(size)
+		DrawTestPattern(frame, first+i)
+
+		measured := ring.Measure(frame, FrameMeta{
+			Index:    i,
+			PTS:      time.Duration(first+i) * time.Second / TESTPATTERN_RATE,
+			Keyframe: true,
+		})
+
+		select {
+		case framesChannel <- measured:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// TestPattern returns frame n of the test pattern at size.
+func TestPattern(size image.Point, n int) *image.NRGBA {
+	frame := image.NewNRGBA(image.Rectangle{Max: size})
+	DrawTestPattern(frame, n)
+	return frame
+}
+
+// DrawTestPattern draws frame n of the test pattern: SMPTE color bars over a
+// gray and a hue ramp, with a box moving across the bars to show motion.
+func DrawTestPattern(frame *image.NRGBA, n int) {
+	size := frame.Rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return
+	}
+
+	bars, reverse, ramps := size.Y*2/3, size.Y*3/4, size.Y*7/8
+
+	// the box bounces from side to side, a cell's worth per frame
+	side := max(size.Y/4, 1)
+	travel := max(size.X-side, 1)
+	x := n % (travel * 2)
+	if x >= travel {
+		x = travel*2 - x
+	}
+	box := image.Rect(x, bars/2-side/2, x+side, bars/2-side/2+side)
+
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			var c color.NRGBA
+
+			switch {
+			case image.Pt(x, y).In(box):
+				c = color.NRGBA{255, 255, 255, 255}
+				if image.Pt(x, y).In(box.Inset(max(side/8, 1))) {
+					c = color.NRGBA{0, 0, 0, 255}
+				}
+			case y < bars:
+				c = smpteBars[x*len(smpteBars)/size.X]
+			case y < reverse:
+				c = smpteReverse[x*len(smpteReverse)/size.X]
+			case y < ramps:
+				gray := uint8(x * 255 / max(size.X-1, 1))
+				c = color.NRGBA{gray, gray, gray, 255}
+			default:
+				c = Hue(float64(x) / float64(size.X))
+			}
+
+			frame.SetNRGBA(frame.Rect.Min.X+x, frame.Rect.Min.Y+y, c)
+		}
+	}
+}
+
+// Hue returns the fully saturated color at h around the color wheel, from 0
+// to 1.
+func Hue(h float64) color.NRGBA {
+	h = (h - float64(int(h))) * 6
+	f := uint8((h - float64(int(h))) * 255)
+
+	switch int(h) {
+	case 0:
+		return color.NRGBA{255, f, 0, 255}
+	case 1:
+		return color.NRGBA{255 - f, 255, 0, 255}
+	case 2:
+		return color.NRGBA{0, 255, f, 255}
+	case 3:
+		return color.NRGBA{0, 255 - f, 255, 255}
+	case 4:
+		return color.NRGBA{f, 0, 255, 255}
+	}
+
+	return color.NRGBA{255, 0, 255 - f, 255}
+}
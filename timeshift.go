@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TIMESHIFT_SEGMENT is about how long the spooled segments are, they're cut
+// at the first keyframe after it.
+const TIMESHIFT_SEGMENT = 2 * time.Second
+
+// TimeshiftSize is set by --timeshift, in bytes, to spool live streams to
+// disk so they can be paused and sought back in. 0 plays them live.
+var TimeshiftSize int64
+
+// Timeshift spools a live stream to a temp directory in segments, copied by
+// ffmpeg without decoding, and plays it back from there. Times are from when
+// spooling started; the oldest segments are removed to keep under Size.
+type Timeshift struct {
+	Size  int64
+	Color ColorInfo
+
+	dir string
+	cmd *exec.Cmd
+	log *ChildLog
+
+	mu       sync.Mutex
+	segments []timeshiftSegment
+	// recorded is the time of all the segments written, removed ones too
+	recorded time.Duration
+	bytes    int64
+	done     bool
+	err      error
+	// changed is closed and replaced when a segment is added
+	changed chan struct{}
+}
+
+type timeshiftSegment struct {
+	path  string
+	start time.Duration
+	end   time.Duration
+	size  int64
+}
+
+// OpenTimeshift starts spooling media and returns source, a live stream
+// probed as info, playing from the spool instead.
+func OpenTimeshift(source *Source, media *Media, size int64) (*Source, error) {
+	dir, err := os.MkdirTemp("", "termtv-timeshift-")
+	if err != nil {
+		return nil, err
+	}
+
+	args := HttpArgs(media.URL, media.Headers)
+	args = append(args,
+		"-i", media.URL,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", fmt.Sprintf("%.3f", TIMESHIFT_SEGMENT.Seconds()),
+		"-segment_format", "mpegts",
+		"-segment_list", "pipe:1",
+		"-segment_list_type", "csv",
+	)
+	args = append(args, LogArgs()...)
+	args = append(args, filepath.Join(dir, "%06d.ts"))
+
+	log := NewChildLog("timeshift: ffmpeg")
+	cmd := ChildCommand(context.Background(), ToolPath("ffmpeg"), args...)
+	cmd.Stderr = log
+	list, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to connect stdout pipe for ffmpeg: %w", err)
+	}
+	if err := StartChild(cmd); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start ffmpeg command: %w", err)
+	}
+
+	timeshift := &Timeshift{Size: size, Color: source.Info.Color, dir: dir, cmd: cmd, log: log, changed: make(chan struct{})}
+	go timeshift.spool(list)
+
+	source.Seekable = true
+	source.Runner = timeshift.Run
+	source.Close = timeshift.Close
+
+	return source, nil
+}
+
+// spool follows the segment list ffmpeg writes, a line as each segment is
+// finished: file name, start and end time.
+func (t *Timeshift) spool(list io.Reader) {
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 3 {
+			continue
+		}
+
+		start, _ := strconv.ParseFloat(fields[1], 64)
+		end, _ := strconv.ParseFloat(fields[2], 64)
+		path := filepath.Join(t.dir, filepath.Base(fields[0]))
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		t.add(path, time.Duration((end-start)*float64(time.Second)), stat.Size())
+	}
+
+	err := WaitChild(t.cmd)
+
+	t.mu.Lock()
+	t.done, t.err = true, err
+	close(t.changed)
+	t.mu.Unlock()
+}
+
+func (t *Timeshift) add(path string, duration time.Duration, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.segments = append(t.segments, timeshiftSegment{path, t.recorded, t.recorded + duration, size})
+	t.recorded += duration
+	t.bytes += size
+
+	// the newest segment is kept whatever its size
+	for len(t.segments) > 1 && t.bytes > t.Size {
+		os.Remove(t.segments[0].path)
+		t.bytes -= t.segments[0].size
+		t.segments = t.segments[1:]
+	}
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// next returns the first segment ending after offset, waiting for one to be
+// written, or false once spooling stopped or ctx is done. Offsets before the
+// oldest segment kept get the oldest.
+func (t *Timeshift) next(ctx context.Context, offset time.Duration) (timeshiftSegment, bool) {
+	for {
+		t.mu.Lock()
+		for _, segment := range t.segments {
+			if segment.end > offset {
+				t.mu.Unlock()
+				return segment, true
+			}
+		}
+		done, changed := t.done, t.changed
+		t.mu.Unlock()
+
+		if done {
+			return timeshiftSegment{}, false
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return timeshiftSegment{}, false
+		}
+	}
+}
+
+// Run plays the spool from offset, or from the oldest segment kept if that's
+// later, following it as segments are written.
+func (t *Timeshift) Run(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	first, ok := t.next(ctx, offset)
+	if !ok {
+		return t.spoolErr()
+	}
+	skip := max(offset-first.start, 0)
+
+	reader, writer := io.Pipe()
+	go func() {
+		segment, ok := first, true
+		for ok {
+			if err := copyFile(writer, segment.path); err != nil {
+				break
+			}
+			segment, ok = t.next(ctx, segment.end)
+		}
+		writer.Close()
+	}()
+	defer reader.Close()
+
+	// segments are fed from their start, ffmpeg decodes up to the offset.
+	// Frames are trimmed before showinfo so it only logs those written.
+	filter := ScaleFilter(size, t.Color) + ",showinfo"
+	if skip > 0 {
+		filter = fmt.Sprintf("trim=start=%.3f,setpts=PTS-STARTPTS,", skip.Seconds()) + filter
+	}
+
+	args := append(HwaccelArgs(), "-i", "pipe:0")
+	args = append(args, "-vf", filter)
+	args = append(args, FFMPEG_LOG...)
+	args = append(args,
+		"-fps_mode", "passthrough",
+		"-pix_fmt", "rgb0",
+		"-vcodec", "rawvideo",
+		"-f", "image2pipe",
+		"-",
+	)
+
+	if err := FfmpegFrameRunner(ctx, args, reader, size, first.start+skip, framesChannel); err != nil {
+		return err
+	}
+
+	return t.spoolErr()
+}
+
+func (t *Timeshift) spoolErr() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.log.Wrap(t.err)
+	}
+	return nil
+}
+
+// Close stops spooling and removes the spool.
+func (t *Timeshift) Close() {
+	killGroup(t.cmd.Process.Pid)
+	os.RemoveAll(t.dir)
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
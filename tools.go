@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// MissingToolError is returned when an external program a source needs isn't
+// installed.
+type MissingToolError struct {
+	Tool string
+	// Why says what termtv needs it for.
+	Why string
+	// Package is what to install to get it.
+	Package string
+}
+
+func (e *MissingToolError) Error() string {
+	return fmt.Sprintf(
+		"%s not found in PATH or next to termtv, it is needed %s; install %s, or point --%s-path at it",
+		e.Tool, e.Why, e.Package, toolFlags[e.Tool],
+	)
+}
+
+var (
+	FFMPEG  = &MissingToolError{"ffmpeg", "to decode video", "ffmpeg from https://ffmpeg.org or your package manager"}
+	FFPROBE = &MissingToolError{"ffprobe", "to read the size and frame rate of video files", "ffmpeg, which comes with it"}
+	YTDL    = &MissingToolError{"yt-dlp", "to find the video on web pages", "yt-dlp from https://github.com/yt-dlp/yt-dlp"}
+	FFPLAY  = &MissingToolError{"ffplay", "to play audio", "ffmpeg, which comes with it on most systems"}
+)
+
+// ToolPaths are where to find the external tools, by name, as set by their
+// flags or the [tools] section of the config file.
+var ToolPaths = map[string]string{}
+
+// toolFlags are the names of the flags setting ToolPaths.
+var toolFlags = map[string]string{"ffmpeg": "ffmpeg", "ffprobe": "ffprobe", "ffplay": "ffplay", "yt-dlp": "ytdl"}
+
+func RegisterToolFlags(flags *flag.FlagSet) {
+	for tool, flag := range toolFlags {
+		flags.Func(flag+"-path", "path to the "+tool+" binary", func(value string) error {
+			ToolPaths[tool] = value
+			return nil
+		})
+	}
+}
+
+// ApplyToolConfig takes the tool paths of the [tools] section that weren't
+// set by flags.
+func ApplyToolConfig(config Config) error {
+	for tool, path := range config["tools"] {
+		if _, ok := toolFlags[tool]; !ok {
+			return fmt.Errorf("[tools]: unknown tool %s", tool)
+		}
+
+		if ToolPaths[tool] == "" {
+			ToolPaths[tool] = path
+		}
+	}
+
+	return nil
+}
+
+// ToolPath returns the binary to run for tool: the configured path, one next
+// to the termtv binary, as static builds are often shipped, or else the name
+// to look up in PATH. ffprobe and ffplay are also looked for next to a
+// configured ffmpeg.
+func ToolPath(tool string) string {
+	if path := ToolPaths[tool]; path != "" {
+		return path
+	}
+
+	var dirs []string
+	if (tool == "ffprobe" || tool == "ffplay") && ToolPaths["ffmpeg"] != "" {
+		dirs = append(dirs, filepath.Dir(ToolPaths["ffmpeg"]))
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+
+	name := tool
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	for _, dir := range dirs {
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path
+		}
+	}
+
+	return tool
+}
+
+// RequireTools returns the error of the first of tools that isn't installed.
+func RequireTools(tools ...*MissingToolError) error {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(ToolPath(tool.Tool)); err != nil {
+			return tool
+		}
+	}
+
+	return nil
+}
+
+// Preflight checks that the tools needed to play item are installed, before
+// their absence shows up as a broken pipe or a blank screen.
+func Preflight(item string) error {
+	switch {
+	case item == TESTPATTERN:
+		return nil
+	case IsUrl(item):
+		// ffprobe is optional for urls, live streams can't be probed
+		// anyway, and which extractor is needed depends on the url
+		return RequireTools(FFMPEG)
+	}
+
+	return RequireTools(FFPROBE, FFMPEG)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// SHELL_PANE_WIDTH is the part of the terminal's columns the playlist
+	// pane takes, at least SHELL_PANE_MIN of them but never over half.
+	SHELL_PANE_WIDTH = 0.25
+	SHELL_PANE_MIN   = 20
+	// SHELL_FOOTER_ROWS are the rows under the picture, for the volume
+	// slider and the key help.
+	SHELL_FOOTER_ROWS = 2
+	// SHELL_SLIDER_WIDTH is the cells of the volume slider.
+	SHELL_SLIDER_WIDTH = 20
+	// SHELL_VOLUME is how the volume slider is labeled.
+	SHELL_VOLUME = " volume "
+)
+
+// SHELL_HELP are the commands the key help lists, with what it calls them.
+var SHELL_HELP = []struct{ Command, Label string }{
+	{"pause", "pause"},
+	{"seek", "seek"},
+	{"volume", "volume"},
+	{"reverse", "backwards"},
+	{"stats", "stats"},
+	{"quit", "quit"},
+}
+
+// Shell is the --tui layout around the picture: the playlist in a pane to
+// its right, a volume slider and a line of key help under it, and the title
+// row with the pause button and progress bar over it as always. The player
+// draws in what's left, see Area. Clicking an item plays it, clicking the
+// slider sets the volume.
+type Shell struct {
+	Items []string
+	// Current is the item of Items playing.
+	Current int
+	// Jump, unless -1, is the item clicked in the pane, to play once the
+	// player quits the current one.
+	Jump     int
+	Bindings KeyBindings
+
+	// top is the first item in view
+	top int
+	// written is what was last written, to write it only when it changed
+	written string
+}
+
+func NewShell(items []string, bindings KeyBindings) *Shell {
+	return &Shell{Items: items, Jump: -1, Bindings: bindings}
+}
+
+func (s *Shell) paneWidth(cols int) int {
+	return min(max(int(float64(cols)*SHELL_PANE_WIDTH), SHELL_PANE_MIN), cols/2)
+}
+
+// Area is the cells of a terminal of cols x rows left for the player, at
+// its top left.
+func (s *Shell) Area(cols, rows int) (int, int) {
+	return cols - s.paneWidth(cols), max(rows-SHELL_FOOTER_ROWS, 1)
+}
+
+// Write writes the pane and the footer of a terminal of cols x rows, when
+// they changed or full is set, with the slider at volume.
+func (s *Shell) Write(buffer *bytes.Buffer, cols, rows, volume int, full bool) {
+	var out strings.Builder
+	area, bottom := s.Area(cols, rows)
+	width := cols - area
+
+	// the pane scrolls to keep the current item in view, under its header
+	height := max(rows-1, 1)
+	if s.Current < s.top {
+		s.top = s.Current
+	}
+	if s.Current >= s.top+height {
+		s.top = s.Current - height + 1
+	}
+
+	header := fmt.Sprintf("│ playlist %d/%d", s.Current+1, len(s.Items))
+	fmt.Fprintf(&out, "\u001b[1;%dH\u001b[%sm%s\u001b[0m", area+1, CAPTION_STYLE, fitText(header, width))
+	for i := 0; i < height; i++ {
+		index := s.top + i
+		style, text := CAPTION_STYLE, "│"
+		if index < len(s.Items) {
+			name := filepath.Base(s.Items[index])
+			if IsUrl(s.Items[index]) {
+				name = s.Items[index]
+			}
+			text += "   " + name
+			if index == s.Current {
+				style, text = BROWSE_SELECTED_STYLE, "│ ▶ "+name
+			}
+		}
+		fmt.Fprintf(&out, "\u001b[%d;%dH\u001b[%sm%s\u001b[0m", i+2, area+1, style, fitText(text, width))
+	}
+
+	filled := SHELL_SLIDER_WIDTH * volume / 100
+	slider := SHELL_VOLUME + strings.Repeat("━", filled) + strings.Repeat("─", SHELL_SLIDER_WIDTH-filled) + fmt.Sprintf(" %d%%", volume)
+	fmt.Fprintf(&out, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", bottom+1, CAPTION_STYLE, fitText(slider, area))
+	if rows-bottom > 1 {
+		fmt.Fprintf(&out, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", bottom+2, CAPTION_STYLE, fitText(s.help(), area))
+	}
+
+	if out.String() == s.written && !full {
+		return
+	}
+	s.written = out.String()
+	buffer.WriteString(s.written)
+}
+
+// help is the line of key help: the keys bound to each of SHELL_HELP, those
+// that move the least when there are several, like left and right to seek.
+func (s *Shell) help() string {
+	var help []string
+	for _, entry := range SHELL_HELP {
+		type bound struct {
+			key string
+			arg float64
+		}
+		var keys []bound
+		for key, command := range s.Bindings {
+			c, err := ParseCommand(command)
+			if err != nil || c.Name != entry.Command || strings.HasPrefix(key, "wheel-") || strings.HasPrefix(key, "click") {
+				continue
+			}
+			keys = append(keys, bound{key, c.Arg})
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		least := slices.MinFunc(keys, func(a, b bound) int { return cmp.Compare(math.Abs(a.arg), math.Abs(b.arg)) })
+		keys = slices.DeleteFunc(keys, func(b bound) bool { return math.Abs(b.arg) > math.Abs(least.arg) })
+		slices.SortFunc(keys, func(a, b bound) int { return cmp.Or(cmp.Compare(a.arg, b.arg), cmp.Compare(a.key, b.key)) })
+
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.key
+		}
+		help = append(help, strings.Join(names, "/")+" "+entry.Label)
+	}
+
+	return " " + strings.Join(help, "  ")
+}
+
+// ItemAt is the item at cell x, y from 1 of a terminal of cols x rows.
+func (s *Shell) ItemAt(x, y, cols, rows int) (int, bool) {
+	area, _ := s.Area(cols, rows)
+	index := s.top + y - 2
+	if x <= area || y < 2 || index >= len(s.Items) {
+		return 0, false
+	}
+	return index, true
+}
+
+// VolumeAt is the volume the slider is set to by a click at cell x, y from
+// 1 of a terminal of cols x rows.
+func (s *Shell) VolumeAt(x, y, cols, rows int) (int, bool) {
+	area, bottom := s.Area(cols, rows)
+	start := len(SHELL_VOLUME)
+	if y != bottom+1 || x <= start || x > min(start+SHELL_SLIDER_WIDTH, area) {
+		return 0, false
+	}
+	return (x - start - 1) * 100 / (SHELL_SLIDER_WIDTH - 1), true
+}
+
+// Contains is whether cell x, y from 1 is in the pane or the footer.
+func (s *Shell) Contains(x, y, cols, rows int) bool {
+	area, bottom := s.Area(cols, rows)
+	return x > area || y > bottom
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"time"
+)
+
+// Audio only sources, or any source with --no-video, are shown as a spectrum
+// and waveform of their sound, drawn in Go from the PCM ffmpeg decodes.
+
+const (
+	VISUALIZER_RATE = 25
+	SAMPLE_RATE     = 44100
+	// FFT_SIZE samples, about 46ms, are analyzed for each frame
+	FFT_SIZE = 2048
+
+	// the spectrum spans these frequencies, on a log scale
+	SPECTRUM_LOW  = 40
+	SPECTRUM_HIGH = 16000
+	// levels are shown from SPECTRUM_FLOOR dB up to full scale
+	SPECTRUM_FLOOR = -60
+)
+
+// NoVideo is set by --no-video, which visualizes the audio of sources that
+// have video too.
+var NoVideo bool
+
+// Visualize reports whether a source probed as info is shown as a
+// visualization.
+func Visualize(info *ProbeInfo) bool {
+	return info.Audio && (NoVideo || info.Size == image.Point{})
+}
+
+func OpenVisualizer(name, title, input string, info ProbeInfo) *Source {
+	info.Size = image.Point{}
+	info.FrameRate = VISUALIZER_RATE
+
+	runner := func(ctx context.Context, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+		return VisualizerFrameRunner(ctx, input, nil, size, offset, framesChannel)
+	}
+
+	return &Source{
+		Name:        name,
+		Title:       title,
+		Info:        info,
+		Input:       input,
+		Seekable:    info.Duration > 0,
+		Scale:       true,
+		Restartable: true,
+		Runner:      runner,
+		Audio:       input,
+	}
+}
+
+// VisualizerFrameRunner decodes the audio of input from offset on, drawing a
+// frame of size for every 1/VISUALIZER_RATE of it. stdin, when not nil, is
+// what ffmpeg reads for an input of pipe:0.
+func VisualizerFrameRunner(ctx context.Context, input string, stdin io.Reader, size image.Point, offset time.Duration, framesChannel chan Frame) error {
+	args := append(SeekArgs(offset), HttpArgs(input, nil)...)
+	args = append(args,
+		"-i", input,
+		"-vn",
+		"-ac", "2",
+		"-ar", strconv.Itoa(SAMPLE_RATE),
+		"-f", "s16le",
+	)
+	args = append(args, LogArgs()...)
+	args = append(args, "-")
+
+	log := NewChildLog("ffmpeg")
+	cmd := ChildCommand(ctx, ToolPath("ffmpeg"), args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = log
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to connect stdout pipe for ffmpeg: %w", err)
+	}
+
+	if err := StartChild(cmd); err != nil {
+		return fmt.Errorf("failed to start ffmpeg command: %w", err)
+	}
+
+	ring := newFrameRing(framesChannel)
+	visualizer := NewVisualizer()
+	// 16 bit stereo
+	block := make([]byte, SAMPLE_RATE/VISUALIZER_RATE*4)
+
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(stdout, block); err != nil {
+			break
+		}
+		visualizer.Push(block)
+
+		frame := ring.Next(size)
+		visualizer.Draw(frame)
+
+		measured := ring.Measure(frame, FrameMeta{
+			Index:    i,
+			PTS:      offset + time.Duration(i)*time.Second/VISUALIZER_RATE,
+			Keyframe: true,
+			Levels:   MeasureLevels(visualizer.block),
+		})
+
+		select {
+		case framesChannel <- measured:
+		case <-ctx.Done():
+		}
+	}
+
+	err = WaitChild(cmd)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return log.Wrap(err)
+	}
+
+	return nil
+}
+
+// Visualizer keeps the recent samples of a stream and the levels of its
+// spectrum, which fall back slowly so that bars don't flicker.
+type Visualizer struct {
+	// mono samples, the last FFT_SIZE of them, from -1 to 1
+	samples []float64
+	// block are the stereo samples of the last block, for the waveform
+	block  [][2]float64
+	levels []float64
+}
+
+func NewVisualizer() *Visualizer {
+	return &Visualizer{samples: make([]float64, FFT_SIZE)}
+}
+
+// Push adds a block of 16 bit little endian stereo samples.
+func (v *Visualizer) Push(pcm []byte) {
+	v.block = v.block[:0]
+
+	for i := 0; i+4 <= len(pcm); i += 4 {
+		left := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) / 32768
+		right := float64(int16(binary.LittleEndian.Uint16(pcm[i+2:]))) / 32768
+		v.block = append(v.block, [2]float64{left, right})
+	}
+
+	n := min(len(v.block), FFT_SIZE)
+	copy(v.samples, v.samples[n:])
+	for i, sample := range v.block[len(v.block)-n:] {
+		v.samples[FFT_SIZE-n+i] = (sample[0] + sample[1]) / 2
+	}
+}
+
+// Spectrum returns the level of bars bands of the spectrum, from 0 to 1.
+func (v *Visualizer) Spectrum(bars int) []float64 {
+	bins := make([]complex128, FFT_SIZE)
+	for i, sample := range v.samples {
+		// Hann window
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(FFT_SIZE-1))
+		bins[i] = complex(sample*window, 0)
+	}
+	FFT(bins)
+
+	if len(v.levels) != bars {
+		v.levels = make([]float64, bars)
+	}
+
+	binWidth := float64(SAMPLE_RATE) / FFT_SIZE
+	ratio := math.Pow(SPECTRUM_HIGH/SPECTRUM_LOW, 1/float64(bars))
+
+	for bar := range v.levels {
+		low := int(SPECTRUM_LOW * math.Pow(ratio, float64(bar)) / binWidth)
+		high := max(int(SPECTRUM_LOW*math.Pow(ratio, float64(bar+1))/binWidth), low+1)
+
+		peak := 0.0
+		for bin := low; bin < min(high, FFT_SIZE/2); bin++ {
+			peak = max(peak, cmplx.Abs(bins[bin]))
+		}
+
+		// a full scale sine peaks at a quarter of FFT_SIZE with the window
+		db := 20 * math.Log10(peak/(FFT_SIZE/4)+1e-9)
+		level := min(max((db-SPECTRUM_FLOOR)/-SPECTRUM_FLOOR, 0), 1)
+
+		// rises at once, falls over about two thirds of a second
+		v.levels[bar] = max(level, v.levels[bar]-1.5/VISUALIZER_RATE)
+	}
+
+	return v.levels
+}
+
+// Draw draws the waveform of the last block over the top quarter of frame and
+// the spectrum as bars, green to red with their height, below it.
+func (v *Visualizer) Draw(frame *image.NRGBA) {
+	size := frame.Rect.Size()
+	for i := range frame.Pix {
+		frame.Pix[i] = 0
+		if i%4 == 3 {
+			frame.Pix[i] = 255
+		}
+	}
+	if size.X < 2 || size.Y < 4 {
+		return
+	}
+
+	wave := size.Y / 4
+	wavePen := color.NRGBA{0, 200, 255, 255}
+	for x := 0; x < size.X && len(v.block) > 0; x++ {
+		sample := v.block[x*len(v.block)/size.X]
+		y := int((1 - (sample[0]+sample[1])/2) * float64(wave-1) / 2)
+		frame.SetNRGBA(x, min(max(y, 0), wave-1), wavePen)
+	}
+
+	// bars are two pixels wide with a gap between them
+	top, height := wave+1, size.Y-wave-1
+	for i, level := range v.Spectrum(size.X / 3) {
+		for y := 0; y < int(level*float64(height)); y++ {
+			pen := Hue((1 - float64(y)/float64(height)) / 3)
+			frame.SetNRGBA(i*3, top+height-1-y, pen)
+			frame.SetNRGBA(i*3+1, top+height-1-y, pen)
+		}
+	}
+}
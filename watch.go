@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WATCH_INTERVAL is how often a watched folder is looked in for new files.
+const WATCH_INTERVAL = time.Second
+
+// FolderWatcher finds the media files that appear in a folder, for termtv
+// watch to play. It looks in the folder every WATCH_INTERVAL rather than
+// asking for events, which works the same on every system and on network
+// mounts. A file is only taken once its size stayed the same between two
+// looks, so one still being written or copied in isn't played half done.
+type FolderWatcher struct {
+	Dir string
+
+	mu sync.Mutex
+	// seen are the modification times of files taken or there from the
+	// start, a file written over is taken again
+	seen map[string]time.Time
+	// sizes are those of files not taken yet, at the last look
+	sizes map[string]int64
+	items []string
+	stop  chan struct{}
+}
+
+// WatchFolder watches dir for media files that appear in it from now on.
+func WatchFolder(dir string) (*FolderWatcher, error) {
+	w := &FolderWatcher{
+		Dir:   dir,
+		seen:  map[string]time.Time{},
+		sizes: map[string]int64{},
+		stop:  make(chan struct{}),
+	}
+
+	files, err := w.list()
+	if err != nil {
+		return nil, err
+	}
+	for path, stat := range files {
+		w.seen[path] = stat.ModTime()
+	}
+
+	go w.watch()
+
+	return w, nil
+}
+
+// list lists the media files in the folder, leaving out hidden ones like
+// the temporary files of downloads.
+func (w *FolderWatcher) list() (map[string]os.FileInfo, error) {
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]os.FileInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !MediaExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+
+		path := filepath.Join(w.Dir, name)
+		stat, err := os.Stat(path)
+		if err != nil || !stat.Mode().IsRegular() {
+			continue
+		}
+		files[path] = stat
+	}
+
+	return files, nil
+}
+
+func (w *FolderWatcher) watch() {
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.look()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// look takes the files that are new and done being written. A folder that
+// can't be read is looked in again next time.
+func (w *FolderWatcher) look() {
+	files, err := w.list()
+	if err != nil {
+		return
+	}
+
+	var found []string
+	for path, stat := range files {
+		if seen, ok := w.seen[path]; ok && seen.Equal(stat.ModTime()) {
+			continue
+		}
+
+		size, ok := w.sizes[path]
+		w.sizes[path] = stat.Size()
+		if !ok || size != stat.Size() || size == 0 {
+			continue
+		}
+
+		delete(w.sizes, path)
+		w.seen[path] = stat.ModTime()
+		found = append(found, path)
+	}
+
+	// one removed and put back is new again
+	for path := range w.seen {
+		if _, ok := files[path]; !ok {
+			delete(w.seen, path)
+		}
+	}
+	for path := range w.sizes {
+		if _, ok := files[path]; !ok {
+			delete(w.sizes, path)
+		}
+	}
+
+	// files found at once are played by name, which is how they'd be listed
+	slices.Sort(found)
+
+	w.mu.Lock()
+	w.items = append(w.items, found...)
+	w.mu.Unlock()
+}
+
+// Take returns the files found since the last call.
+func (w *FolderWatcher) Take() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	items := w.items
+	w.items = nil
+	return items
+}
+
+// Close stops watching.
+func (w *FolderWatcher) Close() {
+	close(w.stop)
+}
+
+type IdleOptions struct {
+	// Item is a source to loop, otherwise the screen is left black with
+	// Caption at the bottom.
+	Item       string
+	Caption    string
+	Renderer   Renderer
+	Bindings   KeyBindings
+	Buffer     int
+	MaxRetries int
+}
+
+// Idle shows the idle screen between items until take has more to play,
+// and returns them, or false when the user quits. The idle source is played
+// like a tile of a Mosaic filling the screen, starting over when it ends.
+func Idle(take func() []string, options IdleOptions, keys <-chan string) ([]string, bool) {
+	resize := make(chan os.Signal, 1)
+	defer NotifyResize(resize)()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var tile *mosaicTile
+	if options.Item != "" {
+		tile = &mosaicTile{item: options.Item}
+		defer func() {
+			if tile.source != nil && tile.source.Close != nil {
+				tile.source.Close()
+			}
+		}()
+	}
+
+	updates := make(chan mosaicUpdate)
+	var cancel context.CancelFunc
+	var running sync.WaitGroup
+
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			running.Wait()
+			cancel = nil
+		}
+	}
+	defer stop()
+
+	var canvas, drawn *image.NRGBA
+	var buffer bytes.Buffer
+
+	draw := func() {
+		if diff, ok := options.Renderer.(DiffRenderer); ok && drawn != nil {
+			diff.RenderDiff(&buffer, drawn, canvas)
+		} else {
+			options.Renderer.Render(&buffer, canvas)
+			drawn = image.NewNRGBA(canvas.Rect)
+		}
+		copy(drawn.Pix, canvas.Pix)
+
+		caption := options.Caption
+		if tile != nil {
+			caption = tile.status
+		}
+		if caption != "" && !plainOutput {
+			cols, rows := TerminalSize()
+			fmt.Fprintf(&buffer, "\u001b[%d;1H\u001b[%sm%s\u001b[0m", rows, CAPTION_STYLE, fitText(" "+caption, cols))
+		}
+
+		os.Stdout.Write(buffer.Bytes())
+		buffer.Reset()
+	}
+
+	// start lays the screen out afresh and plays the idle source for it
+	start := func() {
+		stop()
+
+		grid := TerminalGrid(options.Renderer)
+		canvas = image.NewNRGBA(image.Rectangle{Max: grid})
+		drawn = nil
+
+		ClearScreen()
+		draw()
+
+		if tile == nil {
+			return
+		}
+
+		tile.rect = canvas.Rect
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			tile.run(ctx, 0, MosaicOptions{Buffer: options.Buffer, MaxRetries: options.MaxRetries}, updates)
+		}()
+	}
+
+	start()
+
+	ticker := time.NewTicker(WATCH_INTERVAL / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if items := take(); len(items) > 0 {
+				return items, true
+			}
+
+		case <-resize:
+			Settle(resize, 100*time.Millisecond)
+			start()
+
+		case <-interrupt:
+			return nil, false
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			if c, err := ParseCommand(options.Bindings[key]); err == nil && c.Name == "quit" {
+				return nil, false
+			}
+
+		case update := <-updates:
+			tile.status = update.status
+			if update.picture != nil && update.picture.Rect.Size() == canvas.Rect.Size() {
+				copy(canvas.Pix, update.picture.Pix)
+			}
+			draw()
+		}
+	}
+}
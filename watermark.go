@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+)
+
+const (
+	// WATERMARK_SIZE is the width of the watermark unless
+	// --watermark-size sets it, as a part of the picture's.
+	WATERMARK_SIZE = 0.2
+	// WATERMARK_MARGIN is the space between the watermark and the edges, as
+	// a part of the picture's width.
+	WATERMARK_MARGIN = 0.02
+)
+
+// Watermark is a FrameFilter that blends an image, like a logo, into a corner
+// of the picture, for recorded demos. Transparent parts of the image are left
+// out, and Opacity fades the rest.
+type Watermark struct {
+	// Corner is numbered like PictureInPicture.Corner.
+	Corner int
+	// Size is the width of the watermark as a part of the picture's.
+	Size    float64
+	Opacity float64
+
+	// image has its colors premultiplied by alpha so scaling it down
+	// doesn't bleed the color of transparent pixels into the edges, and
+	// scaled is it fitted to the last picture
+	image  *image.NRGBA
+	scaled *image.NRGBA
+}
+
+// Load reads the image of the watermark from a png, jpeg or gif file.
+func (w *Watermark) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return fmt.Errorf("%s is empty", path)
+	}
+
+	premultiplied := image.NewRGBA(image.Rectangle{Max: bounds.Size()})
+	draw.Draw(premultiplied, premultiplied.Rect, img, bounds.Min, draw.Src)
+
+	// the bytes are the same, scaleAlpha averages them either way
+	w.image = &image.NRGBA{Pix: premultiplied.Pix, Stride: premultiplied.Stride, Rect: premultiplied.Rect}
+	w.scaled = nil
+
+	return nil
+}
+
+// Loaded is whether the image was loaded.
+func (w *Watermark) Loaded() bool {
+	return w.image != nil
+}
+
+func (w *Watermark) Filter(picture *image.NRGBA) {
+	rect := w.rect(picture.Rect.Size())
+	if rect.Empty() {
+		return
+	}
+
+	if w.scaled == nil || w.scaled.Rect.Size() != rect.Size() {
+		w.scaled = image.NewNRGBA(image.Rectangle{Max: rect.Size()})
+		scaleAlpha(w.image, w.scaled)
+	}
+
+	opacity := min(max(w.Opacity, 0), 1)
+	size := rect.Size()
+
+	for y := 0; y < size.Y; y++ {
+		from := w.scaled.Pix[y*w.scaled.Stride:][:size.X*4]
+		to := picture.Pix[picture.PixOffset(rect.Min.X, rect.Min.Y+y):][:size.X*4]
+
+		for i := 0; i < len(from); i += 4 {
+			alpha := float64(from[i+3]) / 255 * opacity
+			if alpha == 0 {
+				continue
+			}
+
+			for c := 0; c < 3; c++ {
+				to[i+c] = uint8(float64(to[i+c])*(1-alpha) + float64(from[i+c])*opacity + 0.5)
+			}
+		}
+	}
+}
+
+func (w *Watermark) Reset() {}
+
+// rect is where the watermark goes in a picture of size, keeping the aspect
+// ratio of its image.
+func (w *Watermark) rect(size image.Point) image.Rectangle {
+	original := w.image.Rect.Size()
+
+	width := int(float64(size.X) * w.Size)
+	height := width * original.Y / original.X
+	if height > size.Y {
+		height = size.Y
+		width = height * original.X / original.Y
+	}
+	margin := int(float64(size.X) * WATERMARK_MARGIN)
+
+	min := image.Pt(size.X-margin-width, size.Y-margin-height)
+	if w.Corner == 1 || w.Corner == 2 {
+		min.X = margin
+	}
+	if w.Corner == 2 || w.Corner == 3 {
+		min.Y = margin
+	}
+
+	return image.Rectangle{min, min.Add(image.Pt(width, height))}.Intersect(image.Rectangle{Max: size})
+}
+
+// scaleAlpha scales from into to by averaging the pixels each covers, alpha
+// and all, unlike Downscale which leaves alpha out of frames that have none.
+func scaleAlpha(from, to *image.NRGBA) {
+	fromSize, toSize := from.Rect.Size(), to.Rect.Size()
+
+	for y := 0; y < toSize.Y; y++ {
+		y0 := y * fromSize.Y / toSize.Y
+		y1 := max((y+1)*fromSize.Y/toSize.Y, y0+1)
+
+		for x := 0; x < toSize.X; x++ {
+			x0 := x * fromSize.X / toSize.X
+			x1 := max((x+1)*fromSize.X/toSize.X, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := from.Pix[sy*from.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			pixel := to.Pix[y*to.Stride+x*4:]
+			for c := range sum {
+				pixel[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+}